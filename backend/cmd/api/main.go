@@ -7,6 +7,7 @@ import (
 	"inventory/internal/config"
 	"inventory/internal/handlers"
 	"inventory/internal/middleware"
+	"inventory/internal/services"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,7 +16,6 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	echomiddleware "github.com/labstack/echo/v4/middleware"
-	_ "github.com/lib/pq"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -56,7 +56,17 @@ func main() {
 	h := handlers.New(db, cfg)
 	setupRoutes(e, h)
 
+	startSnapshotScheduler(db, cfg)
+	startCheckpointScheduler(db, cfg)
+	startUsageFlusher(h.Usage)
+	startTenantDataJobs(h.TenantData)
+
 	startServer(e, cfg)
+
+	// Keep the requests counted since the last flush
+	if err := h.Usage.Flush(services.SystemScope(context.Background())); err != nil {
+		log.Error().Err(err).Msg("Failed to record API usage")
+	}
 }
 
 func setupLogger(cfg *config.Config) {
@@ -73,7 +83,7 @@ func setupLogger(cfg *config.Config) {
 }
 
 func setupDatabase(cfg *config.Config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.DatabaseURL)
+	db, err := services.OpenDB(cfg.DatabaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -107,162 +117,459 @@ func setupMiddleware(e *echo.Echo, cfg *config.Config) {
 }
 
 func setupRoutes(e *echo.Echo, h *handlers.Handler) {
-	api := e.Group("/api/v1")
+	api := e.Group("/api/v1", middleware.HostTenant(&middleware.TenantResolver{
+		BaseDomain: h.Config.TenantBaseDomain,
+		Tenants:    h.Tenants,
+	}))
+	perm := middleware.RequirePermission
 
 	api.GET("/healthz", h.Health)
 	api.GET("/readyz", h.Ready)
 
+	// Sign-in looks users up across tenants
 	auth := api.Group("/auth")
+	auth.Use(middleware.SystemScope())
 	auth.POST("/login", h.Login)
 	auth.POST("/google", h.GoogleOAuth)
+	auth.GET("/oidc/providers", h.ListLoginOIDCProviders)
+	auth.GET("/oidc/:slug/authorize", h.OIDCAuthorize)
+	auth.POST("/oidc/:slug/callback", h.OIDCCallback)
 	auth.POST("/refresh", h.Refresh)
 	auth.POST("/logout", h.Logout)
 	auth.POST("/register", h.RegisterUser)
 	auth.GET("/tenant-lookup", h.TenantLookup)
-	auth.POST("/select-tenant", h.SelectTenantForOAuthUser)
+	auth.POST("/select-tenant", h.SelectTenantForOAuthUser, middleware.JWT(h.Config.JWTSecret, h.DB), middleware.SystemScope())
+	auth.POST("/switch-tenant", h.SwitchTenant, middleware.JWT(h.Config.JWTSecret, h.DB), middleware.SystemScope())
+	auth.POST("/forgot-password", h.ForgotPassword)
+	auth.POST("/reset-password", h.ResetPassword)
+	auth.POST("/verify-email", h.VerifyEmail)
+	auth.POST("/mfa/enroll", h.EnrollMFAWithChallenge)
+	auth.POST("/mfa/verify", h.VerifyMFA)
 
 	log.Info().Msg("Routes configured: /api/v1/auth/login")
 
 	// Current tenant info (requires JWT but not tenant context since it returns tenant info)
 	me := api.Group("/me")
-	me.Use(middleware.JWT(h.Config.JWTSecret))
+	me.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
+	// The account spans the user's tenants; every statement filters on the user
+	me.Use(middleware.SystemScope())
+	me.GET("", h.GetMe)
 	me.GET("/tenant", h.GetCurrentTenant)
+	me.GET("/memberships", h.ListMyMemberships)
+	me.GET("/sessions", h.ListMySessions)
+	me.DELETE("/sessions", h.RevokeMyOtherSessions)
+	me.DELETE("/sessions/:id", h.RevokeMySession)
+	me.POST("/verify-email", h.ResendVerification)
+	me.GET("/mfa", h.GetMyMFA)
+	me.POST("/mfa/enroll", h.EnrollMyMFA)
+	me.POST("/mfa/confirm", h.ConfirmMyMFA)
+	me.POST("/mfa/recovery-codes", h.RegenerateMyRecoveryCodes)
+	me.DELETE("/mfa", h.DisableMyMFA)
 
 	// Protected routes - each with explicit middleware
 	items := api.Group("/items")
-	items.Use(middleware.JWT(h.Config.JWTSecret))
+	items.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	items.Use(middleware.RequireTenant())
-	items.GET("", h.ListItems)
-	items.POST("", h.CreateItem)
-	items.GET("/:id", h.GetItem)
-	items.PUT("/:id", h.UpdateItem)
-	items.DELETE("/:id", h.DeleteItem)
+	items.Use(middleware.MeterRequests(h.Usage))
+	items.Use(middleware.RequireVerifiedEmail())
+	items.GET("", h.ListItems, perm(middleware.PermItemRead))
+	items.POST("", h.CreateItem, perm(middleware.PermItemWrite))
+	items.GET("/:id", h.GetItem, perm(middleware.PermItemRead))
+	items.PUT("/:id", h.UpdateItem, perm(middleware.PermItemWrite))
+	items.DELETE("/:id", h.DeleteItem, perm(middleware.PermItemWrite))
 
 	locations := api.Group("/locations")
-	locations.Use(middleware.JWT(h.Config.JWTSecret))
+	locations.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	locations.Use(middleware.RequireTenant())
-	locations.GET("", h.ListLocations)
-	locations.POST("", h.CreateLocation)
-	locations.GET("/:id", h.GetLocation)
-	locations.PUT("/:id", h.UpdateLocation)
-	locations.DELETE("/:id", h.DeleteLocation)
+	locations.Use(middleware.MeterRequests(h.Usage))
+	locations.Use(middleware.RequireVerifiedEmail())
+	locations.GET("", h.ListLocations, perm(middleware.PermLocationRead))
+	locations.POST("", h.CreateLocation, perm(middleware.PermLocationWrite))
+	locations.GET("/:id", h.GetLocation, perm(middleware.PermLocationRead))
+	locations.PUT("/:id", h.UpdateLocation, perm(middleware.PermLocationWrite))
+	locations.DELETE("/:id", h.DeleteLocation, perm(middleware.PermLocationWrite))
 
 	suppliers := api.Group("/suppliers")
-	suppliers.Use(middleware.JWT(h.Config.JWTSecret))
+	suppliers.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	suppliers.Use(middleware.RequireTenant())
-	suppliers.GET("", h.ListSuppliers)
-	suppliers.POST("", h.CreateSupplier)
-	suppliers.GET("/:id", h.GetSupplier)
-	suppliers.PUT("/:id", h.UpdateSupplier)
-	suppliers.DELETE("/:id", h.DeleteSupplier)
+	suppliers.Use(middleware.MeterRequests(h.Usage))
+	suppliers.Use(middleware.RequireVerifiedEmail())
+	suppliers.GET("", h.ListSuppliers, perm(middleware.PermSupplierRead))
+	suppliers.POST("", h.CreateSupplier, perm(middleware.PermSupplierWrite))
+	suppliers.GET("/scorecards", h.ListSupplierScorecards, perm(middleware.PermSupplierRead))
+	suppliers.GET("/:id", h.GetSupplier, perm(middleware.PermSupplierRead))
+	suppliers.PUT("/:id", h.UpdateSupplier, perm(middleware.PermSupplierWrite))
+	suppliers.DELETE("/:id", h.DeleteSupplier, perm(middleware.PermSupplierWrite))
+	suppliers.GET("/:id/items", h.ListSupplierItems, perm(middleware.PermSupplierRead))
+	suppliers.POST("/:id/items", h.CreateSupplierItem, perm(middleware.PermSupplierWrite))
+	suppliers.PUT("/:id/items/:supplier_item_id", h.UpdateSupplierItem, perm(middleware.PermSupplierWrite))
+	suppliers.DELETE("/:id/items/:supplier_item_id", h.DeleteSupplierItem, perm(middleware.PermSupplierWrite))
+	suppliers.GET("/:id/price", h.GetSupplierPrice, perm(middleware.PermSupplierRead))
+	suppliers.GET("/:id/price-list", h.ExportSupplierPriceList, perm(middleware.PermSupplierRead))
+	suppliers.POST("/:id/price-list", h.ImportSupplierPriceList, perm(middleware.PermSupplierWrite))
+	suppliers.GET("/:id/scorecard", h.GetSupplierScorecard, perm(middleware.PermSupplierRead))
 
 	categories := api.Group("/categories")
-	categories.Use(middleware.JWT(h.Config.JWTSecret))
+	categories.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	categories.Use(middleware.RequireTenant())
-	categories.GET("", h.ListCategories)
-	categories.POST("", h.CreateCategory)
-	categories.GET("/:id", h.GetCategory)
-	categories.PUT("/:id", h.UpdateCategory)
-	categories.DELETE("/:id", h.DeleteCategory)
+	categories.Use(middleware.MeterRequests(h.Usage))
+	categories.Use(middleware.RequireVerifiedEmail())
+	categories.GET("", h.ListCategories, perm(middleware.PermCategoryRead))
+	categories.POST("", h.CreateCategory, perm(middleware.PermCategoryWrite))
+	categories.GET("/:id", h.GetCategory, perm(middleware.PermCategoryRead))
+	categories.PUT("/:id", h.UpdateCategory, perm(middleware.PermCategoryWrite))
+	categories.DELETE("/:id", h.DeleteCategory, perm(middleware.PermCategoryWrite))
 
 	inventory := api.Group("/inventory")
-	inventory.Use(middleware.JWT(h.Config.JWTSecret))
+	inventory.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	inventory.Use(middleware.RequireTenant())
-	inventory.GET("", h.GetInventory)
-	inventory.GET("/:item_id/locations", h.GetItemLocations)
-	inventory.GET("/movements", h.GetMovements)
+	inventory.Use(middleware.MeterRequests(h.Usage))
+	inventory.Use(middleware.ResolveLocations(h.DB))
+	inventory.Use(middleware.RequireVerifiedEmail())
+	inventory.GET("", h.GetInventory, perm(middleware.PermInventoryRead))
+	inventory.GET("/:item_id/locations", h.GetItemLocations, perm(middleware.PermInventoryRead))
+	inventory.GET("/movements", h.GetMovements, perm(middleware.PermInventoryRead))
+	inventory.GET("/export", h.ExportInventory, perm(middleware.PermInventoryRead))
+	inventory.GET("/valuation", h.GetInventoryValuation, perm(middleware.PermInventoryRead))
+
+	dashboard := api.Group("/dashboard")
+	dashboard.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
+	dashboard.Use(middleware.RequireTenant())
+	dashboard.Use(middleware.MeterRequests(h.Usage))
+	dashboard.Use(middleware.ResolveLocations(h.DB))
+	dashboard.Use(middleware.RequireVerifiedEmail())
+	dashboard.GET("", h.GetDashboard, perm(middleware.PermDashboardRead))
+
+	reports := api.Group("/reports")
+	reports.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
+	reports.Use(middleware.RequireTenant())
+	reports.Use(middleware.MeterRequests(h.Usage))
+	reports.Use(middleware.ResolveLocations(h.DB))
+	reports.Use(middleware.RequireVerifiedEmail())
+	reports.GET("/slow-movers", h.GetSlowMoversReport, perm(middleware.PermReportRead))
+	reports.GET("/dead-stock", h.GetDeadStockReport, perm(middleware.PermReportRead))
+	reports.GET("/turnover", h.GetTurnoverReport, perm(middleware.PermReportRead))
 
 	purchaseOrders := api.Group("/purchase-orders")
-	purchaseOrders.Use(middleware.JWT(h.Config.JWTSecret))
+	purchaseOrders.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	purchaseOrders.Use(middleware.RequireTenant())
-	purchaseOrders.GET("", h.ListPurchaseOrders)
-	purchaseOrders.POST("", h.CreatePurchaseOrder)
-	purchaseOrders.GET("/:id", h.GetPurchaseOrder)
-	purchaseOrders.PUT("/:id", h.UpdatePurchaseOrder)
-	purchaseOrders.DELETE("/:id", h.DeletePurchaseOrder)
-	purchaseOrders.POST("/:id/approve", h.ApprovePurchaseOrder)
-	purchaseOrders.POST("/:id/receive", h.ReceivePurchaseOrder)
-	purchaseOrders.POST("/:id/close", h.ClosePurchaseOrder)
+	purchaseOrders.Use(middleware.MeterRequests(h.Usage))
+	purchaseOrders.Use(middleware.ResolveLocations(h.DB))
+	purchaseOrders.Use(middleware.RequireVerifiedEmail())
+	purchaseOrders.GET("", h.ListPurchaseOrders, perm(middleware.PermPORead))
+	purchaseOrders.POST("", h.CreatePurchaseOrder, perm(middleware.PermPOWrite))
+	purchaseOrders.GET("/:id", h.GetPurchaseOrder, perm(middleware.PermPORead))
+	purchaseOrders.PUT("/:id", h.UpdatePurchaseOrder, perm(middleware.PermPOWrite))
+	purchaseOrders.DELETE("/:id", h.DeletePurchaseOrder, perm(middleware.PermPOWrite))
+	purchaseOrders.POST("/:id/approve", h.ApprovePurchaseOrder, perm(middleware.PermPOApprove))
+	purchaseOrders.GET("/:id/approvals", h.ListPurchaseOrderApprovals, perm(middleware.PermPORead))
+	purchaseOrders.POST("/:id/receive", h.ReceivePurchaseOrder, perm(middleware.PermPOReceive))
+	purchaseOrders.POST("/:id/returns", h.ReturnPurchaseOrder, perm(middleware.PermPOReceive))
+	purchaseOrders.POST("/:id/close", h.ClosePurchaseOrder, perm(middleware.PermPOClose))
 
 	transfers := api.Group("/transfers")
-	transfers.Use(middleware.JWT(h.Config.JWTSecret))
+	transfers.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	transfers.Use(middleware.RequireTenant())
-	transfers.GET("", h.ListTransfers)
-	transfers.POST("", h.CreateTransfer)
-	transfers.GET("/:id", h.GetTransfer)
-	transfers.PUT("/:id", h.UpdateTransfer)
-	transfers.DELETE("/:id", h.DeleteTransfer)
-	transfers.POST("/:id/approve", h.ApproveTransfer)
-	transfers.POST("/:id/ship", h.ShipTransfer)
-	transfers.POST("/:id/receive", h.ReceiveTransfer)
+	transfers.Use(middleware.MeterRequests(h.Usage))
+	transfers.Use(middleware.ResolveLocations(h.DB))
+	transfers.Use(middleware.RequireVerifiedEmail())
+	transfers.GET("", h.ListTransfers, perm(middleware.PermTransferRead))
+	transfers.POST("", h.CreateTransfer, perm(middleware.PermTransferWrite))
+	transfers.GET("/:id", h.GetTransfer, perm(middleware.PermTransferRead))
+	transfers.PUT("/:id", h.UpdateTransfer, perm(middleware.PermTransferWrite))
+	transfers.DELETE("/:id", h.DeleteTransfer, perm(middleware.PermTransferWrite))
+	transfers.POST("/:id/approve", h.ApproveTransfer, perm(middleware.PermTransferApprove))
+	transfers.POST("/:id/ship", h.ShipTransfer, perm(middleware.PermTransferShip))
+	transfers.POST("/:id/receive", h.ReceiveTransfer, perm(middleware.PermTransferReceive))
 
 	adjustments := api.Group("/adjustments")
-	adjustments.Use(middleware.JWT(h.Config.JWTSecret))
+	adjustments.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	adjustments.Use(middleware.RequireTenant())
-	adjustments.GET("", h.ListAdjustments)
-	adjustments.POST("", h.CreateAdjustment)
-	adjustments.GET("/:id", h.GetAdjustment)
-	adjustments.PUT("/:id", h.UpdateAdjustment)
-	adjustments.DELETE("/:id", h.DeleteAdjustment)
-	adjustments.POST("/:id/approve", h.ApproveAdjustment)
+	adjustments.Use(middleware.MeterRequests(h.Usage))
+	adjustments.Use(middleware.ResolveLocations(h.DB))
+	adjustments.Use(middleware.RequireVerifiedEmail())
+	adjustments.GET("", h.ListAdjustments, perm(middleware.PermAdjustmentRead))
+	adjustments.POST("", h.CreateAdjustment, perm(middleware.PermAdjustmentWrite))
+	adjustments.GET("/:id", h.GetAdjustment, perm(middleware.PermAdjustmentRead))
+	adjustments.PUT("/:id", h.UpdateAdjustment, perm(middleware.PermAdjustmentWrite))
+	adjustments.DELETE("/:id", h.DeleteAdjustment, perm(middleware.PermAdjustmentWrite))
+	adjustments.POST("/:id/approve", h.ApproveAdjustment, perm(middleware.PermAdjustmentApprove))
+	adjustments.GET("/:id/approvals", h.ListAdjustmentApprovals, perm(middleware.PermAdjustmentRead))
 
 	// Goods Receipts
 	receipts := api.Group("/receipts")
-	receipts.Use(middleware.JWT(h.Config.JWTSecret))
+	receipts.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	receipts.Use(middleware.RequireTenant())
-	receipts.GET("", h.ListReceipts)
-	receipts.POST("", h.CreateReceipt)
-	receipts.GET("/:id", h.GetReceipt)
-	receipts.PUT("/:id", h.UpdateReceipt)
-	receipts.DELETE("/:id", h.DeleteReceipt)
-	receipts.POST("/:id/approve", h.ApproveReceipt)
-	receipts.POST("/:id/post", h.PostReceipt)
-	receipts.POST("/:id/close", h.CloseReceipt)
-	receipts.GET("/:id/lines", h.ListReceiptLines)
-	receipts.POST("/:id/lines", h.AddReceiptLine)
-	receipts.PUT("/:id/lines/:line_id", h.UpdateReceiptLine)
-	receipts.DELETE("/:id/lines/:line_id", h.DeleteReceiptLine)
-	receipts.POST("/from-po", h.CreateReceiptFromPO)
+	receipts.Use(middleware.MeterRequests(h.Usage))
+	receipts.Use(middleware.ResolveLocations(h.DB))
+	receipts.Use(middleware.RequireVerifiedEmail())
+	receipts.GET("", h.ListReceipts, perm(middleware.PermReceiptRead))
+	receipts.POST("", h.CreateReceipt, perm(middleware.PermReceiptWrite))
+	receipts.GET("/:id", h.GetReceipt, perm(middleware.PermReceiptRead))
+	receipts.PUT("/:id", h.UpdateReceipt, perm(middleware.PermReceiptWrite))
+	receipts.DELETE("/:id", h.DeleteReceipt, perm(middleware.PermReceiptWrite))
+	receipts.POST("/:id/approve", h.ApproveReceipt, perm(middleware.PermReceiptApprove))
+	receipts.POST("/:id/post", h.PostReceipt, perm(middleware.PermReceiptPost))
+	receipts.POST("/:id/close", h.CloseReceipt, perm(middleware.PermReceiptClose))
+	receipts.GET("/:id/lines", h.ListReceiptLines, perm(middleware.PermReceiptRead))
+	receipts.POST("/:id/lines", h.AddReceiptLine, perm(middleware.PermReceiptWrite))
+	receipts.PUT("/:id/lines/:line_id", h.UpdateReceiptLine, perm(middleware.PermReceiptWrite))
+	receipts.DELETE("/:id/lines/:line_id", h.DeleteReceiptLine, perm(middleware.PermReceiptWrite))
+	receipts.POST("/from-po", h.CreateReceiptFromPO, perm(middleware.PermReceiptWrite))
+	receipts.GET("/:id/charges", h.ListReceiptCharges, perm(middleware.PermReceiptRead))
+	receipts.POST("/:id/charges", h.AddReceiptCharge, perm(middleware.PermReceiptWrite))
+	receipts.DELETE("/:id/charges/:charge_id", h.DeleteReceiptCharge, perm(middleware.PermReceiptWrite))
 
 	// Stock counting batches and lines
 	counts := api.Group("/counts")
-	counts.Use(middleware.JWT(h.Config.JWTSecret))
+	counts.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	counts.Use(middleware.RequireTenant())
-	counts.GET("", h.ListCountBatches)
-	counts.POST("", h.CreateCountBatch)
-	counts.PUT("/:id", h.UpdateCountBatch)
-	counts.DELETE("/:id", h.DeleteCountBatch)
-	counts.GET("/:batch_id/lines", h.ListCountLines)
-	counts.POST("/:batch_id/lines", h.AddCountLine)
-	counts.PUT("/:batch_id/lines/:line_id", h.UpdateCountLine)
-	counts.DELETE("/:batch_id/lines/:line_id", h.DeleteCountLine)
+	counts.Use(middleware.MeterRequests(h.Usage))
+	counts.Use(middleware.ResolveLocations(h.DB))
+	counts.Use(middleware.RequireVerifiedEmail())
+	counts.GET("", h.ListCountBatches, perm(middleware.PermCountRead))
+	counts.POST("", h.CreateCountBatch, perm(middleware.PermCountWrite))
+	counts.PUT("/:id", h.UpdateCountBatch, perm(middleware.PermCountWrite))
+	counts.DELETE("/:id", h.DeleteCountBatch, perm(middleware.PermCountWrite))
+	counts.GET("/:batch_id/lines", h.ListCountLines, perm(middleware.PermCountRead))
+	counts.POST("/:batch_id/lines", h.AddCountLine, perm(middleware.PermCountWrite))
+	counts.PUT("/:batch_id/lines/:line_id", h.UpdateCountLine, perm(middleware.PermCountWrite))
+	counts.DELETE("/:batch_id/lines/:line_id", h.DeleteCountLine, perm(middleware.PermCountWrite))
 
 	users := api.Group("/users")
-	users.Use(middleware.JWT(h.Config.JWTSecret))
+	users.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	users.Use(middleware.RequireTenant())
-	users.Use(middleware.RequireRole("ADMIN"))
-	users.GET("", h.ListUsers)
-	users.POST("", h.CreateUser)
-	users.GET("/:id", h.GetUser)
-	users.PUT("/:id", h.UpdateUser)
-	users.POST("/:id/disable", h.DisableUser)
+	users.Use(middleware.MeterRequests(h.Usage))
+	users.Use(middleware.RequireVerifiedEmail())
+	users.GET("", h.ListUsers, perm(middleware.PermUserRead))
+	users.POST("", h.CreateUser, perm(middleware.PermUserWrite))
+	users.GET("/:id", h.GetUser, perm(middleware.PermUserRead))
+	users.PUT("/:id", h.UpdateUser, perm(middleware.PermUserWrite))
+	users.POST("/:id/disable", h.DisableUser, perm(middleware.PermUserWrite))
+	users.DELETE("/:id/mfa", h.ResetUserMFA, perm(middleware.PermUserWrite))
+	users.POST("/:id/unlock", h.UnlockUser, perm(middleware.PermUserWrite))
+	users.GET("/:id/locations", h.GetUserLocations, perm(middleware.PermUserRead))
+	users.PUT("/:id/locations", h.SetUserLocations, perm(middleware.PermUserWrite))
+
+	invitations := api.Group("/invitations")
+	invitations.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
+	invitations.Use(middleware.RequireTenant())
+	invitations.Use(middleware.MeterRequests(h.Usage))
+	invitations.Use(middleware.RequireVerifiedEmail())
+	invitations.GET("", h.ListInvitations, perm(middleware.PermUserRead))
+	invitations.POST("", h.CreateInvitation, perm(middleware.PermUserWrite))
+	invitations.POST("/:id/resend", h.ResendInvitation, perm(middleware.PermUserWrite))
+	invitations.DELETE("/:id", h.RevokeInvitation, perm(middleware.PermUserWrite))
+
+	roles := api.Group("/roles")
+	roles.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
+	roles.Use(middleware.RequireTenant())
+	roles.Use(middleware.MeterRequests(h.Usage))
+	roles.Use(middleware.RequireVerifiedEmail())
+	roles.GET("", h.ListRoles, perm(middleware.PermUserRead))
+	roles.GET("/permissions", h.ListPermissions, perm(middleware.PermUserRead))
+	roles.POST("", h.CreateRole, perm(middleware.PermRoleWrite))
+	roles.PUT("/:id", h.UpdateRole, perm(middleware.PermRoleWrite))
+	roles.DELETE("/:id", h.DeleteRole, perm(middleware.PermRoleWrite))
+
+	oidcProviders := api.Group("/oidc-providers")
+	oidcProviders.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
+	oidcProviders.Use(middleware.RequireTenant())
+	oidcProviders.Use(middleware.MeterRequests(h.Usage))
+	oidcProviders.Use(middleware.RequireVerifiedEmail())
+	oidcProviders.GET("", h.ListOIDCProviders, perm(middleware.PermSettingsRead))
+	oidcProviders.POST("", h.CreateOIDCProvider, perm(middleware.PermSettingsWrite))
+	oidcProviders.PUT("/:id", h.UpdateOIDCProvider, perm(middleware.PermSettingsWrite))
+	oidcProviders.DELETE("/:id", h.DeleteOIDCProvider, perm(middleware.PermSettingsWrite))
+
+	apiKeys := api.Group("/api-keys")
+	apiKeys.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
+	apiKeys.Use(middleware.RequireTenant())
+	apiKeys.Use(middleware.MeterRequests(h.Usage))
+	apiKeys.Use(middleware.RequireVerifiedEmail())
+	apiKeys.GET("", h.ListAPIKeys, perm(middleware.PermAPIKeyWrite))
+	apiKeys.POST("", h.CreateAPIKey, perm(middleware.PermAPIKeyWrite))
+	apiKeys.POST("/:id/rotate", h.RotateAPIKey, perm(middleware.PermAPIKeyWrite))
+	apiKeys.DELETE("/:id", h.RevokeAPIKey, perm(middleware.PermAPIKeyWrite))
+
+	approvals := api.Group("/approvals")
+	approvals.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
+	approvals.Use(middleware.RequireTenant())
+	approvals.Use(middleware.MeterRequests(h.Usage))
+	approvals.Use(middleware.ResolveLocations(h.DB))
+	approvals.Use(middleware.RequireVerifiedEmail())
+	approvals.GET("/pending", h.ListPendingApprovals, middleware.RequireAnyPermission(middleware.PermPOApprove, middleware.PermAdjustmentApprove))
+	approvals.GET("/rules", h.ListApprovalRules, perm(middleware.PermSettingsRead))
+	approvals.POST("/rules", h.CreateApprovalRule, perm(middleware.PermSettingsWrite))
+	approvals.PUT("/rules/:id", h.UpdateApprovalRule, perm(middleware.PermSettingsWrite))
+	approvals.DELETE("/rules/:id", h.DeleteApprovalRule, perm(middleware.PermSettingsWrite))
+
+	settings := api.Group("/settings")
+	settings.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
+	settings.Use(middleware.RequireTenant())
+	settings.Use(middleware.MeterRequests(h.Usage))
+	settings.Use(middleware.RequireVerifiedEmail())
+	settings.GET("/costing", h.GetCostingSettings, perm(middleware.PermSettingsRead))
+	settings.PUT("/costing", h.UpdateCostingSettings, perm(middleware.PermSettingsWrite))
+	settings.GET("/security", h.GetSecuritySettings, perm(middleware.PermSettingsRead))
+	settings.PUT("/security", h.UpdateSecuritySettings, perm(middleware.PermSettingsWrite))
 
 	audit := api.Group("/audit")
-	audit.Use(middleware.JWT(h.Config.JWTSecret))
+	audit.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
 	audit.Use(middleware.RequireTenant())
-	audit.GET("", h.GetAuditLogs)
+	audit.Use(middleware.MeterRequests(h.Usage))
+	audit.Use(middleware.RequireVerifiedEmail())
+	audit.GET("", h.GetAuditLogs, perm(middleware.PermAuditRead))
+	audit.GET("/verify", h.VerifyAuditChains, perm(middleware.PermAuditVerify))
+	audit.GET("/checkpoints", h.ExportAuditCheckpoints, perm(middleware.PermAuditVerify))
+	audit.POST("/checkpoints", h.CreateAuditCheckpoint, perm(middleware.PermAuditVerify))
 
 	// System admin routes (no tenant context required)
 	systemAdmin := api.Group("/system")
-	systemAdmin.Use(middleware.JWT(h.Config.JWTSecret))
-	systemAdmin.Use(middleware.RequireRole("SYSTEM_ADMIN"))
+	systemAdmin.Use(middleware.JWT(h.Config.JWTSecret, h.DB))
+	systemAdmin.Use(middleware.SystemScope())
+	systemAdmin.GET("/tenants", h.ListTenants, perm(middleware.PermSystemTenants))
+	systemAdmin.POST("/tenants", h.CreateTenant, perm(middleware.PermSystemTenants))
+	systemAdmin.GET("/tenants/:id", h.GetTenant, perm(middleware.PermSystemTenants))
+	systemAdmin.PUT("/tenants/:id", h.UpdateTenant, perm(middleware.PermSystemTenants))
+	systemAdmin.DELETE("/tenants/:id", h.DeactivateTenant, perm(middleware.PermSystemTenants))
+	systemAdmin.PUT("/tenants/:id/plan", h.AssignTenantPlan, perm(middleware.PermSystemTenants))
+	systemAdmin.GET("/tenants/:id/usage", h.GetTenantUsage, perm(middleware.PermSystemTenants))
+	systemAdmin.POST("/tenants/:id/export", h.ExportTenant, perm(middleware.PermSystemTenants))
+	systemAdmin.GET("/tenants/:id/exports", h.ListTenantExports, perm(middleware.PermSystemTenants))
+	systemAdmin.GET("/tenants/:id/exports/:exportId", h.GetTenantExport, perm(middleware.PermSystemTenants))
+	systemAdmin.GET("/tenants/:id/exports/:exportId/download", h.DownloadTenantExport, perm(middleware.PermSystemTenants))
+	systemAdmin.GET("/tenants/:id/purge", h.GetTenantPurge, perm(middleware.PermSystemTenants))
+	systemAdmin.POST("/tenants/:id/purge", h.ScheduleTenantPurge, perm(middleware.PermSystemTenants))
+	systemAdmin.DELETE("/tenants/:id/purge", h.CancelTenantPurge, perm(middleware.PermSystemTenants))
+	systemAdmin.GET("/audit", h.GetSystemAuditLogs, perm(middleware.PermSystemTenants))
+	systemAdmin.GET("/plans", h.ListPlans, perm(middleware.PermSystemTenants))
+	systemAdmin.PUT("/plans/:code", h.SavePlan, perm(middleware.PermSystemTenants))
+	systemAdmin.GET("/oidc-providers", h.ListGlobalOIDCProviders, perm(middleware.PermSystemTenants))
+	systemAdmin.POST("/oidc-providers", h.CreateGlobalOIDCProvider, perm(middleware.PermSystemTenants))
+	systemAdmin.PUT("/oidc-providers/:id", h.UpdateGlobalOIDCProvider, perm(middleware.PermSystemTenants))
+	systemAdmin.DELETE("/oidc-providers/:id", h.DeleteGlobalOIDCProvider, perm(middleware.PermSystemTenants))
+
+}
+
+// startSnapshotScheduler takes the nightly stock snapshots in the background:
+// once at startup for the previous day (so a missed run is caught up) and then
+// daily at cfg.SnapshotHour UTC.
+func startSnapshotScheduler(db *sql.DB, cfg *config.Config) {
+	if cfg.SnapshotHour < 0 {
+		log.Info().Msg("Stock snapshots disabled")
+		return
+	}
+
+	snapshots := services.NewSnapshotService(db)
+	run := func() {
+		day := services.SnapshotDay(time.Now()).AddDate(0, 0, -1)
+		var n int64
+		// Every replica schedules the job; the first to take the lock runs it
+		ran, err := services.RunExclusive(services.SystemScope(context.Background()), db, services.JobStockSnapshots, func(ctx context.Context) error {
+			var err error
+			n, err = snapshots.TakeSnapshots(ctx, day)
+			return err
+		})
+		if err != nil {
+			log.Error().Err(err).Time("day", day).Msg("Failed to take stock snapshots")
+			return
+		}
+		if !ran {
+			log.Info().Time("day", day).Msg("Stock snapshots already being taken by another instance")
+			return
+		}
+		log.Info().Time("day", day).Int64("rows", n).Msg("Stock snapshots taken")
+	}
 
-	systemAdmin.GET("/tenants", h.ListTenants)
-	systemAdmin.POST("/tenants", h.CreateTenant)
-	systemAdmin.GET("/tenants/:id", h.GetTenant)
-	systemAdmin.PUT("/tenants/:id", h.UpdateTenant)
-	systemAdmin.DELETE("/tenants/:id", h.DeactivateTenant)
+	go func() {
+		run()
+		for {
+			now := time.Now().UTC()
+			next := time.Date(now.Year(), now.Month(), now.Day(), cfg.SnapshotHour%24, 0, 0, 0, time.UTC)
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+			time.Sleep(time.Until(next))
+			run()
+		}
+	}()
+}
+
+// startCheckpointScheduler signs the head of every tenant's audit and stock
+// movement chains daily at cfg.AuditCheckpointHour UTC.
+func startCheckpointScheduler(db *sql.DB, cfg *config.Config) {
+	if cfg.AuditCheckpointHour < 0 {
+		log.Info().Msg("Audit checkpoints disabled")
+		return
+	}
 
+	chains := services.NewHashChainService(db)
+	go func() {
+		for {
+			now := time.Now().UTC()
+			next := time.Date(now.Year(), now.Month(), now.Day(), cfg.AuditCheckpointHour%24, 0, 0, 0, time.UTC)
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+			time.Sleep(time.Until(next))
+
+			var n int
+			// Every replica schedules the job; the first to take the lock runs it
+			ran, err := services.RunExclusive(services.SystemScope(context.Background()), db, services.JobAuditCheckpoints, func(ctx context.Context) error {
+				var err error
+				n, err = chains.CreateCheckpoints(ctx, []byte(cfg.AuditSigningKey), "")
+				return err
+			})
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create audit checkpoints")
+				continue
+			}
+			if !ran {
+				log.Info().Msg("Audit checkpoints already being created by another instance")
+				continue
+			}
+			log.Info().Int("checkpoints", n).Msg("Audit checkpoints created")
+		}
+	}()
+}
+
+// startUsageFlusher writes metered API requests to the database every minute
+// and refreshes which tenants have used up their monthly allowance.
+func startUsageFlusher(usage *services.UsageMeter) {
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := usage.Flush(services.SystemScope(context.Background())); err != nil {
+				log.Error().Err(err).Msg("Failed to record API usage")
+			}
+		}
+	}()
+}
+
+// startTenantDataJobs produces queued tenant exports, including ones left
+// unfinished by a restart, and purges tenants whose grace period has ended,
+// every minute.
+func startTenantDataJobs(data *services.TenantDataService) {
+	run := func() {
+		ctx := services.SystemScope(context.Background())
+		if n, err := data.RunExports(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to run tenant exports")
+		} else if n > 0 {
+			log.Info().Int("exports", n).Msg("Tenant exports finished")
+		}
+		if n, err := data.PurgeDue(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to purge tenants")
+		} else if n > 0 {
+			log.Info().Int("tenants", n).Msg("Tenants purged")
+		}
+	}
+
+	go func() {
+		run()
+		for range time.Tick(time.Minute) {
+			run()
+		}
+	}()
 }
 
 func startServer(e *echo.Echo, cfg *config.Config) {
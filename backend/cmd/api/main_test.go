@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"inventory/internal/config"
+	"inventory/internal/handlers"
+	"inventory/internal/middleware"
+	"inventory/internal/services"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A manager restricted to one of two stocked locations only sees that one on
+// the dashboard and in reports
+func TestLocationRestrictedRoutes(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := services.OpenDB(dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := services.SystemScope(context.Background())
+	suffix := uuid.NewString()[:8]
+	tenantID, userID, itemID := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	own, other := uuid.NewString(), uuid.NewString()
+	for _, seed := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO tenants (id, name, slug, is_active) VALUES ($1, $2, $2, true)`, []interface{}{tenantID, "loc-" + suffix}},
+		{`INSERT INTO locations (id, tenant_id, code, name) VALUES ($1, $3, $4, $4), ($2, $3, $5, $5)`, []interface{}{own, other, tenantID, "OWN-" + suffix, "OTHER-" + suffix}},
+		{`INSERT INTO users (id, tenant_id, email, name, role) VALUES ($1, $2, $3, 'Manager', 'MANAGER')`, []interface{}{userID, tenantID, "manager-" + suffix + "@example.com"}},
+		{`INSERT INTO tenant_memberships (user_id, tenant_id, role, location_restricted) VALUES ($1, $2, 'MANAGER', true)`, []interface{}{userID, tenantID}},
+		{`INSERT INTO user_locations (user_id, location_id, tenant_id) VALUES ($1, $2, $3)`, []interface{}{userID, own, tenantID}},
+		{`INSERT INTO items (id, tenant_id, sku, name, uom, cost) VALUES ($1, $2, $3, 'Widget', 'EA', 2)`, []interface{}{itemID, tenantID, "LOC-" + suffix}},
+		{`INSERT INTO inventory_levels (tenant_id, item_id, location_id, on_hand) VALUES ($1, $2, $3, 5), ($1, $2, $4, 7)`, []interface{}{tenantID, itemID, own, other}},
+	} {
+		_, err := db.ExecContext(ctx, seed.query, seed.args...)
+		require.NoError(t, err, seed.query)
+	}
+	t.Cleanup(func() {
+		db.ExecContext(ctx, `DELETE FROM inventory_levels WHERE item_id = $1`, itemID)
+		db.ExecContext(ctx, `DELETE FROM items WHERE id = $1`, itemID)
+		db.ExecContext(ctx, `DELETE FROM user_locations WHERE user_id = $1`, userID)
+		db.ExecContext(ctx, `DELETE FROM tenant_memberships WHERE user_id = $1`, userID)
+		db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+		db.ExecContext(ctx, `DELETE FROM locations WHERE id IN ($1, $2)`, own, other)
+		db.ExecContext(ctx, `DELETE FROM tenants WHERE id = $1`, tenantID)
+	})
+
+	cfg := &config.Config{JWTSecret: "test-secret"}
+	e := echo.New()
+	e.Validator = &CustomValidator{validator: validator.New()}
+	setupRoutes(e, handlers.New(db, cfg))
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &middleware.Claims{
+		UserID:   userID,
+		TenantID: tenantID,
+		Role:     middleware.RoleManager,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}).SignedString([]byte(cfg.JWTSecret))
+	require.NoError(t, err)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = "localhost"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("dashboard", func(t *testing.T) {
+		rec := get("/api/v1/dashboard")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var d struct {
+			TotalStockValue decimal.Decimal `json:"total_stock_value"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &d))
+		assert.True(t, d.TotalStockValue.Equal(decimal.NewFromInt(10)), d.TotalStockValue.String())
+	})
+
+	t.Run("report", func(t *testing.T) {
+		rec := get("/api/v1/reports/turnover")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var report struct {
+			Rows []struct {
+				Location struct {
+					ID string `json:"id"`
+				} `json:"location"`
+			} `json:"rows"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		require.Len(t, report.Rows, 1)
+		assert.Equal(t, own, report.Rows[0].Location.ID)
+	})
+
+	t.Run("report of another location", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, get("/api/v1/reports/turnover?location_id="+other).Code)
+	})
+
+	// Losing the last assignment, as when its location is deleted, does not
+	// lift the restriction
+	t.Run("restricted without locations", func(t *testing.T) {
+		_, err := db.ExecContext(ctx, `DELETE FROM user_locations WHERE user_id = $1`, userID)
+		require.NoError(t, err)
+
+		rec := get("/api/v1/reports/turnover")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var report struct {
+			Rows []json.RawMessage `json:"rows"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		assert.Empty(t, report.Rows)
+	})
+}
@@ -6,9 +6,9 @@ import (
 	"log"
 
 	"inventory/internal/config"
+	"inventory/internal/services"
 
 	"github.com/google/uuid"
-	_ "github.com/lib/pq"
 )
 
 func main() {
@@ -17,7 +17,7 @@ func main() {
 		log.Fatal("Failed to load config:", err)
 	}
 
-	db, err := sql.Open("postgres", cfg.DatabaseURL)
+	db, err := services.OpenSystemDB(cfg.DatabaseURL)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
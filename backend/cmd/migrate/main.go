@@ -5,9 +5,9 @@ import (
 	"database/sql"
 	"fmt"
 	"inventory/internal/config"
+	"inventory/internal/services"
 	"log"
 
-	_ "github.com/lib/pq"
 )
 
 func main() {
@@ -16,7 +16,7 @@ func main() {
 		log.Fatal("Failed to load configuration:", err)
 	}
 
-	db, err := sql.Open("postgres", cfg.DatabaseURL)
+	db, err := services.OpenSystemDB(cfg.DatabaseURL)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
@@ -412,6 +412,95 @@ func createSchema(ctx context.Context, db *sql.DB) error {
 		return fmt.Errorf("failed to migrate user OAuth fields: %w", err)
 	}
 
+	if err := migrateSupplierCatalog(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate supplier catalog: %w", err)
+	}
+
+	if err := migrateSupplierPerformance(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate supplier performance tracking: %w", err)
+	}
+
+	if err := migrateLandedCosts(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate landed costs: %w", err)
+	}
+
+	if err := migrateCostLayers(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate cost layers: %w", err)
+	}
+
+	if err := migrateStockSnapshots(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate stock snapshots: %w", err)
+	}
+
+	if err := migrateAuditTrail(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate audit trail: %w", err)
+	}
+
+	if err := migrateHashChains(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate hash chains: %w", err)
+	}
+
+	if err := migrateUserManagement(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate user management: %w", err)
+	}
+
+	if err := migrateInvitations(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate invitations: %w", err)
+	}
+
+	if err := migrateSessions(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate sessions: %w", err)
+	}
+
+	if err := migrateAccountTokens(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate account tokens: %w", err)
+	}
+
+	if err := migrateMFA(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate MFA: %w", err)
+	}
+
+	if err := migrateLoginThrottles(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate login throttles: %w", err)
+	}
+
+	if err := migrateRoles(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate roles: %w", err)
+	}
+
+	if err := migrateUserLocations(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate user locations: %w", err)
+	}
+
+	if err := migrateApprovals(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate approvals: %w", err)
+	}
+
+	if err := migrateAPIKeys(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate API keys: %w", err)
+	}
+
+	if err := migrateOIDCProviders(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate OIDC providers: %w", err)
+	}
+
+	if err := migrateMemberships(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate memberships: %w", err)
+	}
+
+	if err := migratePlans(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate plans: %w", err)
+	}
+
+	if err := migrateTenantLifecycle(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate tenant lifecycle: %w", err)
+	}
+
+	// Last, so it covers every table with a tenant_id
+	if err := migrateRowLevelSecurity(ctx, db); err != nil {
+		return fmt.Errorf("failed to migrate row level security: %w", err)
+	}
+
 	return nil
 }
 
@@ -551,3 +640,955 @@ func migrateUserOAuth(ctx context.Context, db *sql.DB) error {
 	log.Println("User OAuth migration completed")
 	return nil
 }
+
+func migrateSupplierCatalog(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating supplier catalog and price lists...")
+
+	queries := []string{
+		// Items a supplier can deliver, with the supplier's own ordering terms
+		`CREATE TABLE IF NOT EXISTS supplier_items (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			supplier_id UUID NOT NULL REFERENCES suppliers(id) ON DELETE CASCADE,
+			item_id UUID NOT NULL REFERENCES items(id),
+			supplier_sku VARCHAR(255),
+			pack_size INTEGER NOT NULL DEFAULT 1 CHECK (pack_size > 0),
+			moq INTEGER NOT NULL DEFAULT 1 CHECK (moq > 0),
+			lead_time_days INTEGER NOT NULL DEFAULT 0 CHECK (lead_time_days >= 0),
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			UNIQUE(supplier_id, item_id)
+		)`,
+
+		// Quantity-tiered unit costs with validity windows
+		`CREATE TABLE IF NOT EXISTS supplier_prices (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			supplier_item_id UUID NOT NULL REFERENCES supplier_items(id) ON DELETE CASCADE,
+			min_qty INTEGER NOT NULL DEFAULT 1 CHECK (min_qty > 0),
+			unit_cost NUMERIC(10,2) NOT NULL CHECK (unit_cost >= 0),
+			valid_from DATE NOT NULL DEFAULT CURRENT_DATE,
+			valid_to DATE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			CHECK (valid_to IS NULL OR valid_to >= valid_from)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_supplier_items_tenant_id ON supplier_items(tenant_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_supplier_items_item ON supplier_items(item_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_supplier_prices_supplier_item ON supplier_prices(supplier_item_id, min_qty)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Supplier catalog migration completed")
+	return nil
+}
+
+func migrateSupplierPerformance(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating supplier performance tracking...")
+
+	queries := []string{
+		// Link receipts to the purchase order they fulfil so delivery dates can be measured
+		`ALTER TABLE goods_receipts ADD COLUMN IF NOT EXISTS purchase_order_id UUID REFERENCES purchase_orders(id)`,
+		`CREATE INDEX IF NOT EXISTS idx_goods_receipts_purchase_order ON goods_receipts(purchase_order_id) WHERE purchase_order_id IS NOT NULL`,
+
+		// Quantities sent back to the supplier, issued from stock as PO_RETURN movements
+		`ALTER TABLE purchase_order_lines ADD COLUMN IF NOT EXISTS qty_returned INTEGER NOT NULL DEFAULT 0 CHECK (qty_returned >= 0)`,
+		`ALTER TABLE stock_movements DROP CONSTRAINT IF EXISTS stock_movements_reason_check`,
+		`ALTER TABLE stock_movements ADD CONSTRAINT stock_movements_reason_check
+			CHECK (reason IN ('PO_RECEIPT', 'PO_RETURN', 'ADJUSTMENT', 'TRANSFER_OUT', 'TRANSFER_IN', 'COUNT'))`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Supplier performance migration completed")
+	return nil
+}
+
+func migrateLandedCosts(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating landed costs...")
+
+	queries := []string{
+		// Freight, duty, insurance and other charges attached to a goods receipt
+		`CREATE TABLE IF NOT EXISTS receipt_charges (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			receipt_id UUID NOT NULL REFERENCES goods_receipts(id) ON DELETE CASCADE,
+			charge_type VARCHAR(50) NOT NULL CHECK (charge_type IN ('FREIGHT', 'DUTY', 'INSURANCE', 'OTHER')),
+			description TEXT,
+			amount NUMERIC(12,2) NOT NULL CHECK (amount > 0),
+			allocation_method VARCHAR(20) NOT NULL DEFAULT 'VALUE' CHECK (allocation_method IN ('VALUE', 'QTY', 'WEIGHT')),
+			posted_at TIMESTAMP WITH TIME ZONE,
+			created_by UUID REFERENCES users(id),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		// Share of each charge assigned to a receipt line when it was posted
+		`CREATE TABLE IF NOT EXISTS receipt_charge_allocations (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			charge_id UUID NOT NULL REFERENCES receipt_charges(id) ON DELETE CASCADE,
+			receipt_line_id UUID NOT NULL REFERENCES goods_receipt_lines(id) ON DELETE CASCADE,
+			amount NUMERIC(12,2) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			UNIQUE(charge_id, receipt_line_id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_receipt_charges_receipt ON receipt_charges(receipt_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_receipt_charge_allocations_line ON receipt_charge_allocations(receipt_line_id)`,
+
+		// Landed cost carried by each line and the resulting unit cost it was posted at
+		`ALTER TABLE goods_receipt_lines ADD COLUMN IF NOT EXISTS landed_cost NUMERIC(12,2) NOT NULL DEFAULT 0`,
+		`ALTER TABLE goods_receipt_lines ADD COLUMN IF NOT EXISTS posted_unit_cost NUMERIC(12,4)`,
+
+		// Cost-only revaluations are recorded as zero-quantity stock movements
+		`ALTER TABLE stock_movements DROP CONSTRAINT IF EXISTS stock_movements_reason_check`,
+		`ALTER TABLE stock_movements ADD CONSTRAINT stock_movements_reason_check
+			CHECK (reason IN ('PO_RECEIPT', 'PO_RETURN', 'ADJUSTMENT', 'TRANSFER_OUT', 'TRANSFER_IN', 'COUNT', 'REVALUATION'))`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Landed costs migration completed")
+	return nil
+}
+
+func migrateCostLayers(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating costing methods and cost layers...")
+
+	queries := []string{
+		`ALTER TABLE tenants ADD COLUMN IF NOT EXISTS costing_method VARCHAR(20) NOT NULL DEFAULT 'MOVING_AVERAGE'`,
+		`ALTER TABLE tenants DROP CONSTRAINT IF EXISTS tenants_costing_method_check`,
+		`ALTER TABLE tenants ADD CONSTRAINT tenants_costing_method_check
+			CHECK (costing_method IN ('MOVING_AVERAGE', 'FIFO', 'STANDARD'))`,
+
+		// Cost each movement was posted at; total_cost is signed like qty
+		`ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS unit_cost NUMERIC(12,4)`,
+		`ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS total_cost NUMERIC(14,2)`,
+		`CREATE INDEX IF NOT EXISTS idx_stock_movements_tenant_occurred ON stock_movements(tenant_id, occurred_at)`,
+
+		// FIFO cost layers opened by receipts and consumed by issues
+		`CREATE TABLE IF NOT EXISTS cost_layers (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			item_id UUID NOT NULL REFERENCES items(id),
+			location_id UUID NOT NULL REFERENCES locations(id),
+			movement_id UUID REFERENCES stock_movements(id),
+			source_line_id UUID,
+			qty_received INTEGER NOT NULL CHECK (qty_received >= 0),
+			qty_remaining INTEGER NOT NULL CHECK (qty_remaining >= 0),
+			unit_cost NUMERIC(12,4) NOT NULL,
+			received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			closed_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_cost_layers_open ON cost_layers(tenant_id, item_id, location_id, received_at) WHERE qty_remaining > 0 AND closed_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_cost_layers_source_line ON cost_layers(source_line_id) WHERE source_line_id IS NOT NULL`,
+
+		`CREATE TABLE IF NOT EXISTS cost_layer_consumptions (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			layer_id UUID NOT NULL REFERENCES cost_layers(id),
+			movement_id UUID NOT NULL REFERENCES stock_movements(id),
+			qty INTEGER NOT NULL CHECK (qty > 0),
+			unit_cost NUMERIC(12,4) NOT NULL,
+			consumed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_cost_layer_consumptions_layer ON cost_layer_consumptions(layer_id, consumed_at)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Cost layers migration completed")
+	return nil
+}
+
+func migrateStockSnapshots(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating stock snapshots...")
+
+	queries := []string{
+		// On hand per item and location at the end of snapshot_date (UTC)
+		`CREATE TABLE IF NOT EXISTS stock_snapshots (
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			snapshot_date DATE NOT NULL,
+			item_id UUID NOT NULL REFERENCES items(id),
+			location_id UUID NOT NULL REFERENCES locations(id),
+			on_hand INTEGER NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (tenant_id, snapshot_date, item_id, location_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_stock_movements_tenant_item_location ON stock_movements(tenant_id, item_id, location_id, occurred_at)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Stock snapshots migration completed")
+	return nil
+}
+
+func migrateAuditTrail(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating audit trail...")
+
+	queries := []string{
+		// Request metadata of the change
+		`ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS request_id VARCHAR(100)`,
+		`ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS ip_address VARCHAR(64)`,
+		`ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS user_agent TEXT`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_tenant_at ON audit_logs(tenant_id, at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_tenant_user ON audit_logs(tenant_id, user_id)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Audit trail migration completed")
+	return nil
+}
+
+func migrateHashChains(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating hash chains...")
+
+	queries := []string{
+		// Chain position and hashes of each row (see services.HashChainService)
+		`ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS chain_seq BIGINT`,
+		`ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64)`,
+		`ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS row_hash VARCHAR(64)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_logs_chain ON audit_logs(tenant_id, chain_seq)`,
+		`ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS chain_seq BIGINT`,
+		`ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64)`,
+		`ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS row_hash VARCHAR(64)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_movements_chain ON stock_movements(tenant_id, chain_seq)`,
+
+		// Last link of each tenant's chain; locked while appending
+		`CREATE TABLE IF NOT EXISTS hash_chain_heads (
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			chain VARCHAR(50) NOT NULL,
+			seq BIGINT NOT NULL DEFAULT 0,
+			last_hash VARCHAR(64) NOT NULL DEFAULT '',
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (tenant_id, chain)
+		)`,
+
+		// Signed chain heads for export to auditors
+		`CREATE TABLE IF NOT EXISTS audit_checkpoints (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			chain VARCHAR(50) NOT NULL,
+			seq BIGINT NOT NULL,
+			head_hash VARCHAR(64) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			signature VARCHAR(64) NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_checkpoints_tenant ON audit_checkpoints(tenant_id, created_at)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	// Chain the rows written before chaining existed, once per tenant
+	rows, err := db.QueryContext(ctx, `SELECT id FROM tenants`)
+	if err != nil {
+		return fmt.Errorf("failed to list tenants: %w", err)
+	}
+	var tenantIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan tenant: %w", err)
+		}
+		tenantIDs = append(tenantIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	for _, tenantID := range tenantIDs {
+		for _, chain := range services.Chains {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return fmt.Errorf("failed to begin transaction: %w", err)
+			}
+			n, err := services.NewHashChainService(tx).StartChain(ctx, chain.Name, chain.OrderBy, tenantID)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to start %s chain for tenant %s: %w", chain.Name, tenantID, err)
+			}
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("failed to commit %s chain for tenant %s: %w", chain.Name, tenantID, err)
+			}
+			if n > 0 {
+				log.Printf("Chained %d existing %s rows for tenant %s", n, chain.Name, tenantID)
+			}
+		}
+	}
+
+	log.Println("Hash chains migration completed")
+	return nil
+}
+
+func migrateUserManagement(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating user management...")
+
+	queries := []string{
+		`CREATE INDEX IF NOT EXISTS idx_users_tenant_role ON users(tenant_id, role) WHERE is_active = TRUE`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("User management migration completed")
+	return nil
+}
+
+func migrateInvitations(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating invitations...")
+
+	queries := []string{
+		// Pending, accepted and revoked invitations; only a hash of the token is stored
+		`CREATE TABLE IF NOT EXISTS invitations (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			email VARCHAR(255) NOT NULL,
+			role VARCHAR(50) NOT NULL CHECK (role IN ('ADMIN', 'MANAGER', 'CLERK')),
+			token_hash VARCHAR(64) NOT NULL,
+			invited_by UUID REFERENCES users(id),
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			accepted_at TIMESTAMP WITH TIME ZONE,
+			accepted_user_id UUID REFERENCES users(id),
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			last_sent_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			send_count INTEGER NOT NULL DEFAULT 1,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_invitations_tenant_email ON invitations(tenant_id, email)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Invitations migration completed")
+	return nil
+}
+
+func migrateSessions(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating sessions...")
+
+	queries := []string{
+		// One row per login; revoking it ends every token of the session
+		`CREATE TABLE IF NOT EXISTS user_sessions (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id),
+			tenant_id UUID REFERENCES tenants(id),
+			user_agent TEXT,
+			ip_address VARCHAR(64),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			last_used_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			revoke_reason VARCHAR(50)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions(user_id) WHERE revoked_at IS NULL`,
+
+		// Refresh tokens of a session; each is used once and replaced on refresh
+		`CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			session_id UUID NOT NULL REFERENCES user_sessions(id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			used_at TIMESTAMP WITH TIME ZONE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session ON refresh_tokens(session_id)`,
+
+		// Superseded by user_sessions
+		`ALTER TABLE users DROP COLUMN IF EXISTS sessions_revoked_at`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Sessions migration completed")
+	return nil
+}
+
+func migrateAccountTokens(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating account tokens...")
+
+	queries := []string{
+		// Existing accounts are taken as verified: the default fills them in
+		// when the column is added and is dropped for new rows
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()`,
+		`ALTER TABLE users ALTER COLUMN email_verified_at DROP DEFAULT`,
+
+		// Single-use password reset and email verification tokens (hashed)
+		`CREATE TABLE IF NOT EXISTS user_tokens (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			purpose VARCHAR(20) NOT NULL CHECK (purpose IN ('PASSWORD_RESET', 'EMAIL_VERIFY')),
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_tokens_user ON user_tokens(user_id, purpose) WHERE used_at IS NULL`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Account tokens migration completed")
+	return nil
+}
+
+func migrateMFA(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating MFA...")
+
+	queries := []string{
+		// TOTP secret (encrypted), when it was confirmed and the last time
+		// step used, so a code cannot be replayed
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_secret TEXT`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_enabled_at TIMESTAMP WITH TIME ZONE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_last_counter BIGINT NOT NULL DEFAULT 0`,
+
+		// Tenant setting: ADMIN and MANAGER users must use MFA
+		`ALTER TABLE tenants ADD COLUMN IF NOT EXISTS mfa_required BOOLEAN NOT NULL DEFAULT FALSE`,
+
+		// One-time recovery codes (hashed)
+		`CREATE TABLE IF NOT EXISTS user_recovery_codes (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash VARCHAR(64) NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user ON user_recovery_codes(user_id) WHERE used_at IS NULL`,
+
+		// Login challenges are account tokens with a limited number of attempts
+		`ALTER TABLE user_tokens ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE user_tokens DROP CONSTRAINT IF EXISTS user_tokens_purpose_check`,
+		`ALTER TABLE user_tokens ADD CONSTRAINT user_tokens_purpose_check
+			CHECK (purpose IN ('PASSWORD_RESET', 'EMAIL_VERIFY', 'MFA_CHALLENGE'))`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("MFA migration completed")
+	return nil
+}
+
+func migrateLoginThrottles(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating login throttles...")
+
+	queries := []string{
+		// Consecutive failed logins per account (email) and per client IP
+		`CREATE TABLE IF NOT EXISTS login_throttles (
+			scope VARCHAR(10) NOT NULL CHECK (scope IN ('ACCOUNT', 'IP')),
+			key VARCHAR(255) NOT NULL,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			blocked_until TIMESTAMP WITH TIME ZONE,
+			PRIMARY KEY (scope, key)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_login_throttles_last_failure ON login_throttles(last_failure_at)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Login throttles migration completed")
+	return nil
+}
+
+func migrateRoles(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating roles...")
+
+	queries := []string{
+		// Custom roles per tenant; the built-in roles are defined in code
+		`CREATE TABLE IF NOT EXISTS roles (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			name VARCHAR(50) NOT NULL,
+			description TEXT,
+			permissions TEXT[] NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			UNIQUE (tenant_id, name)
+		)`,
+		// Users and invitations may now hold a custom role
+		`ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check`,
+		`ALTER TABLE invitations DROP CONSTRAINT IF EXISTS invitations_role_check`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Roles migration completed")
+	return nil
+}
+
+func migrateUserLocations(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating user locations...")
+
+	queries := []string{
+		// Locations a user is restricted to, see tenant_memberships.location_restricted
+		`CREATE TABLE IF NOT EXISTS user_locations (
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			location_id UUID NOT NULL REFERENCES locations(id) ON DELETE CASCADE,
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (user_id, location_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_locations_location ON user_locations(location_id)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("User locations migration completed")
+	return nil
+}
+
+func migrateApprovals(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating approvals...")
+
+	queries := []string{
+		// Documents worth at least min_amount need required_approvals approvers
+		// holding required_role (any role when NULL)
+		`CREATE TABLE IF NOT EXISTS approval_rules (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			document_type VARCHAR(50) NOT NULL CHECK (document_type IN ('PURCHASE_ORDER', 'ADJUSTMENT')),
+			min_amount NUMERIC(14,2) NOT NULL DEFAULT 0 CHECK (min_amount >= 0),
+			required_role VARCHAR(50),
+			required_approvals INTEGER NOT NULL DEFAULT 1 CHECK (required_approvals BETWEEN 1 AND 5),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_approval_rules_tenant ON approval_rules(tenant_id, document_type)`,
+		// Approval history; approvals are superseded when the document is edited
+		`CREATE TABLE IF NOT EXISTS approvals (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			document_type VARCHAR(50) NOT NULL,
+			document_id UUID NOT NULL,
+			user_id UUID NOT NULL REFERENCES users(id),
+			role VARCHAR(50) NOT NULL,
+			amount NUMERIC(14,2) NOT NULL DEFAULT 0,
+			comment TEXT,
+			superseded_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_approvals_document ON approvals(document_type, document_id)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Approvals migration completed")
+	return nil
+}
+
+func migrateAPIKeys(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating API keys...")
+
+	queries := []string{
+		// Keys act as the user who created them, limited to their scopes; only
+		// the SHA-256 of a key is stored
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			name VARCHAR(255) NOT NULL,
+			prefix VARCHAR(20) NOT NULL,
+			key_hash VARCHAR(64) NOT NULL UNIQUE,
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			allowed_ips TEXT[] NOT NULL DEFAULT '{}',
+			expires_at TIMESTAMP WITH TIME ZONE,
+			last_used_at TIMESTAMP WITH TIME ZONE,
+			last_used_ip VARCHAR(64),
+			created_by UUID NOT NULL REFERENCES users(id),
+			rotated_at TIMESTAMP WITH TIME ZONE,
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_tenant ON api_keys(tenant_id)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("API keys migration completed")
+	return nil
+}
+
+func migrateOIDCProviders(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating OIDC providers...")
+
+	queries := []string{
+		// OpenID Connect identity providers; tenant_id is NULL for providers
+		// shared by all tenants. client_secret is encrypted.
+		`CREATE TABLE IF NOT EXISTS oidc_providers (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID REFERENCES tenants(id),
+			slug VARCHAR(40) NOT NULL,
+			display_name VARCHAR(255) NOT NULL,
+			discovery_url TEXT NOT NULL,
+			client_id VARCHAR(255) NOT NULL,
+			client_secret TEXT NOT NULL,
+			scopes TEXT[] NOT NULL DEFAULT '{email,profile}',
+			email_claim VARCHAR(100) NOT NULL DEFAULT 'email',
+			name_claim VARCHAR(100) NOT NULL DEFAULT 'name',
+			groups_claim VARCHAR(100) NOT NULL DEFAULT 'groups',
+			group_roles JSONB NOT NULL DEFAULT '{}',
+			default_role VARCHAR(50),
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_oidc_providers_slug
+			ON oidc_providers(COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'::uuid), slug)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("OIDC providers migration completed")
+	return nil
+}
+
+func migrateMemberships(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating memberships...")
+
+	queries := []string{
+		// A user's role and access in each tenant they belong to.
+		// users.tenant_id, role and is_active mirror the user's home tenant.
+		`CREATE TABLE IF NOT EXISTS tenant_memberships (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			role VARCHAR(50) NOT NULL,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			UNIQUE (user_id, tenant_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_tenant_memberships_tenant ON tenant_memberships(tenant_id, role)`,
+		`INSERT INTO tenant_memberships (user_id, tenant_id, role, is_active, created_at, updated_at)
+			SELECT id, tenant_id, role, is_active, created_at, updated_at FROM users WHERE tenant_id IS NOT NULL
+			ON CONFLICT (user_id, tenant_id) DO NOTHING`,
+		// The tenant an MFA challenge was started for
+		`ALTER TABLE user_tokens ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES tenants(id)`,
+		// Set on sessions opened with a second factor, which switching
+		// tenant carries over
+		`ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS mfa_verified_at TIMESTAMP WITH TIME ZONE`,
+		// Members limited to their user_locations; one left without any
+		// assignment sees no location rather than all of them
+		`ALTER TABLE tenant_memberships ADD COLUMN IF NOT EXISTS location_restricted BOOLEAN NOT NULL DEFAULT FALSE`,
+		`UPDATE tenant_memberships m SET location_restricted = TRUE
+			WHERE NOT m.location_restricted
+			  AND EXISTS (SELECT 1 FROM user_locations ul WHERE ul.user_id = m.user_id AND ul.tenant_id = m.tenant_id)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Memberships migration completed")
+	return nil
+}
+
+func migratePlans(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating plans...")
+
+	queries := []string{
+		// Subscription plans; a NULL limit is unlimited. features holds flags
+		// such as {"lots": true, "multi_currency": false}.
+		`CREATE TABLE IF NOT EXISTS plans (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			code VARCHAR(50) UNIQUE NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			max_users INTEGER CHECK (max_users >= 0),
+			max_locations INTEGER CHECK (max_locations >= 0),
+			max_items INTEGER CHECK (max_items >= 0),
+			max_api_requests_month BIGINT CHECK (max_api_requests_month >= 0),
+			features JSONB NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`INSERT INTO plans (code, name, max_users, max_locations, max_items, max_api_requests_month, features) VALUES
+			('free', 'Free', 3, 1, 500, 10000, '{"lots": false, "multi_currency": false}'),
+			('standard', 'Standard', 25, 10, 10000, 500000, '{"lots": true, "multi_currency": false}'),
+			('enterprise', 'Enterprise', NULL, NULL, NULL, NULL, '{"lots": true, "multi_currency": true}')
+			ON CONFLICT (code) DO NOTHING`,
+		// Tenants without a plan are not limited
+		`ALTER TABLE tenants ADD COLUMN IF NOT EXISTS plan_id UUID REFERENCES plans(id)`,
+
+		// Metered usage per tenant and calendar month (UTC)
+		`CREATE TABLE IF NOT EXISTS tenant_usage (
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			period DATE NOT NULL,
+			metric VARCHAR(50) NOT NULL,
+			count BIGINT NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (tenant_id, period, metric)
+		)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Plans migration completed")
+	return nil
+}
+
+func migrateTenantLifecycle(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating tenant lifecycle...")
+
+	queries := []string{
+		// Background exports of a tenant's data; file_path is the finished ZIP
+		`CREATE TABLE IF NOT EXISTS tenant_exports (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			status VARCHAR(20) NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'RUNNING', 'COMPLETED', 'FAILED')),
+			requested_by UUID,
+			file_path TEXT,
+			size_bytes BIGINT,
+			error TEXT,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			started_at TIMESTAMP WITH TIME ZONE,
+			completed_at TIMESTAMP WITH TIME ZONE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_tenant_exports_tenant ON tenant_exports(tenant_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_tenant_exports_pending ON tenant_exports(created_at) WHERE status IN ('PENDING', 'RUNNING')`,
+
+		// Scheduled hard deletion; the tenant is purged once purge_after passes
+		`ALTER TABLE tenants ADD COLUMN IF NOT EXISTS purge_requested_at TIMESTAMP WITH TIME ZONE`,
+		`ALTER TABLE tenants ADD COLUMN IF NOT EXISTS purge_requested_by UUID`,
+		`ALTER TABLE tenants ADD COLUMN IF NOT EXISTS purge_after TIMESTAMP WITH TIME ZONE`,
+		`CREATE INDEX IF NOT EXISTS idx_tenants_purge_after ON tenants(purge_after) WHERE purge_after IS NOT NULL`,
+
+		// Audit trail of system administration. It outlives the tenants it
+		// names, so neither tenant_id nor actor_id is a foreign key.
+		`CREATE TABLE IF NOT EXISTS system_audit_log (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			action VARCHAR(100) NOT NULL,
+			tenant_id UUID,
+			tenant_slug VARCHAR(255),
+			tenant_name VARCHAR(255),
+			actor_id UUID,
+			actor_email VARCHAR(255),
+			request_id VARCHAR(255),
+			ip_address VARCHAR(64),
+			details JSONB,
+			at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_system_audit_log_tenant ON system_audit_log(tenant_id, at)`,
+		`CREATE INDEX IF NOT EXISTS idx_system_audit_log_at ON system_audit_log(at)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Println("Tenant lifecycle migration completed")
+	return nil
+}
+
+// Tables with a tenant_id that are left without row level security:
+// oidc_providers holds providers shared by all tenants, and system_audit_log
+// is not tenant data.
+var rlsExemptTables = map[string]bool{
+	"oidc_providers":   true,
+	"system_audit_log": true,
+}
+
+// Identity tables whose rows a tenant sees by more than their tenant_id. A
+// user is visible to their home tenant and to the tenants they are a member
+// of; their sessions and tokens to the tenant they were opened in and to the
+// home tenant, which administers the account. Sign-in reads them across
+// tenants through the bypass.
+var rlsIdentityPolicies = map[string]string{
+	"users": `tenant_id = app_current_tenant()
+		OR EXISTS (SELECT 1 FROM tenant_memberships m WHERE m.user_id = users.id AND m.tenant_id = app_current_tenant())`,
+	"user_sessions": `tenant_id = app_current_tenant()
+		OR EXISTS (SELECT 1 FROM users u WHERE u.id = user_sessions.user_id AND u.tenant_id = app_current_tenant())`,
+	"user_tokens": `tenant_id = app_current_tenant()
+		OR EXISTS (SELECT 1 FROM users u WHERE u.id = user_tokens.user_id AND u.tenant_id = app_current_tenant())`,
+}
+
+// Line tables without a tenant_id, and the header column they hang off. Their
+// rows are visible when the header is.
+var rlsLineTables = []struct{ table, parent, column string }{
+	{"purchase_order_lines", "purchase_orders", "purchase_order_id"},
+	{"goods_receipt_lines", "goods_receipts", "receipt_id"},
+	{"count_lines", "count_batches", "batch_id"},
+}
+
+func migrateRowLevelSecurity(ctx context.Context, db *sql.DB) error {
+	log.Println("Migrating row level security...")
+
+	queries := []string{
+		// Count batches and goods receipts were numbered globally and had
+		// no tenant; take it from their location
+		`ALTER TABLE count_batches ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES tenants(id)`,
+		`UPDATE count_batches cb SET tenant_id = l.tenant_id FROM locations l WHERE l.id = cb.location_id AND cb.tenant_id IS NULL`,
+		`ALTER TABLE count_batches DROP CONSTRAINT IF EXISTS count_batches_number_key`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_count_batches_tenant_number ON count_batches(tenant_id, number)`,
+		`ALTER TABLE goods_receipts ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES tenants(id)`,
+		`UPDATE goods_receipts gr SET tenant_id = l.tenant_id FROM locations l WHERE l.id = gr.location_id AND gr.tenant_id IS NULL`,
+		`UPDATE goods_receipts gr SET tenant_id = po.tenant_id FROM purchase_orders po WHERE po.id = gr.purchase_order_id AND gr.tenant_id IS NULL`,
+		`ALTER TABLE goods_receipts DROP CONSTRAINT IF EXISTS goods_receipts_number_key`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_goods_receipts_tenant_number ON goods_receipts(tenant_id, number)`,
+
+		// The tenant the connection is scoped to, set in app.tenant_id by
+		// the services.OpenDB driver. NULL matches no tenant's rows.
+		`CREATE OR REPLACE FUNCTION app_current_tenant() RETURNS UUID
+			LANGUAGE sql STABLE
+			AS $$ SELECT NULLIF(current_setting('app.tenant_id', true), '')::uuid $$`,
+		// Sign-in, system administration, background jobs and the command
+		// line tools see every tenant by turning app.rls_bypass on
+		`CREATE OR REPLACE FUNCTION app_rls_bypassed() RETURNS BOOLEAN
+			LANGUAGE sql STABLE
+			AS $$ SELECT COALESCE(current_setting('app.rls_bypass', true), '') = 'on' $$`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.table_name
+		FROM information_schema.columns c
+		JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = current_schema() AND c.column_name = 'tenant_id' AND t.table_type = 'BASE TABLE'
+		ORDER BY c.table_name
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to list tenant tables: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to list tenant tables: %w", err)
+		}
+		if !rlsExemptTables[table] {
+			tables = append(tables, table)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list tenant tables: %w", err)
+	}
+
+	// FORCE applies the policies to the table owner too, which is usually
+	// the role the API connects as
+	queries = nil
+	for _, table := range tables {
+		check := `tenant_id = app_current_tenant()`
+		if policy, ok := rlsIdentityPolicies[table]; ok {
+			check = policy
+		}
+		queries = append(queries,
+			fmt.Sprintf(`ALTER TABLE %s ENABLE ROW LEVEL SECURITY`, table),
+			fmt.Sprintf(`ALTER TABLE %s FORCE ROW LEVEL SECURITY`, table),
+			fmt.Sprintf(`DROP POLICY IF EXISTS tenant_isolation ON %s`, table),
+			fmt.Sprintf(`CREATE POLICY tenant_isolation ON %s
+				USING (app_rls_bypassed() OR %s)
+				WITH CHECK (app_rls_bypassed() OR %s)`, table, check, check),
+		)
+	}
+	for _, line := range rlsLineTables {
+		check := fmt.Sprintf(`EXISTS (SELECT 1 FROM %s p WHERE p.id = %s.%s)`, line.parent, line.table, line.column)
+		queries = append(queries,
+			fmt.Sprintf(`ALTER TABLE %s ENABLE ROW LEVEL SECURITY`, line.table),
+			fmt.Sprintf(`ALTER TABLE %s FORCE ROW LEVEL SECURITY`, line.table),
+			fmt.Sprintf(`DROP POLICY IF EXISTS tenant_isolation ON %s`, line.table),
+			fmt.Sprintf(`CREATE POLICY tenant_isolation ON %s USING (%s) WITH CHECK (%s)`, line.table, check, check),
+		)
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w\nQuery: %s", err, query)
+		}
+	}
+
+	log.Printf("Row level security enabled on %d tables", len(tables)+len(rlsLineTables))
+	log.Println("Row level security migration completed")
+	return nil
+}
@@ -5,9 +5,9 @@ import (
 	"database/sql"
 	"fmt"
 	"inventory/internal/config"
+	"inventory/internal/services"
 	"log"
 
-	_ "github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -17,7 +17,7 @@ func main() {
 		log.Fatal("Failed to load configuration:", err)
 	}
 
-	db, err := sql.Open("postgres", cfg.DatabaseURL)
+	db, err := services.OpenSystemDB(cfg.DatabaseURL)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
@@ -47,8 +47,8 @@ func seedData(ctx context.Context, db *sql.DB) error {
 	queries := []string{
 		// Insert default admin user
 		fmt.Sprintf(`
-			INSERT INTO users (email, password_hash, name, role, is_active) 
-			VALUES ('admin@example.com', '%s', 'Admin User', 'ADMIN', true)
+			INSERT INTO users (email, password_hash, name, role, is_active, email_verified_at) 
+			VALUES ('admin@example.com', '%s', 'Admin User', 'ADMIN', true, NOW())
 			ON CONFLICT (email) DO NOTHING
 		`, string(hashedPassword)),
 
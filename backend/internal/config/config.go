@@ -24,6 +24,45 @@ type Config struct {
 	GoogleClientID     string
 	GoogleClientSecret string
 	GoogleRedirectURL  string
+	// Hour (UTC) at which nightly stock snapshots are taken; negative disables them
+	SnapshotHour int
+	// Key signing audit chain checkpoints (defaults to JWTSecret) and the hour
+	// (UTC) at which they are taken; a negative hour disables them
+	AuditSigningKey     string
+	AuditCheckpointHour int
+	// Outgoing mail; without SMTPHost mail is written as .eml files to MailDir
+	// when set and only logged otherwise
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+	MailDir      string
+	// Base URL of the web app, used for links in emails
+	AppURL string
+	// Lifetime of user invitations
+	InviteExpiry time.Duration
+	// Lifetime of password reset and email verification links
+	PasswordResetExpiry time.Duration
+	EmailVerifyExpiry   time.Duration
+	// Key encrypting TOTP secrets (defaults to JWTSecret) and the issuer name
+	// shown in authenticator apps
+	MFAEncryptionKey string
+	MFAIssuer        string
+	// Failed logins after which an account or client IP is locked out, and
+	// for how long
+	LoginMaxAttempts   int
+	LoginIPMaxAttempts int
+	LoginLockout       time.Duration
+	// Domain whose subdomains name tenants by slug ("acme.<domain>"); hosts
+	// outside it are looked up as tenants' custom domains. Resolved tenants
+	// are cached for TenantCacheTTL.
+	TenantBaseDomain string
+	TenantCacheTTL   time.Duration
+	// Directory tenant exports are written to, and how long a tenant scheduled
+	// for purge keeps its data
+	TenantExportDir  string
+	TenantPurgeGrace time.Duration
 }
 
 func Load() (*Config, error) {
@@ -38,14 +77,48 @@ func Load() (*Config, error) {
 		MaxPageSize:     getEnvAsInt("MAX_PAGE_SIZE", 100),
 		DefaultPageSize: getEnvAsInt("DEFAULT_PAGE_SIZE", 20),
 		// Google OAuth Configuration
-		GoogleClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
-		GoogleClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
-		GoogleRedirectURL:  getEnv("GOOGLE_REDIRECT_URL", "http://localhost:5173/auth/google/callback"),
+		GoogleClientID:      getEnv("GOOGLE_CLIENT_ID", ""),
+		GoogleClientSecret:  getEnv("GOOGLE_CLIENT_SECRET", ""),
+		GoogleRedirectURL:   getEnv("GOOGLE_REDIRECT_URL", "http://localhost:5173/auth/google/callback"),
+		SnapshotHour:        getEnvAsInt("STOCK_SNAPSHOT_HOUR", 1),
+		AuditCheckpointHour: getEnvAsInt("AUDIT_CHECKPOINT_HOUR", 2),
+		SMTPHost:            getEnv("SMTP_HOST", ""),
+		SMTPPort:            getEnvAsInt("SMTP_PORT", 587),
+		SMTPUsername:        getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:        getEnv("SMTP_PASSWORD", ""),
+		MailFrom:            getEnv("MAIL_FROM", "no-reply@localhost"),
+		MailDir:             getEnv("MAIL_DIR", ""),
+		MFAIssuer:           getEnv("MFA_ISSUER", "Inventory"),
+		LoginMaxAttempts:    getEnvAsInt("LOGIN_MAX_ATTEMPTS", 10),
+		LoginIPMaxAttempts:  getEnvAsInt("LOGIN_IP_MAX_ATTEMPTS", 50),
+		AppURL:              strings.TrimRight(getEnv("APP_URL", "http://localhost:5173"), "/"),
+		TenantBaseDomain:    getEnv("TENANT_BASE_DOMAIN", ""),
+		TenantExportDir:     getEnv("TENANT_EXPORT_DIR", "exports"),
 	}
+	cfg.AuditSigningKey = getEnv("AUDIT_SIGNING_KEY", cfg.JWTSecret)
+	cfg.MFAEncryptionKey = getEnv("MFA_ENCRYPTION_KEY", cfg.JWTSecret)
 
 	jwtExpiry := getEnvAsInt("JWT_EXPIRY_MINUTES", 15)
 	cfg.JWTExpiry = time.Duration(jwtExpiry) * time.Minute
 
+	inviteExpiry := getEnvAsInt("INVITE_EXPIRY_HOURS", 72)
+	cfg.InviteExpiry = time.Duration(inviteExpiry) * time.Hour
+
+	resetExpiry := getEnvAsInt("PASSWORD_RESET_EXPIRY_MINUTES", 60)
+	cfg.PasswordResetExpiry = time.Duration(resetExpiry) * time.Minute
+
+	verifyExpiry := getEnvAsInt("EMAIL_VERIFY_EXPIRY_HOURS", 48)
+	cfg.EmailVerifyExpiry = time.Duration(verifyExpiry) * time.Hour
+
+	lockout := getEnvAsInt("LOGIN_LOCKOUT_MINUTES", 15)
+	cfg.LoginLockout = time.Duration(lockout) * time.Minute
+
+	tenantCacheTTL := getEnvAsInt("TENANT_CACHE_SECONDS", 60)
+	cfg.TenantCacheTTL = time.Duration(tenantCacheTTL) * time.Second
+
+	purgeGrace := getEnvAsInt("TENANT_PURGE_GRACE_DAYS", 30)
+	cfg.TenantPurgeGrace = time.Duration(purgeGrace) * 24 * time.Hour
+
 	refreshExpiry := getEnvAsInt("REFRESH_EXPIRY_DAYS", 7)
 	cfg.RefreshExpiry = time.Duration(refreshExpiry) * 24 * time.Hour
 
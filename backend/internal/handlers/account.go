@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Purposes of single-use account tokens. Like refresh tokens they are random
+// strings stored only as their SHA-256; issuing a new one replaces any unused
+// token of the same purpose.
+const (
+	TokenPasswordReset = "PASSWORD_RESET"
+	TokenEmailVerify   = "EMAIL_VERIFY"
+	TokenMFAChallenge  = "MFA_CHALLENGE"
+)
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// forgotPasswordReply is returned whether or not the address has an account.
+const forgotPasswordReply = "If an account exists for this email, a password reset link has been sent."
+
+func issueUserToken(tx *sql.Tx, userID, purpose string, ttl time.Duration) (string, error) {
+	token, err := randomToken("")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, userID, purpose); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4, NOW())
+	`, userID, purpose, hashToken(token), time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeUserToken marks the token used and returns its user, or
+// errInvalidUserToken when it is unknown, used or expired.
+func consumeUserToken(tx *sql.Tx, purpose, token string) (string, error) {
+	var id, userID string
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	err := tx.QueryRow(`
+		SELECT id, user_id, expires_at, used_at FROM user_tokens WHERE token_hash = $1 AND purpose = $2 FOR UPDATE
+	`, hashToken(token), purpose).Scan(&id, &userID, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return "", errInvalidUserToken
+	}
+	if err != nil {
+		return "", err
+	}
+	if usedAt.Valid || !time.Now().Before(expiresAt) {
+		return "", errInvalidUserToken
+	}
+	if _, err := tx.Exec(`UPDATE user_tokens SET used_at = NOW() WHERE id = $1`, id); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// ForgotPassword emails a password reset link to the active password account
+// with the address (only if it belongs to tenant_slug when given). The reply is
+// the same whether or not an account exists.
+func (h *Handler) ForgotPassword(c echo.Context) error {
+	var req struct {
+		Email      string `json:"email" validate:"required,email"`
+		TenantSlug string `json:"tenant_slug"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "a valid email is required")
+	}
+
+	// One link per account, named after the tenant asked for or else the
+	// user's home tenant
+	rows, err := h.db(c).Query(`
+		SELECT DISTINCT ON (u.id) u.id, t.name
+		FROM `+memberTables+`
+		JOIN tenants t ON t.id = m.tenant_id
+		WHERE u.email = $1 AND m.is_active = true AND t.is_active = true
+		  AND COALESCE(u.password_hash, '') <> '' AND COALESCE(u.oauth_provider, '') = ''
+		  AND ($2 = '' OR t.slug = $2)
+		ORDER BY u.id, m.tenant_id IS NOT DISTINCT FROM u.tenant_id DESC
+	`, req.Email, req.TenantSlug)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	type account struct{ userID, tenantName string }
+	var accounts []account
+	for rows.Next() {
+		var a account
+		if err := rows.Scan(&a.userID, &a.tenantName); err != nil {
+			rows.Close()
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		accounts = append(accounts, a)
+	}
+	rows.Close()
+
+	for _, a := range accounts {
+		token, err := h.issueUserTokenNow(c, a.userID, TokenPasswordReset, h.Config.PasswordResetExpiry)
+		if err != nil {
+			log.Error().Err(err).Str("user_id", a.userID).Msg("Failed to issue password reset token")
+			continue
+		}
+		link := fmt.Sprintf("%s/reset-password?token=%s", h.Config.AppURL, url.QueryEscape(token))
+		msg := services.Message{
+			To:      req.Email,
+			Subject: fmt.Sprintf("Reset your %s password", a.tenantName),
+			Body: fmt.Sprintf("A password reset was requested for your %s account.\n\nChoose a new password here:\n%s\n\nThis link can be used once and expires on %s. If you did not ask for it, you can ignore this email.\n",
+				a.tenantName, link, time.Now().Add(h.Config.PasswordResetExpiry).UTC().Format("2006-01-02 15:04 MST")),
+		}
+		if err := h.Mailer.Send(c.Request().Context(), msg); err != nil {
+			log.Error().Err(err).Str("user_id", a.userID).Msg("Failed to send password reset email")
+		}
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{"message": forgotPasswordReply})
+}
+
+func (h *Handler) issueUserTokenNow(c echo.Context, userID, purpose string, ttl time.Duration) (string, error) {
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	token, err := issueUserToken(tx, userID, purpose, ttl)
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// ResetPassword sets a new password with a reset token and signs the user out
+// everywhere. Following the link proves the address, so it is verified too.
+func (h *Handler) ResetPassword(c echo.Context) error {
+	var req struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required,min=8"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "token and a password of at least 8 characters are required")
+	}
+
+	passwordHash, err := hashPassword(req.Password)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to secure password")
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeUserToken(tx, TokenPasswordReset, req.Token)
+	if err == errInvalidUserToken {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired reset link")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	err = h.updateAccount(c, tx, userID, `
+		UPDATE users SET password_hash = $2, email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		WHERE id = $1 AND is_active = true
+	`, passwordHash)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired reset link")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := revokeUserSessions(tx, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	// The owner proved control of the address, so a lockout no longer applies
+	var email string
+	if err := tx.QueryRow(`SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := h.loginThrottle(tx).Unlock(c.Request().Context(), email); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password has been reset. Please sign in again."})
+}
+
+// VerifyEmail marks the user's address verified with a verification token.
+// Sessions pick the change up on their next refresh.
+func (h *Handler) VerifyEmail(c echo.Context) error {
+	var req struct {
+		Token string `json:"token" validate:"required"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "token is required")
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeUserToken(tx, TokenEmailVerify, req.Token)
+	if err == errInvalidUserToken {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired verification link")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	err = h.updateAccount(c, tx, userID, `
+		UPDATE users SET email_verified_at = NOW(), updated_at = NOW() WHERE id = $1 AND email_verified_at IS NULL
+	`)
+	if err != nil && err != sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Email address verified"})
+}
+
+// ResendVerification emails the current user a new verification link.
+func (h *Handler) ResendVerification(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var email string
+	var verified bool
+	err := h.db(c).QueryRow(`SELECT email, email_verified_at IS NOT NULL FROM users WHERE id = $1`, claims.UserID).Scan(&email, &verified)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if verified {
+		return echo.NewHTTPError(http.StatusConflict, "email address is already verified")
+	}
+
+	token, err := h.issueUserTokenNow(c, claims.UserID, TokenEmailVerify, h.Config.EmailVerifyExpiry)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !h.sendVerificationEmail(c.Request().Context(), email, token) {
+		return echo.NewHTTPError(http.StatusBadGateway, "failed to send verification email")
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "Verification email sent"})
+}
+
+func (h *Handler) sendVerificationEmail(ctx context.Context, email, token string) bool {
+	link := fmt.Sprintf("%s/verify-email?token=%s", h.Config.AppURL, url.QueryEscape(token))
+	msg := services.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Please confirm your email address by opening this link:\n%s\n\nUntil then your account is read-only. The link expires on %s.\n",
+			link, time.Now().Add(h.Config.EmailVerifyExpiry).UTC().Format("2006-01-02 15:04 MST")),
+	}
+	if err := h.Mailer.Send(ctx, msg); err != nil {
+		log.Error().Err(err).Str("email", email).Msg("Failed to send verification email")
+		return false
+	}
+	return true
+}
+
+// updateAccount runs a single-row UPDATE of the user (query takes the user ID
+// as $1) and audits it as done by the user themselves. It returns
+// sql.ErrNoRows when nothing was updated.
+func (h *Handler) updateAccount(c echo.Context, tx *sql.Tx, userID, query string, args ...interface{}) error {
+	var tenantID sql.NullString
+	if err := tx.QueryRow(`SELECT tenant_id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&tenantID); err != nil {
+		return err
+	}
+	audit := services.NewAuditService(tx)
+	before, err := audit.Snapshot(c.Request().Context(), "user", userID)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(query, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if !tenantID.Valid {
+		// Audit trails are per tenant
+		return nil
+	}
+	e := auditEntry(c, AuditUpdate, "user", userID)
+	e.TenantID, e.UserID = tenantID.String, userID
+	return writeAudit(c, tx, e, before)
+}
@@ -10,6 +10,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
 
 	appmw "inventory/internal/middleware"
 )
@@ -117,6 +119,12 @@ func (h *Handler) resolveOrCreateItemForAdjustment(tx *sql.Tx, itemIdentifier, t
 	return &foundItemID, nil
 }
 
+// checkAdjustmentLocation rejects access to an adjustment at a location the
+// caller is not assigned to.
+func checkAdjustmentLocation(c echo.Context, q queryRower, id, tenantID string) error {
+	return checkDocumentLocation(c, q, `SELECT location_id FROM adjustments WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+}
+
 // ListAdjustments returns a paginated list of adjustments
 func (h *Handler) ListAdjustments(c echo.Context) error {
 	// Get user claims for tenant ID
@@ -164,6 +172,12 @@ func (h *Handler) ListAdjustments(c echo.Context) error {
 		args = append(args, "%"+search+"%")
 	}
 
+	if allowed, restricted := appmw.GetAllowedLocations(c.Request().Context()); restricted {
+		argCount++
+		whereClause += fmt.Sprintf(" AND a.location_id = ANY($%d)", argCount)
+		args = append(args, pq.Array(allowed))
+	}
+
 	// Get total count
 	var total int64
 	countQuery := fmt.Sprintf(`
@@ -173,7 +187,7 @@ func (h *Handler) ListAdjustments(c echo.Context) error {
 		%s
 	`, whereClause)
 
-	err := h.DB.QueryRow(countQuery, args...).Scan(&total)
+	err := h.db(c).QueryRow(countQuery, args...).Scan(&total)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to count adjustments")
 	}
@@ -197,7 +211,7 @@ func (h *Handler) ListAdjustments(c echo.Context) error {
 		%s%s%s
 	`, whereClause, limitClause, offsetClause)
 
-	rows, err := h.DB.Query(query, args...)
+	rows, err := h.db(c).Query(query, args...)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch adjustments")
 	}
@@ -277,6 +291,10 @@ func (h *Handler) GetAdjustment(c echo.Context) error {
 
 	log.Printf("GetAdjustment called for ID: %s, TenantID: %s", id, tenantID)
 
+	if err := checkAdjustmentLocation(c, h.db(c), id, tenantID); err != nil {
+		return err
+	}
+
 	// Get adjustment
 	var adj Adjustment
 	adj.Location = &Location{} // Initialize before scanning
@@ -285,7 +303,7 @@ func (h *Handler) GetAdjustment(c echo.Context) error {
 	var approvedBy sql.NullString
 	var approvedAt sql.NullTime
 
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT 
 			a.id, a.number, a.location_id, a.reason, a.status,
 			a.notes, a.created_by, a.approved_by, a.approved_at,
@@ -323,7 +341,7 @@ func (h *Handler) GetAdjustment(c echo.Context) error {
 	}
 
 	// Get adjustment lines
-	linesRows, err := h.DB.Query(`
+	linesRows, err := h.db(c).Query(`
 		SELECT al.id, al.item_id, al.item_identifier, COALESCE(al.notes, '') as notes, 
 			   al.qty_expected, al.qty_actual, al.qty_diff,
 			   COALESCE(i.sku, '') as sku, COALESCE(i.name, '') as name
@@ -395,9 +413,12 @@ func (h *Handler) CreateAdjustment(c echo.Context) error {
 	if err := c.Validate(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	if err := checkLocationAccess(c, req.LocationID); err != nil {
+		return err
+	}
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
 	}
@@ -451,6 +472,10 @@ func (h *Handler) CreateAdjustment(c echo.Context) error {
 		}
 	}
 
+	if err := recordAudit(c, tx, AuditCreate, "adjustment", adjustmentID, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
@@ -489,14 +514,25 @@ func (h *Handler) UpdateAdjustment(c echo.Context) error {
 	if err := c.Validate(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	if err := checkLocationAccess(c, req.LocationID); err != nil {
+		return err
+	}
+	if err := checkAdjustmentLocation(c, h.db(c), id, tenantID); err != nil {
+		return err
+	}
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
 	}
 	defer tx.Rollback()
 
+	before, err := auditSnapshot(c, tx, "adjustment", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Check if adjustment exists and is modifiable
 	var status string
 	err = tx.QueryRow(`
@@ -535,6 +571,11 @@ func (h *Handler) UpdateAdjustment(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update adjustment")
 	}
 
+	// Approvals were given for the old version
+	if err := supersedeApprovals(tx, ApprovalAdjustment, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Delete existing lines
 	_, err = tx.Exec(`
 		DELETE FROM adjustment_lines WHERE adjustment_id = $1 AND tenant_id = $2
@@ -566,6 +607,10 @@ func (h *Handler) UpdateAdjustment(c echo.Context) error {
 		}
 	}
 
+	if err := recordAudit(c, tx, AuditUpdate, "adjustment", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
@@ -585,9 +630,13 @@ func (h *Handler) DeleteAdjustment(c echo.Context) error {
 
 	id := c.Param("id")
 
+	if err := checkAdjustmentLocation(c, h.db(c), id, tenantID); err != nil {
+		return err
+	}
+
 	// Check if adjustment exists and is deletable
 	var status string
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT status FROM adjustments WHERE id = $1 AND tenant_id = $2
 	`, id, tenantID).Scan(&status)
 	if err != nil {
@@ -602,17 +651,36 @@ func (h *Handler) DeleteAdjustment(c echo.Context) error {
 	}
 
 	// Delete adjustment (lines will be deleted by cascade)
-	_, err = h.DB.Exec(`
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "adjustment", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	_, err = tx.Exec(`
 		DELETE FROM adjustments WHERE id = $1 AND tenant_id = $2
 	`, id, tenantID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete adjustment")
 	}
 
+	if err := recordAudit(c, tx, AuditDelete, "adjustment", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Adjustment deleted successfully"})
 }
 
-// ApproveAdjustment approves an adjustment and applies inventory changes
+// ApproveAdjustment records the caller's approval of an adjustment; once the
+// tenant's approval rules are met it approves it and applies inventory changes
 func (h *Handler) ApproveAdjustment(c echo.Context) error {
 	// Get user claims for tenant ID
 	claims, errClaims := appmw.GetUserClaims(c)
@@ -625,17 +693,23 @@ func (h *Handler) ApproveAdjustment(c echo.Context) error {
 	id := c.Param("id")
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
 	}
 	defer tx.Rollback()
 
+	before, err := auditSnapshot(c, tx, "adjustment", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Check if adjustment exists and can be approved
 	var status, locationID string
+	var createdBy sql.NullString
 	err = tx.QueryRow(`
-		SELECT status, location_id FROM adjustments WHERE id = $1 AND tenant_id = $2
-	`, id, tenantID).Scan(&status, &locationID)
+		SELECT status, location_id, created_by FROM adjustments WHERE id = $1 AND tenant_id = $2 FOR UPDATE
+	`, id, tenantID).Scan(&status, &locationID, &createdBy)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "Adjustment not found")
@@ -646,6 +720,23 @@ func (h *Handler) ApproveAdjustment(c echo.Context) error {
 	if status != "DRAFT" {
 		return echo.NewHTTPError(http.StatusBadRequest, "Can only approve draft adjustments")
 	}
+	if err := checkLocationAccess(c, locationID); err != nil {
+		return err
+	}
+
+	state, err := recordApproval(c, tx, ApprovalAdjustment, id, createdBy)
+	if err != nil {
+		return err
+	}
+	if !state.complete() {
+		if err := recordAudit(c, tx, AuditApprove, "adjustment", id, before); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if err := tx.Commit(); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
+		}
+		return approvalResponse(c, state, "")
+	}
 
 	// Get adjustment lines
 	linesRows, err := tx.Query(`
@@ -655,40 +746,69 @@ func (h *Handler) ApproveAdjustment(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch adjustment lines")
 	}
-	defer linesRows.Close()
 
-	// Apply inventory changes
+	type adjustmentDiff struct {
+		itemID  string
+		qtyDiff int
+	}
+	var diffs []adjustmentDiff
 	for linesRows.Next() {
-		var itemID string
-		var qtyDiff int
-
-		err := linesRows.Scan(&itemID, &qtyDiff)
-		if err != nil {
+		var d adjustmentDiff
+		if err := linesRows.Scan(&d.itemID, &d.qtyDiff); err != nil {
+			linesRows.Close()
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to scan adjustment line")
 		}
+		diffs = append(diffs, d)
+	}
+	linesRows.Close()
 
-		if qtyDiff != 0 {
-			// Update inventory levels
-			_, err = tx.Exec(`
-				INSERT INTO inventory_levels (item_id, location_id, on_hand, allocated, reorder_point, reorder_qty, created_at, updated_at)
-				VALUES ($1, $2, $3, 0, 0, 0, NOW(), NOW())
-				ON CONFLICT (item_id, location_id)
-				DO UPDATE SET 
-					on_hand = inventory_levels.on_hand + $3,
-					updated_at = NOW()
-			`, itemID, locationID, qtyDiff)
-			if err != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update inventory")
-			}
+	costingMethod, err := tenantCostingMethod(tx, tenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load costing method")
+	}
 
-			// Create stock movement record
-			_, err = tx.Exec(`
-				INSERT INTO stock_movements (id, item_id, location_id, user_id, qty, reason, reference, ref_id, occurred_at, created_at)
-				VALUES ($1, $2, $3, $4, $5, 'ADJUSTMENT', 'Adjustment', $6, NOW(), NOW())
-			`, uuid.New().String(), itemID, locationID, userID, qtyDiff, id)
-			if err != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create stock movement")
+	// Apply inventory changes
+	for _, d := range diffs {
+		if d.qtyDiff == 0 {
+			continue
+		}
+
+		// Create stock movement record valued with the tenant's costing method
+		move := stockMove{
+			TenantID:   tenantID,
+			ItemID:     d.itemID,
+			LocationID: locationID,
+			UserID:     userID,
+			Reason:     "ADJUSTMENT",
+			Reference:  "Adjustment",
+			RefID:      id,
+		}
+		if d.qtyDiff > 0 {
+			var currentCost decimal.Decimal
+			if err := tx.QueryRow(`SELECT COALESCE(cost, 0) FROM items WHERE id = $1 AND tenant_id = $2`, d.itemID, tenantID).Scan(&currentCost); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load item cost")
 			}
+			move.Qty = d.qtyDiff
+			_, err = receiveStock(tx, costingMethod, move, currentCost)
+		} else {
+			move.Qty = -d.qtyDiff
+			_, err = issueStock(tx, costingMethod, move)
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create stock movement")
+		}
+
+		// Update inventory levels
+		_, err = tx.Exec(`
+			INSERT INTO inventory_levels (tenant_id, item_id, location_id, on_hand, allocated, reorder_point, reorder_qty, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, 0, 0, 0, NOW(), NOW())
+			ON CONFLICT (item_id, location_id)
+			DO UPDATE SET 
+				on_hand = inventory_levels.on_hand + $4,
+				updated_at = NOW()
+		`, tenantID, d.itemID, locationID, d.qtyDiff)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update inventory")
 		}
 	}
 
@@ -702,10 +822,15 @@ func (h *Handler) ApproveAdjustment(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to approve adjustment")
 	}
 
+	if err := recordAudit(c, tx, AuditApprove, "adjustment", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
 	}
+	h.invalidateDashboard(tenantID)
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "Adjustment approved successfully"})
+	return approvalResponse(c, state, "Adjustment approved successfully")
 }
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// APIKey is a tenant's key for machine integrations. It acts as the user who
+// created it, limited to its scopes. The key itself is only returned when it is
+// created or rotated.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP *string    `json:"last_used_ip,omitempty"`
+	CreatedBy  string     `json:"created_by"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Key        string     `json:"key,omitempty"`
+}
+
+// Characters of a key kept in clear to tell keys apart
+const apiKeyPrefixLen = 11
+
+const apiKeyColumns = `id, name, prefix, scopes, allowed_ips, expires_at, last_used_at, last_used_ip, created_by, rotated_at, created_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var k APIKey
+	var expiresAt, lastUsedAt, rotatedAt sql.NullTime
+	var lastUsedIP sql.NullString
+	if err := row.Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), pq.Array(&k.AllowedIPs),
+		&expiresAt, &lastUsedAt, &lastUsedIP, &k.CreatedBy, &rotatedAt, &k.CreatedAt); err != nil {
+		return k, err
+	}
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if lastUsedIP.Valid {
+		k.LastUsedIP = &lastUsedIP.String
+	}
+	if rotatedAt.Valid {
+		k.RotatedAt = &rotatedAt.Time
+	}
+	if k.Scopes == nil {
+		k.Scopes = []string{}
+	}
+	if k.AllowedIPs == nil {
+		k.AllowedIPs = []string{}
+	}
+	return k, nil
+}
+
+// newAPIKey generates a key and returns it with its display prefix and hash.
+func newAPIKey() (key, prefix, hash string, err error) {
+	key, err = randomToken(appmw.APIKeyPrefix)
+	if err != nil {
+		return "", "", "", err
+	}
+	return key, key[:apiKeyPrefixLen], appmw.HashAPIKey(key), nil
+}
+
+// normalizeAllowedIPs validates an IP allow-list of addresses and CIDR ranges
+// and returns it in canonical form.
+func normalizeAllowedIPs(entries []string) ([]string, error) {
+	out := []string{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			out = append(out, network.String())
+		} else if ip := net.ParseIP(entry); ip != nil {
+			out = append(out, ip.String())
+		} else {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "allowed_ips entry "+entry+" is not an IP address or CIDR range")
+		}
+	}
+	return out, nil
+}
+
+// apiKeyManager returns the claims of a user allowed to manage API keys. Keys
+// cannot manage keys, so a leaked key cannot mint others.
+func apiKeyManager(c echo.Context) (*appmw.Claims, error) {
+	claims, err := appmw.GetUserClaims(c)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	if claims.APIKeyID != "" {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "API keys cannot manage API keys")
+	}
+	return claims, nil
+}
+
+// ListAPIKeys returns the tenant's API keys that have not been revoked.
+func (h *Handler) ListAPIKeys(c echo.Context) error {
+	claims, err := apiKeyManager(c)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.db(c).Query(`
+		SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE tenant_id = $1 AND revoked_at IS NULL
+		ORDER BY name, created_at
+	`, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey issues an API key. Scopes are permissions the caller holds; the
+// key is returned once and only its hash is stored.
+func (h *Handler) CreateAPIKey(c echo.Context) error {
+	claims, err := apiKeyManager(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Name       string     `json:"name"`
+		Scopes     []string   `json:"scopes"`
+		AllowedIPs []string   `json:"allowed_ips"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 255 {
+		return echo.NewHTTPError(http.StatusBadRequest, "name is required and at most 255 characters")
+	}
+	if len(req.Scopes) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "scopes must name at least one permission")
+	}
+	scopes, err := validatePermissions(req.Scopes)
+	if err != nil {
+		return err
+	}
+	for _, p := range scopes {
+		if !claims.HasPermission(p) {
+			return echo.NewHTTPError(http.StatusForbidden, "cannot grant permission "+p+" you do not have")
+		}
+	}
+	allowedIPs, err := normalizeAllowedIPs(req.AllowedIPs)
+	if err != nil {
+		return err
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "expires_at must be in the future")
+	}
+
+	key, prefix, hash, err := newAPIKey()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate key")
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	k, err := scanAPIKey(tx.QueryRow(`
+		INSERT INTO api_keys (tenant_id, name, prefix, key_hash, scopes, allowed_ips, expires_at, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())
+		RETURNING `+apiKeyColumns,
+		claims.TenantID, name, prefix, hash, pq.Array(scopes), pq.Array(allowedIPs), req.ExpiresAt, claims.UserID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := recordAudit(c, tx, AuditCreate, "api_key", k.ID, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	k.Key = key
+	return c.JSON(http.StatusCreated, k)
+}
+
+// RotateAPIKey replaces a key's secret, keeping its settings. The old key
+// stops working immediately.
+func (h *Handler) RotateAPIKey(c echo.Context) error {
+	claims, err := apiKeyManager(c)
+	if err != nil {
+		return err
+	}
+	id := c.Param("id")
+
+	key, prefix, hash, err := newAPIKey()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate key")
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "api_key", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	k, err := scanAPIKey(tx.QueryRow(`
+		UPDATE api_keys SET prefix = $3, key_hash = $4, rotated_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND tenant_id = $2 AND revoked_at IS NULL
+		RETURNING `+apiKeyColumns,
+		id, claims.TenantID, prefix, hash))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "API key not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := recordAudit(c, tx, AuditUpdate, "api_key", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	k.Key = key
+	return c.JSON(http.StatusOK, k)
+}
+
+// RevokeAPIKey permanently disables a key. Revoked keys are kept for the audit
+// trail.
+func (h *Handler) RevokeAPIKey(c echo.Context) error {
+	claims, err := apiKeyManager(c)
+	if err != nil {
+		return err
+	}
+	id := c.Param("id")
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "api_key", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	res, err := tx.Exec(`
+		UPDATE api_keys SET revoked_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND tenant_id = $2 AND revoked_at IS NULL
+	`, id, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "API key not found")
+	}
+	if err := recordAudit(c, tx, AuditDisable, "api_key", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
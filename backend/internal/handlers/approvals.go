@@ -0,0 +1,606 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+// Document types approval rules apply to
+const (
+	ApprovalPurchaseOrder = "PURCHASE_ORDER"
+	ApprovalAdjustment    = "ADJUSTMENT"
+)
+
+const maxRequiredApprovals = 5
+
+// ApprovalRule requires documents of a type worth at least MinAmount to be
+// approved by RequiredApprovals different users, each holding RequiredRole
+// (or a higher built-in role) when it is set. Documents matching several
+// rules need the most approvals of them and satisfy every role.
+type ApprovalRule struct {
+	ID                string          `json:"id"`
+	DocumentType      string          `json:"document_type"`
+	MinAmount         decimal.Decimal `json:"min_amount"`
+	RequiredRole      *string         `json:"required_role,omitempty"`
+	RequiredApprovals int             `json:"required_approvals"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
+}
+
+// Approval is one user's approval of a document. Approvals given before the
+// document was last edited are superseded and no longer count.
+type Approval struct {
+	ID           string          `json:"id"`
+	UserID       string          `json:"user_id"`
+	UserName     string          `json:"user_name"`
+	UserEmail    string          `json:"user_email"`
+	Role         string          `json:"role"`
+	Amount       decimal.Decimal `json:"amount"`
+	Comment      *string         `json:"comment,omitempty"`
+	SupersededAt *time.Time      `json:"superseded_at,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// PendingApproval is a document in a user's approval queue.
+type PendingApproval struct {
+	DocumentType      string          `json:"document_type"`
+	DocumentID        string          `json:"document_id"`
+	Number            string          `json:"number"`
+	Amount            decimal.Decimal `json:"amount"`
+	CreatedBy         *string         `json:"created_by,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+	Approvals         int             `json:"approvals"`
+	RequiredApprovals int             `json:"required_approvals"`
+	RequiredRoles     []string        `json:"required_roles,omitempty"`
+}
+
+// approvalRequirement is what a document needs before it is approved.
+type approvalRequirement struct {
+	Approvals int
+	Roles     []string
+}
+
+// approvalState is the outcome of recording an approval.
+type approvalState struct {
+	Approvals         int      `json:"approvals"`
+	RequiredApprovals int      `json:"required_approvals"`
+	RequiredRoles     []string `json:"required_roles,omitempty"`
+}
+
+func (s approvalState) complete() bool {
+	return s.Approvals >= s.RequiredApprovals
+}
+
+// Built-in roles by seniority, for rules requiring a role
+var roleRank = map[string]int{
+	appmw.RoleClerk:   1,
+	appmw.RoleManager: 2,
+	appmw.RoleAdmin:   3,
+}
+
+// roleSatisfies reports whether a user with role may approve where required is
+// needed. ADMIN satisfies every role; custom roles only satisfy themselves.
+func roleSatisfies(role, required string) bool {
+	if role == required || role == appmw.RoleAdmin {
+		return true
+	}
+	have, ok := roleRank[role]
+	need, requiredOK := roleRank[required]
+	return ok && requiredOK && have >= need
+}
+
+// requirementFor combines the rules that apply to a document worth amount.
+// Without a matching rule one approval by anyone but the creator suffices.
+func requirementFor(rules []ApprovalRule, amount decimal.Decimal) approvalRequirement {
+	req := approvalRequirement{Approvals: 1}
+	seen := map[string]bool{}
+	for _, r := range rules {
+		if amount.LessThan(r.MinAmount) {
+			continue
+		}
+		if r.RequiredApprovals > req.Approvals {
+			req.Approvals = r.RequiredApprovals
+		}
+		if r.RequiredRole != nil && !seen[*r.RequiredRole] {
+			seen[*r.RequiredRole] = true
+			req.Roles = append(req.Roles, *r.RequiredRole)
+		}
+	}
+	sort.Strings(req.Roles)
+	return req
+}
+
+func (r approvalRequirement) allows(role string) bool {
+	for _, required := range r.Roles {
+		if !roleSatisfies(role, required) {
+			return false
+		}
+	}
+	return true
+}
+
+func isApprovalDocumentType(t string) bool {
+	return t == ApprovalPurchaseOrder || t == ApprovalAdjustment
+}
+
+const approvalRuleColumns = `id, document_type, min_amount, required_role, required_approvals, created_at, updated_at`
+
+func scanApprovalRule(row rowScanner) (ApprovalRule, error) {
+	var r ApprovalRule
+	var role sql.NullString
+	if err := row.Scan(&r.ID, &r.DocumentType, &r.MinAmount, &role, &r.RequiredApprovals, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return r, err
+	}
+	if role.Valid {
+		r.RequiredRole = &role.String
+	}
+	return r, nil
+}
+
+func loadApprovalRules(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, tenantID, documentType string) ([]ApprovalRule, error) {
+	query := `SELECT ` + approvalRuleColumns + ` FROM approval_rules WHERE tenant_id = $1`
+	args := []interface{}{tenantID}
+	if documentType != "" {
+		query += ` AND document_type = $2`
+		args = append(args, documentType)
+	}
+	rows, err := q.Query(query+` ORDER BY document_type, min_amount`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []ApprovalRule{}
+	for rows.Next() {
+		r, err := scanApprovalRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// documentAmount is the value approval thresholds are compared with: the
+// ordered value of a purchase order, or the absolute value of an adjustment's
+// quantity changes at current item cost.
+func documentAmount(q queryRower, documentType, id, tenantID string) (decimal.Decimal, error) {
+	var amount decimal.Decimal
+	var err error
+	switch documentType {
+	case ApprovalPurchaseOrder:
+		err = q.QueryRow(`
+			SELECT COALESCE(SUM(pol.qty_ordered * pol.unit_cost), 0)
+			FROM purchase_order_lines pol
+			JOIN purchase_orders po ON po.id = pol.purchase_order_id
+			WHERE pol.purchase_order_id = $1 AND po.tenant_id = $2
+		`, id, tenantID).Scan(&amount)
+	case ApprovalAdjustment:
+		err = q.QueryRow(`
+			SELECT COALESCE(SUM(ABS(al.qty_diff) * COALESCE(i.cost, 0)), 0)
+			FROM adjustment_lines al
+			JOIN adjustments a ON a.id = al.adjustment_id
+			LEFT JOIN items i ON i.id = al.item_id
+			WHERE al.adjustment_id = $1 AND a.tenant_id = $2
+		`, id, tenantID).Scan(&amount)
+	default:
+		err = fmt.Errorf("unknown document type %s", documentType)
+	}
+	return amount, err
+}
+
+// recordApproval records the caller's approval of a document after enforcing
+// separation of duties and the tenant's approval rules, and returns how many
+// of the required approvals the document now has.
+func recordApproval(c echo.Context, tx *sql.Tx, documentType, id string, createdBy sql.NullString) (approvalState, error) {
+	claims, err := appmw.GetUserClaims(c)
+	if err != nil {
+		return approvalState{}, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	if createdBy.Valid && createdBy.String == claims.UserID {
+		return approvalState{}, echo.NewHTTPError(http.StatusForbidden, "the creator of a document cannot approve it")
+	}
+
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return approvalState{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	amount, err := documentAmount(tx, documentType, id, claims.TenantID)
+	if err != nil {
+		return approvalState{}, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	rules, err := loadApprovalRules(tx, claims.TenantID, documentType)
+	if err != nil {
+		return approvalState{}, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	requirement := requirementFor(rules, amount)
+	if !requirement.allows(claims.Role) {
+		return approvalState{}, echo.NewHTTPError(http.StatusForbidden,
+			fmt.Sprintf("approving this document requires the %s role", strings.Join(requirement.Roles, " and ")))
+	}
+
+	var already bool
+	if err := tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM approvals
+			WHERE document_type = $1 AND document_id = $2 AND user_id = $3 AND superseded_at IS NULL)
+	`, documentType, id, claims.UserID).Scan(&already); err != nil {
+		return approvalState{}, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if already {
+		return approvalState{}, echo.NewHTTPError(http.StatusConflict, "you have already approved this document")
+	}
+
+	state := approvalState{RequiredApprovals: requirement.Approvals, RequiredRoles: requirement.Roles}
+	if err := tx.QueryRow(`
+		WITH inserted AS (
+			INSERT INTO approvals (tenant_id, document_type, document_id, user_id, role, amount, comment, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+			RETURNING id
+		)
+		SELECT COUNT(*) + 1 FROM approvals
+		WHERE document_type = $2 AND document_id = $3 AND superseded_at IS NULL
+	`, claims.TenantID, documentType, id, claims.UserID, claims.Role, amount, nullIfEmpty(strings.TrimSpace(req.Comment))).Scan(&state.Approvals); err != nil {
+		return approvalState{}, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return state, nil
+}
+
+// supersedeApprovals voids the approvals of a document that has been edited.
+func supersedeApprovals(tx *sql.Tx, documentType, id string) error {
+	_, err := tx.Exec(`
+		UPDATE approvals SET superseded_at = NOW()
+		WHERE document_type = $1 AND document_id = $2 AND superseded_at IS NULL
+	`, documentType, id)
+	return err
+}
+
+// approvalResponse answers an approve request: 200 once the document is
+// approved, 202 while it still needs approvals from others.
+func approvalResponse(c echo.Context, state approvalState, approvedMessage string) error {
+	if !state.complete() {
+		return c.JSON(http.StatusAccepted, map[string]interface{}{
+			"message":            fmt.Sprintf("Approval recorded; %d of %d approvals", state.Approvals, state.RequiredApprovals),
+			"approvals":          state.Approvals,
+			"required_approvals": state.RequiredApprovals,
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":            approvedMessage,
+		"approvals":          state.Approvals,
+		"required_approvals": state.RequiredApprovals,
+	})
+}
+
+// listApprovals returns the approval history of a document, oldest first.
+func (h *Handler) listApprovals(c echo.Context, documentType, id string) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	rows, err := h.db(c).Query(`
+		SELECT a.id, a.user_id, u.name, u.email, a.role, a.amount, a.comment, a.superseded_at, a.created_at
+		FROM approvals a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.tenant_id = $1 AND a.document_type = $2 AND a.document_id = $3
+		ORDER BY a.created_at
+	`, claims.TenantID, documentType, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer rows.Close()
+
+	approvals := []Approval{}
+	for rows.Next() {
+		var a Approval
+		var comment sql.NullString
+		var supersededAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.UserID, &a.UserName, &a.UserEmail, &a.Role, &a.Amount, &comment, &supersededAt, &a.CreatedAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if comment.Valid {
+			a.Comment = &comment.String
+		}
+		if supersededAt.Valid {
+			a.SupersededAt = &supersededAt.Time
+		}
+		approvals = append(approvals, a)
+	}
+	if err := rows.Err(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, approvals)
+}
+
+// ListPurchaseOrderApprovals returns a purchase order's approval history.
+func (h *Handler) ListPurchaseOrderApprovals(c echo.Context) error {
+	return h.listApprovals(c, ApprovalPurchaseOrder, c.Param("id"))
+}
+
+// ListAdjustmentApprovals returns an adjustment's approval history.
+func (h *Handler) ListAdjustmentApprovals(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	if err := checkAdjustmentLocation(c, h.db(c), c.Param("id"), claims.TenantID); err != nil {
+		return err
+	}
+	return h.listApprovals(c, ApprovalAdjustment, c.Param("id"))
+}
+
+// ListPendingApprovals returns the draft purchase orders and adjustments the
+// caller can approve: not created or already approved by them, with rules
+// their role satisfies, and (for adjustments) at their locations.
+func (h *Handler) ListPendingApprovals(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	rules, err := loadApprovalRules(h.db(c), claims.TenantID, "")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	rulesByType := map[string][]ApprovalRule{}
+	for _, r := range rules {
+		rulesByType[r.DocumentType] = append(rulesByType[r.DocumentType], r)
+	}
+
+	// Drafts not created by the caller, with their value and current approvals
+	const approvalCounts = `
+		(SELECT COUNT(*) FROM approvals ap
+			WHERE ap.document_type = $3 AND ap.document_id = d.id AND ap.superseded_at IS NULL),
+		EXISTS (SELECT 1 FROM approvals ap
+			WHERE ap.document_type = $3 AND ap.document_id = d.id AND ap.superseded_at IS NULL AND ap.user_id = $2)`
+	type pendingQuery struct {
+		documentType string
+		query        string
+		args         []interface{}
+	}
+	var queries []pendingQuery
+	if claims.HasPermission(appmw.PermPOApprove) {
+		queries = append(queries, pendingQuery{ApprovalPurchaseOrder, `
+			SELECT d.id, d.number, d.created_by, d.created_at,
+				(SELECT COALESCE(SUM(qty_ordered * unit_cost), 0) FROM purchase_order_lines WHERE purchase_order_id = d.id),
+				` + approvalCounts + `
+			FROM purchase_orders d
+			WHERE d.tenant_id = $1 AND d.status = 'DRAFT' AND (d.created_by IS NULL OR d.created_by <> $2)
+			ORDER BY d.created_at`, []interface{}{claims.TenantID, claims.UserID, ApprovalPurchaseOrder}})
+	}
+	if claims.HasPermission(appmw.PermAdjustmentApprove) {
+		query := `
+			SELECT d.id, d.number, d.created_by, d.created_at,
+				(SELECT COALESCE(SUM(ABS(al.qty_diff) * COALESCE(i.cost, 0)), 0)
+					FROM adjustment_lines al LEFT JOIN items i ON i.id = al.item_id WHERE al.adjustment_id = d.id),
+				` + approvalCounts + `
+			FROM adjustments d
+			WHERE d.tenant_id = $1 AND d.status = 'DRAFT' AND (d.created_by IS NULL OR d.created_by <> $2)`
+		args := []interface{}{claims.TenantID, claims.UserID, ApprovalAdjustment}
+		if allowed, restricted := appmw.GetAllowedLocations(c.Request().Context()); restricted {
+			args = append(args, pq.Array(allowed))
+			query += ` AND d.location_id = ANY($4)`
+		}
+		queries = append(queries, pendingQuery{ApprovalAdjustment, query + ` ORDER BY d.created_at`, args})
+	}
+
+	pending := []PendingApproval{}
+	for _, q := range queries {
+		rows, err := h.db(c).Query(q.query, q.args...)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		for rows.Next() {
+			p := PendingApproval{DocumentType: q.documentType}
+			var createdBy sql.NullString
+			var approvedByCaller bool
+			if err := rows.Scan(&p.DocumentID, &p.Number, &createdBy, &p.CreatedAt, &p.Amount, &p.Approvals, &approvedByCaller); err != nil {
+				rows.Close()
+				return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+			}
+			requirement := requirementFor(rulesByType[q.documentType], p.Amount)
+			if approvedByCaller || !requirement.allows(claims.Role) {
+				continue
+			}
+			if createdBy.Valid {
+				p.CreatedBy = &createdBy.String
+			}
+			p.RequiredApprovals = requirement.Approvals
+			p.RequiredRoles = requirement.Roles
+			pending = append(pending, p)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+
+	return c.JSON(http.StatusOK, pending)
+}
+
+// ListApprovalRules returns the tenant's approval rules.
+func (h *Handler) ListApprovalRules(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	rules, err := loadApprovalRules(h.db(c), claims.TenantID, "")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+type approvalRuleRequest struct {
+	DocumentType      string          `json:"document_type"`
+	MinAmount         decimal.Decimal `json:"min_amount"`
+	RequiredRole      *string         `json:"required_role"`
+	RequiredApprovals int             `json:"required_approvals"`
+}
+
+// validate normalises the request and checks it against the tenant's roles.
+func (r *approvalRuleRequest) validate(q queryRower, tenantID string) error {
+	r.DocumentType = strings.ToUpper(strings.TrimSpace(r.DocumentType))
+	if !isApprovalDocumentType(r.DocumentType) {
+		return echo.NewHTTPError(http.StatusBadRequest, "document_type must be PURCHASE_ORDER or ADJUSTMENT")
+	}
+	if r.MinAmount.IsNegative() {
+		return echo.NewHTTPError(http.StatusBadRequest, "min_amount cannot be negative")
+	}
+	if r.RequiredApprovals == 0 {
+		r.RequiredApprovals = 1
+	}
+	if r.RequiredApprovals < 1 || r.RequiredApprovals > maxRequiredApprovals {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("required_approvals must be between 1 and %d", maxRequiredApprovals))
+	}
+	if r.RequiredRole != nil {
+		role := strings.ToUpper(strings.TrimSpace(*r.RequiredRole))
+		if role == "" {
+			r.RequiredRole = nil
+			return nil
+		}
+		if err := checkAssignableRole(q, tenantID, role); err != nil {
+			return err
+		}
+		r.RequiredRole = &role
+	}
+	return nil
+}
+
+// CreateApprovalRule adds an approval rule.
+func (h *Handler) CreateApprovalRule(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req approvalRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if err := req.validate(h.db(c), claims.TenantID); err != nil {
+		return err
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	rule, err := scanApprovalRule(tx.QueryRow(`
+		INSERT INTO approval_rules (tenant_id, document_type, min_amount, required_role, required_approvals, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+		RETURNING `+approvalRuleColumns,
+		claims.TenantID, req.DocumentType, req.MinAmount, req.RequiredRole, req.RequiredApprovals))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := recordAudit(c, tx, AuditCreate, "approval_rule", rule.ID, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateApprovalRule replaces an approval rule. Approvals already given are
+// kept; the new rule applies to the documents' next approval.
+func (h *Handler) UpdateApprovalRule(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	id := c.Param("id")
+
+	var req approvalRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if err := req.validate(h.db(c), claims.TenantID); err != nil {
+		return err
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "approval_rule", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	rule, err := scanApprovalRule(tx.QueryRow(`
+		UPDATE approval_rules
+		SET document_type = $3, min_amount = $4, required_role = $5, required_approvals = $6, updated_at = NOW()
+		WHERE id = $1 AND tenant_id = $2
+		RETURNING `+approvalRuleColumns,
+		id, claims.TenantID, req.DocumentType, req.MinAmount, req.RequiredRole, req.RequiredApprovals))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "approval rule not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := recordAudit(c, tx, AuditUpdate, "approval_rule", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, rule)
+}
+
+// DeleteApprovalRule removes an approval rule.
+func (h *Handler) DeleteApprovalRule(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	id := c.Param("id")
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "approval_rule", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	res, err := tx.Exec(`DELETE FROM approval_rules WHERE id = $1 AND tenant_id = $2`, id, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "approval rule not found")
+	}
+	if err := recordAudit(c, tx, AuditDelete, "approval_rule", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
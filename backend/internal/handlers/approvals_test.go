@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"testing"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleSatisfies(t *testing.T) {
+	assert.True(t, roleSatisfies(appmw.RoleManager, appmw.RoleManager))
+	assert.True(t, roleSatisfies(appmw.RoleAdmin, appmw.RoleManager))
+	assert.True(t, roleSatisfies(appmw.RoleAdmin, "BUYER"), "admins satisfy custom roles")
+	assert.False(t, roleSatisfies(appmw.RoleClerk, appmw.RoleManager))
+	assert.False(t, roleSatisfies("BUYER", appmw.RoleClerk), "custom roles have no rank")
+	assert.True(t, roleSatisfies("BUYER", "BUYER"))
+}
+
+func TestRequirementFor(t *testing.T) {
+	manager := appmw.RoleManager
+	rules := []ApprovalRule{
+		{MinAmount: decimal.NewFromInt(5000), RequiredRole: &manager, RequiredApprovals: 1},
+		{MinAmount: decimal.NewFromInt(500), RequiredApprovals: 2},
+	}
+
+	req := requirementFor(rules, decimal.NewFromInt(100))
+	assert.Equal(t, 1, req.Approvals)
+	assert.Empty(t, req.Roles)
+	assert.True(t, req.allows(appmw.RoleClerk))
+
+	req = requirementFor(rules, decimal.NewFromInt(500))
+	assert.Equal(t, 2, req.Approvals, "threshold is inclusive")
+	assert.Empty(t, req.Roles)
+
+	req = requirementFor(rules, decimal.NewFromInt(7500))
+	assert.Equal(t, 2, req.Approvals)
+	assert.Equal(t, []string{appmw.RoleManager}, req.Roles)
+	assert.False(t, req.allows(appmw.RoleClerk))
+	assert.True(t, req.allows(appmw.RoleAdmin))
+}
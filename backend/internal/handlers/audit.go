@@ -1,17 +1,252 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
+
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
+// Audit actions
+const (
+	AuditCreate  = "CREATE"
+	AuditUpdate  = "UPDATE"
+	AuditDelete  = "DELETE"
+	AuditApprove = "APPROVE"
+	AuditPost    = "POST"
+	AuditReceive = "RECEIVE"
+	AuditShip    = "SHIP"
+	AuditReturn  = "RETURN"
+	AuditClose   = "CLOSE"
+	AuditDisable = "DISABLE"
+	// Login protection
+	AuditLoginFailed = "LOGIN_FAILED"
+	AuditLock        = "LOCK"
+	AuditUnlock      = "UNLOCK"
+)
+
+// auditEntry starts an audit entry carrying the actor and request metadata of c.
+func auditEntry(c echo.Context, action, entity, entityID string) services.AuditEntry {
+	e := services.AuditEntry{
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		IPAddress: c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+	if e.RequestID == "" {
+		e.RequestID = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+	if claims, err := appmw.GetUserClaims(c); err == nil {
+		e.UserID = claims.UserID
+		e.TenantID = claims.TenantID
+	}
+	if entity == "tenant" {
+		e.TenantID = entityID
+	}
+	return e
+}
+
+// auditSnapshot captures the before image of an entity on tx.
+func auditSnapshot(c echo.Context, tx *sql.Tx, entity, id string) (json.RawMessage, error) {
+	return services.NewAuditService(tx).Snapshot(c.Request().Context(), entity, id)
+}
+
+// recordAudit writes an audit entry on tx, taking the after image from the
+// entity's current state in the transaction. Call it after the mutation and
+// before Commit so the change and its audit record succeed or fail together.
+func recordAudit(c echo.Context, tx *sql.Tx, action, entity, entityID string, before json.RawMessage) error {
+	return writeAudit(c, tx, auditEntry(c, action, entity, entityID), before)
+}
+
+// writeAudit is recordAudit for a prepared entry, for callers such as
+// registration whose actor is not yet in the request claims.
+func writeAudit(c echo.Context, tx *sql.Tx, e services.AuditEntry, before json.RawMessage) error {
+	audit := services.NewAuditService(tx)
+	after, err := audit.Snapshot(c.Request().Context(), e.Entity, e.EntityID)
+	if err != nil {
+		return err
+	}
+	e.Before, e.After = before, after
+	return audit.Record(c.Request().Context(), e)
+}
+
+// GetAuditLogs lists the tenant's audit trail
+// (?entity=&entity_id=&user_id=&action=&from=&to=&page=&page_size=).
 func (h *Handler) GetAuditLogs(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page <= 0 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.QueryParam("page_size"))
+	if pageSize <= 0 || pageSize > h.Config.MaxPageSize {
+		pageSize = h.Config.DefaultPageSize
+	}
+
+	f := services.AuditFilter{
+		TenantID: claims.TenantID,
+		Entity:   c.QueryParam("entity"),
+		EntityID: c.QueryParam("entity_id"),
+		UserID:   c.QueryParam("user_id"),
+		Action:   c.QueryParam("action"),
+		Limit:    pageSize,
+		Offset:   (page - 1) * pageSize,
+	}
+	if f.Entity != "" && !services.IsAuditedEntity(f.Entity) {
+		return echo.NewHTTPError(http.StatusBadRequest, "unknown entity")
+	}
+	if _, err := uuid.Parse(f.EntityID); f.EntityID != "" && err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid entity_id, expected UUID")
+	}
+	if _, err := uuid.Parse(f.UserID); f.UserID != "" && err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user_id, expected UUID")
+	}
+	if v := c.QueryParam("from"); v != "" {
+		from, err := parseDateParam(v, false)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid from, expected RFC3339 timestamp or YYYY-MM-DD")
+		}
+		f.From = &from
+	}
+	if v := c.QueryParam("to"); v != "" {
+		to, err := parseDateParam(v, true)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid to, expected RFC3339 timestamp or YYYY-MM-DD")
+		}
+		f.To = &to
+	}
+
+	logs, total, err := services.NewAuditService(h.DB).ListAuditLogs(c.Request().Context(), f)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load audit logs")
+	}
+
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
 	return c.JSON(http.StatusOK, PaginatedResponse{
-		Data:       []interface{}{},
-		Page:       1,
-		PageSize:   20,
-		TotalPages: 0,
-		Total:      0,
+		Data:       logs,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: totalPages,
+		Total:      total,
+	})
+}
+
+// VerifyAuditChains walks the tenant's audit log and stock movement hash chains
+// and reports the first broken link of each, if any.
+func (h *Handler) VerifyAuditChains(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	chains := services.NewHashChainService(tx)
+	reports := []*services.ChainReport{}
+	valid := true
+	for _, chain := range services.Chains {
+		report, err := chains.Verify(ctx, chain.Name, claims.TenantID, []byte(h.Config.AuditSigningKey))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to verify audit chain")
+		}
+		valid = valid && report.Valid
+		reports = append(reports, report)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"valid":  valid,
+		"chains": reports,
+	})
+}
+
+// ExportAuditCheckpoints downloads the tenant's signed chain checkpoints
+// (?from=&to=) for safekeeping outside the database.
+func (h *Handler) ExportAuditCheckpoints(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var from, to time.Time
+	if v := c.QueryParam("from"); v != "" {
+		t, err := parseDateParam(v, false)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid from, expected RFC3339 timestamp or YYYY-MM-DD")
+		}
+		from = t
+	}
+	if v := c.QueryParam("to"); v != "" {
+		t, err := parseDateParam(v, true)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid to, expected RFC3339 timestamp or YYYY-MM-DD")
+		}
+		to = t
+	}
+
+	checkpoints, err := services.NewHashChainService(h.DB).ListCheckpoints(c.Request().Context(), claims.TenantID, from, to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load checkpoints")
+	}
+
+	now := time.Now().UTC()
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="audit-checkpoints-%s.json"`, now.Format("20060102")))
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tenant_id":   claims.TenantID,
+		"exported_at": now,
+		"algorithm":   "HMAC-SHA256(tenant_id|chain|seq|head_hash|created_at)",
+		"checkpoints": checkpoints,
 	})
-}
\ No newline at end of file
+}
+
+// CreateAuditCheckpoint signs the tenant's current chain heads now, in addition
+// to the daily checkpoints.
+func (h *Handler) CreateAuditCheckpoint(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	n, err := services.NewHashChainService(h.DB).CreateCheckpoints(c.Request().Context(), []byte(h.Config.AuditSigningKey), claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create checkpoint")
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{"created": n})
+}
+
+// parseDateParam parses an RFC3339 timestamp or a date. A date is the start of
+// that day (UTC), or the start of the next day when it ends an exclusive range.
+func parseDateParam(value string, endOfRange bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfRange {
+		d = d.AddDate(0, 0, 1)
+	}
+	return d, nil
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"inventory/internal/config"
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDateParam(t *testing.T) {
+	from, err := parseDateParam("2024-03-01", false)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), from)
+
+	to, err := parseDateParam("2024-03-01", true)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), to)
+
+	ts, err := parseDateParam("2024-03-01T10:30:00Z", true)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC), ts)
+
+	_, err = parseDateParam("March 1st", false)
+	assert.Error(t, err)
+}
+
+func TestAuditEntry(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(echo.HeaderXRequestID, "req-1")
+	req.Header.Set("User-Agent", "test-agent")
+	req.RemoteAddr = "10.0.0.5:1234"
+	c := e.NewContext(req, httptest.NewRecorder())
+	c.Set("user", &appmw.Claims{UserID: "u1", TenantID: "t1"})
+
+	entry := auditEntry(c, AuditCreate, "item", "i1")
+	assert.Equal(t, "t1", entry.TenantID)
+	assert.Equal(t, "u1", entry.UserID)
+	assert.Equal(t, "req-1", entry.RequestID)
+	assert.Equal(t, "10.0.0.5", entry.IPAddress)
+	assert.Equal(t, "test-agent", entry.UserAgent)
+
+	// Tenant changes are filed under the tenant they affect.
+	entry = auditEntry(c, AuditUpdate, "tenant", "t2")
+	assert.Equal(t, "t2", entry.TenantID)
+}
+
+func TestGetAuditLogsRejectsInvalidIDs(t *testing.T) {
+	h := &Handler{Config: &config.Config{DefaultPageSize: 20, MaxPageSize: 100}}
+	e := echo.New()
+	for _, query := range []string{"user_id=abc", "entity_id=42", "user_id=00000000-0000-0000-0000-00000000000g"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/audit?"+query, nil)
+		c := e.NewContext(req, httptest.NewRecorder())
+		c.Set("user", &appmw.Claims{UserID: "u1", TenantID: "t1"})
+
+		err := h.GetAuditLogs(c)
+		var he *echo.HTTPError
+		require.ErrorAs(t, err, &he, query)
+		assert.Equal(t, http.StatusBadRequest, he.Code, query)
+	}
+}
@@ -56,6 +56,7 @@ type LoginResponse struct {
 	ExpiresIn    int            `json:"expires_in"`
 	User         UserResponse   `json:"user"`
 	Tenant       TenantResponse `json:"tenant"`
+	Memberships  []Membership   `json:"memberships,omitempty"` // Set when no tenant was asked for
 }
 
 func (h *Handler) Login(c echo.Context) error {
@@ -70,51 +71,52 @@ func (h *Handler) Login(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
 	}
 
+	// On a tenant's subdomain or custom domain the host picks the tenant
+	if host, ok := middleware.GetHostTenant(c.Request().Context()); ok {
+		if req.TenantSlug != "" && req.TenantSlug != host.Slug {
+			return echo.NewHTTPError(http.StatusBadRequest, "tenant_slug does not match this host")
+		}
+		req.TenantSlug = host.Slug
+	}
+
 	log.Info().
 		Str("email", req.Email).
 		Bool("has_password", req.Password != "").
 		Msg("Login attempt")
 
-	// Query user from database with optional tenant filtering
-	var userID, tenantID, tenantName, tenantSlug, hashedPassword, name, role string
-	var isActive bool
-	var oauthProvider sql.NullString
-
-	var query string
-	var args []interface{}
-
-	if req.TenantSlug != "" {
-		// Specific tenant login
-		query = `
-			SELECT u.id, u.tenant_id, t.name, t.slug, u.password_hash, u.name, u.role, u.is_active, u.oauth_provider
-			FROM users u
-			INNER JOIN tenants t ON u.tenant_id = t.id
-			WHERE u.email = $1 AND t.slug = $2 AND u.is_active = true AND t.is_active = true
-		`
-		args = []interface{}{req.Email, req.TenantSlug}
-	} else {
-		// Find first active tenant for this email (backward compatibility)
-		query = `
-			SELECT u.id, u.tenant_id, t.name, t.slug, u.password_hash, u.name, u.role, u.is_active, u.oauth_provider
-			FROM users u
-			INNER JOIN tenants t ON u.tenant_id = t.id
-			WHERE u.email = $1 AND u.is_active = true AND t.is_active = true
-			ORDER BY u.created_at ASC
-			LIMIT 1
-		`
-		args = []interface{}{req.Email}
-	}
-
-	err := h.DB.QueryRow(query, args...).Scan(&userID, &tenantID, &tenantName, &tenantSlug, &hashedPassword, &name, &role, &isActive, &oauthProvider)
+	if err := h.checkLoginThrottle(c, req.Email); err != nil {
+		return err
+	}
+
+	// Accounts are global; the tenant is chosen from the user's memberships.
+	// Databases converted by migrate-to-multitenant may still hold one account
+	// per tenant for an email, so the one belonging to tenant_slug wins.
+	var userID, hashedPassword, name string
+	var homeTenantID, oauthProvider sql.NullString
+	err := h.db(c).QueryRow(`
+		SELECT u.id, u.tenant_id, COALESCE(u.password_hash, ''), u.name, u.oauth_provider
+		FROM users u
+		WHERE u.email = $1
+		ORDER BY EXISTS (
+			SELECT 1 FROM tenant_memberships m JOIN tenants t ON t.id = m.tenant_id
+			WHERE m.user_id = u.id AND t.slug = $2
+		) DESC, u.created_at ASC
+		LIMIT 1
+	`, req.Email, req.TenantSlug).Scan(&userID, &homeTenantID, &hashedPassword, &name, &oauthProvider)
 
 	if err != nil {
 		log.Error().Err(err).Str("email", req.Email).Msg("User not found")
+		h.loginFailed(c, req.Email, "", "", "unknown_account")
 		return echo.NewHTTPError(http.StatusUnauthorized, "invalid email or password")
 	}
+	tenantID := homeTenantID.String
 
 	// Check if user is an OAuth user (no password)
 	if oauthProvider.Valid && oauthProvider.String != "" {
 		log.Error().Str("email", req.Email).Str("oauth_provider", oauthProvider.String).Msg("OAuth user attempting traditional login")
+		if strings.HasPrefix(oauthProvider.String, "oidc:") {
+			return echo.NewHTTPError(http.StatusUnauthorized, "this account uses single sign-on. Please sign in with your identity provider instead.")
+		}
 		return echo.NewHTTPError(http.StatusUnauthorized, "this account uses Google OAuth. Please sign in with Google instead.")
 	}
 
@@ -127,30 +129,49 @@ func (h *Handler) Login(c echo.Context) error {
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)); err != nil {
 		log.Error().Err(err).Str("email", req.Email).Msg("Invalid password")
+		h.loginFailed(c, req.Email, userID, tenantID, "invalid_password")
 		return echo.NewHTTPError(http.StatusUnauthorized, "invalid email or password")
 	}
+	if err := h.loginThrottle(h.DB).Succeed(c.Request().Context(), req.Email); err != nil {
+		log.Error().Err(err).Msg("Failed to clear login throttle")
+	}
 
-	// Generate tokens
-	accessToken, err := h.generateToken(
-		userID,
-		tenantID,
-		req.Email,
-		role,
-		h.Config.JWTExpiry,
-	)
+	var membership Membership
+	var memberships []Membership
+	if req.TenantSlug != "" {
+		membership, err = activeMembership(h.db(c), userID, req.TenantSlug)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusForbidden, "you are not an active member of this company")
+		}
+	} else {
+		// Without a tenant the home tenant (or the first other) is used and the
+		// client is told which others it can switch to
+		memberships, err = listMemberships(h.db(c), userID)
+		if err == nil && len(memberships) == 0 {
+			return echo.NewHTTPError(http.StatusForbidden, "your account is not an active member of any company")
+		}
+		if err == nil {
+			membership = memberships[0]
+		}
+	}
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate access token")
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
 	}
+	tenantID, role := membership.TenantID, membership.Role
 
-	refreshToken, err := h.generateToken(
-		userID,
-		tenantID,
-		req.Email,
-		role,
-		h.Config.RefreshExpiry,
-	)
+	// A second factor, if required, is checked before a session is started
+	challenge, err := h.mfaChallenge(c, userID, tenantID, role)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate refresh token")
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
+	}
+	if challenge != nil {
+		return c.JSON(http.StatusOK, challenge)
+	}
+
+	// Generate tokens
+	accessToken, refreshToken, err := h.startSession(c, userID, tenantID, req.Email, role, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
 	}
 
 	return c.JSON(http.StatusOK, LoginResponse{
@@ -166,9 +187,10 @@ func (h *Handler) Login(c echo.Context) error {
 		},
 		Tenant: TenantResponse{
 			ID:   tenantID,
-			Name: tenantName,
-			Slug: tenantSlug,
+			Name: membership.TenantName,
+			Slug: membership.TenantSlug,
 		},
+		Memberships: memberships,
 	})
 }
 
@@ -207,14 +229,20 @@ func (h *Handler) GoogleOAuth(c echo.Context) error {
 	var isNewUser bool
 	var needsTenant bool
 	var tenantID, tenantName, tenantSlug string
-	var hashedPassword string
+
+	// Accounts are global; an existing one is matched by email
+	err = h.db(c).QueryRow(`SELECT id, name FROM users WHERE email = $1`, googleUser.Email).Scan(&userID, &name)
+	if err != nil && err != sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to look up user")
+	}
+	exists := err == nil
 
 	if req.TenantSlug != "" {
 		// Scenario 1: OAuth with specific tenant
 		log.Info().Str("tenant_slug", req.TenantSlug).Msg("OAuth with specific tenant")
 
 		// Verify tenant exists and is active
-		err = h.DB.QueryRow(`
+		err = h.db(c).QueryRow(`
 			SELECT id, name, slug FROM tenants 
 			WHERE slug = $1 AND is_active = true
 		`, req.TenantSlug).Scan(&tenantID, &tenantName, &tenantSlug)
@@ -224,46 +252,40 @@ func (h *Handler) GoogleOAuth(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusNotFound, "tenant not found or inactive")
 		}
 
-		// Check if user already exists in this tenant
-		err = h.DB.QueryRow(`
-			SELECT id, password_hash, name, role, is_active 
-			FROM users 
-			WHERE email = $1 AND tenant_id = $2
-		`, googleUser.Email, tenantID).Scan(&userID, &hashedPassword, &name, &role, &isActive)
+		// Check if user is already a member of this tenant
+		if exists {
+			err = h.db(c).QueryRow(`
+				SELECT role, is_active FROM tenant_memberships WHERE user_id = $1 AND tenant_id = $2
+			`, userID, tenantID).Scan(&role, &isActive)
+		}
 
-		if err != nil {
-			// User doesn't exist in this tenant, create new user
-			isNewUser = true
+		if !exists || err == sql.ErrNoRows {
+			// Not a member of this tenant yet: join it with the default role
 			role = "CLERK" // Default role for new OAuth users
-			name = googleUser.Name
+			if !exists {
+				isNewUser = true
+				name = googleUser.Name
 
-			// Insert new user
-			err = h.DB.QueryRow(`
-				INSERT INTO users (email, name, role, tenant_id, oauth_provider, oauth_id, avatar_url, is_active)
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-				RETURNING id
-			`, googleUser.Email, googleUser.Name, role, tenantID, "google", googleUser.ID, googleUser.Picture, true).Scan(&userID)
+				// Insert new user
+				err = h.db(c).QueryRow(`
+					INSERT INTO users (email, name, role, tenant_id, oauth_provider, oauth_id, avatar_url, is_active, email_verified_at)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CASE WHEN $9 THEN NOW() END)
+					RETURNING id
+				`, googleUser.Email, googleUser.Name, role, tenantID, "google", googleUser.ID, googleUser.Picture, true, googleUser.VerifiedEmail).Scan(&userID)
 
-			if err != nil {
-				log.Error().Err(err).Str("email", googleUser.Email).Msg("Failed to create new user")
-				return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user account")
-			}
-		} else {
-			// User exists in this tenant, update OAuth info and last login
-			if !isActive {
-				return echo.NewHTTPError(http.StatusUnauthorized, "user account is inactive")
+				if err != nil {
+					log.Error().Err(err).Str("email", googleUser.Email).Msg("Failed to create new user")
+					return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user account")
+				}
 			}
-
-			// Update OAuth info and last login
-			_, err = h.DB.Exec(`
-				UPDATE users 
-				SET oauth_provider = $1, oauth_id = $2, avatar_url = $3, last_login = $4, updated_at = $4
-				WHERE id = $5
-			`, "google", googleUser.ID, googleUser.Picture, time.Now(), userID)
-
-			if err != nil {
-				log.Error().Err(err).Str("user_id", userID).Msg("Failed to update user OAuth info")
+			if err := addMembership(h.db(c), userID, tenantID, role); err != nil {
+				log.Error().Err(err).Str("email", googleUser.Email).Msg("Failed to add user to tenant")
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user account")
 			}
+		} else if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to look up user")
+		} else if !isActive {
+			return echo.NewHTTPError(http.StatusUnauthorized, "user account is inactive")
 		}
 
 		needsTenant = false
@@ -271,25 +293,24 @@ func (h *Handler) GoogleOAuth(c echo.Context) error {
 		// Scenario 2: OAuth without tenant (new user flow)
 		log.Info().Msg("OAuth without tenant - new user flow")
 
-		// Check if user exists in any tenant
-		err = h.DB.QueryRow(`
-			SELECT u.id, u.name, u.role, u.is_active, t.id, t.name, t.slug
-			FROM users u
-			INNER JOIN tenants t ON u.tenant_id = t.id
-			WHERE u.email = $1 AND u.is_active = true AND t.is_active = true
-			ORDER BY u.created_at ASC
-			LIMIT 1
-		`, googleUser.Email).Scan(&userID, &name, &role, &isActive, &tenantID, &tenantName, &tenantSlug)
-
-		if err != nil {
+		if exists {
+			// The user signs in to their home tenant, or the first other one
+			memberships, err := listMemberships(h.db(c), userID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to look up user")
+			}
+			if len(memberships) == 0 {
+				return echo.NewHTTPError(http.StatusUnauthorized, "user account is inactive")
+			}
+			tenantID, tenantName, tenantSlug, role = memberships[0].TenantID, memberships[0].TenantName, memberships[0].TenantSlug, memberships[0].Role
+		} else {
 			// User doesn't exist anywhere, create user and assign to default tenant
 			isNewUser = true
 			role = "ADMIN" // Promote to ADMIN for new users
 			name = googleUser.Name
-			needsTenant = false
 
 			// Get the default tenant
-			err = h.DB.QueryRow(`
+			err = h.db(c).QueryRow(`
 				SELECT id, name, slug FROM tenants 
 				WHERE slug = 'default' AND is_active = true
 			`).Scan(&tenantID, &tenantName, &tenantSlug)
@@ -297,7 +318,7 @@ func (h *Handler) GoogleOAuth(c echo.Context) error {
 			if err != nil {
 				// If default tenant doesn't exist, create it
 				log.Info().Msg("Default tenant not found, creating one")
-				err = h.DB.QueryRow(`
+				err = h.db(c).QueryRow(`
 					INSERT INTO tenants (name, slug, is_active, settings, contact)
 					VALUES ($1, $2, $3, $4, $5)
 					RETURNING id, name, slug
@@ -312,60 +333,53 @@ func (h *Handler) GoogleOAuth(c echo.Context) error {
 			}
 
 			// Insert new user with default tenant
-			err = h.DB.QueryRow(`
-				INSERT INTO users (email, name, role, tenant_id, oauth_provider, oauth_id, avatar_url, is_active)
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			err = h.db(c).QueryRow(`
+				INSERT INTO users (email, name, role, tenant_id, oauth_provider, oauth_id, avatar_url, is_active, email_verified_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CASE WHEN $9 THEN NOW() END)
 				RETURNING id
-			`, googleUser.Email, googleUser.Name, role, tenantID, "google", googleUser.ID, googleUser.Picture, true).Scan(&userID)
+			`, googleUser.Email, googleUser.Name, role, tenantID, "google", googleUser.ID, googleUser.Picture, true, googleUser.VerifiedEmail).Scan(&userID)
 
 			if err != nil {
 				log.Error().Err(err).Str("email", googleUser.Email).Msg("Failed to create new user")
 				return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user account")
 			}
+			if err := addMembership(h.db(c), userID, tenantID, role); err != nil {
+				log.Error().Err(err).Str("email", googleUser.Email).Msg("Failed to add user to tenant")
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user account")
+			}
 
 			log.Info().Str("user_id", userID).Str("tenant_id", tenantID).Msg("New OAuth user created and assigned to default tenant")
-		} else {
-			// User exists in a tenant, update OAuth info
-			if !isActive {
-				return echo.NewHTTPError(http.StatusUnauthorized, "user account is inactive")
-			}
+		}
 
-			// Update OAuth info and last login
-			_, err = h.DB.Exec(`
-				UPDATE users 
-				SET oauth_provider = $1, oauth_id = $2, avatar_url = $3, last_login = $4, updated_at = $4
-				WHERE id = $5
-			`, "google", googleUser.ID, googleUser.Picture, time.Now(), userID)
+		needsTenant = false
+	}
 
-			if err != nil {
-				log.Error().Err(err).Str("user_id", userID).Msg("Failed to update user OAuth info")
-			}
+	if exists {
+		// Update OAuth info and last login
+		_, err = h.db(c).Exec(`
+			UPDATE users 
+			SET oauth_provider = $1, oauth_id = $2, avatar_url = $3, last_login = $4, updated_at = $4
+			WHERE id = $5
+		`, "google", googleUser.ID, googleUser.Picture, time.Now(), userID)
 
-			needsTenant = false
+		if err != nil {
+			log.Error().Err(err).Str("user_id", userID).Msg("Failed to update user OAuth info")
 		}
 	}
-
-	// Generate tokens
-	accessToken, err := h.generateToken(
-		userID,
-		tenantID,
-		googleUser.Email,
-		role,
-		h.Config.JWTExpiry,
-	)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate access token")
+	if !needsTenant {
+		challenge, err := h.mfaChallenge(c, userID, tenantID, role)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
+		}
+		if challenge != nil {
+			return c.JSON(http.StatusOK, challenge)
+		}
 	}
 
-	refreshToken, err := h.generateToken(
-		userID,
-		tenantID,
-		googleUser.Email,
-		role,
-		h.Config.RefreshExpiry,
-	)
+	// Generate tokens
+	accessToken, refreshToken, err := h.startSession(c, userID, tenantID, googleUser.Email, role, false)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate refresh token")
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
 	}
 
 	response := GoogleOAuthResponse{
@@ -395,13 +409,17 @@ func (h *Handler) GoogleOAuth(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
-// SelectTenantForOAuthUser allows OAuth users to select or create a tenant
+// SelectTenantForOAuthUser lets a signed-in user continue in a tenant they are a
+// member of, or create a tenant and become its ADMIN.
 func (h *Handler) SelectTenantForOAuthUser(c echo.Context) error {
 	// Get user from JWT context
 	user := c.Get("user").(*middleware.Claims)
 	if user == nil {
 		return echo.NewHTTPError(http.StatusUnauthorized, "user not authenticated")
 	}
+	if user.APIKeyID != "" {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys cannot select a tenant")
+	}
 
 	var req struct {
 		Action       string `json:"action" validate:"required,oneof=select create"`
@@ -423,32 +441,23 @@ func (h *Handler) SelectTenantForOAuthUser(c echo.Context) error {
 	}
 
 	var tenantID, tenantName, tenantSlug string
+	role := "ADMIN"
 
 	if req.Action == "select" {
-		// User wants to join existing tenant
-		err := h.DB.QueryRow(`
-			SELECT id, name, slug FROM tenants 
-			WHERE slug = $1 AND is_active = true
-		`, req.TenantSlug).Scan(&tenantID, &tenantName, &tenantSlug)
-
-		if err != nil {
-			return echo.NewHTTPError(http.StatusNotFound, "tenant not found or inactive")
+		// Joining a tenant takes an invitation; only memberships can be selected
+		m, err := activeMembership(h.db(c), user.UserID, req.TenantSlug)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "you are not a member of this tenant")
 		}
-
-		// Check if user already exists in this tenant
-		var existingUserID string
-		err = h.DB.QueryRow(`
-			SELECT id FROM users WHERE email = $1 AND tenant_id = $2
-		`, user.Email, tenantID).Scan(&existingUserID)
-
-		if err == nil {
-			return echo.NewHTTPError(http.StatusConflict, "user already exists in this tenant")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to look up tenant")
 		}
+		tenantID, tenantName, tenantSlug, role = m.TenantID, m.TenantName, m.TenantSlug, m.Role
 	} else {
 		// User wants to create new tenant
 		// Check if tenant slug is available
 		var existingTenantID string
-		err := h.DB.QueryRow(`
+		err := h.db(c).QueryRow(`
 			SELECT id FROM tenants WHERE slug = $1
 		`, req.TenantSlug).Scan(&existingTenantID)
 
@@ -456,8 +465,14 @@ func (h *Handler) SelectTenantForOAuthUser(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusConflict, "tenant slug already exists")
 		}
 
+		tx, err := h.db(c).Begin()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create tenant")
+		}
+		defer tx.Rollback()
+
 		// Create new tenant
-		err = h.DB.QueryRow(`
+		err = tx.QueryRow(`
 			INSERT INTO tenants (name, slug, domain, is_active, settings, contact)
 			VALUES ($1, $2, $3, $4, $5, $6)
 			RETURNING id, name, slug
@@ -469,41 +484,37 @@ func (h *Handler) SelectTenantForOAuthUser(c echo.Context) error {
 			log.Error().Err(err).Msg("Failed to create tenant")
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create tenant")
 		}
-	}
-
-	// Update user with tenant_id and promote to ADMIN role
-	_, err := h.DB.Exec(`
-		UPDATE users 
-		SET tenant_id = $1, role = $2, updated_at = $3
-		WHERE id = $4
-	`, tenantID, "ADMIN", time.Now(), user.UserID)
 
-	if err != nil {
-		log.Error().Err(err).Str("user_id", user.UserID).Msg("Failed to assign user to tenant")
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to assign user to tenant")
+		// The creator administers the new tenant; it becomes their home tenant
+		// if they had none
+		if err := addMembership(tx, user.UserID, tenantID, role); err != nil {
+			log.Error().Err(err).Str("user_id", user.UserID).Msg("Failed to assign user to tenant")
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to assign user to tenant")
+		}
+		if _, err := tx.Exec(`
+			UPDATE users SET tenant_id = $1, role = $2, updated_at = $3 WHERE id = $4 AND tenant_id IS NULL
+		`, tenantID, role, time.Now(), user.UserID); err != nil {
+			log.Error().Err(err).Str("user_id", user.UserID).Msg("Failed to assign user to tenant")
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to assign user to tenant")
+		}
+		if err := tx.Commit(); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create tenant")
+		}
 	}
 
-	// Generate new tokens with updated tenant info
-	accessToken, err := h.generateToken(
-		user.UserID,
-		tenantID,
-		user.Email,
-		"ADMIN", // New role
-		h.Config.JWTExpiry,
-	)
+	// A second factor, if required, is checked before a session is started
+	challenge, err := h.mfaChallenge(c, user.UserID, tenantID, role)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate access token")
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
+	}
+	if challenge != nil {
+		return c.JSON(http.StatusOK, challenge)
 	}
 
-	refreshToken, err := h.generateToken(
-		user.UserID,
-		tenantID,
-		user.Email,
-		"ADMIN", // New role
-		h.Config.RefreshExpiry,
-	)
+	// Generate new tokens with updated tenant info
+	accessToken, refreshToken, err := h.startSession(c, user.UserID, tenantID, user.Email, role, false)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate refresh token")
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -514,7 +525,7 @@ func (h *Handler) SelectTenantForOAuthUser(c echo.Context) error {
 			ID:       user.UserID,
 			Name:     "", // Will be updated from database
 			Email:    user.Email,
-			Role:     "ADMIN",
+			Role:     role,
 			TenantID: tenantID,
 		},
 		"tenant": TenantResponse{
@@ -526,52 +537,18 @@ func (h *Handler) SelectTenantForOAuthUser(c echo.Context) error {
 	})
 }
 
-func (h *Handler) Refresh(c echo.Context) error {
-	var req struct {
-		RefreshToken string `json:"refresh_token" validate:"required"`
-	}
-
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
-	}
-
-	claims, err := h.validateToken(req.RefreshToken)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid refresh token")
-	}
-
-	accessToken, err := h.generateToken(
-		claims.UserID,
-		claims.TenantID,
-		claims.Email,
-		claims.Role,
-		h.Config.JWTExpiry,
-	)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate access token")
-	}
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"access_token": accessToken,
-		"expires_in":   int(h.Config.JWTExpiry.Seconds()),
-	})
-}
-
-func (h *Handler) Logout(c echo.Context) error {
-	// TODO: Implement token blacklisting
-	return c.JSON(http.StatusOK, map[string]string{
-		"message": "logged out successfully",
-	})
-}
-
-func (h *Handler) generateToken(userID, tenantID, email, role string, expiry time.Duration) (string, error) {
+// generateSessionToken issues an access token bound to a login session.
+func (h *Handler) generateSessionToken(userID, tenantID, email, role, sessionID string, verified bool, perms []string) (string, error) {
 	claims := &middleware.Claims{
-		UserID:   userID,
-		TenantID: tenantID,
-		Email:    email,
-		Role:     role,
+		UserID:      userID,
+		TenantID:    tenantID,
+		Email:       email,
+		Role:        role,
+		SessionID:   sessionID,
+		Unverified:  !verified,
+		Permissions: perms,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(h.Config.JWTExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
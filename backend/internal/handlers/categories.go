@@ -66,7 +66,7 @@ func (h *Handler) ListCategories(c echo.Context) error {
 	// Count total
 	countSQL := "SELECT COUNT(1) FROM categories " + where
 	var total int64
-	if err := h.DB.QueryRow(countSQL, args...).Scan(&total); err != nil {
+	if err := h.db(c).QueryRow(countSQL, args...).Scan(&total); err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
 	}
 
@@ -79,7 +79,7 @@ func (h *Handler) ListCategories(c echo.Context) error {
 	listSQL = fmt.Sprintf(listSQL, limitIndex, offsetIndex)
 	args = append(args, pageSize, offset)
 
-	rows, err := h.DB.Query(listSQL, args...)
+	rows, err := h.db(c).Query(listSQL, args...)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
 	}
@@ -146,7 +146,13 @@ func (h *Handler) CreateCategory(c echo.Context) error {
 		parentID sql.NullString
 	)
 
-	err := h.DB.QueryRow(
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
 		query,
 		id,
 		tenantID,
@@ -165,6 +171,13 @@ func (h *Handler) CreateCategory(c echo.Context) error {
 		return c.JSON(http.StatusConflict, ErrorResponse{Error: ErrorDetail{Code: "CONFLICT", Message: err.Error()}})
 	}
 
+	if err := recordAudit(c, tx, AuditCreate, "category", id.String(), nil); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+
 	if parentID.Valid {
 		if pid, err := uuid.Parse(parentID.String); err == nil {
 			returned.ParentID = &pid
@@ -197,7 +210,7 @@ func (h *Handler) GetCategory(c echo.Context) error {
 		parentID sql.NullString
 	)
 
-	err = h.DB.QueryRow(query, categoryID, tenantID).Scan(
+	err = h.db(c).QueryRow(query, categoryID, tenantID).Scan(
 		&dto.ID, &dto.Name, &parentID, &dto.CreatedAt, &dto.UpdatedAt,
 	)
 	if err != nil {
@@ -251,7 +264,18 @@ func (h *Handler) UpdateCategory(c echo.Context) error {
 		parentID sql.NullString
 	)
 
-	err = h.DB.QueryRow(
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "category", categoryID.String())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+
+	err = tx.QueryRow(
 		query,
 		req.Name,
 		req.ParentID,
@@ -268,6 +292,13 @@ func (h *Handler) UpdateCategory(c echo.Context) error {
 		return c.JSON(http.StatusConflict, ErrorResponse{Error: ErrorDetail{Code: "CONFLICT", Message: err.Error()}})
 	}
 
+	if err := recordAudit(c, tx, AuditUpdate, "category", categoryID.String(), before); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+
 	if parentID.Valid {
 		if pid, err := uuid.Parse(parentID.String); err == nil {
 			dto.ParentID = &pid
@@ -292,7 +323,7 @@ func (h *Handler) DeleteCategory(c echo.Context) error {
 
 	// Check if there are items using this category
 	var itemCount int64
-	err = h.DB.QueryRow("SELECT COUNT(1) FROM items WHERE category_id = $1 AND tenant_id = $2 AND deleted_at IS NULL", categoryID, tenantID).Scan(&itemCount)
+	err = h.db(c).QueryRow("SELECT COUNT(1) FROM items WHERE category_id = $1 AND tenant_id = $2 AND deleted_at IS NULL", categoryID, tenantID).Scan(&itemCount)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
 	}
@@ -302,7 +333,7 @@ func (h *Handler) DeleteCategory(c echo.Context) error {
 
 	// Check if there are child categories
 	var childCount int64
-	err = h.DB.QueryRow("SELECT COUNT(1) FROM categories WHERE parent_id = $1 AND tenant_id = $2", categoryID, tenantID).Scan(&childCount)
+	err = h.db(c).QueryRow("SELECT COUNT(1) FROM categories WHERE parent_id = $1 AND tenant_id = $2", categoryID, tenantID).Scan(&childCount)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
 	}
@@ -310,8 +341,19 @@ func (h *Handler) DeleteCategory(c echo.Context) error {
 		return c.JSON(http.StatusConflict, ErrorResponse{Error: ErrorDetail{Code: "CONFLICT", Message: "Cannot delete category with child categories"}})
 	}
 
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "category", categoryID.String())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+
 	query := `DELETE FROM categories WHERE id = $1 AND tenant_id = $2`
-	result, err := h.DB.Exec(query, categoryID, tenantID)
+	result, err := tx.Exec(query, categoryID, tenantID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
 	}
@@ -324,5 +366,12 @@ func (h *Handler) DeleteCategory(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, ErrorResponse{Error: ErrorDetail{Code: "NOT_FOUND", Message: "category not found"}})
 	}
 
+	if err := recordAudit(c, tx, AuditDelete, "category", categoryID.String(), before); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+
 	return c.NoContent(http.StatusNoContent)
 }
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Inventory costing methods, configured per tenant
+const (
+	CostingMovingAverage = "MOVING_AVERAGE"
+	CostingFIFO          = "FIFO"
+	CostingStandard      = "STANDARD"
+)
+
+// stockMove describes a single stock movement to be posted with its cost.
+// SourceLineID identifies the document line (e.g. receipt line) that created it.
+type stockMove struct {
+	TenantID     string
+	ItemID       string
+	LocationID   string
+	UserID       string
+	Qty          int
+	Reason       string
+	Reference    string
+	RefID        string
+	SourceLineID string
+	Meta         map[string]interface{}
+}
+
+type CostingSettings struct {
+	CostingMethod string `json:"costing_method"`
+}
+
+// GetCostingSettings returns the tenant's inventory costing method
+func (h *Handler) GetCostingSettings(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	method, err := tenantCostingMethod(h.db(c), claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, CostingSettings{CostingMethod: method})
+}
+
+// UpdateCostingSettings changes the tenant's costing method. Switching to FIFO
+// opens one cost layer per item and location from the current stock at the
+// item's current cost.
+func (h *Handler) UpdateCostingSettings(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req CostingSettings
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	method := strings.ToUpper(strings.TrimSpace(req.CostingMethod))
+	if method != CostingMovingAverage && method != CostingFIFO && method != CostingStandard {
+		return echo.NewHTTPError(http.StatusBadRequest, "costing_method must be one of MOVING_AVERAGE, FIFO, STANDARD")
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "tenant", claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	current, err := tenantCostingMethod(tx, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if current == method {
+		return c.JSON(http.StatusOK, CostingSettings{CostingMethod: method})
+	}
+
+	if method == CostingFIFO {
+		if err := openCostLayers(tx, claims.TenantID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to open cost layers")
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE tenants SET costing_method = $1, updated_at = NOW() WHERE id = $2`, method, claims.TenantID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update costing method")
+	}
+	if err := recordAudit(c, tx, AuditUpdate, "tenant", claims.TenantID, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	h.invalidateDashboard(claims.TenantID)
+	return c.JSON(http.StatusOK, CostingSettings{CostingMethod: method})
+}
+
+func tenantCostingMethod(q queryRower, tenantID string) (string, error) {
+	var method sql.NullString
+	if err := q.QueryRow(`SELECT costing_method FROM tenants WHERE id = $1`, tenantID).Scan(&method); err != nil {
+		return "", err
+	}
+	if !method.Valid || method.String == "" {
+		return CostingMovingAverage, nil
+	}
+	return method.String, nil
+}
+
+// openCostLayers closes any layers left from an earlier FIFO period and opens a
+// fresh layer for the stock on hand of every item and location.
+func openCostLayers(tx *sql.Tx, tenantID string) error {
+	if _, err := tx.Exec(`UPDATE cost_layers SET closed_at = NOW() WHERE tenant_id = $1 AND closed_at IS NULL`, tenantID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO cost_layers (id, tenant_id, item_id, location_id, qty_received, qty_remaining, unit_cost, received_at, created_at)
+		SELECT gen_random_uuid(), il.tenant_id, il.item_id, il.location_id, il.on_hand, il.on_hand, COALESCE(i.cost, 0), NOW(), NOW()
+		FROM inventory_levels il
+		JOIN items i ON i.id = il.item_id
+		WHERE il.tenant_id = $1 AND il.on_hand > 0
+	`, tenantID)
+	return err
+}
+
+// receiveStock posts an inbound movement at unitCost and updates the costing
+// records for the tenant's method. It must run before inventory_levels is
+// increased, since the moving average blends with the stock on hand.
+//
+//   - MOVING_AVERAGE: items.cost becomes the weighted average.
+//   - FIFO: a cost layer is opened; items.cost tracks the moving average for reference.
+//   - STANDARD: the movement is valued at items.cost and the difference to
+//     unitCost is recorded as purchase price variance.
+func receiveStock(tx *sql.Tx, method string, m stockMove, unitCost decimal.Decimal) (decimal.Decimal, error) {
+	var onHand int
+	var currentCost decimal.Decimal
+	err := tx.QueryRow(`
+		SELECT COALESCE((SELECT SUM(on_hand) FROM inventory_levels WHERE item_id = i.id AND tenant_id = i.tenant_id), 0), COALESCE(i.cost, 0)
+		FROM items i WHERE i.id = $1 AND i.tenant_id = $2
+	`, m.ItemID, m.TenantID).Scan(&onHand, &currentCost)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	postedCost := unitCost
+	if method == CostingStandard {
+		postedCost = currentCost
+		if m.Meta == nil {
+			m.Meta = map[string]interface{}{}
+		}
+		m.Meta["purchase_price_variance"] = unitCost.Sub(currentCost).Mul(decimal.NewFromInt(int64(m.Qty))).StringFixed(2)
+	} else {
+		if _, err := tx.Exec(`UPDATE items SET cost = $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3`,
+			movingAverageCost(currentCost, onHand, m.Qty, unitCost), m.ItemID, m.TenantID); err != nil {
+			return decimal.Zero, err
+		}
+	}
+
+	movementID, err := insertStockMovement(tx, m, &postedCost, postedCost.Mul(decimal.NewFromInt(int64(m.Qty))))
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	if method == CostingFIFO {
+		var sourceLine interface{}
+		if m.SourceLineID != "" {
+			sourceLine = m.SourceLineID
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO cost_layers (id, tenant_id, item_id, location_id, movement_id, source_line_id, qty_received, qty_remaining, unit_cost, received_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8, NOW(), NOW())
+		`, uuid.New().String(), m.TenantID, m.ItemID, m.LocationID, movementID, sourceLine, m.Qty, postedCost); err != nil {
+			return decimal.Zero, err
+		}
+	}
+	return postedCost, nil
+}
+
+// issueStock posts an outbound movement of m.Qty units (a positive number; the
+// movement is stored as negative) and returns its total cost. FIFO consumes the
+// oldest layers at the location first and values any shortfall at items.cost;
+// the other methods value the issue at items.cost.
+func issueStock(tx *sql.Tx, method string, m stockMove) (decimal.Decimal, error) {
+	var currentCost decimal.Decimal
+	if err := tx.QueryRow(`SELECT COALESCE(cost, 0) FROM items WHERE id = $1 AND tenant_id = $2`, m.ItemID, m.TenantID).Scan(&currentCost); err != nil {
+		return decimal.Zero, err
+	}
+
+	qty := m.Qty
+	m.Qty = -qty
+	movementID := uuid.New().String()
+
+	if method != CostingFIFO {
+		total := currentCost.Mul(decimal.NewFromInt(int64(qty)))
+		if _, err := insertStockMovementWithID(tx, movementID, m, &currentCost, total.Neg()); err != nil {
+			return decimal.Zero, err
+		}
+		return total, nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, qty_remaining, unit_cost FROM cost_layers
+		WHERE tenant_id = $1 AND item_id = $2 AND location_id = $3 AND qty_remaining > 0 AND closed_at IS NULL
+		ORDER BY received_at, created_at, id
+		FOR UPDATE
+	`, m.TenantID, m.ItemID, m.LocationID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	var layers []fifoLayer
+	for rows.Next() {
+		var l fifoLayer
+		if err := rows.Scan(&l.ID, &l.Remaining, &l.UnitCost); err != nil {
+			rows.Close()
+			return decimal.Zero, err
+		}
+		layers = append(layers, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return decimal.Zero, err
+	}
+
+	takes, shortfall := consumeLayers(layers, qty)
+	total := currentCost.Mul(decimal.NewFromInt(int64(shortfall)))
+	for _, t := range takes {
+		total = total.Add(t.UnitCost.Mul(decimal.NewFromInt(int64(t.Qty))))
+	}
+	unitCost := total.DivRound(decimal.NewFromInt(int64(qty)), 4)
+	if _, err := insertStockMovementWithID(tx, movementID, m, &unitCost, total.Neg()); err != nil {
+		return decimal.Zero, err
+	}
+
+	for _, t := range takes {
+		if _, err := tx.Exec(`UPDATE cost_layers SET qty_remaining = qty_remaining - $1 WHERE id = $2`, t.Qty, t.LayerID); err != nil {
+			return decimal.Zero, err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO cost_layer_consumptions (id, tenant_id, layer_id, movement_id, qty, unit_cost, consumed_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		`, uuid.New().String(), m.TenantID, t.LayerID, movementID, t.Qty, t.UnitCost); err != nil {
+			return decimal.Zero, err
+		}
+	}
+	return total, nil
+}
+
+type fifoLayer struct {
+	ID        string
+	Remaining int
+	UnitCost  decimal.Decimal
+}
+
+type layerTake struct {
+	LayerID  string
+	Qty      int
+	UnitCost decimal.Decimal
+}
+
+// consumeLayers takes qty units from layers in order and reports how many units
+// could not be covered by any layer.
+func consumeLayers(layers []fifoLayer, qty int) ([]layerTake, int) {
+	var takes []layerTake
+	for _, l := range layers {
+		if qty == 0 {
+			break
+		}
+		take := l.Remaining
+		if take > qty {
+			take = qty
+		}
+		if take <= 0 {
+			continue
+		}
+		takes = append(takes, layerTake{LayerID: l.ID, Qty: take, UnitCost: l.UnitCost})
+		qty -= take
+	}
+	return takes, qty
+}
+
+func insertStockMovement(tx *sql.Tx, m stockMove, unitCost *decimal.Decimal, totalCost decimal.Decimal) (string, error) {
+	return insertStockMovementWithID(tx, uuid.New().String(), m, unitCost, totalCost)
+}
+
+// insertStockMovementWithID writes a stock movement with the unit and extended
+// cost it was posted at and links it into the tenant's stock movement chain.
+func insertStockMovementWithID(tx *sql.Tx, id string, m stockMove, unitCost *decimal.Decimal, totalCost decimal.Decimal) (string, error) {
+	var meta, userID, refID interface{}
+	if len(m.Meta) > 0 {
+		raw, err := json.Marshal(m.Meta)
+		if err != nil {
+			return "", err
+		}
+		meta = raw
+	}
+	if m.UserID != "" {
+		userID = m.UserID
+	}
+	if m.RefID != "" {
+		refID = m.RefID
+	}
+	var unit interface{}
+	if unitCost != nil {
+		unit = *unitCost
+	}
+	_, err := tx.Exec(`
+		INSERT INTO stock_movements (id, tenant_id, item_id, location_id, user_id, qty, reason, reference, ref_id, meta, unit_cost, total_cost, occurred_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW(), NOW())
+	`, id, m.TenantID, m.ItemID, m.LocationID, userID, m.Qty, m.Reason, m.Reference, refID, meta, unit, totalCost.Round(2))
+	if err != nil {
+		return "", err
+	}
+	return id, services.NewHashChainService(tx).Append(context.Background(), services.ChainStockMovements, m.TenantID, id)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumeLayers(t *testing.T) {
+	layers := []fifoLayer{
+		{ID: "old", Remaining: 5, UnitCost: decimal.NewFromInt(2)},
+		{ID: "empty", Remaining: 0, UnitCost: decimal.NewFromInt(9)},
+		{ID: "new", Remaining: 10, UnitCost: decimal.NewFromInt(3)},
+	}
+
+	takes, shortfall := consumeLayers(layers, 8)
+	require.Len(t, takes, 2)
+	assert.Equal(t, layerTake{LayerID: "old", Qty: 5, UnitCost: decimal.NewFromInt(2)}, takes[0])
+	assert.Equal(t, "new", takes[1].LayerID)
+	assert.Equal(t, 3, takes[1].Qty)
+	assert.Equal(t, 0, shortfall)
+
+	takes, shortfall = consumeLayers(layers, 20)
+	assert.Len(t, takes, 2)
+	assert.Equal(t, 5, shortfall)
+}
+
+func TestSummariseValuation(t *testing.T) {
+	rows := []valuationRow{
+		{LocationID: "l1", LocationCode: "A", ItemID: "i1", Qty: 10, Value: decimal.NewFromFloat(25.5)},
+		{LocationID: "l1", LocationCode: "A", ItemID: "i2", Qty: 3, Value: decimal.NewFromInt(30)},
+		{LocationID: "l2", LocationCode: "B", ItemID: "i1", Qty: 4, Value: decimal.NewFromInt(10)},
+	}
+
+	locations, total := summariseValuation(rows, true)
+	require.Len(t, locations, 2)
+	assert.Equal(t, 13, locations[0].Qty)
+	assert.Equal(t, "55.5", locations[0].Value.String())
+	require.Len(t, locations[0].Items, 2)
+	assert.Equal(t, "2.55", locations[0].Items[0].UnitCost.String())
+	assert.Equal(t, "65.5", total.String())
+
+	locations, _ = summariseValuation(rows, false)
+	assert.Nil(t, locations[1].Items)
+}
+
+func TestParseAsOf(t *testing.T) {
+	now := time.Date(2026, 4, 15, 12, 0, 0, 0, time.UTC)
+
+	asOf, err := parseAsOf("", now)
+	require.NoError(t, err)
+	assert.Equal(t, now, asOf)
+
+	asOf, err = parseAsOf("2026-03-31", now)
+	require.NoError(t, err)
+	assert.True(t, asOf.Before(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, asOf.After(time.Date(2026, 3, 31, 23, 59, 59, 0, time.UTC)))
+
+	asOf, err = parseAsOf("2026-03-31T08:00:00Z", now)
+	require.NoError(t, err)
+	assert.Equal(t, 8, asOf.Hour())
+
+	_, err = parseAsOf("31/03/2026", now)
+	assert.Error(t, err)
+}
+
+func TestDefaultReceiveLocation(t *testing.T) {
+	id, err := defaultReceiveLocation(context.Background(), []string{"l1"})
+	require.NoError(t, err)
+	assert.Equal(t, "l1", id)
+
+	// Only the locations the user may access count
+	restricted := appmw.SetAllowedLocations(context.Background(), []string{"l2"})
+	id, err = defaultReceiveLocation(restricted, []string{"l1", "l2"})
+	require.NoError(t, err)
+	assert.Equal(t, "l2", id)
+
+	var httpErr *echo.HTTPError
+	for _, locations := range [][]string{nil, {"l1", "l2"}} {
+		_, err = defaultReceiveLocation(context.Background(), locations)
+		require.ErrorAs(t, err, &httpErr)
+		assert.Equal(t, http.StatusBadRequest, httpErr.Code)
+	}
+}
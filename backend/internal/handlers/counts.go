@@ -7,8 +7,11 @@ import (
 	"strconv"
 	"strings"
 
+	appmw "inventory/internal/middleware"
+
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 )
 
 type CountBatch struct {
@@ -37,6 +40,12 @@ type CountLine struct {
 
 // Batches
 func (h *Handler) ListCountBatches(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	tenantID := claims.TenantID
+
 	page, _ := strconv.Atoi(c.QueryParam("page"))
 	if page <= 0 {
 		page = 1
@@ -47,12 +56,15 @@ func (h *Handler) ListCountBatches(c echo.Context) error {
 	}
 	status := c.QueryParam("status")
 	locationID := c.QueryParam("location_id")
+	if err := checkLocationAccess(c, locationID); err != nil {
+		return err
+	}
 
 	offset := (page - 1) * pageSize
 
-	query := `SELECT id, number, location_id, status, notes, created_by, completed_at, created_at, updated_at FROM count_batches WHERE 1=1`
-	args := []interface{}{}
-	n := 0
+	query := `SELECT id, number, location_id, status, notes, created_by, completed_at, created_at, updated_at FROM count_batches WHERE tenant_id = $1`
+	args := []interface{}{tenantID}
+	n := 1
 	if status != "" {
 		n++
 		query += fmt.Sprintf(" AND status = $%d", n)
@@ -63,6 +75,12 @@ func (h *Handler) ListCountBatches(c echo.Context) error {
 		query += fmt.Sprintf(" AND location_id = $%d", n)
 		args = append(args, locationID)
 	}
+	allowed, restricted := appmw.GetAllowedLocations(c.Request().Context())
+	if restricted {
+		n++
+		query += fmt.Sprintf(" AND location_id = ANY($%d)", n)
+		args = append(args, pq.Array(allowed))
+	}
 	query += " ORDER BY created_at DESC"
 	n++
 	query += fmt.Sprintf(" LIMIT $%d", n)
@@ -71,7 +89,7 @@ func (h *Handler) ListCountBatches(c echo.Context) error {
 	query += fmt.Sprintf(" OFFSET $%d", n)
 	args = append(args, offset)
 
-	rows, err := h.DB.Query(query, args...)
+	rows, err := h.db(c).Query(query, args...)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
@@ -97,9 +115,9 @@ func (h *Handler) ListCountBatches(c echo.Context) error {
 	}
 
 	var total int
-	countQ := `SELECT COUNT(*) FROM count_batches WHERE 1=1`
-	countArgs := []interface{}{}
-	k := 0
+	countQ := `SELECT COUNT(*) FROM count_batches WHERE tenant_id = $1`
+	countArgs := []interface{}{tenantID}
+	k := 1
 	if status != "" {
 		k++
 		countQ += fmt.Sprintf(" AND status = $%d", k)
@@ -110,7 +128,12 @@ func (h *Handler) ListCountBatches(c echo.Context) error {
 		countQ += fmt.Sprintf(" AND location_id = $%d", k)
 		countArgs = append(countArgs, locationID)
 	}
-	if err := h.DB.QueryRow(countQ, countArgs...).Scan(&total); err != nil {
+	if restricted {
+		k++
+		countQ += fmt.Sprintf(" AND location_id = ANY($%d)", k)
+		countArgs = append(countArgs, pq.Array(allowed))
+	}
+	if err := h.db(c).QueryRow(countQ, countArgs...).Scan(&total); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
 
@@ -118,6 +141,12 @@ func (h *Handler) ListCountBatches(c echo.Context) error {
 }
 
 func (h *Handler) CreateCountBatch(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	tenantID := claims.TenantID
+
 	var req struct {
 		LocationID string  `json:"location_id"`
 		Notes      *string `json:"notes"`
@@ -128,23 +157,41 @@ func (h *Handler) CreateCountBatch(c echo.Context) error {
 	if req.LocationID == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "location_id is required")
 	}
+	if err := checkLocationAccess(c, req.LocationID); err != nil {
+		return err
+	}
+	if err := checkCountLocation(h.db(c), req.LocationID, tenantID); err != nil {
+		return err
+	}
 
 	// next number
 	var maxNumber int
-	_ = h.DB.QueryRow(`SELECT COALESCE(MAX(CAST(SUBSTRING(number FROM 'CB-([0-9]+)') AS INTEGER)), 0) FROM count_batches WHERE number ~ '^CB-[0-9]+$'`).Scan(&maxNumber)
+	_ = h.db(c).QueryRow(`SELECT COALESCE(MAX(CAST(SUBSTRING(number FROM 'CB-([0-9]+)') AS INTEGER)), 0) FROM count_batches WHERE number ~ '^CB-[0-9]+$' AND tenant_id = $1`, tenantID).Scan(&maxNumber)
 	number := fmt.Sprintf("CB-%06d", maxNumber+1)
 
 	id := uuid.New().String()
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
 	var created CountBatch
 	var notes sql.NullString
-	err := h.DB.QueryRow(`
-        INSERT INTO count_batches (id, number, location_id, status, notes, created_at, updated_at)
-        VALUES ($1, $2, $3, 'OPEN', $4, NOW(), NOW())
+	err = tx.QueryRow(`
+        INSERT INTO count_batches (id, number, location_id, status, notes, tenant_id, created_by, created_at, updated_at)
+        VALUES ($1, $2, $3, 'OPEN', $4, $5, $6, NOW(), NOW())
         RETURNING id, number, location_id, status, notes, created_at, updated_at
-    `, id, number, req.LocationID, req.Notes).Scan(&created.ID, &created.Number, &created.LocationID, &created.Status, &notes, &created.CreatedAt, &created.UpdatedAt)
+    `, id, number, req.LocationID, req.Notes, tenantID, claims.UserID).Scan(&created.ID, &created.Number, &created.LocationID, &created.Status, &notes, &created.CreatedAt, &created.UpdatedAt)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
+	if err := recordAudit(c, tx, AuditCreate, "count_batch", id, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 	if notes.Valid {
 		created.Notes = &notes.String
 	}
@@ -152,6 +199,11 @@ func (h *Handler) CreateCountBatch(c echo.Context) error {
 }
 
 func (h *Handler) UpdateCountBatch(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	tenantID := claims.TenantID
 	id := c.Param("id")
 	var req struct {
 		LocationID *string `json:"location_id"`
@@ -161,11 +213,20 @@ func (h *Handler) UpdateCountBatch(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
 	}
+	if err := checkCountBatchLocation(c, h.db(c), id, tenantID); err != nil {
+		return err
+	}
 
 	sets := []string{}
 	args := []interface{}{}
 	i := 1
 	if req.LocationID != nil {
+		if err := checkLocationAccess(c, *req.LocationID); err != nil {
+			return err
+		}
+		if err := checkCountLocation(h.db(c), *req.LocationID, tenantID); err != nil {
+			return err
+		}
 		sets = append(sets, fmt.Sprintf("location_id = $%d", i))
 		args = append(args, *req.LocationID)
 		i++
@@ -184,17 +245,34 @@ func (h *Handler) UpdateCountBatch(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "no fields to update")
 	}
 	sets = append(sets, "updated_at = NOW()")
-	args = append(args, id)
+	args = append(args, id, tenantID)
+
+	query := fmt.Sprintf(`UPDATE count_batches SET %s WHERE id = $%d AND tenant_id = $%d RETURNING id, number, location_id, status, notes, created_at, updated_at`, strings.Join(sets, ", "), i, i+1)
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "count_batch", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 
-	query := fmt.Sprintf(`UPDATE count_batches SET %s WHERE id = $%d RETURNING id, number, location_id, status, notes, created_at, updated_at`, strings.Join(sets, ", "), i)
 	var out CountBatch
 	var notes sql.NullString
-	if err := h.DB.QueryRow(query, args...).Scan(&out.ID, &out.Number, &out.LocationID, &out.Status, &notes, &out.CreatedAt, &out.UpdatedAt); err != nil {
+	if err := tx.QueryRow(query, args...).Scan(&out.ID, &out.Number, &out.LocationID, &out.Status, &notes, &out.CreatedAt, &out.UpdatedAt); err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "batch not found")
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
+	if err := recordAudit(c, tx, AuditUpdate, "count_batch", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 	if notes.Valid {
 		out.Notes = &notes.String
 	}
@@ -202,8 +280,27 @@ func (h *Handler) UpdateCountBatch(c echo.Context) error {
 }
 
 func (h *Handler) DeleteCountBatch(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	tenantID := claims.TenantID
 	id := c.Param("id")
-	res, err := h.DB.Exec(`DELETE FROM count_batches WHERE id = $1`, id)
+	if err := checkCountBatchLocation(c, h.db(c), id, tenantID); err != nil {
+		return err
+	}
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "count_batch", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	res, err := tx.Exec(`DELETE FROM count_batches WHERE id = $1 AND tenant_id = $2`, id, tenantID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusConflict, "cannot delete batch (in use)")
 	}
@@ -211,13 +308,59 @@ func (h *Handler) DeleteCountBatch(c echo.Context) error {
 	if n == 0 {
 		return echo.NewHTTPError(http.StatusNotFound, "batch not found")
 	}
+	if err := recordAudit(c, tx, AuditDelete, "count_batch", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 	return c.NoContent(http.StatusNoContent)
 }
 
+// checkCountBatchLocation rejects access to a batch at a location the caller
+// is not assigned to.
+func checkCountBatchLocation(c echo.Context, q queryRower, batchID, tenantID string) error {
+	return checkDocumentLocation(c, q, `SELECT location_id FROM count_batches WHERE id = $1 AND tenant_id = $2`, batchID, tenantID)
+}
+
+// checkCountLocation rejects a location outside the tenant.
+func checkCountLocation(q queryRower, locationID, tenantID string) error {
+	var exists bool
+	if err := q.QueryRow(`SELECT EXISTS(SELECT 1 FROM locations WHERE id = $1 AND tenant_id = $2)`, locationID, tenantID).Scan(&exists); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid location_id")
+	}
+	return nil
+}
+
+// countBatchExists reports whether the batch belongs to the tenant.
+func countBatchExists(q queryRower, batchID, tenantID string) (bool, error) {
+	var exists bool
+	err := q.QueryRow(`SELECT EXISTS(SELECT 1 FROM count_batches WHERE id = $1 AND tenant_id = $2)`, batchID, tenantID).Scan(&exists)
+	return exists, err
+}
+
 // Lines
 func (h *Handler) ListCountLines(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	tenantID := claims.TenantID
 	batchID := c.Param("batch_id")
-	rows, err := h.DB.Query(`
+	if err := checkCountBatchLocation(c, h.db(c), batchID, tenantID); err != nil {
+		return err
+	}
+	exists, err := countBatchExists(h.db(c), batchID, tenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "batch not found")
+	}
+	rows, err := h.db(c).Query(`
         SELECT cl.id, cl.batch_id, cl.item_id, COALESCE(i.sku, ''), COALESCE(i.name, ''), cl.expected_on_hand, cl.counted_qty, cl.created_at, cl.updated_at
         FROM count_lines cl
         LEFT JOIN items i ON i.id = cl.item_id
@@ -240,6 +383,11 @@ func (h *Handler) ListCountLines(c echo.Context) error {
 }
 
 func (h *Handler) AddCountLine(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	tenantID := claims.TenantID
 	batchID := c.Param("batch_id")
 	var req struct {
 		ItemID         string `json:"item_id"`
@@ -255,18 +403,21 @@ func (h *Handler) AddCountLine(c echo.Context) error {
 
 	// Ensure batch exists and get its location
 	var batchLocationID string
-	if err := h.DB.QueryRow(`SELECT location_id FROM count_batches WHERE id = $1`, batchID).Scan(&batchLocationID); err != nil {
+	if err := h.db(c).QueryRow(`SELECT location_id FROM count_batches WHERE id = $1 AND tenant_id = $2`, batchID, tenantID).Scan(&batchLocationID); err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "batch not found")
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
+	if err := checkLocationAccess(c, batchLocationID); err != nil {
+		return err
+	}
 
 	// Resolve item id: allow UUID or SKU
 	resolvedItemID := ""
 	if _, err := uuid.Parse(req.ItemID); err == nil {
 		// UUID provided; verify exists
-		if err := h.DB.QueryRow(`SELECT id FROM items WHERE id = $1`, req.ItemID).Scan(&resolvedItemID); err != nil {
+		if err := h.db(c).QueryRow(`SELECT id FROM items WHERE id = $1 AND tenant_id = $2`, req.ItemID, tenantID).Scan(&resolvedItemID); err != nil {
 			if err == sql.ErrNoRows {
 				return echo.NewHTTPError(http.StatusBadRequest, "invalid item id")
 			}
@@ -275,18 +426,19 @@ func (h *Handler) AddCountLine(c echo.Context) error {
 	} else {
 		// Treat as SKU or barcode; accept hyphen-less variants
 		q := strings.TrimSpace(req.ItemID)
-		err := h.DB.QueryRow(`
+		err := h.db(c).QueryRow(`
             SELECT id FROM items
-            WHERE replace(sku, '-', '') = replace($1, '-', '')
+            WHERE tenant_id = $2
+              AND (replace(sku, '-', '') = replace($1, '-', '')
                OR sku = $1
-               OR barcode = $1
+               OR barcode = $1)
             LIMIT 1
-        `, q).Scan(&resolvedItemID)
+        `, q, tenantID).Scan(&resolvedItemID)
 		if err == sql.ErrNoRows {
 			// Fallback: try by name (case-insensitive), pick first match
-			err = h.DB.QueryRow(`
-                SELECT id FROM items WHERE LOWER(name) = LOWER($1) OR name ILIKE $2 LIMIT 1
-            `, q, "%"+q+"%").Scan(&resolvedItemID)
+			err = h.db(c).QueryRow(`
+                SELECT id FROM items WHERE tenant_id = $3 AND (LOWER(name) = LOWER($1) OR name ILIKE $2) LIMIT 1
+            `, q, "%"+q+"%", tenantID).Scan(&resolvedItemID)
 		}
 		if err != nil {
 			if err == sql.ErrNoRows {
@@ -300,10 +452,10 @@ func (h *Handler) AddCountLine(c echo.Context) error {
 				uom := "each"
 				created := false
 				for attempt := 0; attempt < 3; attempt++ {
-					if _, insErr := h.DB.Exec(`
-                    INSERT INTO items (id, sku, name, uom, cost, price, is_active, created_at, updated_at)
-                    VALUES ($1, $2, $3, $4, $5::numeric, $6::numeric, TRUE, NOW(), NOW())
-                `, newID, sku, name, uom, "0.00", "0.00"); insErr == nil {
+					if _, insErr := h.db(c).Exec(`
+                    INSERT INTO items (id, sku, name, uom, cost, price, tenant_id, is_active, created_at, updated_at)
+                    VALUES ($1, $2, $3, $4, $5::numeric, $6::numeric, $7, TRUE, NOW(), NOW())
+                `, newID, sku, name, uom, "0.00", "0.00", tenantID); insErr == nil {
 						resolvedItemID = newID
 						created = true
 						break
@@ -326,14 +478,25 @@ func (h *Handler) AddCountLine(c echo.Context) error {
 	// Auto-fill expected_on_hand from inventory_levels if not provided (>0)
 	if req.ExpectedOnHand <= 0 {
 		var onHand sql.NullInt64
-		if err := h.DB.QueryRow(`SELECT on_hand FROM inventory_levels WHERE item_id = $1 AND location_id = $2`, resolvedItemID, batchLocationID).Scan(&onHand); err == nil && onHand.Valid {
+		if err := h.db(c).QueryRow(`SELECT on_hand FROM inventory_levels WHERE item_id = $1 AND location_id = $2 AND tenant_id = $3`, resolvedItemID, batchLocationID, tenantID).Scan(&onHand); err == nil && onHand.Valid {
 			req.ExpectedOnHand = int(onHand.Int64)
 		}
 	}
 
 	id := uuid.New().String()
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "count_batch", batchID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
 	var out CountLine
-	err := h.DB.QueryRow(`
+	err = tx.QueryRow(`
         INSERT INTO count_lines (id, batch_id, item_id, expected_on_hand, counted_qty, created_at, updated_at)
         VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
         RETURNING id, batch_id, item_id, expected_on_hand, counted_qty, created_at, updated_at
@@ -341,12 +504,26 @@ func (h *Handler) AddCountLine(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
+	if err := recordAudit(c, tx, AuditUpdate, "count_batch", batchID, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 	return c.JSON(http.StatusCreated, out)
 }
 
 func (h *Handler) UpdateCountLine(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	tenantID := claims.TenantID
 	batchID := c.Param("batch_id")
 	lineID := c.Param("line_id")
+	if err := checkCountBatchLocation(c, h.db(c), batchID, tenantID); err != nil {
+		return err
+	}
 	var req struct {
 		ExpectedOnHand *int `json:"expected_on_hand"`
 		CountedQty     *int `json:"counted_qty"`
@@ -375,20 +552,72 @@ func (h *Handler) UpdateCountLine(c echo.Context) error {
 	args = append(args, lineID, batchID)
 
 	query := fmt.Sprintf(`UPDATE count_lines SET %s WHERE id = $%d AND batch_id = $%d RETURNING id, batch_id, item_id, expected_on_hand, counted_qty, created_at, updated_at`, strings.Join(sets, ", "), i, i+1)
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	exists, err := countBatchExists(tx, batchID, tenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "batch not found")
+	}
+
+	before, err := auditSnapshot(c, tx, "count_batch", batchID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
 	var out CountLine
-	if err := h.DB.QueryRow(query, args...).Scan(&out.ID, &out.BatchID, &out.ItemID, &out.ExpectedOnHand, &out.CountedQty, &out.CreatedAt, &out.UpdatedAt); err != nil {
+	if err := tx.QueryRow(query, args...).Scan(&out.ID, &out.BatchID, &out.ItemID, &out.ExpectedOnHand, &out.CountedQty, &out.CreatedAt, &out.UpdatedAt); err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "line not found")
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
+	if err := recordAudit(c, tx, AuditUpdate, "count_batch", batchID, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 	return c.JSON(http.StatusOK, out)
 }
 
 func (h *Handler) DeleteCountLine(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	tenantID := claims.TenantID
 	batchID := c.Param("batch_id")
 	lineID := c.Param("line_id")
-	res, err := h.DB.Exec(`DELETE FROM count_lines WHERE id = $1 AND batch_id = $2`, lineID, batchID)
+	if err := checkCountBatchLocation(c, h.db(c), batchID, tenantID); err != nil {
+		return err
+	}
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	exists, err := countBatchExists(tx, batchID, tenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "batch not found")
+	}
+
+	before, err := auditSnapshot(c, tx, "count_batch", batchID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	res, err := tx.Exec(`DELETE FROM count_lines WHERE id = $1 AND batch_id = $2`, lineID, batchID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusConflict, "cannot delete line")
 	}
@@ -396,5 +625,11 @@ func (h *Handler) DeleteCountLine(c echo.Context) error {
 	if n == 0 {
 		return echo.NewHTTPError(http.StatusNotFound, "line not found")
 	}
+	if err := recordAudit(c, tx, AuditUpdate, "count_batch", batchID, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 	return c.NoContent(http.StatusNoContent)
 }
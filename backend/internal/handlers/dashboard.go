@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+// dashboardTTL is how long a tenant's KPIs are served from cache. Posting stock
+// drops the entry earlier.
+const dashboardTTL = 60 * time.Second
+
+const topMoversDays = 7
+
+// Dashboard holds the home screen KPIs of a tenant.
+type Dashboard struct {
+	TotalStockValue        decimal.Decimal `json:"total_stock_value"`
+	ItemsBelowReorderPoint int             `json:"items_below_reorder_point"`
+	OpenPurchaseOrders     map[string]int  `json:"open_purchase_orders"`
+	TransfersInTransit     int             `json:"transfers_in_transit"`
+	AdjustmentsAwaiting    int             `json:"adjustments_awaiting_approval"`
+	ReceiptsAwaiting       int             `json:"receipts_awaiting_posting"`
+	TopMovers              []TopMover      `json:"top_movers"`
+	GeneratedAt            time.Time       `json:"generated_at"`
+}
+
+// TopMover is an item ranked by the units it moved over the last days.
+type TopMover struct {
+	Item      Item `json:"item"`
+	QtyIn     int  `json:"qty_in"`
+	QtyOut    int  `json:"qty_out"`
+	Movements int  `json:"movements"`
+}
+
+// dashboardCache keeps computed dashboards per tenant and set of allowed
+// locations. The zero value is ready to use.
+type dashboardCache struct {
+	mu      sync.Mutex
+	entries map[string]map[string]*Dashboard
+}
+
+// dashboardScope keys a cached dashboard by the locations it covers; the empty
+// key is every location and "-" is none.
+func dashboardScope(locations []string) string {
+	if locations == nil {
+		return ""
+	}
+	if len(locations) == 0 {
+		return "-"
+	}
+	ids := make([]string, len(locations))
+	for i, id := range locations {
+		ids[i] = strings.ToLower(id)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func (dc *dashboardCache) get(tenantID, scope string, now time.Time) *Dashboard {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	d, ok := dc.entries[tenantID][scope]
+	if !ok || now.Sub(d.GeneratedAt) >= dashboardTTL {
+		return nil
+	}
+	return d
+}
+
+func (dc *dashboardCache) put(tenantID, scope string, d *Dashboard) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if dc.entries == nil {
+		dc.entries = make(map[string]map[string]*Dashboard)
+	}
+	if dc.entries[tenantID] == nil {
+		dc.entries[tenantID] = make(map[string]*Dashboard)
+	}
+	dc.entries[tenantID][scope] = d
+}
+
+func (dc *dashboardCache) invalidate(tenantID string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	delete(dc.entries, tenantID)
+}
+
+// invalidateDashboard drops a tenant's cached KPIs; call it after committing
+// anything that posts stock or changes its value.
+func (h *Handler) invalidateDashboard(tenantID string) {
+	h.dashboard.invalidate(tenantID)
+}
+
+// GetDashboard returns the tenant's home screen KPIs in one response. Users
+// restricted to some locations see the KPIs of those locations only.
+func (h *Handler) GetDashboard(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	allowed, _ := appmw.GetAllowedLocations(c.Request().Context())
+	scope := dashboardScope(allowed)
+
+	now := time.Now().UTC()
+	if d := h.dashboard.get(claims.TenantID, scope, now); d != nil {
+		return c.JSON(http.StatusOK, d)
+	}
+
+	d, err := h.buildDashboard(c, claims.TenantID, allowed, now)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load dashboard")
+	}
+	h.dashboard.put(claims.TenantID, scope, d)
+	return c.JSON(http.StatusOK, d)
+}
+
+// buildDashboard computes the KPIs of the tenant's stock, or of the given
+// locations when locations is not nil. Purchase orders have no location and are
+// always counted for the whole tenant.
+func (h *Handler) buildDashboard(c echo.Context, tenantID string, locations []string, now time.Time) (*Dashboard, error) {
+	d := &Dashboard{
+		OpenPurchaseOrders: map[string]int{"DRAFT": 0, "APPROVED": 0, "PARTIAL": 0},
+		TopMovers:          []TopMover{},
+		GeneratedAt:        now,
+	}
+
+	method, err := tenantCostingMethod(h.db(c), tenantID)
+	if err != nil {
+		return nil, err
+	}
+	// $2 is the allowed locations, NULL for all of them
+	args := []interface{}{tenantID, pq.Array(locations)}
+
+	valueQuery := `
+		SELECT COALESCE(SUM(il.on_hand * COALESCE(i.cost, 0)), 0)
+		FROM inventory_levels il
+		JOIN items i ON i.id = il.item_id
+		WHERE il.tenant_id = $1 AND ($2::uuid[] IS NULL OR il.location_id = ANY($2::uuid[]))`
+	if method == CostingFIFO {
+		valueQuery = `
+			SELECT COALESCE(SUM(qty_remaining * unit_cost), 0)
+			FROM cost_layers
+			WHERE tenant_id = $1 AND closed_at IS NULL AND ($2::uuid[] IS NULL OR location_id = ANY($2::uuid[]))`
+	}
+	if err := h.db(c).QueryRow(valueQuery, args...).Scan(&d.TotalStockValue); err != nil {
+		return nil, err
+	}
+	d.TotalStockValue = d.TotalStockValue.Round(2)
+
+	if err := h.db(c).QueryRow(`
+		SELECT
+			(SELECT COUNT(DISTINCT item_id) FROM inventory_levels
+				WHERE tenant_id = $1 AND reorder_point > 0 AND on_hand < reorder_point
+				AND ($2::uuid[] IS NULL OR location_id = ANY($2::uuid[]))),
+			(SELECT COUNT(*) FROM transfers WHERE tenant_id = $1 AND status = 'IN_TRANSIT'
+				AND ($2::uuid[] IS NULL OR from_location_id = ANY($2::uuid[]) OR to_location_id = ANY($2::uuid[]))),
+			(SELECT COUNT(*) FROM adjustments WHERE tenant_id = $1 AND status = 'DRAFT'
+				AND ($2::uuid[] IS NULL OR location_id = ANY($2::uuid[]))),
+			(SELECT COUNT(*) FROM goods_receipts WHERE tenant_id = $1 AND status IN ('DRAFT', 'APPROVED')
+				AND ($2::uuid[] IS NULL OR location_id = ANY($2::uuid[])))
+	`, args...).Scan(&d.ItemsBelowReorderPoint, &d.TransfersInTransit, &d.AdjustmentsAwaiting, &d.ReceiptsAwaiting); err != nil {
+		return nil, err
+	}
+
+	rows, err := h.db(c).Query(`
+		SELECT status, COUNT(*) FROM purchase_orders
+		WHERE tenant_id = $1 AND status IN ('DRAFT', 'APPROVED', 'PARTIAL')
+		GROUP BY status
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		d.OpenPurchaseOrders[status] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	movers, err := h.db(c).Query(`
+		SELECT i.id, i.sku, i.name,
+			COALESCE(SUM(sm.qty) FILTER (WHERE sm.qty > 0), 0),
+			COALESCE(SUM(-sm.qty) FILTER (WHERE sm.qty < 0), 0),
+			COUNT(*)
+		FROM stock_movements sm
+		JOIN items i ON i.id = sm.item_id
+		WHERE sm.tenant_id = $1 AND ($2::uuid[] IS NULL OR sm.location_id = ANY($2::uuid[]))
+			AND sm.occurred_at >= $3 AND sm.qty <> 0
+		GROUP BY i.id, i.sku, i.name
+		ORDER BY SUM(ABS(sm.qty)) DESC, i.sku
+		LIMIT 10
+	`, tenantID, pq.Array(locations), now.AddDate(0, 0, -topMoversDays))
+	if err != nil {
+		return nil, err
+	}
+	defer movers.Close()
+	for movers.Next() {
+		var m TopMover
+		if err := movers.Scan(&m.Item.ID, &m.Item.SKU, &m.Item.Name, &m.QtyIn, &m.QtyOut, &m.Movements); err != nil {
+			return nil, err
+		}
+		d.TopMovers = append(d.TopMovers, m)
+	}
+	return d, movers.Err()
+}
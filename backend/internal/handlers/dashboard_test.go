@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboardCache(t *testing.T) {
+	var cache dashboardCache
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	assert.Nil(t, cache.get("t1", "", now))
+
+	d := &Dashboard{GeneratedAt: now}
+	cache.put("t1", "", d)
+	assert.Same(t, d, cache.get("t1", "", now.Add(dashboardTTL-time.Second)))
+	assert.Nil(t, cache.get("t1", "", now.Add(dashboardTTL)))
+	assert.Nil(t, cache.get("t2", "", now))
+
+	// A restricted user does not get the whole tenant's KPIs
+	scoped := &Dashboard{GeneratedAt: now}
+	cache.put("t1", "l1", scoped)
+	assert.Nil(t, cache.get("t1", "l2", now))
+	assert.Same(t, scoped, cache.get("t1", "l1", now))
+	assert.Same(t, d, cache.get("t1", "", now))
+
+	cache.invalidate("t1")
+	assert.Nil(t, cache.get("t1", "", now))
+	assert.Nil(t, cache.get("t1", "l1", now))
+}
+
+func TestDashboardScope(t *testing.T) {
+	assert.Equal(t, "", dashboardScope(nil))
+	assert.Equal(t, "-", dashboardScope([]string{}))
+	assert.Equal(t, "a,b", dashboardScope([]string{"B", "a"}))
+	assert.Equal(t, dashboardScope([]string{"a", "b"}), dashboardScope([]string{"b", "a"}))
+}
@@ -3,17 +3,33 @@ package handlers
 import (
 	"database/sql"
 	"inventory/internal/config"
+	"inventory/internal/services"
 )
 
 type Handler struct {
 	DB     *sql.DB
 	Config *config.Config
+	Mailer services.Mailer
+	OIDC   *services.OIDCClient
+	// Tenants looked up by slug or custom domain, cached
+	Tenants *services.TenantCache
+	// API requests counted against tenants' plans
+	Usage *services.UsageMeter
+	// Tenant data exports and purges
+	TenantData *services.TenantDataService
+
+	dashboard dashboardCache
 }
 
 func New(db *sql.DB, cfg *config.Config) *Handler {
 	return &Handler{
-		DB:     db,
-		Config: cfg,
+		DB:         db,
+		Config:     cfg,
+		Mailer:     services.NewMailer(cfg),
+		OIDC:       services.NewOIDCClient(nil),
+		Tenants:    services.NewTenantCache(services.NewTenantService(db), cfg.TenantCacheTTL),
+		Usage:      services.NewUsageMeter(db),
+		TenantData: services.NewTenantDataService(db, cfg.TenantExportDir),
 	}
 }
 
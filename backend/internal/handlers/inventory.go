@@ -1,21 +1,226 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
 
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 )
 
+// InventoryLevel is the stock of one item at one location. For as-of queries the
+// quantities are rebuilt from stock movements and allocations are not known.
+type InventoryLevel struct {
+	Item         Item       `json:"item"`
+	Location     Location   `json:"location"`
+	OnHand       int        `json:"on_hand"`
+	Allocated    int        `json:"allocated"`
+	Available    int        `json:"available"`
+	ReorderPoint int        `json:"reorder_point"`
+	ReorderQty   int        `json:"reorder_qty"`
+	UpdatedAt    *time.Time `json:"updated_at,omitempty"`
+}
+
+// inventoryFilter narrows a stock-on-hand query. AsOf is nil for current stock.
+type inventoryFilter struct {
+	ItemID     string
+	LocationID string
+	Search     string
+	AsOf       *time.Time
+}
+
+func inventoryFilterFromRequest(c echo.Context) (inventoryFilter, error) {
+	f := inventoryFilter{
+		ItemID:     c.QueryParam("item_id"),
+		LocationID: c.QueryParam("location_id"),
+		Search:     strings.TrimSpace(c.QueryParam("q")),
+	}
+	if asOfParam := c.QueryParam("as_of"); asOfParam != "" {
+		asOf, err := parseAsOf(asOfParam, time.Now())
+		if err != nil {
+			return f, err
+		}
+		f.AsOf = &asOf
+	}
+	return f, nil
+}
+
+// GetInventory lists stock per item and location (?item_id=&location_id=&q=&as_of=).
+// With as_of the quantities are rebuilt from the latest nightly snapshot before
+// that instant plus the stock movements since.
 func (h *Handler) GetInventory(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	f, err := inventoryFilterFromRequest(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := checkLocationAccess(c, f.LocationID); err != nil {
+		return err
+	}
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page <= 0 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.QueryParam("page_size"))
+	if pageSize <= 0 || pageSize > h.Config.MaxPageSize {
+		pageSize = h.Config.DefaultPageSize
+	}
+
+	levels, total, err := h.queryInventory(c, claims.TenantID, f, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load inventory")
+	}
+
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
 	return c.JSON(http.StatusOK, PaginatedResponse{
-		Data:       []interface{}{},
-		Page:       1,
-		PageSize:   20,
-		TotalPages: 0,
-		Total:      0,
+		Data:       levels,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: totalPages,
+		Total:      total,
 	})
 }
 
+// ExportInventory downloads stock on hand as CSV; it accepts the same filters as
+// GetInventory, including as_of.
+func (h *Handler) ExportInventory(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	f, err := inventoryFilterFromRequest(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := checkLocationAccess(c, f.LocationID); err != nil {
+		return err
+	}
+
+	levels, _, err := h.queryInventory(c, claims.TenantID, f, 0, 0)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load inventory")
+	}
+
+	filename := "stock-on-hand.csv"
+	if f.AsOf != nil {
+		filename = fmt.Sprintf("stock-on-hand-%s.csv", f.AsOf.UTC().Format(time.DateOnly))
+	}
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(c.Response())
+	if err := w.Write([]string{"sku", "item_name", "location_code", "location_name", "on_hand", "allocated", "available"}); err != nil {
+		return err
+	}
+	for _, l := range levels {
+		if err := w.Write([]string{
+			l.Item.SKU, l.Item.Name, l.Location.Code, l.Location.Name,
+			strconv.Itoa(l.OnHand), strconv.Itoa(l.Allocated), strconv.Itoa(l.Available),
+		}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// queryInventory returns a page of stock levels (all when limit is 0) and the
+// total number of matching rows.
+func (h *Handler) queryInventory(c echo.Context, tenantID string, f inventoryFilter, limit, offset int) ([]InventoryLevel, int64, error) {
+	var source string
+	var args []interface{}
+	if f.AsOf == nil {
+		source = `
+			SELECT item_id, location_id, on_hand, COALESCE(allocated, 0) AS allocated,
+				COALESCE(reorder_point, 0) AS reorder_point, COALESCE(reorder_qty, 0) AS reorder_qty, updated_at
+			FROM inventory_levels
+			WHERE tenant_id = $1`
+		args = []interface{}{tenantID}
+	} else {
+		base, err := services.LatestSnapshotBefore(c.Request().Context(), h.DB, tenantID, *f.AsOf)
+		if err != nil {
+			return nil, 0, err
+		}
+		var replay string
+		replay, args = services.StockOnHandQuery(tenantID, base, f.AsOf.Add(time.Microsecond))
+		source = fmt.Sprintf(`
+			SELECT item_id, location_id, on_hand, 0 AS allocated, 0 AS reorder_point, 0 AS reorder_qty,
+				NULL::timestamptz AS updated_at
+			FROM (%s) soh`, replay)
+	}
+
+	where := []string{"1=1"}
+	if f.ItemID != "" {
+		args = append(args, f.ItemID)
+		where = append(where, fmt.Sprintf("lv.item_id = $%d", len(args)))
+	}
+	if f.LocationID != "" {
+		args = append(args, f.LocationID)
+		where = append(where, fmt.Sprintf("lv.location_id = $%d", len(args)))
+	}
+	if ids, restricted := appmw.GetAllowedLocations(c.Request().Context()); restricted {
+		args = append(args, pq.Array(ids))
+		where = append(where, fmt.Sprintf("lv.location_id = ANY($%d)", len(args)))
+	}
+	if f.Search != "" {
+		args = append(args, "%"+f.Search+"%")
+		where = append(where, fmt.Sprintf("(i.sku ILIKE $%d OR i.name ILIKE $%d)", len(args), len(args)))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT i.id, i.sku, i.name, l.id, l.code, l.name,
+			lv.on_hand, lv.allocated, lv.reorder_point, lv.reorder_qty, lv.updated_at,
+			COUNT(*) OVER()
+		FROM (%s) lv
+		JOIN items i ON i.id = lv.item_id
+		JOIN locations l ON l.id = lv.location_id
+		WHERE %s
+		ORDER BY i.sku, l.code`, source, strings.Join(where, " AND "))
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+	}
+
+	rows, err := h.db(c).Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	levels := []InventoryLevel{}
+	var total int64
+	for rows.Next() {
+		var l InventoryLevel
+		var updatedAt sql.NullTime
+		if err := rows.Scan(
+			&l.Item.ID, &l.Item.SKU, &l.Item.Name, &l.Location.ID, &l.Location.Code, &l.Location.Name,
+			&l.OnHand, &l.Allocated, &l.ReorderPoint, &l.ReorderQty, &updatedAt, &total,
+		); err != nil {
+			return nil, 0, err
+		}
+		if updatedAt.Valid {
+			l.UpdatedAt = &updatedAt.Time
+		}
+		l.Available = l.OnHand - l.Allocated
+		levels = append(levels, l)
+	}
+	return levels, total, rows.Err()
+}
+
 func (h *Handler) GetItemLocations(c echo.Context) error {
 	itemID := c.Param("item_id")
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -0,0 +1,535 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Invitation statuses, derived from the accepted/revoked/expiry timestamps
+const (
+	InvitationPending  = "PENDING"
+	InvitationAccepted = "ACCEPTED"
+	InvitationRevoked  = "REVOKED"
+	InvitationExpired  = "EXPIRED"
+)
+
+type Invitation struct {
+	ID         string     `json:"id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	Status     string     `json:"status"`
+	InvitedBy  *string    `json:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastSentAt time.Time  `json:"last_sent_at"`
+	SendCount  int        `json:"send_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// Whether the invitation email went out on this request
+	EmailSent *bool `json:"email_sent,omitempty"`
+}
+
+const invitationColumns = `id, email, role, invited_by, expires_at, accepted_at, revoked_at, last_sent_at, send_count, created_at`
+
+func scanInvitation(row rowScanner, now time.Time) (Invitation, error) {
+	var inv Invitation
+	var invitedBy sql.NullString
+	var acceptedAt, revokedAt sql.NullTime
+	if err := row.Scan(&inv.ID, &inv.Email, &inv.Role, &invitedBy, &inv.ExpiresAt, &acceptedAt, &revokedAt,
+		&inv.LastSentAt, &inv.SendCount, &inv.CreatedAt); err != nil {
+		return inv, err
+	}
+	if invitedBy.Valid {
+		inv.InvitedBy = &invitedBy.String
+	}
+	if acceptedAt.Valid {
+		inv.AcceptedAt = &acceptedAt.Time
+	}
+	if revokedAt.Valid {
+		inv.RevokedAt = &revokedAt.Time
+	}
+	inv.Status = invitationStatus(inv.AcceptedAt, inv.RevokedAt, inv.ExpiresAt, now)
+	return inv, nil
+}
+
+func invitationStatus(acceptedAt, revokedAt *time.Time, expiresAt, now time.Time) string {
+	switch {
+	case acceptedAt != nil:
+		return InvitationAccepted
+	case revokedAt != nil:
+		return InvitationRevoked
+	case !now.Before(expiresAt):
+		return InvitationExpired
+	default:
+		return InvitationPending
+	}
+}
+
+// Invitation tokens are "<invitation id>.<nonce>.<signature>", signed with the
+// JWT secret. Only a hash of the token is stored, and resending replaces it, so
+// a token is valid for one invitation send until it is used or expires.
+
+func signInviteToken(key []byte, id, nonce string) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "invite|%s|%s", id, nonce)
+	return id + "." + nonce + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newInviteToken(key []byte, id string) (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return signInviteToken(key, id, base64.RawURLEncoding.EncodeToString(b)), nil
+}
+
+var errInvalidInviteToken = errors.New("invalid invitation token")
+
+// parseInviteToken checks a token's signature and returns its invitation ID.
+func parseInviteToken(key []byte, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return "", errInvalidInviteToken
+	}
+	if !hmac.Equal([]byte(signInviteToken(key, parts[0], parts[1])), []byte(token)) {
+		return "", errInvalidInviteToken
+	}
+	return parts[0], nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateInvitation invites an email address to join the tenant with a role and
+// emails them a registration link. A pending invitation for the same address
+// is replaced.
+func (h *Handler) CreateInvitation(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req struct {
+		Email string `json:"email" validate:"required,email"`
+		Role  string `json:"role" validate:"required"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	req.Role = strings.ToUpper(strings.TrimSpace(req.Role))
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := checkGrantableRole(h.db(c), claims, req.Role); err != nil {
+		return err
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM `+memberTables+` WHERE m.tenant_id = $1 AND u.email = $2)
+	`, claims.TenantID, req.Email).Scan(&exists); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if exists {
+		return echo.NewHTTPError(http.StatusConflict, "a user with this email is already a member")
+	}
+
+	// Replace any pending invitation for the address
+	rows, err := tx.Query(`
+		UPDATE invitations SET revoked_at = NOW()
+		WHERE tenant_id = $1 AND email = $2 AND accepted_at IS NULL AND revoked_at IS NULL
+		RETURNING id
+	`, claims.TenantID, req.Email)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	var replaced []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		replaced = append(replaced, id)
+	}
+	rows.Close()
+	for _, id := range replaced {
+		if err := recordAudit(c, tx, AuditDelete, "invitation", id, nil); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+
+	id := uuid.New().String()
+	token, err := newInviteToken([]byte(h.Config.JWTSecret), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create invitation token")
+	}
+
+	now := time.Now()
+	inv, err := scanInvitation(tx.QueryRow(`
+		INSERT INTO invitations (id, tenant_id, email, role, token_hash, invited_by, expires_at, last_sent_at, send_count, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), 1, NOW())
+		RETURNING `+invitationColumns,
+		id, claims.TenantID, req.Email, req.Role, hashToken(token), claims.UserID, now.Add(h.Config.InviteExpiry)), now)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := recordAudit(c, tx, AuditCreate, "invitation", id, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	sent := h.sendInvitation(c.Request().Context(), claims.TenantID, inv, token)
+	inv.EmailSent = &sent
+	return c.JSON(http.StatusCreated, inv)
+}
+
+// ListInvitations lists the tenant's invitations (?status=PENDING|ACCEPTED|REVOKED|EXPIRED|ALL,
+// default PENDING), newest first.
+func (h *Handler) ListInvitations(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	status := strings.ToUpper(c.QueryParam("status"))
+	if status == "" {
+		status = InvitationPending
+	}
+	where := "tenant_id = $1"
+	switch status {
+	case InvitationPending:
+		where += " AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()"
+	case InvitationAccepted:
+		where += " AND accepted_at IS NOT NULL"
+	case InvitationRevoked:
+		where += " AND accepted_at IS NULL AND revoked_at IS NOT NULL"
+	case InvitationExpired:
+		where += " AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= NOW()"
+	case "ALL":
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "status must be one of PENDING, ACCEPTED, REVOKED, EXPIRED, ALL")
+	}
+
+	rows, err := h.db(c).Query(`SELECT `+invitationColumns+` FROM invitations WHERE `+where+` ORDER BY created_at DESC`, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	invitations := []Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows, now)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database scan error")
+		}
+		invitations = append(invitations, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"data": invitations})
+}
+
+// ResendInvitation issues a fresh token with a new expiry and emails it again.
+// The previous link stops working.
+func (h *Handler) ResendInvitation(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	id := c.Param("id")
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	inv, err := scanInvitation(tx.QueryRow(`
+		SELECT `+invitationColumns+` FROM invitations WHERE id = $1 AND tenant_id = $2 FOR UPDATE
+	`, id, claims.TenantID), now)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "invitation not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if inv.Status == InvitationAccepted || inv.Status == InvitationRevoked {
+		return echo.NewHTTPError(http.StatusConflict, "invitation has been "+strings.ToLower(inv.Status))
+	}
+
+	before, err := auditSnapshot(c, tx, "invitation", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	token, err := newInviteToken([]byte(h.Config.JWTSecret), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create invitation token")
+	}
+	inv, err = scanInvitation(tx.QueryRow(`
+		UPDATE invitations
+		SET token_hash = $2, expires_at = $3, last_sent_at = NOW(), send_count = send_count + 1
+		WHERE id = $1
+		RETURNING `+invitationColumns,
+		id, hashToken(token), now.Add(h.Config.InviteExpiry)), now)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := recordAudit(c, tx, AuditUpdate, "invitation", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	sent := h.sendInvitation(c.Request().Context(), claims.TenantID, inv, token)
+	inv.EmailSent = &sent
+	return c.JSON(http.StatusOK, inv)
+}
+
+// RevokeInvitation cancels a pending invitation.
+func (h *Handler) RevokeInvitation(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	id := c.Param("id")
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "invitation", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	res, err := tx.Exec(`
+		UPDATE invitations SET revoked_at = NOW()
+		WHERE id = $1 AND tenant_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL
+	`, id, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "pending invitation not found")
+	}
+	if err := recordAudit(c, tx, AuditDelete, "invitation", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// sendInvitation emails the registration link for inv and reports whether it
+// was sent. The invitation stands either way; it can be resent.
+func (h *Handler) sendInvitation(ctx context.Context, tenantID string, inv Invitation, token string) bool {
+	var tenantName string
+	if err := h.DB.QueryRowContext(ctx, `SELECT name FROM tenants WHERE id = $1`, tenantID).Scan(&tenantName); err != nil {
+		log.Error().Err(err).Str("tenant_id", tenantID).Msg("Failed to load tenant for invitation")
+		return false
+	}
+
+	link := fmt.Sprintf("%s/register?invite_token=%s", h.Config.AppURL, url.QueryEscape(token))
+	msg := services.Message{
+		To:      inv.Email,
+		Subject: fmt.Sprintf("You have been invited to %s", tenantName),
+		Body: fmt.Sprintf("You have been invited to join %s as %s.\n\nCreate your account here:\n%s\n\nThis link expires on %s.\n",
+			tenantName, inv.Role, link, inv.ExpiresAt.UTC().Format("2006-01-02 15:04 MST")),
+	}
+	if err := h.Mailer.Send(ctx, msg); err != nil {
+		log.Error().Err(err).Str("invitation_id", inv.ID).Msg("Failed to send invitation")
+		return false
+	}
+	return true
+}
+
+// registerWithInvite creates the invited user in the inviting tenant with the
+// invited role, or adds an existing account to it, and consumes the invitation.
+// The link was mailed to the address, so it counts as verified.
+func (h *Handler) registerWithInvite(c echo.Context, req RegisterRequest) error {
+	id, err := parseInviteToken([]byte(h.Config.JWTSecret), req.InviteToken)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid or expired invitation")
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	var tenantID, tenantName, tenantSlug, email, role, tokenHash string
+	var expiresAt time.Time
+	var acceptedAt, revokedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT i.tenant_id, t.name, t.slug, i.email, i.role, i.token_hash, i.expires_at, i.accepted_at, i.revoked_at
+		FROM invitations i
+		JOIN tenants t ON t.id = i.tenant_id AND t.is_active = true
+		WHERE i.id = $1
+		FOR UPDATE OF i
+	`, id).Scan(&tenantID, &tenantName, &tenantSlug, &email, &role, &tokenHash, &expiresAt, &acceptedAt, &revokedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load invitation")
+	}
+	if err == sql.ErrNoRows || subtle.ConstantTimeCompare([]byte(tokenHash), []byte(hashToken(req.InviteToken))) != 1 ||
+		acceptedAt.Valid || revokedAt.Valid || !time.Now().Before(expiresAt) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid or expired invitation")
+	}
+	if req.Email != email {
+		return echo.NewHTTPError(http.StatusBadRequest, "Email does not match the invitation")
+	}
+
+	before, err := auditSnapshot(c, tx, "invitation", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load invitation")
+	}
+	if err := checkQuota(c, tx, tenantID, services.QuotaUsers); err != nil {
+		return err
+	}
+
+	// Someone with an account in another tenant joins with it; their password
+	// proves it is theirs
+	var userID, existingHash string
+	err = tx.QueryRow(`SELECT id, COALESCE(password_hash, '') FROM users WHERE email = $1 FOR UPDATE`, req.Email).Scan(&userID, &existingHash)
+	existing := err == nil
+	if err == sql.ErrNoRows {
+		passwordHash, err := hashPassword(req.Password)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to secure password")
+		}
+		err = tx.QueryRow(`
+			INSERT INTO users (tenant_id, email, password_hash, name, role, is_active, email_verified_at, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, true, NOW(), NOW(), NOW())
+			RETURNING id
+		`, tenantID, req.Email, passwordHash, strings.TrimSpace(req.Name), role).Scan(&userID)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return echo.NewHTTPError(http.StatusConflict, "Email already registered in this company")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
+		}
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
+	} else {
+		if existingHash != "" && bcrypt.CompareHashAndPassword([]byte(existingHash), []byte(req.Password)) != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Enter the password of your existing account to accept the invitation")
+		}
+		var member bool
+		if err := tx.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM tenant_memberships WHERE user_id = $1 AND tenant_id = $2 AND is_active = true)
+		`, userID, tenantID).Scan(&member); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
+		}
+		if member {
+			return echo.NewHTTPError(http.StatusConflict, "Email already registered in this company")
+		}
+		if _, err := tx.Exec(`UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1`, userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
+		}
+	}
+	if err := addMembership(tx, userID, tenantID, role); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
+	}
+	if _, err := tx.Exec(`UPDATE invitations SET accepted_at = NOW(), accepted_user_id = $2 WHERE id = $1`, id, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to accept invitation")
+	}
+
+	// The new user is the actor of both changes
+	userEntry := auditEntry(c, AuditCreate, "user", userID)
+	if existing {
+		memberID, err := membershipID(tx, userID, tenantID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+		}
+		userEntry = auditEntry(c, AuditCreate, "membership", memberID)
+	}
+	userEntry.TenantID, userEntry.UserID = tenantID, userID
+	if err := writeAudit(c, tx, userEntry, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+	}
+	inviteEntry := auditEntry(c, AuditUpdate, "invitation", id)
+	inviteEntry.TenantID, inviteEntry.UserID = tenantID, userID
+	if err := writeAudit(c, tx, inviteEntry, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to complete registration")
+	}
+
+	// An invited ADMIN or MANAGER may have to enrol in MFA first
+	challenge, err := h.mfaChallenge(c, userID, tenantID, role)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start session")
+	}
+	if challenge != nil {
+		return c.JSON(http.StatusCreated, challenge)
+	}
+
+	accessToken, refreshToken, err := h.startSession(c, userID, tenantID, req.Email, role, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start session")
+	}
+
+	log.Info().
+		Str("user_email", req.Email).
+		Str("tenant_slug", tenantSlug).
+		Str("user_id", userID).
+		Str("invitation_id", id).
+		Msg("User registered from invitation")
+
+	return c.JSON(http.StatusCreated, RegisterResponse{
+		User: UserResponse{
+			ID:       userID,
+			Name:     strings.TrimSpace(req.Name),
+			Email:    req.Email,
+			Role:     role,
+			TenantID: tenantID,
+		},
+		Tenant: TenantResponse{
+			ID:   tenantID,
+			Name: tenantName,
+			Slug: tenantSlug,
+		},
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.Config.JWTExpiry.Seconds()),
+	})
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInviteToken(t *testing.T) {
+	key := []byte("secret")
+	token, err := newInviteToken(key, "inv-1")
+	require.NoError(t, err)
+
+	id, err := parseInviteToken(key, token)
+	require.NoError(t, err)
+	assert.Equal(t, "inv-1", id)
+
+	_, err = parseInviteToken([]byte("other"), token)
+	assert.ErrorIs(t, err, errInvalidInviteToken)
+
+	// The ID cannot be swapped without breaking the signature
+	forged := "inv-2" + token[len("inv-1"):]
+	_, err = parseInviteToken(key, forged)
+	assert.ErrorIs(t, err, errInvalidInviteToken)
+
+	_, err = parseInviteToken(key, "garbage")
+	assert.ErrorIs(t, err, errInvalidInviteToken)
+
+	other, err := newInviteToken(key, "inv-1")
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+	assert.NotEqual(t, hashToken(token), hashToken(other))
+}
+
+func TestInvitationStatus(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	later, earlier := now.Add(time.Hour), now.Add(-time.Hour)
+
+	assert.Equal(t, InvitationPending, invitationStatus(nil, nil, later, now))
+	assert.Equal(t, InvitationExpired, invitationStatus(nil, nil, earlier, now))
+	assert.Equal(t, InvitationRevoked, invitationStatus(nil, &earlier, later, now))
+	assert.Equal(t, InvitationAccepted, invitationStatus(&earlier, nil, earlier, now))
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"inventory/internal/middleware"
+	"inventory/internal/services"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
@@ -84,7 +85,7 @@ func (h *Handler) ListItems(c echo.Context) error {
 	// Count total (need to fix this to use same table alias)
 	countSQL := "SELECT COUNT(1) FROM items i " + where
 	var total int64
-	if err := h.DB.QueryRow(countSQL, args...).Scan(&total); err != nil {
+	if err := h.db(c).QueryRow(countSQL, args...).Scan(&total); err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
 	}
 
@@ -100,7 +101,7 @@ func (h *Handler) ListItems(c echo.Context) error {
 	listSQL = fmt.Sprintf(listSQL, limitIndex, offsetIndex)
 	args = append(args, pageSize, offset)
 
-	rows, err := h.DB.Query(listSQL, args...)
+	rows, err := h.db(c).Query(listSQL, args...)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
 	}
@@ -216,7 +217,16 @@ func (h *Handler) CreateItem(c echo.Context) error {
 		barcode = sql.NullString{String: *req.Barcode, Valid: true}
 	}
 
-	err = h.DB.QueryRow(
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	defer tx.Rollback()
+	if err := checkQuota(c, tx, tenantID.String(), services.QuotaItems); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(
 		query,
 		id,
 		tenantID,
@@ -250,6 +260,13 @@ func (h *Handler) CreateItem(c echo.Context) error {
 		return c.JSON(http.StatusConflict, ErrorResponse{Error: ErrorDetail{Code: "CONFLICT", Message: err.Error()}})
 	}
 
+	if err := recordAudit(c, tx, AuditCreate, "item", id.String(), nil); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+
 	if barcode.Valid {
 		s := barcode.String
 		returned.Barcode = &s
@@ -291,7 +308,7 @@ func (h *Handler) GetItem(c echo.Context) error {
 		rawAttr    []byte
 	)
 
-	err = h.DB.QueryRow(query, itemID, tenantID).Scan(
+	err = h.db(c).QueryRow(query, itemID, tenantID).Scan(
 		&dto.ID, &dto.SKU, &dto.Name, &barcode, &dto.UOM, &categoryID, &dto.Cost, &dto.Price, &rawAttr, &dto.IsActive, &dto.CreatedAt, &dto.UpdatedAt, &dto.DeletedAt, &catID, &catName,
 	)
 	if err != nil {
@@ -390,9 +407,20 @@ func (h *Handler) UpdateItem(c echo.Context) error {
         RETURNING id, sku, name, barcode, uom, category_id, cost, price, attributes, is_active, created_at, updated_at, deleted_at
     `
 
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "item", itemID.String())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+
 	var dto ItemDTO
 	var rawAttrs []byte
-	err = h.DB.QueryRow(
+	err = tx.QueryRow(
 		query,
 		req.SKU,
 		req.Name,
@@ -416,6 +444,13 @@ func (h *Handler) UpdateItem(c echo.Context) error {
 		return c.JSON(http.StatusConflict, ErrorResponse{Error: ErrorDetail{Code: "CONFLICT", Message: err.Error()}})
 	}
 
+	if err := recordAudit(c, tx, AuditUpdate, "item", itemID.String(), before); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+
 	if barcode.Valid {
 		s := barcode.String
 		dto.Barcode = &s
@@ -439,17 +474,34 @@ func (h *Handler) DeleteItem(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, ErrorResponse{Error: ErrorDetail{Code: "VALIDATION_ERROR", Message: "invalid id"}})
 	}
 
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "item", itemID.String())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+
 	query := `
         UPDATE items SET deleted_at = $1, updated_at = $1 WHERE id = $2 AND tenant_id = $3 AND deleted_at IS NULL
         RETURNING id
     `
 	var id uuid.UUID
-	err = h.DB.QueryRow(query, time.Now().UTC(), itemID, tenantID).Scan(&id)
+	err = tx.QueryRow(query, time.Now().UTC(), itemID, tenantID).Scan(&id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return c.JSON(http.StatusNotFound, ErrorResponse{Error: ErrorDetail{Code: "NOT_FOUND", Message: "item not found"}})
 		}
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
 	}
+	if err := recordAudit(c, tx, AuditDelete, "item", itemID.String(), before); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Code: "INTERNAL_ERROR", Message: err.Error()}})
+	}
 	return c.NoContent(http.StatusNoContent)
 }
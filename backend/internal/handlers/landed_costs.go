@@ -0,0 +1,615 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Landed cost allocation methods
+const (
+	AllocateByValue  = "VALUE"
+	AllocateByQty    = "QTY"
+	AllocateByWeight = "WEIGHT"
+)
+
+var validChargeTypes = map[string]bool{"FREIGHT": true, "DUTY": true, "INSURANCE": true, "OTHER": true}
+
+// ReceiptCharge is a landed-cost charge (freight, duty, insurance...) attached to
+// a goods receipt. Charges added before posting are allocated when the receipt is
+// posted; charges added afterwards are allocated and revalue stock immediately.
+type ReceiptCharge struct {
+	ID               string                    `json:"id"`
+	ReceiptID        string                    `json:"receipt_id"`
+	ChargeType       string                    `json:"charge_type"`
+	Description      *string                   `json:"description,omitempty"`
+	Amount           decimal.Decimal           `json:"amount"`
+	AllocationMethod string                    `json:"allocation_method"`
+	PostedAt         *time.Time                `json:"posted_at,omitempty"`
+	Allocations      []ReceiptChargeAllocation `json:"allocations"`
+	CreatedAt        time.Time                 `json:"created_at"`
+	UpdatedAt        time.Time                 `json:"updated_at"`
+}
+
+// ReceiptChargeAllocation is the share of a charge assigned to one receipt line.
+// For unposted charges it is a preview based on the current lines.
+type ReceiptChargeAllocation struct {
+	ReceiptLineID string          `json:"receipt_line_id"`
+	ItemID        string          `json:"item_id"`
+	Amount        decimal.Decimal `json:"amount"`
+}
+
+type ReceiptChargeRequest struct {
+	ChargeType       string  `json:"charge_type"`
+	Description      *string `json:"description"`
+	Amount           string  `json:"amount"`
+	AllocationMethod string  `json:"allocation_method"` // VALUE (default), QTY or WEIGHT
+}
+
+// costLine is a receipt line as used for landed cost allocation and posting.
+// Weight is the per-unit weight from the item's "weight" attribute.
+type costLine struct {
+	ID         string
+	ItemID     string
+	Qty        int
+	UnitCost   decimal.Decimal
+	LandedCost decimal.Decimal
+	Weight     decimal.Decimal
+}
+
+// postedUnitCost is the invoice unit cost plus the landed cost spread per unit.
+func (l costLine) postedUnitCost() decimal.Decimal {
+	if l.Qty <= 0 {
+		return l.UnitCost
+	}
+	return l.UnitCost.Add(l.LandedCost.DivRound(decimal.NewFromInt(int64(l.Qty)), 4))
+}
+
+// sqlQuerier is satisfied by both *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+	queryRower
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// ListReceiptCharges returns the landed-cost charges of a receipt with their allocations
+func (h *Handler) ListReceiptCharges(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	receiptID := c.Param("id")
+	if err := checkReceiptLocation(c, h.db(c), receiptID, claims.TenantID); err != nil {
+		return err
+	}
+
+	if _, _, err := h.receiptStatus(c, receiptID, claims.TenantID); err != nil {
+		return err
+	}
+
+	charges, err := h.loadReceiptCharges(c, receiptID, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load receipt charges")
+	}
+	return c.JSON(http.StatusOK, charges)
+}
+
+// AddReceiptCharge attaches a landed-cost charge to a receipt. On a posted receipt
+// the charge is allocated straight away and posted as a cost-only revaluation.
+func (h *Handler) AddReceiptCharge(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	receiptID := c.Param("id")
+	if err := checkReceiptLocation(c, h.db(c), receiptID, claims.TenantID); err != nil {
+		return err
+	}
+
+	var req ReceiptChargeRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	req.ChargeType = strings.ToUpper(strings.TrimSpace(req.ChargeType))
+	if !validChargeTypes[req.ChargeType] {
+		return echo.NewHTTPError(http.StatusBadRequest, "charge_type must be one of FREIGHT, DUTY, INSURANCE, OTHER")
+	}
+	req.AllocationMethod = strings.ToUpper(strings.TrimSpace(req.AllocationMethod))
+	if req.AllocationMethod == "" {
+		req.AllocationMethod = AllocateByValue
+	}
+	if req.AllocationMethod != AllocateByValue && req.AllocationMethod != AllocateByQty && req.AllocationMethod != AllocateByWeight {
+		return echo.NewHTTPError(http.StatusBadRequest, "allocation_method must be one of VALUE, QTY, WEIGHT")
+	}
+	amount, err := decimal.NewFromString(strings.TrimSpace(req.Amount))
+	if err != nil || !amount.IsPositive() {
+		return echo.NewHTTPError(http.StatusBadRequest, "amount must be a positive number")
+	}
+	amount = amount.Round(2)
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	// Locked so the receipt cannot be posted between reading its status and
+	// adding the charge
+	status, locationID, err := lockReceipt(tx, receiptID, claims.TenantID)
+	if err != nil {
+		return err
+	}
+	if status == "CANCELED" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Cannot add charges to a canceled receipt")
+	}
+
+	chargeID := uuid.New().String()
+	if _, err := tx.Exec(`
+		INSERT INTO receipt_charges (id, tenant_id, receipt_id, charge_type, description, amount, allocation_method, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())
+	`, chargeID, claims.TenantID, receiptID, req.ChargeType, req.Description, amount, req.AllocationMethod, claims.UserID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create charge")
+	}
+
+	// Charges on posted receipts revalue the stock that was received
+	if status == "POSTED" || status == "CLOSED" {
+		if !locationID.Valid {
+			return echo.NewHTTPError(http.StatusBadRequest, "Receipt has no location")
+		}
+		lines, err := loadCostLines(tx, receiptID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load receipt lines")
+		}
+		shares, err := allocateCharge(amount, req.AllocationMethod, lines)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := postChargeAllocations(tx, claims.TenantID, chargeID, lines, shares); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to allocate charge")
+		}
+		method, err := tenantCostingMethod(tx, claims.TenantID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if err := revalueReceiptLines(tx, method, claims.TenantID, claims.UserID, locationID.String, receiptID, chargeID, req.ChargeType, lines, shares); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revalue stock")
+		}
+	}
+
+	if err := recordAudit(c, tx, AuditCreate, "receipt_charge", chargeID, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	h.invalidateDashboard(claims.TenantID)
+
+	charges, err := h.loadReceiptCharges(c, receiptID, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load receipt charges")
+	}
+	for _, charge := range charges {
+		if charge.ID == chargeID {
+			return c.JSON(http.StatusCreated, charge)
+		}
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load receipt charge")
+}
+
+// DeleteReceiptCharge removes a charge that has not been posted yet
+func (h *Handler) DeleteReceiptCharge(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	if err := checkReceiptLocation(c, h.db(c), c.Param("id"), claims.TenantID); err != nil {
+		return err
+	}
+
+	var postedAt sql.NullTime
+	err := h.db(c).QueryRow(`
+		SELECT posted_at FROM receipt_charges
+		WHERE id = $1 AND receipt_id = $2 AND tenant_id = $3
+	`, c.Param("charge_id"), c.Param("id"), claims.TenantID).Scan(&postedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "Charge not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if postedAt.Valid {
+		return echo.NewHTTPError(http.StatusBadRequest, "Cannot delete a posted charge")
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "receipt_charge", c.Param("charge_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	if _, err := tx.Exec(`DELETE FROM receipt_charges WHERE id = $1 AND tenant_id = $2`, c.Param("charge_id"), claims.TenantID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete charge")
+	}
+
+	if err := recordAudit(c, tx, AuditDelete, "receipt_charge", c.Param("charge_id"), before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// allocateCharge splits amount across lines in proportion to line value
+// (qty * unit cost), quantity or weight (qty * unit weight). Shares are rounded
+// to cents and the rounding difference goes to the line with the largest basis.
+func allocateCharge(amount decimal.Decimal, method string, lines []costLine) ([]decimal.Decimal, error) {
+	bases := make([]decimal.Decimal, len(lines))
+	total := decimal.Zero
+	largest := -1
+	for i, l := range lines {
+		qty := decimal.NewFromInt(int64(l.Qty))
+		switch method {
+		case AllocateByValue:
+			bases[i] = qty.Mul(l.UnitCost)
+		case AllocateByQty:
+			bases[i] = qty
+		case AllocateByWeight:
+			bases[i] = qty.Mul(l.Weight)
+		default:
+			return nil, fmt.Errorf("unknown allocation method %s", method)
+		}
+		if bases[i].IsNegative() {
+			bases[i] = decimal.Zero
+		}
+		total = total.Add(bases[i])
+		if largest < 0 || bases[i].GreaterThan(bases[largest]) {
+			largest = i
+		}
+	}
+	if !total.IsPositive() {
+		switch method {
+		case AllocateByValue:
+			return nil, fmt.Errorf("cannot allocate by value: receipt lines have no cost")
+		case AllocateByWeight:
+			return nil, fmt.Errorf("cannot allocate by weight: items have no weight attribute")
+		default:
+			return nil, fmt.Errorf("cannot allocate: receipt has no lines")
+		}
+	}
+
+	shares := make([]decimal.Decimal, len(lines))
+	allocated := decimal.Zero
+	for i := range lines {
+		shares[i] = amount.Mul(bases[i]).Div(total).Round(2)
+		allocated = allocated.Add(shares[i])
+	}
+	shares[largest] = shares[largest].Add(amount.Sub(allocated))
+	return shares, nil
+}
+
+// movingAverageCost blends the current average cost of onHand units with qty
+// units received at unitCost. Negative stock is treated as none.
+func movingAverageCost(currentCost decimal.Decimal, onHand, qty int, unitCost decimal.Decimal) decimal.Decimal {
+	if onHand < 0 {
+		onHand = 0
+	}
+	if onHand+qty <= 0 {
+		return unitCost
+	}
+	value := currentCost.Mul(decimal.NewFromInt(int64(onHand))).Add(unitCost.Mul(decimal.NewFromInt(int64(qty))))
+	return value.DivRound(decimal.NewFromInt(int64(onHand+qty)), 4)
+}
+
+// loadCostLines loads the lines of a receipt with their per-unit weight. Lines
+// without a unit cost fall back to the item's current cost.
+func loadCostLines(q sqlQuerier, receiptID string) ([]costLine, error) {
+	rows, err := q.Query(`
+		SELECT grl.id, grl.item_id, grl.qty, COALESCE(grl.unit_cost, i.cost, 0), grl.landed_cost, i.attributes->>'weight'
+		FROM goods_receipt_lines grl
+		JOIN items i ON i.id = grl.item_id
+		WHERE grl.receipt_id = $1
+		ORDER BY grl.created_at, grl.id
+	`, receiptID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []costLine{}
+	for rows.Next() {
+		var l costLine
+		var weight sql.NullString
+		if err := rows.Scan(&l.ID, &l.ItemID, &l.Qty, &l.UnitCost, &l.LandedCost, &weight); err != nil {
+			return nil, err
+		}
+		if weight.Valid {
+			l.Weight, _ = decimal.NewFromString(strings.TrimSpace(weight.String))
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// postPendingCharges allocates all unposted charges of a receipt across its lines
+// and adds the shares to the lines' landed cost. Used when the receipt is posted.
+func postPendingCharges(tx *sql.Tx, tenantID, receiptID string, lines []costLine) error {
+	rows, err := tx.Query(`
+		SELECT id, amount, allocation_method FROM receipt_charges
+		WHERE receipt_id = $1 AND tenant_id = $2 AND posted_at IS NULL
+		ORDER BY created_at, id
+	`, receiptID, tenantID)
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		id     string
+		amount decimal.Decimal
+		method string
+	}
+	var charges []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.amount, &p.method); err != nil {
+			rows.Close()
+			return err
+		}
+		charges = append(charges, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, charge := range charges {
+		shares, err := allocateCharge(charge.amount, charge.method, lines)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := postChargeAllocations(tx, tenantID, charge.id, lines, shares); err != nil {
+			return err
+		}
+		for i := range lines {
+			lines[i].LandedCost = lines[i].LandedCost.Add(shares[i])
+		}
+	}
+	return nil
+}
+
+// postChargeAllocations stores the shares of a charge and marks it posted.
+func postChargeAllocations(tx *sql.Tx, tenantID, chargeID string, lines []costLine, shares []decimal.Decimal) error {
+	for i, l := range lines {
+		if shares[i].IsZero() {
+			continue
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO receipt_charge_allocations (id, tenant_id, charge_id, receipt_line_id, amount, created_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+		`, uuid.New().String(), tenantID, chargeID, l.ID, shares[i]); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`UPDATE receipt_charges SET posted_at = NOW(), updated_at = NOW() WHERE id = $1`, chargeID)
+	return err
+}
+
+// revalueReceiptLines applies a charge allocated after posting: it raises the
+// lines' landed and posted unit cost and records a zero-quantity REVALUATION
+// movement carrying the change in stock value. Under moving average the share is
+// spread over the item's stock on hand; under FIFO it raises the unit cost of the
+// layer the line created; under standard costing it is only a variance. A share
+// with no stock left to carry it is recorded as cost variance.
+func revalueReceiptLines(tx *sql.Tx, method, tenantID, userID, locationID, receiptID, chargeID, chargeType string, lines []costLine, shares []decimal.Decimal) error {
+	for i, l := range lines {
+		if shares[i].IsZero() {
+			continue
+		}
+		l.LandedCost = l.LandedCost.Add(shares[i])
+		if _, err := tx.Exec(`
+			UPDATE goods_receipt_lines SET landed_cost = $1, posted_unit_cost = $2, updated_at = NOW()
+			WHERE id = $3
+		`, l.LandedCost, l.postedUnitCost(), l.ID); err != nil {
+			return err
+		}
+
+		meta := map[string]interface{}{
+			"charge_id":   chargeID,
+			"charge_type": chargeType,
+			"amount":      shares[i].StringFixed(2),
+		}
+		value := decimal.Zero
+
+		switch method {
+		case CostingStandard:
+			meta["cost_variance"] = shares[i].StringFixed(2)
+		case CostingFIFO:
+			var layerID string
+			var qtyReceived, qtyRemaining int
+			err := tx.QueryRow(`
+				SELECT id, qty_received, qty_remaining FROM cost_layers WHERE source_line_id = $1 AND tenant_id = $2 AND closed_at IS NULL
+			`, l.ID, tenantID).Scan(&layerID, &qtyReceived, &qtyRemaining)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			if err == sql.ErrNoRows || qtyReceived <= 0 {
+				// The layer is used up: nothing left on hand to carry the charge
+				meta["cost_variance"] = shares[i].StringFixed(2)
+			} else {
+				unitDelta := shares[i].DivRound(decimal.NewFromInt(int64(qtyReceived)), 4)
+				if _, err := tx.Exec(`UPDATE cost_layers SET unit_cost = unit_cost + $1 WHERE id = $2`, unitDelta, layerID); err != nil {
+					return err
+				}
+				value = unitDelta.Mul(decimal.NewFromInt(int64(qtyRemaining)))
+				meta["unit_cost_delta"] = unitDelta.String()
+				meta["cost_variance"] = shares[i].Sub(value).StringFixed(2)
+			}
+		default:
+			var onHand int
+			if err := tx.QueryRow(`
+				SELECT COALESCE(SUM(on_hand), 0) FROM inventory_levels WHERE item_id = $1 AND tenant_id = $2
+			`, l.ItemID, tenantID).Scan(&onHand); err != nil {
+				return err
+			}
+			unitDelta, revalued, variance := movingAverageRevaluation(shares[i], onHand)
+			if !unitDelta.IsZero() {
+				if _, err := tx.Exec(`
+					UPDATE items SET cost = cost + $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3
+				`, unitDelta, l.ItemID, tenantID); err != nil {
+					return err
+				}
+				meta["unit_cost_delta"] = unitDelta.String()
+			}
+			value = revalued
+			if !variance.IsZero() {
+				meta["cost_variance"] = variance.StringFixed(2)
+			}
+		}
+
+		if _, err := insertStockMovement(tx, stockMove{
+			TenantID:   tenantID,
+			ItemID:     l.ItemID,
+			LocationID: locationID,
+			UserID:     userID,
+			Reason:     "REVALUATION",
+			Reference:  "Landed cost",
+			RefID:      receiptID,
+			Meta:       meta,
+		}, nil, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// movingAverageRevaluation spreads a charge allocated after posting over the
+// item's stock on hand. It returns the rise in unit cost and the stock value
+// added; with nothing on hand the charge has no stock to revalue and is all
+// cost variance.
+func movingAverageRevaluation(share decimal.Decimal, onHand int) (unitDelta, value, variance decimal.Decimal) {
+	if onHand <= 0 {
+		return decimal.Zero, decimal.Zero, share
+	}
+	return share.DivRound(decimal.NewFromInt(int64(onHand)), 4), share, decimal.Zero
+}
+
+// receiptStatus returns the status and location of a receipt in the tenant.
+func (h *Handler) receiptStatus(c echo.Context, receiptID, tenantID string) (string, sql.NullString, error) {
+	var status string
+	var locationID sql.NullString
+	err := h.db(c).QueryRow(`SELECT status, location_id FROM goods_receipts WHERE id = $1 AND tenant_id = $2`, receiptID, tenantID).Scan(&status, &locationID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", locationID, echo.NewHTTPError(http.StatusNotFound, "Receipt not found")
+		}
+		return "", locationID, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return status, locationID, nil
+}
+
+// lockReceipt returns the status and location of a receipt in the tenant and
+// locks it for the rest of the transaction.
+func lockReceipt(tx *sql.Tx, receiptID, tenantID string) (string, sql.NullString, error) {
+	var status string
+	var locationID sql.NullString
+	err := tx.QueryRow(`SELECT status, location_id FROM goods_receipts WHERE id = $1 AND tenant_id = $2 FOR UPDATE`, receiptID, tenantID).Scan(&status, &locationID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", locationID, echo.NewHTTPError(http.StatusNotFound, "Receipt not found")
+		}
+		return "", locationID, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return status, locationID, nil
+}
+
+// loadReceiptCharges loads the charges of a receipt. Posted charges carry their
+// stored allocations; pending ones a preview against the current lines.
+func (h *Handler) loadReceiptCharges(c echo.Context, receiptID, tenantID string) ([]ReceiptCharge, error) {
+	rows, err := h.db(c).Query(`
+		SELECT id, receipt_id, charge_type, description, amount, allocation_method, posted_at, created_at, updated_at
+		FROM receipt_charges
+		WHERE receipt_id = $1 AND tenant_id = $2
+		ORDER BY created_at, id
+	`, receiptID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	charges := []ReceiptCharge{}
+	for rows.Next() {
+		var ch ReceiptCharge
+		var description sql.NullString
+		var postedAt sql.NullTime
+		if err := rows.Scan(&ch.ID, &ch.ReceiptID, &ch.ChargeType, &description, &ch.Amount, &ch.AllocationMethod, &postedAt, &ch.CreatedAt, &ch.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if description.Valid {
+			ch.Description = &description.String
+		}
+		if postedAt.Valid {
+			ch.PostedAt = &postedAt.Time
+		}
+		ch.Allocations = []ReceiptChargeAllocation{}
+		charges = append(charges, ch)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var lines []costLine
+	for i := range charges {
+		ch := &charges[i]
+		if ch.PostedAt == nil {
+			if lines == nil {
+				if lines, err = loadCostLines(h.db(c), receiptID); err != nil {
+					return nil, err
+				}
+			}
+			shares, err := allocateCharge(ch.Amount, ch.AllocationMethod, lines)
+			if err != nil {
+				continue
+			}
+			for j, l := range lines {
+				ch.Allocations = append(ch.Allocations, ReceiptChargeAllocation{ReceiptLineID: l.ID, ItemID: l.ItemID, Amount: shares[j]})
+			}
+			continue
+		}
+
+		allocRows, err := h.db(c).Query(`
+			SELECT a.receipt_line_id, grl.item_id, a.amount
+			FROM receipt_charge_allocations a
+			JOIN goods_receipt_lines grl ON grl.id = a.receipt_line_id
+			WHERE a.charge_id = $1
+			ORDER BY grl.created_at, grl.id
+		`, ch.ID)
+		if err != nil {
+			return nil, err
+		}
+		for allocRows.Next() {
+			var a ReceiptChargeAllocation
+			if err := allocRows.Scan(&a.ReceiptLineID, &a.ItemID, &a.Amount); err != nil {
+				allocRows.Close()
+				return nil, err
+			}
+			ch.Allocations = append(ch.Allocations, a)
+		}
+		allocRows.Close()
+		if err := allocRows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return charges, nil
+}
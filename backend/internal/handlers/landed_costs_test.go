@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllocateCharge(t *testing.T) {
+	lines := []costLine{
+		{ID: "a", Qty: 10, UnitCost: decimal.NewFromInt(5), Weight: decimal.NewFromInt(2)},
+		{ID: "b", Qty: 20, UnitCost: decimal.NewFromInt(10), Weight: decimal.NewFromFloat(0.5)},
+		{ID: "c", Qty: 30, UnitCost: decimal.NewFromInt(1)},
+	}
+	amount := decimal.NewFromInt(100)
+
+	t.Run("By value", func(t *testing.T) {
+		// values 50, 200, 30
+		shares, err := allocateCharge(amount, AllocateByValue, lines)
+		require.NoError(t, err)
+		assert.Equal(t, "17.86", shares[0].StringFixed(2))
+		assert.Equal(t, "71.43", shares[1].StringFixed(2))
+		assert.Equal(t, "10.71", shares[2].StringFixed(2))
+		assert.True(t, shares[0].Add(shares[1]).Add(shares[2]).Equal(amount))
+	})
+
+	t.Run("By quantity", func(t *testing.T) {
+		shares, err := allocateCharge(amount, AllocateByQty, lines)
+		require.NoError(t, err)
+		assert.Equal(t, "16.67", shares[0].StringFixed(2))
+		assert.Equal(t, "33.33", shares[1].StringFixed(2))
+		assert.Equal(t, "50.00", shares[2].StringFixed(2))
+	})
+
+	t.Run("By weight", func(t *testing.T) {
+		// weights 20, 10, 0
+		shares, err := allocateCharge(decimal.NewFromInt(30), AllocateByWeight, lines)
+		require.NoError(t, err)
+		assert.Equal(t, "20.00", shares[0].StringFixed(2))
+		assert.Equal(t, "10.00", shares[1].StringFixed(2))
+		assert.True(t, shares[2].IsZero())
+	})
+
+	t.Run("Rounding difference goes to the largest line", func(t *testing.T) {
+		even := []costLine{{Qty: 1}, {Qty: 1}, {Qty: 1}}
+		shares, err := allocateCharge(amount, AllocateByQty, even)
+		require.NoError(t, err)
+		assert.Equal(t, "33.34", shares[0].StringFixed(2))
+		assert.Equal(t, "33.33", shares[2].StringFixed(2))
+	})
+
+	t.Run("No basis", func(t *testing.T) {
+		_, err := allocateCharge(amount, AllocateByWeight, lines[2:])
+		assert.Error(t, err)
+		_, err = allocateCharge(amount, AllocateByQty, nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestMovingAverageCost(t *testing.T) {
+	// 10 units at 4.00 plus 30 units at 6.00
+	cost := movingAverageCost(decimal.NewFromInt(4), 10, 30, decimal.NewFromInt(6))
+	assert.Equal(t, "5.5", cost.String())
+
+	// Negative or no stock takes the receipt cost
+	cost = movingAverageCost(decimal.NewFromInt(4), -5, 10, decimal.NewFromInt(6))
+	assert.Equal(t, "6", cost.String())
+}
+
+func TestPostedUnitCost(t *testing.T) {
+	line := costLine{Qty: 8, UnitCost: decimal.NewFromInt(10), LandedCost: decimal.NewFromInt(4)}
+	assert.Equal(t, "10.5", line.postedUnitCost().String())
+}
+
+func TestMovingAverageRevaluation(t *testing.T) {
+	// 30.00 over 40 units on hand
+	unitDelta, value, variance := movingAverageRevaluation(decimal.NewFromInt(30), 40)
+	assert.Equal(t, "0.75", unitDelta.String())
+	assert.Equal(t, "30", value.String())
+	assert.True(t, variance.IsZero())
+
+	// Nothing on hand: the whole share is cost variance, not dropped
+	for _, onHand := range []int{0, -3} {
+		unitDelta, value, variance = movingAverageRevaluation(decimal.NewFromInt(30), onHand)
+		assert.True(t, unitDelta.IsZero())
+		assert.True(t, value.IsZero())
+		assert.Equal(t, "30", variance.String())
+	}
+}
@@ -9,6 +9,9 @@ import (
 	"strconv"
 	"strings"
 
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
+
 	"github.com/labstack/echo/v4"
 )
 
@@ -57,7 +60,7 @@ func (h *Handler) ListLocations(c echo.Context) error {
 	query += fmt.Sprintf(" OFFSET $%d", n)
 	args = append(args, offset)
 
-	rows, err := h.DB.Query(query, args...)
+	rows, err := h.db(c).Query(query, args...)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
@@ -91,7 +94,7 @@ func (h *Handler) ListLocations(c echo.Context) error {
 		countArgs = append(countArgs, isActiveParam == "true")
 	}
 	var total int
-	if err := h.DB.QueryRow(countQ, countArgs...).Scan(&total); err != nil {
+	if err := h.db(c).QueryRow(countQ, countArgs...).Scan(&total); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
 
@@ -99,6 +102,11 @@ func (h *Handler) ListLocations(c echo.Context) error {
 }
 
 func (h *Handler) CreateLocation(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
 	var req struct {
 		Code     string                 `json:"code"`
 		Name     string                 `json:"name"`
@@ -128,19 +136,34 @@ func (h *Handler) CreateLocation(c echo.Context) error {
 		addrJSON = b
 	}
 
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+	if err := checkQuota(c, tx, claims.TenantID, services.QuotaLocations); err != nil {
+		return err
+	}
+
 	var m LocationModel
 	var addr sql.NullString
-	err := h.DB.QueryRow(`
-        INSERT INTO locations (code, name, address, is_active, created_at, updated_at)
-        VALUES ($1, $2, $3, $4, NOW(), NOW())
+	err = tx.QueryRow(`
+        INSERT INTO locations (tenant_id, code, name, address, is_active, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
         RETURNING id, code, name, address, is_active
-    `, req.Code, req.Name, nullableJSON(addrJSON), isActive).Scan(&m.ID, &m.Code, &m.Name, &addr, &m.IsActive)
+    `, claims.TenantID, req.Code, req.Name, nullableJSON(addrJSON), isActive).Scan(&m.ID, &m.Code, &m.Name, &addr, &m.IsActive)
 	if err != nil {
 		if isUniqueViolation(err) {
 			return echo.NewHTTPError(http.StatusConflict, "location code already exists")
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
+	if err := recordAudit(c, tx, AuditCreate, "location", m.ID, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 	if addr.Valid {
 		m.Address = addr.String
 	}
@@ -151,7 +174,7 @@ func (h *Handler) GetLocation(c echo.Context) error {
 	id := c.Param("id")
 	var m LocationModel
 	var addr sql.NullString
-	if err := h.DB.QueryRow(`SELECT id, code, name, address, is_active FROM locations WHERE id = $1`, id).Scan(&m.ID, &m.Code, &m.Name, &addr, &m.IsActive); err != nil {
+	if err := h.db(c).QueryRow(`SELECT id, code, name, address, is_active FROM locations WHERE id = $1`, id).Scan(&m.ID, &m.Code, &m.Name, &addr, &m.IsActive); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return echo.NewHTTPError(http.StatusNotFound, "location not found")
 		}
@@ -210,9 +233,20 @@ func (h *Handler) UpdateLocation(c echo.Context) error {
 
 	query := fmt.Sprintf(`UPDATE locations SET %s WHERE id = $%d RETURNING id, code, name, address, is_active`, strings.Join(sets, ", "), i)
 
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "location", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
 	var m LocationModel
 	var addr sql.NullString
-	if err := h.DB.QueryRow(query, args...).Scan(&m.ID, &m.Code, &m.Name, &addr, &m.IsActive); err != nil {
+	if err := tx.QueryRow(query, args...).Scan(&m.ID, &m.Code, &m.Name, &addr, &m.IsActive); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return echo.NewHTTPError(http.StatusNotFound, "location not found")
 		}
@@ -221,6 +255,12 @@ func (h *Handler) UpdateLocation(c echo.Context) error {
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
+	if err := recordAudit(c, tx, AuditUpdate, "location", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 	if addr.Valid {
 		m.Address = addr.String
 	}
@@ -229,7 +269,18 @@ func (h *Handler) UpdateLocation(c echo.Context) error {
 
 func (h *Handler) DeleteLocation(c echo.Context) error {
 	id := c.Param("id")
-	res, err := h.DB.Exec(`DELETE FROM locations WHERE id = $1`, id)
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "location", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	res, err := tx.Exec(`DELETE FROM locations WHERE id = $1`, id)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusConflict, "cannot delete location (in use)")
 	}
@@ -237,5 +288,11 @@ func (h *Handler) DeleteLocation(c echo.Context) error {
 	if n == 0 {
 		return echo.NewHTTPError(http.StatusNotFound, "location not found")
 	}
+	if err := recordAudit(c, tx, AuditDelete, "location", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 	return c.NoContent(http.StatusNoContent)
 }
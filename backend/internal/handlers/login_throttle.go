@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Failures allowed before backoff starts, per account and per IP (an office
+// behind one address shares the IP budget), and the first delay
+const (
+	loginFreeAttempts   = 3
+	loginIPFreeAttempts = 10
+	loginBaseDelay      = time.Second
+)
+
+func (h *Handler) loginThrottle(db services.DBTX) *services.LoginThrottle {
+	return services.NewLoginThrottle(db, services.ThrottlePolicy{
+		MaxAttempts:    h.Config.LoginMaxAttempts,
+		IPMaxAttempts:  h.Config.LoginIPMaxAttempts,
+		FreeAttempts:   loginFreeAttempts,
+		IPFreeAttempts: loginIPFreeAttempts,
+		BaseDelay:      loginBaseDelay,
+		Lockout:        h.Config.LoginLockout,
+	})
+}
+
+// checkLoginThrottle returns a 429 with Retry-After when logins for the email
+// or from the client's IP are currently blocked.
+func (h *Handler) checkLoginThrottle(c echo.Context, email string) error {
+	blocked, err := h.loginThrottle(h.DB).Check(c.Request().Context(), email, c.RealIP())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check login throttle")
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if blocked == nil {
+		return nil
+	}
+
+	seconds := int(math.Ceil(blocked.RetryAfter.Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+	if blocked.Locked && blocked.Scope == services.ThrottleAccount {
+		return echo.NewHTTPError(http.StatusTooManyRequests, "account is temporarily locked after too many failed logins")
+	}
+	return echo.NewHTTPError(http.StatusTooManyRequests, "too many failed logins, try again in "+strconv.Itoa(seconds)+"s")
+}
+
+// loginFailed counts a failed login against the email and the client IP. When
+// the account exists the failure (and a resulting lockout) is audited in its
+// tenant with the client's IP address and user agent.
+func (h *Handler) loginFailed(c echo.Context, email, userID, tenantID, reason string) {
+	ctx := c.Request().Context()
+	locked, err := h.loginThrottle(h.DB).Fail(ctx, email, c.RealIP())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to record failed login")
+	}
+
+	log.Warn().
+		Str("email", email).
+		Str("ip", c.RealIP()).
+		Str("user_agent", c.Request().UserAgent()).
+		Str("reason", reason).
+		Bool("locked", locked).
+		Msg("Failed login")
+
+	if userID == "" || tenantID == "" {
+		return
+	}
+	details, _ := json.Marshal(map[string]string{"reason": reason})
+	actions := []string{AuditLoginFailed}
+	if locked {
+		actions = append(actions, AuditLock)
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to audit failed login")
+		return
+	}
+	defer tx.Rollback()
+	audit := services.NewAuditService(tx)
+	for _, action := range actions {
+		e := auditEntry(c, action, "user", userID)
+		e.TenantID = tenantID
+		e.After = details
+		if err := audit.Record(ctx, e); err != nil {
+			log.Error().Err(err).Msg("Failed to audit failed login")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Error().Err(err).Msg("Failed to audit failed login")
+	}
+}
+
+// UnlockUser lifts a login lockout or backoff on one of the tenant's users.
+func (h *Handler) UnlockUser(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	id := c.Param("id")
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRow(`SELECT u.email FROM `+memberTables+` WHERE u.id = $1 AND m.tenant_id = $2`, id, claims.TenantID).Scan(&email)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	if err := h.loginThrottle(tx).Unlock(c.Request().Context(), email); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := services.NewAuditService(tx).Record(c.Request().Context(), auditEntry(c, AuditUnlock, "user", id)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
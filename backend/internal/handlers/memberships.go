@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Membership is a tenant a user belongs to and their role in it. A user's home
+// tenant is the one their account was created in; it alone manages their
+// profile and password.
+type Membership struct {
+	TenantID   string `json:"tenant_id"`
+	TenantName string `json:"tenant_name"`
+	TenantSlug string `json:"tenant_slug"`
+	Role       string `json:"role"`
+	Home       bool   `json:"home"`
+}
+
+// Columns and joins for a user as seen by one tenant: role and active flag
+// come from the membership
+const (
+	memberColumns = `u.id, u.email, u.name, m.role, m.is_active, u.email_verified_at, u.mfa_enabled_at IS NOT NULL, u.oauth_provider, u.avatar_url, u.last_login, u.created_at, u.updated_at`
+	memberTables  = `users u JOIN tenant_memberships m ON m.user_id = u.id`
+)
+
+const membershipColumns = `t.id, t.name, t.slug, m.role, m.tenant_id IS NOT DISTINCT FROM u.tenant_id`
+
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func scanMembership(row rowScanner) (Membership, error) {
+	var m Membership
+	err := row.Scan(&m.TenantID, &m.TenantName, &m.TenantSlug, &m.Role, &m.Home)
+	return m, err
+}
+
+// addMembership makes the user a member of the tenant with role, reactivating
+// a membership that was disabled.
+func addMembership(q sqlExecer, userID, tenantID, role string) error {
+	_, err := q.Exec(`
+		INSERT INTO tenant_memberships (user_id, tenant_id, role, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, true, NOW(), NOW())
+		ON CONFLICT (user_id, tenant_id) DO UPDATE SET role = EXCLUDED.role, is_active = true, updated_at = NOW()
+	`, userID, tenantID, role)
+	return err
+}
+
+// syncHomeMembership copies the user's membership of their home tenant onto
+// users.role and users.is_active. It does nothing for other tenants.
+func syncHomeMembership(q sqlExecer, userID, tenantID string) error {
+	_, err := q.Exec(`
+		UPDATE users u SET role = m.role, is_active = m.is_active, updated_at = NOW()
+		FROM tenant_memberships m
+		WHERE u.id = $1 AND u.tenant_id = $2 AND m.user_id = u.id AND m.tenant_id = u.tenant_id
+	`, userID, tenantID)
+	return err
+}
+
+// listMemberships returns the user's active memberships of active tenants,
+// home tenant first.
+func listMemberships(q sqlQuerier, userID string) ([]Membership, error) {
+	rows, err := q.Query(`
+		SELECT `+membershipColumns+`
+		FROM tenant_memberships m
+		JOIN users u ON u.id = m.user_id
+		JOIN tenants t ON t.id = m.tenant_id
+		WHERE m.user_id = $1 AND m.is_active = true AND t.is_active = true
+		ORDER BY m.tenant_id IS NOT DISTINCT FROM u.tenant_id DESC, t.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []Membership{}
+	for rows.Next() {
+		m, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, m)
+	}
+	return memberships, rows.Err()
+}
+
+// activeMembership returns the user's active membership of the active tenant
+// with the given ID or slug, or sql.ErrNoRows.
+func activeMembership(q queryRower, userID, tenant string) (Membership, error) {
+	return scanMembership(q.QueryRow(`
+		SELECT `+membershipColumns+`
+		FROM tenant_memberships m
+		JOIN users u ON u.id = m.user_id
+		JOIN tenants t ON t.id = m.tenant_id
+		WHERE m.user_id = $1 AND (t.slug = $2 OR t.id::text = $2) AND m.is_active = true AND t.is_active = true
+	`, userID, tenant))
+}
+
+// ListMyMemberships returns the tenants the current user can switch to.
+func (h *Handler) ListMyMemberships(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	memberships, err := listMemberships(h.db(c), claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, memberships)
+}
+
+// SwitchTenant ends the current session and starts one in another tenant the
+// user belongs to. Unless the current session was opened with a second factor,
+// a user with MFA, or one the tenant requires to have it, gets an MFA
+// challenge instead.
+func (h *Handler) SwitchTenant(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	if claims.APIKeyID != "" {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys cannot switch tenant")
+	}
+
+	var req struct {
+		TenantID   string `json:"tenant_id"`
+		TenantSlug string `json:"tenant_slug"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	tenant := strings.TrimSpace(req.TenantID)
+	if tenant == "" {
+		tenant = strings.TrimSpace(req.TenantSlug)
+	}
+	if tenant == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "tenant_id or tenant_slug is required")
+	}
+
+	m, err := activeMembership(h.db(c), claims.UserID, tenant)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "you are not a member of this tenant")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	var name string
+	if err := h.db(c).QueryRow(`SELECT name FROM users WHERE id = $1`, claims.UserID).Scan(&name); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	// A session opened with a second factor carries it over; any other is
+	// challenged like a login
+	mfaVerified, err := sessionMFAVerified(h.db(c), claims.SessionID, claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !mfaVerified {
+		challenge, err := h.mfaChallenge(c, claims.UserID, m.TenantID, m.Role)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
+		}
+		if challenge != nil {
+			return c.JSON(http.StatusOK, challenge)
+		}
+	}
+
+	accessToken, refreshToken, err := h.startSession(c, claims.UserID, m.TenantID, claims.Email, m.Role, mfaVerified)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
+	}
+	if claims.SessionID != "" {
+		tx, err := h.db(c).Begin()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		defer tx.Rollback()
+		if err := revokeSession(tx, claims.SessionID, SessionLogout); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if err := tx.Commit(); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+
+	memberships, err := listMemberships(h.db(c), claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, LoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.Config.JWTExpiry.Seconds()),
+		User: UserResponse{
+			ID:       claims.UserID,
+			Name:     name,
+			Email:    claims.Email,
+			Role:     m.Role,
+			TenantID: m.TenantID,
+		},
+		Tenant: TenantResponse{
+			ID:   m.TenantID,
+			Name: m.TenantName,
+			Slug: m.TenantSlug,
+		},
+		Memberships: memberships,
+	})
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwitchTenantRejectsBeforeLookup(t *testing.T) {
+	for name, tc := range map[string]struct {
+		claims *appmw.Claims
+		body   string
+		code   int
+	}{
+		"no tenant": {&appmw.Claims{UserID: "u1", TenantID: "t1"}, `{}`, http.StatusBadRequest},
+		"API key":   {&appmw.Claims{UserID: "u1", TenantID: "t1", APIKeyID: "k1"}, `{"tenant_slug":"acme"}`, http.StatusForbidden},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/auth/switch-tenant", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			c := e.NewContext(req, httptest.NewRecorder())
+			c.Set("user", tc.claims)
+
+			// The handler has no database, so it must answer before any query
+			err := (&Handler{}).SwitchTenant(c)
+			var httpErr *echo.HTTPError
+			require.ErrorAs(t, err, &httpErr)
+			assert.Equal(t, tc.code, httpErr.Code)
+		})
+	}
+}
+
+func TestSessionMFAVerifiedWithoutSession(t *testing.T) {
+	// Tokens without a session never passed a second factor; no query is made
+	verified, err := sessionMFAVerified(nil, "", "u1")
+	require.NoError(t, err)
+	assert.False(t, verified)
+}
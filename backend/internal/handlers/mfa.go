@@ -0,0 +1,731 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// A password (or Google) login of a user with MFA, or of an ADMIN or MANAGER
+// of a tenant that requires it, only yields a short-lived challenge token. It
+// is exchanged for a session at /auth/mfa/verify with a TOTP or recovery code;
+// users who still have to enrol do so with the challenge first.
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaMaxAttempts    = 5
+	recoveryCodeCount = 10
+)
+
+// Roles that must use MFA when the tenant requires it
+var mfaRequiredRoles = []string{"ADMIN", "MANAGER"}
+
+var (
+	errMFAEnabled    = errors.New("MFA is already enabled")
+	errMFANotStarted = errors.New("MFA enrolment has not been started")
+)
+
+type MFAChallengeResponse struct {
+	MFARequired        bool   `json:"mfa_required"`
+	EnrollmentRequired bool   `json:"mfa_enrollment_required"`
+	MFAToken           string `json:"mfa_token"`
+	ExpiresIn          int    `json:"expires_in"`
+}
+
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type MFAVerifyResponse struct {
+	LoginResponse
+	// Only set when the verification completed enrolment
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+type MFAStatus struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+type userMFA struct {
+	secret      string
+	enabled     bool
+	lastCounter int64
+}
+
+func isMFARequiredRole(role string) bool {
+	for _, r := range mfaRequiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// mfaRequired reports whether the tenant requires MFA of users with role.
+func mfaRequired(q queryRower, tenantID, role string) (bool, error) {
+	if tenantID == "" || !isMFARequiredRole(role) {
+		return false, nil
+	}
+	var required bool
+	err := q.QueryRow(`SELECT mfa_required FROM tenants WHERE id = $1`, tenantID).Scan(&required)
+	return required, err
+}
+
+// mfaChallenge returns the challenge a login has to pass before a session is
+// started, or nil when the user needs no second factor.
+func (h *Handler) mfaChallenge(c echo.Context, userID, tenantID, role string) (*MFAChallengeResponse, error) {
+	var enabled bool
+	if err := h.db(c).QueryRow(`SELECT mfa_enabled_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&enabled); err != nil {
+		return nil, err
+	}
+	if !enabled {
+		required, err := mfaRequired(h.db(c), tenantID, role)
+		if err != nil || !required {
+			return nil, err
+		}
+	}
+
+	// The challenge remembers the tenant the session is for
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	token, err := issueUserToken(tx, userID, TokenMFAChallenge, mfaChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE user_tokens SET tenant_id = $2 WHERE token_hash = $1`, hashToken(token), nullIfEmpty(tenantID)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &MFAChallengeResponse{
+		MFARequired:        true,
+		EnrollmentRequired: !enabled,
+		MFAToken:           token,
+		ExpiresIn:          int(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
+// lockMFAChallenge returns the challenge's ID and user, or errInvalidUserToken
+// when it is unknown, used, expired or out of attempts.
+func lockMFAChallenge(tx *sql.Tx, token string) (string, string, error) {
+	var id, userID string
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	var attempts int
+	err := tx.QueryRow(`
+		SELECT id, user_id, expires_at, used_at, attempts FROM user_tokens WHERE token_hash = $1 AND purpose = $2 FOR UPDATE
+	`, hashToken(token), TokenMFAChallenge).Scan(&id, &userID, &expiresAt, &usedAt, &attempts)
+	if err == sql.ErrNoRows {
+		return "", "", errInvalidUserToken
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if usedAt.Valid || !time.Now().Before(expiresAt) || attempts >= mfaMaxAttempts {
+		return "", "", errInvalidUserToken
+	}
+	return id, userID, nil
+}
+
+func (h *Handler) lockUserMFA(tx *sql.Tx, userID string) (userMFA, error) {
+	var m userMFA
+	var sealed sql.NullString
+	err := tx.QueryRow(`
+		SELECT mfa_secret, mfa_enabled_at IS NOT NULL, mfa_last_counter FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&sealed, &m.enabled, &m.lastCounter)
+	if err != nil {
+		return m, err
+	}
+	if sealed.Valid {
+		if m.secret, err = services.OpenSecret(h.Config.MFAEncryptionKey, sealed.String); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// beginMFAEnrollment gives the user a new, unconfirmed TOTP secret.
+func (h *Handler) beginMFAEnrollment(tx *sql.Tx, userID string) (MFAEnrollment, error) {
+	var email string
+	var enabled bool
+	if err := tx.QueryRow(`
+		SELECT email, mfa_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&email, &enabled); err != nil {
+		return MFAEnrollment{}, err
+	}
+	if enabled {
+		return MFAEnrollment{}, errMFAEnabled
+	}
+
+	secret, err := services.GenerateTOTPSecret()
+	if err != nil {
+		return MFAEnrollment{}, err
+	}
+	sealed, err := services.SealSecret(h.Config.MFAEncryptionKey, secret)
+	if err != nil {
+		return MFAEnrollment{}, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET mfa_secret = $2, mfa_last_counter = 0 WHERE id = $1`, userID, sealed); err != nil {
+		return MFAEnrollment{}, err
+	}
+	return MFAEnrollment{Secret: secret, OTPAuthURI: services.TOTPURI(h.Config.MFAIssuer, email, secret)}, nil
+}
+
+// checkSecondFactor validates a TOTP code, or a recovery code once MFA is
+// enabled, and consumes it. It confirms a pending enrolment and then returns
+// the new recovery codes.
+func (h *Handler) checkSecondFactor(c echo.Context, tx *sql.Tx, userID, code, recoveryCode string) (ok bool, recoveryCodes []string, err error) {
+	m, err := h.lockUserMFA(tx, userID)
+	if err != nil {
+		return false, nil, err
+	}
+	if m.secret == "" {
+		return false, nil, errMFANotStarted
+	}
+
+	if recoveryCode != "" {
+		if !m.enabled {
+			return false, nil, nil
+		}
+		res, err := tx.Exec(`
+			UPDATE user_recovery_codes SET used_at = NOW()
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+		`, userID, hashToken(services.NormalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return false, nil, err
+		}
+		n, _ := res.RowsAffected()
+		return n > 0, nil, nil
+	}
+
+	counter, valid := services.ValidateTOTP(m.secret, code, time.Now(), m.lastCounter)
+	if !valid {
+		return false, nil, nil
+	}
+	if m.enabled {
+		_, err = tx.Exec(`UPDATE users SET mfa_last_counter = $2 WHERE id = $1`, userID, counter)
+		return err == nil, nil, err
+	}
+
+	err = h.updateAccount(c, tx, userID, `
+		UPDATE users SET mfa_enabled_at = NOW(), mfa_last_counter = $2, updated_at = NOW() WHERE id = $1
+	`, counter)
+	if err != nil {
+		return false, nil, err
+	}
+	if recoveryCodes, err = replaceRecoveryCodes(tx, userID); err != nil {
+		return false, nil, err
+	}
+	log.Info().Str("user_id", userID).Msg("MFA enabled")
+	return true, recoveryCodes, nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID string) ([]string, error) {
+	codes, err := services.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(`
+			INSERT INTO user_recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, NOW())
+		`, userID, hashToken(code)); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// EnrollMFAWithChallenge starts enrolment for a user whose login was held
+// back because their tenant requires MFA.
+func (h *Handler) EnrollMFAWithChallenge(c echo.Context) error {
+	var req struct {
+		MFAToken string `json:"mfa_token" validate:"required"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "mfa_token is required")
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	_, userID, err := lockMFAChallenge(tx, req.MFAToken)
+	if err == errInvalidUserToken {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired MFA challenge")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	enrollment, err := h.beginMFAEnrollment(tx, userID)
+	if err == errMFAEnabled {
+		return echo.NewHTTPError(http.StatusConflict, "MFA is already enabled")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start MFA enrolment")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+// VerifyMFA exchanges a login challenge and a TOTP or recovery code for an
+// access/refresh pair.
+func (h *Handler) VerifyMFA(c echo.Context) error {
+	var req struct {
+		MFAToken     string `json:"mfa_token" validate:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if err := c.Validate(req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		return echo.NewHTTPError(http.StatusBadRequest, "mfa_token and a code or recovery_code are required")
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	challengeID, userID, err := lockMFAChallenge(tx, req.MFAToken)
+	if err == errInvalidUserToken {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired MFA challenge")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	// Wrong codes count towards the account's login lockout
+	var email string
+	var tenantID sql.NullString
+	if err := tx.QueryRow(`
+		SELECT u.email, COALESCE(ut.tenant_id, u.tenant_id) FROM user_tokens ut JOIN users u ON u.id = ut.user_id WHERE ut.id = $1
+	`, challengeID).Scan(&email, &tenantID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := h.checkLoginThrottle(c, email); err != nil {
+		return err
+	}
+
+	ok, recoveryCodes, err := h.checkSecondFactor(c, tx, userID, req.Code, req.RecoveryCode)
+	if err == errMFANotStarted {
+		return echo.NewHTTPError(http.StatusBadRequest, "enrol at /auth/mfa/enroll first")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !ok {
+		if _, err := tx.Exec(`UPDATE user_tokens SET attempts = attempts + 1 WHERE id = $1`, challengeID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if err := tx.Commit(); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		h.loginFailed(c, email, userID, tenantID.String, "invalid_mfa_code")
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid code")
+	}
+	if _, err := tx.Exec(`UPDATE user_tokens SET used_at = NOW() WHERE id = $1`, challengeID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	var resp MFAVerifyResponse
+	err = tx.QueryRow(`
+		SELECT u.name, u.email, m.role, m.tenant_id, t.name, t.slug
+		FROM `+memberTables+`
+		JOIN tenants t ON t.id = m.tenant_id
+		WHERE u.id = $1 AND m.tenant_id = $2 AND m.is_active = true AND t.is_active = true
+	`, userID, tenantID).Scan(&resp.User.Name, &resp.User.Email, &resp.User.Role, &resp.User.TenantID, &resp.Tenant.Name, &resp.Tenant.Slug)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusUnauthorized, "account is disabled")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	resp.User.ID = userID
+	resp.Tenant.ID = resp.User.TenantID
+	resp.RecoveryCodes = recoveryCodes
+
+	resp.AccessToken, resp.RefreshToken, err = h.startSession(c, userID, resp.User.TenantID, resp.User.Email, resp.User.Role, true)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
+	}
+	resp.ExpiresIn = int(h.Config.JWTExpiry.Seconds())
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GetMyMFA returns the current user's MFA status.
+func (h *Handler) GetMyMFA(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var status MFAStatus
+	err := h.db(c).QueryRow(`
+		SELECT u.mfa_enabled_at IS NOT NULL,
+			(SELECT COUNT(*) FROM user_recovery_codes r WHERE r.user_id = u.id AND r.used_at IS NULL)
+		FROM users u WHERE u.id = $1
+	`, claims.UserID).Scan(&status.Enabled, &status.RecoveryCodesRemaining)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if status.Required, err = mfaRequired(h.db(c), claims.TenantID, claims.Role); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// EnrollMyMFA starts MFA enrolment for the current user. It is completed with
+// ConfirmMyMFA.
+func (h *Handler) EnrollMyMFA(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	enrollment, err := h.beginMFAEnrollment(tx, claims.UserID)
+	if err == errMFAEnabled {
+		return echo.NewHTTPError(http.StatusConflict, "MFA is already enabled")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start MFA enrolment")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmMyMFA enables MFA with a code from the newly enrolled authenticator
+// and returns the recovery codes, which are shown only this once.
+func (h *Handler) ConfirmMyMFA(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	var req struct {
+		Code string `json:"code" validate:"required"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "code is required")
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	m, err := h.lockUserMFA(tx, claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if m.enabled {
+		return echo.NewHTTPError(http.StatusConflict, "MFA is already enabled")
+	}
+
+	ok, codes, err := h.checkSecondFactor(c, tx, claims.UserID, req.Code, "")
+	if err == errMFANotStarted {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+// RegenerateMyRecoveryCodes replaces the current user's recovery codes; it
+// takes a current TOTP code.
+func (h *Handler) RegenerateMyRecoveryCodes(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	var req struct {
+		Code string `json:"code" validate:"required"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "code is required")
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	m, err := h.lockUserMFA(tx, claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !m.enabled {
+		return echo.NewHTTPError(http.StatusConflict, "MFA is not enabled")
+	}
+	ok, _, err := h.checkSecondFactor(c, tx, claims.UserID, req.Code, "")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code")
+	}
+	codes, err := replaceRecoveryCodes(tx, claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+// DisableMyMFA turns MFA off for the current user with a TOTP or recovery
+// code, unless their tenant requires it for their role.
+func (h *Handler) DisableMyMFA(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	var req struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "code or recovery_code is required")
+	}
+
+	required, err := mfaRequired(h.db(c), claims.TenantID, claims.Role)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if required {
+		return echo.NewHTTPError(http.StatusConflict, "MFA is required for your role in this tenant")
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	m, err := h.lockUserMFA(tx, claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !m.enabled {
+		return echo.NewHTTPError(http.StatusConflict, "MFA is not enabled")
+	}
+	ok, _, err := h.checkSecondFactor(c, tx, claims.UserID, req.Code, req.RecoveryCode)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code")
+	}
+	if err := h.updateAccount(c, tx, claims.UserID, disableMFAQuery); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, claims.UserID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	log.Info().Str("user_id", claims.UserID).Msg("MFA disabled")
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+const disableMFAQuery = `
+	UPDATE users SET mfa_secret = NULL, mfa_enabled_at = NULL, mfa_last_counter = 0, updated_at = NOW() WHERE id = $1
+`
+
+// ResetUserMFA removes a user's authenticator and recovery codes, for a user
+// who lost both, and signs them out. They enrol again at their next login if
+// their role requires MFA.
+func (h *Handler) ResetUserMFA(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	id := c.Param("id")
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	// The authenticator belongs to the account, so only its home tenant can
+	// reset it
+	var exists bool
+	if err := tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2)
+	`, id, claims.TenantID).Scan(&exists); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+
+	before, err := auditSnapshot(c, tx, "user", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if _, err := tx.Exec(disableMFAQuery, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := revokeUserSessions(tx, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := recordAudit(c, tx, AuditUpdate, "user", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type SecuritySettings struct {
+	MFARequired bool `json:"mfa_required"`
+}
+
+// GetSecuritySettings returns the tenant's security settings.
+func (h *Handler) GetSecuritySettings(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var s SecuritySettings
+	if err := h.db(c).QueryRow(`SELECT mfa_required FROM tenants WHERE id = $1`, claims.TenantID).Scan(&s.MFARequired); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, s)
+}
+
+// UpdateSecuritySettings changes the tenant's security settings. Requiring MFA
+// signs out the ADMIN and MANAGER users who have not enabled it yet, so the
+// admin turning it on must have enabled it themselves.
+func (h *Handler) UpdateSecuritySettings(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req SecuritySettings
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "tenant", claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	var current bool
+	if err := tx.QueryRow(`SELECT mfa_required FROM tenants WHERE id = $1 FOR UPDATE`, claims.TenantID).Scan(&current); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if current == req.MFARequired {
+		return c.JSON(http.StatusOK, req)
+	}
+
+	if req.MFARequired {
+		var enabled bool
+		if err := tx.QueryRow(`SELECT mfa_enabled_at IS NOT NULL FROM users WHERE id = $1`, claims.UserID).Scan(&enabled); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if !enabled {
+			return echo.NewHTTPError(http.StatusConflict, "enable MFA on your own account before requiring it")
+		}
+		if _, err := tx.Exec(`
+			UPDATE user_sessions SET revoked_at = NOW(), revoke_reason = $3
+			WHERE revoked_at IS NULL AND tenant_id = $1 AND user_id IN (
+				SELECT u.id FROM `+memberTables+`
+				WHERE m.tenant_id = $1 AND m.role = ANY($2) AND u.mfa_enabled_at IS NULL
+			)
+		`, claims.TenantID, pq.Array(mfaRequiredRoles), SessionUserChange); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE tenants SET mfa_required = $1, updated_at = NOW() WHERE id = $2`, req.MFARequired, claims.TenantID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update security settings")
+	}
+	if err := recordAudit(c, tx, AuditUpdate, "tenant", claims.TenantID, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, req)
+}
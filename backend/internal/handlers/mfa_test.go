@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMFARequiredOnlyForPrivilegedRoles(t *testing.T) {
+	assert.True(t, isMFARequiredRole("ADMIN"))
+	assert.True(t, isMFARequiredRole("MANAGER"))
+	assert.False(t, isMFARequiredRole("CLERK"))
+
+	// No query is made for roles the setting does not cover or without a tenant
+	required, err := mfaRequired(nil, "t1", "CLERK")
+	assert.NoError(t, err)
+	assert.False(t, required)
+	required, err = mfaRequired(nil, "", "ADMIN")
+	assert.NoError(t, err)
+	assert.False(t, required)
+}
@@ -0,0 +1,752 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// OIDCProviderConfig is an OpenID Connect identity provider users can sign in
+// with. Providers without a tenant are global and available to every tenant.
+// The client secret is stored encrypted and never returned.
+type OIDCProviderConfig struct {
+	ID              string            `json:"id"`
+	TenantID        *string           `json:"tenant_id,omitempty"`
+	Slug            string            `json:"slug"`
+	DisplayName     string            `json:"display_name"`
+	DiscoveryURL    string            `json:"discovery_url"`
+	ClientID        string            `json:"client_id"`
+	HasClientSecret bool              `json:"has_client_secret"`
+	Scopes          []string          `json:"scopes"`
+	EmailClaim      string            `json:"email_claim"`
+	NameClaim       string            `json:"name_claim"`
+	GroupsClaim     string            `json:"groups_claim"`
+	GroupRoles      map[string]string `json:"group_roles"`
+	DefaultRole     *string           `json:"default_role,omitempty"`
+	IsActive        bool              `json:"is_active"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+
+	clientSecret string
+}
+
+var oidcSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,39}$`)
+
+// How long a sign-in started with OIDCAuthorize may take
+const oidcStateTTL = 10 * time.Minute
+
+const oidcProviderColumns = `p.id, p.tenant_id, p.slug, p.display_name, p.discovery_url, p.client_id, p.client_secret,
+	p.scopes, p.email_claim, p.name_claim, p.groups_claim, p.group_roles, p.default_role, p.is_active, p.created_at, p.updated_at`
+
+func scanOIDCProvider(row rowScanner) (OIDCProviderConfig, error) {
+	var p OIDCProviderConfig
+	var tenantID, defaultRole sql.NullString
+	var groupRoles []byte
+	if err := row.Scan(&p.ID, &tenantID, &p.Slug, &p.DisplayName, &p.DiscoveryURL, &p.ClientID, &p.clientSecret,
+		pq.Array(&p.Scopes), &p.EmailClaim, &p.NameClaim, &p.GroupsClaim, &groupRoles, &defaultRole,
+		&p.IsActive, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return p, err
+	}
+	if tenantID.Valid {
+		p.TenantID = &tenantID.String
+	}
+	if defaultRole.Valid {
+		p.DefaultRole = &defaultRole.String
+	}
+	p.GroupRoles = map[string]string{}
+	if len(groupRoles) > 0 {
+		if err := json.Unmarshal(groupRoles, &p.GroupRoles); err != nil {
+			return p, err
+		}
+	}
+	if p.Scopes == nil {
+		p.Scopes = []string{}
+	}
+	p.HasClientSecret = p.clientSecret != ""
+	return p, nil
+}
+
+// oidcClientConfig returns the provider's client configuration with the secret
+// decrypted.
+func (h *Handler) oidcClientConfig(p OIDCProviderConfig) (services.OIDCProvider, error) {
+	secret, err := services.OpenSecret(h.Config.MFAEncryptionKey, p.clientSecret)
+	if err != nil {
+		return services.OIDCProvider{}, err
+	}
+	return services.OIDCProvider{
+		DiscoveryURL: p.DiscoveryURL,
+		ClientID:     p.ClientID,
+		ClientSecret: secret,
+		Scopes:       p.Scopes,
+		EmailClaim:   p.EmailClaim,
+		NameClaim:    p.NameClaim,
+		GroupsClaim:  p.GroupsClaim,
+	}, nil
+}
+
+// oidcRole picks a user's role from their groups: the most senior role any of
+// them maps to. mapped is false when no group maps to a role.
+func oidcRole(groupRoles map[string]string, groups []string) (role string, mapped bool) {
+	var candidates []string
+	for _, g := range groups {
+		if r, ok := groupRoles[g]; ok {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if roleRank[candidates[i]] != roleRank[candidates[j]] {
+			return roleRank[candidates[i]] > roleRank[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+	return candidates[0], true
+}
+
+// oidcState travels through the identity provider with the sign-in and binds
+// its callback to the provider, redirect URI and nonce it was started with.
+type oidcState struct {
+	ProviderID  string `json:"pid"`
+	TenantSlug  string `json:"tenant,omitempty"`
+	RedirectURI string `json:"redirect_uri"`
+	Nonce       string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// oidcStateKey is distinct from the access token key so a state can never be
+// used as a token.
+func (h *Handler) oidcStateKey() []byte {
+	return []byte(h.Config.JWTSecret + ":oidc-state")
+}
+
+func randomNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// loginOIDCProvider finds the active provider with slug for a sign-in,
+// preferring the tenant's own provider over a global one.
+func (h *Handler) loginOIDCProvider(c echo.Context, slug, tenantSlug string) (OIDCProviderConfig, error) {
+	p, err := scanOIDCProvider(h.db(c).QueryRow(`
+		SELECT `+oidcProviderColumns+`
+		FROM oidc_providers p
+		LEFT JOIN tenants t ON t.id = p.tenant_id
+		WHERE p.slug = $1 AND p.is_active = true
+			AND (p.tenant_id IS NULL OR (t.slug = $2 AND t.is_active = true))
+		ORDER BY p.tenant_id NULLS LAST
+		LIMIT 1
+	`, slug, tenantSlug))
+	if err == sql.ErrNoRows {
+		return p, echo.NewHTTPError(http.StatusNotFound, "identity provider not found")
+	}
+	if err != nil {
+		return p, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return p, nil
+}
+
+// ListLoginOIDCProviders lists the identity providers shown on the login page:
+// the global ones and, with ?tenant_slug=, the tenant's.
+func (h *Handler) ListLoginOIDCProviders(c echo.Context) error {
+	rows, err := h.db(c).Query(`
+		SELECT p.slug, p.display_name, p.tenant_id IS NOT NULL
+		FROM oidc_providers p
+		LEFT JOIN tenants t ON t.id = p.tenant_id
+		WHERE p.is_active = true AND (p.tenant_id IS NULL OR (t.slug = $1 AND t.is_active = true))
+		ORDER BY p.display_name
+	`, c.QueryParam("tenant_slug"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer rows.Close()
+
+	type loginProvider struct {
+		Slug        string `json:"slug"`
+		DisplayName string `json:"display_name"`
+		Tenant      bool   `json:"tenant"`
+	}
+	providers := []loginProvider{}
+	for rows.Next() {
+		var p loginProvider
+		if err := rows.Scan(&p.Slug, &p.DisplayName, &p.Tenant); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		providers = append(providers, p)
+	}
+	if err := rows.Err(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, providers)
+}
+
+// OIDCAuthorize starts a sign-in with an identity provider
+// (?redirect_uri=&tenant_slug=) and returns the URL to send the user to. The
+// returned state must be passed back to OIDCCallback.
+func (h *Handler) OIDCAuthorize(c echo.Context) error {
+	redirectURI := c.QueryParam("redirect_uri")
+	if u, err := url.Parse(redirectURI); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "redirect_uri must be an absolute URL")
+	}
+	tenantSlug := c.QueryParam("tenant_slug")
+	p, err := h.loginOIDCProvider(c, c.Param("slug"), tenantSlug)
+	if err != nil {
+		return err
+	}
+	cfg, err := h.oidcClientConfig(p)
+	if err != nil {
+		log.Error().Err(err).Str("provider", p.Slug).Msg("Failed to decrypt OIDC client secret")
+		return echo.NewHTTPError(http.StatusInternalServerError, "identity provider misconfigured")
+	}
+
+	nonce, err := randomNonce()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start sign-in")
+	}
+	state, err := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcState{
+		ProviderID:  p.ID,
+		TenantSlug:  tenantSlug,
+		RedirectURI: redirectURI,
+		Nonce:       nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcStateTTL)),
+		},
+	}).SignedString(h.oidcStateKey())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start sign-in")
+	}
+
+	authURL, err := h.OIDC.AuthorizationURL(c.Request().Context(), cfg, redirectURI, state, nonce)
+	if err != nil {
+		log.Error().Err(err).Str("provider", p.Slug).Msg("OIDC discovery failed")
+		return echo.NewHTTPError(http.StatusBadGateway, "identity provider unavailable")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"authorization_url": authURL,
+		"state":             state,
+	})
+}
+
+// OIDCCallback completes a sign-in: it redeems the authorization code, maps the
+// identity to a user of the provider's tenant (or of the tenant chosen when
+// the sign-in started) and starts a session. New members are added with the
+// role their groups map to, or the provider's default role; existing users'
+// roles follow their groups on every sign-in.
+func (h *Handler) OIDCCallback(c echo.Context) error {
+	var req struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if err := c.Bind(&req); err != nil || req.Code == "" || req.State == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "code and state are required")
+	}
+
+	var state oidcState
+	if _, err := jwt.ParseWithClaims(req.State, &state, func(t *jwt.Token) (interface{}, error) {
+		return h.oidcStateKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired()); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired state")
+	}
+
+	p, err := scanOIDCProvider(h.db(c).QueryRow(`SELECT `+oidcProviderColumns+` FROM oidc_providers p WHERE p.id = $1 AND p.is_active = true`, state.ProviderID))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "identity provider not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	cfg, err := h.oidcClientConfig(p)
+	if err != nil {
+		log.Error().Err(err).Str("provider", p.Slug).Msg("Failed to decrypt OIDC client secret")
+		return echo.NewHTTPError(http.StatusInternalServerError, "identity provider misconfigured")
+	}
+
+	identity, err := h.OIDC.Exchange(c.Request().Context(), cfg, req.Code, state.RedirectURI, state.Nonce)
+	if err != nil {
+		log.Error().Err(err).Str("provider", p.Slug).Msg("OIDC code exchange failed")
+		return echo.NewHTTPError(http.StatusUnauthorized, "failed to authenticate with the identity provider")
+	}
+
+	// Resolve the tenant: the provider's own, or the one chosen at sign-in
+	var tenantID, tenantName, tenantSlug string
+	if p.TenantID != nil {
+		err = h.db(c).QueryRow(`SELECT id, name, slug FROM tenants WHERE id = $1 AND is_active = true`, *p.TenantID).Scan(&tenantID, &tenantName, &tenantSlug)
+	} else if state.TenantSlug != "" {
+		err = h.db(c).QueryRow(`SELECT id, name, slug FROM tenants WHERE slug = $1 AND is_active = true`, state.TenantSlug).Scan(&tenantID, &tenantName, &tenantSlug)
+	} else {
+		// Without a tenant only existing users can sign in
+		err = h.db(c).QueryRow(`
+			SELECT t.id, t.name, t.slug FROM `+memberTables+`
+			JOIN tenants t ON t.id = m.tenant_id
+			WHERE u.email = $1 AND m.is_active = true AND t.is_active = true
+			ORDER BY m.tenant_id IS NOT DISTINCT FROM u.tenant_id DESC, m.created_at ASC
+			LIMIT 1
+		`, identity.Email).Scan(&tenantID, &tenantName, &tenantSlug)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusForbidden, "no account for this identity; sign in through your organization's login page")
+		}
+	}
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "tenant not found or inactive")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	mappedRole, mapped := oidcRole(p.GroupRoles, identity.Groups)
+	if mapped {
+		ok, err := tenantRoleExists(h.db(c), tenantID, mappedRole)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if !ok {
+			log.Error().Str("provider", p.Slug).Str("role", mappedRole).Msg("OIDC group mapped to unknown role")
+			return echo.NewHTTPError(http.StatusForbidden, "identity provider maps to role "+mappedRole+", which this tenant does not have")
+		}
+	}
+	oauthProvider := "oidc:" + p.Slug
+
+	var userID, name, role string
+	var isActive, isNewUser bool
+	err = h.db(c).QueryRow(`SELECT id, name FROM users WHERE email = $1`, identity.Email).Scan(&userID, &name)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if exists {
+		err = h.db(c).QueryRow(`SELECT role, is_active FROM tenant_memberships WHERE user_id = $1 AND tenant_id = $2`,
+			userID, tenantID).Scan(&role, &isActive)
+		if err != nil && err != sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		// Global providers are not vouched for by the tenant, so only a
+		// verified email may sign in to an existing account
+		if p.TenantID == nil && !identity.EmailVerified {
+			return echo.NewHTTPError(http.StatusForbidden, "the identity provider has not verified this email address")
+		}
+	}
+	member := exists && err == nil
+
+	if !member {
+		role = mappedRole
+		if !mapped {
+			if p.DefaultRole == nil {
+				return echo.NewHTTPError(http.StatusForbidden, "your identity provider groups do not grant access to this tenant")
+			}
+			role = *p.DefaultRole
+		}
+		if !exists {
+			isNewUser, name = true, identity.Name
+			err = h.db(c).QueryRow(`
+				INSERT INTO users (email, name, role, tenant_id, oauth_provider, oauth_id, avatar_url, is_active, email_verified_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, true, CASE WHEN $8 THEN NOW() END)
+				RETURNING id
+			`, identity.Email, identity.Name, role, tenantID, oauthProvider, identity.Subject, nullIfEmpty(identity.Picture), identity.EmailVerified).Scan(&userID)
+			if err != nil {
+				log.Error().Err(err).Str("email", identity.Email).Msg("Failed to create OIDC user")
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user account")
+			}
+		}
+		if err := addMembership(h.db(c), userID, tenantID, role); err != nil {
+			log.Error().Err(err).Str("email", identity.Email).Msg("Failed to add OIDC user to tenant")
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user account")
+		}
+	} else {
+		if !isActive {
+			return echo.NewHTTPError(http.StatusUnauthorized, "user account is inactive")
+		}
+		if mapped && mappedRole != role {
+			role = mappedRole
+			if _, err := h.db(c).Exec(`
+				UPDATE tenant_memberships SET role = $3, updated_at = NOW() WHERE user_id = $1 AND tenant_id = $2
+			`, userID, tenantID, role); err != nil {
+				log.Error().Err(err).Str("user_id", userID).Msg("Failed to update OIDC user role")
+			} else if err := syncHomeMembership(h.db(c), userID, tenantID); err != nil {
+				log.Error().Err(err).Str("user_id", userID).Msg("Failed to update OIDC user role")
+			}
+		}
+	}
+	if exists {
+		if _, err := h.db(c).Exec(`
+			UPDATE users
+			SET oauth_provider = $1, oauth_id = $2, last_login = NOW(), updated_at = NOW()
+			WHERE id = $3
+		`, oauthProvider, identity.Subject, userID); err != nil {
+			log.Error().Err(err).Str("user_id", userID).Msg("Failed to update OIDC user")
+		}
+	}
+
+	challenge, err := h.mfaChallenge(c, userID, tenantID, role)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
+	}
+	if challenge != nil {
+		return c.JSON(http.StatusOK, challenge)
+	}
+
+	accessToken, refreshToken, err := h.startSession(c, userID, tenantID, identity.Email, role, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start session")
+	}
+
+	return c.JSON(http.StatusOK, GoogleOAuthResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.Config.JWTExpiry.Seconds()),
+		User: UserResponse{
+			ID:       userID,
+			Name:     name,
+			Email:    identity.Email,
+			Role:     role,
+			TenantID: tenantID,
+		},
+		Tenant:    &TenantResponse{ID: tenantID, Name: tenantName, Slug: tenantSlug},
+		IsNewUser: isNewUser,
+	})
+}
+
+type oidcProviderRequest struct {
+	Slug         string            `json:"slug"`
+	DisplayName  string            `json:"display_name"`
+	DiscoveryURL string            `json:"discovery_url"`
+	ClientID     string            `json:"client_id"`
+	ClientSecret string            `json:"client_secret"`
+	Scopes       []string          `json:"scopes"`
+	EmailClaim   string            `json:"email_claim"`
+	NameClaim    string            `json:"name_claim"`
+	GroupsClaim  string            `json:"groups_claim"`
+	GroupRoles   map[string]string `json:"group_roles"`
+	DefaultRole  *string           `json:"default_role"`
+	IsActive     *bool             `json:"is_active"`
+}
+
+// validate normalises the request. Roles must exist in the tenant and grant no
+// more than the caller holds, or be built-in roles for global providers. The discovery document is fetched to
+// catch a wrong URL before users try to sign in.
+func (h *Handler) validateOIDCProvider(c echo.Context, req *oidcProviderRequest, tenantID *string, creating bool) error {
+	req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+	if !oidcSlugPattern.MatchString(req.Slug) {
+		return echo.NewHTTPError(http.StatusBadRequest, "slug must be 2-40 lower-case letters, digits or dashes")
+	}
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	req.ClientID = strings.TrimSpace(req.ClientID)
+	if req.DisplayName == "" || req.ClientID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "display_name and client_id are required")
+	}
+	if creating && req.ClientSecret == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "client_secret is required")
+	}
+	if u, err := url.Parse(req.DiscoveryURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "discovery_url must be an absolute URL")
+	}
+	if _, err := h.OIDC.Discover(c.Request().Context(), req.DiscoveryURL); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "discovery_url: "+err.Error())
+	}
+	if req.Scopes == nil {
+		req.Scopes = []string{"email", "profile"}
+	}
+	req.EmailClaim = defaultString(strings.TrimSpace(req.EmailClaim), "email")
+	req.NameClaim = defaultString(strings.TrimSpace(req.NameClaim), "name")
+	req.GroupsClaim = defaultString(strings.TrimSpace(req.GroupsClaim), "groups")
+
+	checkRole := func(role string) error {
+		if tenantID == nil {
+			if !isUserRole(role) {
+				return echo.NewHTTPError(http.StatusBadRequest, "global providers can only map to ADMIN, MANAGER or CLERK")
+			}
+			return nil
+		}
+		// Mapping a group to a role hands the role out like assigning it does
+		claims, errClaims := appmw.GetUserClaims(c)
+		if errClaims != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+		return checkGrantableRole(h.db(c), claims, role)
+	}
+	roles := map[string]string{}
+	for group, role := range req.GroupRoles {
+		role = strings.ToUpper(strings.TrimSpace(role))
+		if err := checkRole(role); err != nil {
+			return err
+		}
+		roles[group] = role
+	}
+	req.GroupRoles = roles
+	if req.DefaultRole != nil {
+		role := strings.ToUpper(strings.TrimSpace(*req.DefaultRole))
+		if role == "" {
+			req.DefaultRole = nil
+		} else if err := checkRole(role); err != nil {
+			return err
+		} else {
+			req.DefaultRole = &role
+		}
+	}
+	return nil
+}
+
+func defaultString(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+func (h *Handler) listOIDCProviders(c echo.Context, tenantID *string) error {
+	rows, err := h.db(c).Query(`
+		SELECT `+oidcProviderColumns+` FROM oidc_providers p
+		WHERE p.tenant_id IS NOT DISTINCT FROM $1
+		ORDER BY p.display_name
+	`, tenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer rows.Close()
+	providers := []OIDCProviderConfig{}
+	for rows.Next() {
+		p, err := scanOIDCProvider(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		providers = append(providers, p)
+	}
+	if err := rows.Err(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, providers)
+}
+
+func (h *Handler) createOIDCProvider(c echo.Context, tenantID *string) error {
+	var req oidcProviderRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if err := h.validateOIDCProvider(c, &req, tenantID, true); err != nil {
+		return err
+	}
+	secret, err := services.SealSecret(h.Config.MFAEncryptionKey, req.ClientSecret)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to store client secret")
+	}
+	groupRoles, _ := json.Marshal(req.GroupRoles)
+	active := req.IsActive == nil || *req.IsActive
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow(`
+		INSERT INTO oidc_providers (tenant_id, slug, display_name, discovery_url, client_id, client_secret, scopes,
+			email_claim, name_claim, groups_claim, group_roles, default_role, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NOW(), NOW())
+		RETURNING id
+	`, tenantID, req.Slug, req.DisplayName, req.DiscoveryURL, req.ClientID, secret, pq.Array(req.Scopes),
+		req.EmailClaim, req.NameClaim, req.GroupsClaim, groupRoles, req.DefaultRole, active).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return echo.NewHTTPError(http.StatusConflict, "a provider with this slug already exists")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	// The audit trail is per tenant; global providers are not audited
+	if tenantID != nil {
+		if err := recordAudit(c, tx, AuditCreate, "oidc_provider", id, nil); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	p, err := scanOIDCProvider(tx.QueryRow(`SELECT `+oidcProviderColumns+` FROM oidc_providers p WHERE p.id = $1`, id))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusCreated, p)
+}
+
+// updateOIDCProvider replaces a provider's settings; an empty client_secret
+// keeps the current one.
+func (h *Handler) updateOIDCProvider(c echo.Context, tenantID *string) error {
+	id := c.Param("id")
+	var req oidcProviderRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if err := h.validateOIDCProvider(c, &req, tenantID, false); err != nil {
+		return err
+	}
+	var secret interface{}
+	if req.ClientSecret != "" {
+		sealed, err := services.SealSecret(h.Config.MFAEncryptionKey, req.ClientSecret)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to store client secret")
+		}
+		secret = sealed
+	}
+	groupRoles, _ := json.Marshal(req.GroupRoles)
+	active := req.IsActive == nil || *req.IsActive
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	var before json.RawMessage
+	if tenantID != nil {
+		if before, err = auditSnapshot(c, tx, "oidc_provider", id); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	res, err := tx.Exec(`
+		UPDATE oidc_providers
+		SET slug = $3, display_name = $4, discovery_url = $5, client_id = $6, client_secret = COALESCE($7, client_secret),
+			scopes = $8, email_claim = $9, name_claim = $10, groups_claim = $11, group_roles = $12, default_role = $13,
+			is_active = $14, updated_at = NOW()
+		WHERE id = $1 AND tenant_id IS NOT DISTINCT FROM $2
+	`, id, tenantID, req.Slug, req.DisplayName, req.DiscoveryURL, req.ClientID, secret, pq.Array(req.Scopes),
+		req.EmailClaim, req.NameClaim, req.GroupsClaim, groupRoles, req.DefaultRole, active)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return echo.NewHTTPError(http.StatusConflict, "a provider with this slug already exists")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "identity provider not found")
+	}
+	if tenantID != nil {
+		if err := recordAudit(c, tx, AuditUpdate, "oidc_provider", id, before); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	p, err := scanOIDCProvider(tx.QueryRow(`SELECT `+oidcProviderColumns+` FROM oidc_providers p WHERE p.id = $1`, id))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+func (h *Handler) deleteOIDCProvider(c echo.Context, tenantID *string) error {
+	id := c.Param("id")
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	var before json.RawMessage
+	if tenantID != nil {
+		if before, err = auditSnapshot(c, tx, "oidc_provider", id); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	res, err := tx.Exec(`DELETE FROM oidc_providers WHERE id = $1 AND tenant_id IS NOT DISTINCT FROM $2`, id, tenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "identity provider not found")
+	}
+	if tenantID != nil {
+		if err := recordAudit(c, tx, AuditDelete, "oidc_provider", id, before); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// claimsTenant returns the caller's tenant for tenant-scoped provider routes.
+func claimsTenant(c echo.Context) (*string, error) {
+	claims, err := appmw.GetUserClaims(c)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	return &claims.TenantID, nil
+}
+
+// ListOIDCProviders returns the tenant's identity providers.
+func (h *Handler) ListOIDCProviders(c echo.Context) error {
+	tenantID, err := claimsTenant(c)
+	if err != nil {
+		return err
+	}
+	return h.listOIDCProviders(c, tenantID)
+}
+
+// CreateOIDCProvider adds an identity provider to the tenant.
+func (h *Handler) CreateOIDCProvider(c echo.Context) error {
+	tenantID, err := claimsTenant(c)
+	if err != nil {
+		return err
+	}
+	return h.createOIDCProvider(c, tenantID)
+}
+
+// UpdateOIDCProvider changes one of the tenant's identity providers.
+func (h *Handler) UpdateOIDCProvider(c echo.Context) error {
+	tenantID, err := claimsTenant(c)
+	if err != nil {
+		return err
+	}
+	return h.updateOIDCProvider(c, tenantID)
+}
+
+// DeleteOIDCProvider removes one of the tenant's identity providers. Users who
+// signed in with it keep their accounts.
+func (h *Handler) DeleteOIDCProvider(c echo.Context) error {
+	tenantID, err := claimsTenant(c)
+	if err != nil {
+		return err
+	}
+	return h.deleteOIDCProvider(c, tenantID)
+}
+
+// ListGlobalOIDCProviders returns the identity providers shared by all tenants.
+func (h *Handler) ListGlobalOIDCProviders(c echo.Context) error {
+	return h.listOIDCProviders(c, nil)
+}
+
+// CreateGlobalOIDCProvider adds an identity provider shared by all tenants.
+func (h *Handler) CreateGlobalOIDCProvider(c echo.Context) error {
+	return h.createOIDCProvider(c, nil)
+}
+
+// UpdateGlobalOIDCProvider changes a shared identity provider.
+func (h *Handler) UpdateGlobalOIDCProvider(c echo.Context) error {
+	return h.updateOIDCProvider(c, nil)
+}
+
+// DeleteGlobalOIDCProvider removes a shared identity provider.
+func (h *Handler) DeleteGlobalOIDCProvider(c echo.Context) error {
+	return h.deleteOIDCProvider(c, nil)
+}
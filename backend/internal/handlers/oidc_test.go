@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOIDCRole(t *testing.T) {
+	groupRoles := map[string]string{
+		"inventory-clerks":   "CLERK",
+		"inventory-managers": "MANAGER",
+		"buyers":             "BUYER",
+	}
+
+	role, mapped := oidcRole(groupRoles, []string{"staff", "inventory-clerks", "inventory-managers"})
+	assert.True(t, mapped)
+	assert.Equal(t, "MANAGER", role, "most senior built-in role wins")
+
+	role, mapped = oidcRole(groupRoles, []string{"buyers", "inventory-clerks"})
+	assert.True(t, mapped)
+	assert.Equal(t, "CLERK", role, "built-in roles outrank custom ones")
+
+	role, mapped = oidcRole(groupRoles, []string{"buyers"})
+	assert.True(t, mapped)
+	assert.Equal(t, "BUYER", role)
+
+	_, mapped = oidcRole(groupRoles, []string{"staff"})
+	assert.False(t, mapped)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"inventory/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type SavePlanRequest struct {
+	Name                string          `json:"name" validate:"required,min=1,max=255"`
+	MaxUsers            *int64          `json:"max_users" validate:"omitempty,min=0"`
+	MaxLocations        *int64          `json:"max_locations" validate:"omitempty,min=0"`
+	MaxItems            *int64          `json:"max_items" validate:"omitempty,min=0"`
+	MaxAPIRequestsMonth *int64          `json:"max_api_requests_month" validate:"omitempty,min=0"`
+	Features            map[string]bool `json:"features"`
+}
+
+type AssignPlanRequest struct {
+	// Plan code; empty takes the tenant off any plan
+	Plan string `json:"plan"`
+}
+
+// checkQuota answers QUOTA_EXCEEDED when the tenant's plan allows no more of
+// resource. Call it in the transaction that creates the resource.
+func checkQuota(c echo.Context, tx *sql.Tx, tenantID, resource string) error {
+	err := services.NewTenantService(tx).CheckQuota(c.Request().Context(), tenantID, resource)
+	var quotaErr *services.QuotaError
+	if errors.As(err, &quotaErr) {
+		return echo.NewHTTPError(http.StatusForbidden, ErrorResponse{Error: ErrorDetail{
+			Code:    "QUOTA_EXCEEDED",
+			Message: quotaErr.Error(),
+			Details: map[string]interface{}{
+				"resource": quotaErr.Resource,
+				"plan":     quotaErr.Plan,
+				"limit":    quotaErr.Limit,
+				"used":     quotaErr.Used,
+			},
+		}})
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return nil
+}
+
+// ListPlans returns every plan (system admin only)
+func (h *Handler) ListPlans(c echo.Context) error {
+	plans, err := services.NewTenantService(h.DB).ListPlans(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": plans,
+	})
+}
+
+// SavePlan creates or replaces the plan with the code in the path. Omitted
+// limits are unlimited.
+func (h *Handler) SavePlan(c echo.Context) error {
+	var req SavePlanRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	plan, err := services.NewTenantService(h.DB).SavePlan(c.Request().Context(), &services.Plan{
+		Code:                c.Param("code"),
+		Name:                req.Name,
+		MaxUsers:            req.MaxUsers,
+		MaxLocations:        req.MaxLocations,
+		MaxItems:            req.MaxItems,
+		MaxAPIRequestsMonth: req.MaxAPIRequestsMonth,
+		Features:            req.Features,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": plan,
+	})
+}
+
+// AssignTenantPlan puts a tenant on a plan
+func (h *Handler) AssignTenantPlan(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant ID")
+	}
+	var req AssignPlanRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "tenant", id.String())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load tenant")
+	}
+
+	tenant, err := services.NewTenantService(tx).AssignPlan(c.Request().Context(), id, req.Plan)
+	switch {
+	case errors.Is(err, services.ErrTenantNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrPlanNotFound):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if err := recordAudit(c, tx, AuditUpdate, "tenant", id.String(), before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": tenant,
+	})
+}
+
+// GetTenantUsage reports a tenant's usage this month against its plan
+func (h *Handler) GetTenantUsage(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant ID")
+	}
+
+	usage, err := services.NewTenantService(h.DB).GetUsage(c.Request().Context(), id)
+	if errors.Is(err, services.ErrTenantNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	// Requests counted since the meter last wrote to the database
+	if h.Usage != nil {
+		usage.APIRequests.Used += h.Usage.Pending(id.String())
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": usage,
+	})
+}
@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	appmw "inventory/internal/middleware"
@@ -49,6 +51,7 @@ type PurchaseOrderLine struct {
 	Item        *Item           `json:"item,omitempty"`
 	QtyOrdered  int             `json:"qty_ordered"`
 	QtyReceived int             `json:"qty_received"`
+	QtyReturned int             `json:"qty_returned"`
 	UnitCost    decimal.Decimal `json:"unit_cost"`
 	Tax         interface{}     `json:"tax,omitempty"`
 	LineTotal   decimal.Decimal `json:"line_total"`
@@ -66,7 +69,7 @@ type CreatePurchaseOrderRequest struct {
 type CreatePurchaseOrderLineRequest struct {
 	ItemID     string      `json:"item_id" validate:"required"`
 	QtyOrdered int         `json:"qty_ordered" validate:"required,min=1"`
-	UnitCost   string      `json:"unit_cost" validate:"required"`
+	UnitCost   string      `json:"unit_cost"` // defaults to the supplier's active price when empty
 	Tax        interface{} `json:"tax,omitempty"`
 }
 
@@ -81,7 +84,7 @@ type UpdatePurchaseOrderLineRequest struct {
 	ID         *string     `json:"id,omitempty"`
 	ItemID     string      `json:"item_id" validate:"required"`
 	QtyOrdered int         `json:"qty_ordered" validate:"required,min=1"`
-	UnitCost   string      `json:"unit_cost" validate:"required"`
+	UnitCost   string      `json:"unit_cost"` // defaults to the supplier's active price when empty
 	Tax        interface{} `json:"tax,omitempty"`
 }
 
@@ -167,7 +170,7 @@ func (h *Handler) ListPurchaseOrders(c echo.Context) error {
 	query += fmt.Sprintf(" OFFSET $%d", argCount)
 	args = append(args, offset)
 
-	rows, err := h.DB.Query(query, args...)
+	rows, err := h.db(c).Query(query, args...)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -248,7 +251,7 @@ func (h *Handler) ListPurchaseOrders(c echo.Context) error {
 	}
 
 	var total int
-	err = h.DB.QueryRow(countQuery, countArgs...).Scan(&total)
+	err = h.db(c).QueryRow(countQuery, countArgs...).Scan(&total)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -279,11 +282,11 @@ func (h *Handler) CreatePurchaseOrder(c echo.Context) error {
 
 	// Generate PO number
 	var maxNumber int
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT COALESCE(MAX(CAST(SUBSTRING(number FROM 'PO-([0-9]+)') AS INTEGER)), 0)
 		FROM purchase_orders 
-		WHERE number ~ '^PO-[0-9]+$'
-	`).Scan(&maxNumber)
+		WHERE number ~ '^PO-[0-9]+$' AND tenant_id = $1
+	`, claims.TenantID).Scan(&maxNumber)
 	if err != nil && err != sql.ErrNoRows {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -291,7 +294,7 @@ func (h *Handler) CreatePurchaseOrder(c echo.Context) error {
 	poNumber := fmt.Sprintf("PO-%06d", maxNumber+1)
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -321,16 +324,25 @@ func (h *Handler) CreatePurchaseOrder(c echo.Context) error {
 	var lines []PurchaseOrderLine
 	for _, lineReq := range req.Lines {
 		lineID := uuid.New().String()
-		// Convert string unit cost to decimal for resolveOrCreateItem
-		unitCostDecimal, err := decimal.NewFromString(lineReq.UnitCost)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid unit cost: %s", lineReq.UnitCost))
+		// Convert an explicit unit cost to decimal for resolveOrCreateItem
+		var providedCost *decimal.Decimal
+		if lineReq.UnitCost != "" {
+			cost, err := decimal.NewFromString(lineReq.UnitCost)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid unit cost: %s", lineReq.UnitCost))
+			}
+			providedCost = &cost
 		}
 		// Resolve or create item by provided identifier (UUID or SKU)
-		resolvedItemID, resErr := h.resolveOrCreateItem(tx, lineReq.ItemID, &unitCostDecimal, claims.TenantID)
+		resolvedItemID, resErr := h.resolveOrCreateItem(tx, lineReq.ItemID, providedCost, claims.TenantID)
 		if resErr != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, resErr.Error())
 		}
+		// Fall back to the supplier's price list when no unit cost was given
+		unitCostDecimal, err := purchaseLineUnitCost(tx, claims.TenantID, req.SupplierID, resolvedItemID, lineReq.QtyOrdered, lineReq.UnitCost)
+		if err != nil {
+			return err
+		}
 		// Ensure proper types for DB: numeric and jsonb
 		unitCostStr := unitCostDecimal.StringFixed(2)
 		var taxJSON *string
@@ -366,6 +378,10 @@ func (h *Handler) CreatePurchaseOrder(c echo.Context) error {
 		})
 	}
 
+	if err := recordAudit(c, tx, AuditCreate, "purchase_order", poID, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
@@ -406,7 +422,7 @@ func (h *Handler) GetPurchaseOrder(c echo.Context) error {
 	var approvedAt sql.NullTime
 	var notes sql.NullString
 
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT 
 			po.id, po.number, po.status, po.supplier_id, po.created_by,
 			po.approved_by, po.expected_at, po.approved_at, po.notes,
@@ -449,9 +465,9 @@ func (h *Handler) GetPurchaseOrder(c echo.Context) error {
 	}
 
 	// Get purchase order lines
-	rows, err := h.DB.Query(`
+	rows, err := h.db(c).Query(`
 		SELECT 
-			pol.id, pol.item_id, pol.qty_ordered, pol.qty_received, 
+			pol.id, pol.item_id, pol.qty_ordered, pol.qty_received, pol.qty_returned,
 			pol.unit_cost, pol.tax, pol.created_at, pol.updated_at,
 			i.sku, i.name as item_name
 		FROM purchase_order_lines pol
@@ -473,7 +489,7 @@ func (h *Handler) GetPurchaseOrder(c echo.Context) error {
 		var itemSKU, itemName sql.NullString
 
 		err := rows.Scan(
-			&line.ID, &line.ItemID, &line.QtyOrdered, &line.QtyReceived,
+			&line.ID, &line.ItemID, &line.QtyOrdered, &line.QtyReceived, &line.QtyReturned,
 			&unitCostStr, &line.Tax, &line.CreatedAt, &line.UpdatedAt,
 			&itemSKU, &itemName,
 		)
@@ -520,7 +536,7 @@ func (h *Handler) UpdatePurchaseOrder(c echo.Context) error {
 
 	// Check if purchase order exists and is in DRAFT status
 	var currentStatus string
-	err := h.DB.QueryRow("SELECT status FROM purchase_orders WHERE id = $1", id).Scan(&currentStatus)
+	err := h.db(c).QueryRow("SELECT status FROM purchase_orders WHERE id = $1 AND tenant_id = $2", id, claims.TenantID).Scan(&currentStatus)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "Purchase order not found")
@@ -533,12 +549,17 @@ func (h *Handler) UpdatePurchaseOrder(c echo.Context) error {
 	}
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
 	defer tx.Rollback()
 
+	before, err := auditSnapshot(c, tx, "purchase_order", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Parse expected_at date if provided
 	var expectedAt *time.Time
 	if req.ExpectedAt != nil && *req.ExpectedAt != "" {
@@ -553,12 +574,17 @@ func (h *Handler) UpdatePurchaseOrder(c echo.Context) error {
 	_, err = tx.Exec(`
 		UPDATE purchase_orders 
 		SET supplier_id = $1, expected_at = $2, notes = $3, updated_at = NOW()
-		WHERE id = $4
-	`, req.SupplierID, expectedAt, req.Notes, id)
+		WHERE id = $4 AND tenant_id = $5
+	`, req.SupplierID, expectedAt, req.Notes, id, claims.TenantID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update purchase order")
 	}
 
+	// Approvals were given for the old version
+	if err := supersedeApprovals(tx, ApprovalPurchaseOrder, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Delete existing lines
 	_, err = tx.Exec("DELETE FROM purchase_order_lines WHERE purchase_order_id = $1", id)
 	if err != nil {
@@ -569,15 +595,23 @@ func (h *Handler) UpdatePurchaseOrder(c echo.Context) error {
 	var lines []PurchaseOrderLine
 	for _, lineReq := range req.Lines {
 		lineID := uuid.New().String()
-		// Convert string unit cost to decimal for resolveOrCreateItem
-		unitCostDecimal, err := decimal.NewFromString(lineReq.UnitCost)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid unit cost: %s", lineReq.UnitCost))
+		// Convert an explicit unit cost to decimal for resolveOrCreateItem
+		var providedCost *decimal.Decimal
+		if lineReq.UnitCost != "" {
+			cost, err := decimal.NewFromString(lineReq.UnitCost)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid unit cost: %s", lineReq.UnitCost))
+			}
+			providedCost = &cost
 		}
-		resolvedItemID, resErr := h.resolveOrCreateItem(tx, lineReq.ItemID, &unitCostDecimal, claims.TenantID)
+		resolvedItemID, resErr := h.resolveOrCreateItem(tx, lineReq.ItemID, providedCost, claims.TenantID)
 		if resErr != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, resErr.Error())
 		}
+		unitCostDecimal, err := purchaseLineUnitCost(tx, claims.TenantID, req.SupplierID, resolvedItemID, lineReq.QtyOrdered, lineReq.UnitCost)
+		if err != nil {
+			return err
+		}
 		unitCostStr := unitCostDecimal.StringFixed(2)
 		var taxJSON *string
 		if lineReq.Tax != nil {
@@ -611,6 +645,10 @@ func (h *Handler) UpdatePurchaseOrder(c echo.Context) error {
 		})
 	}
 
+	if err := recordAudit(c, tx, AuditUpdate, "purchase_order", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
@@ -672,6 +710,8 @@ func (h *Handler) resolveOrCreateItem(tx *sql.Tx, provided string, unitCost *dec
 	return newID, nil
 }
 
+// ApprovePurchaseOrder records the caller's approval of a draft purchase order
+// and approves it once the tenant's approval rules are met.
 func (h *Handler) ApprovePurchaseOrder(c echo.Context) error {
 	id := c.Param("id")
 	claims, errClaims := appmw.GetUserClaims(c)
@@ -680,9 +720,16 @@ func (h *Handler) ApprovePurchaseOrder(c echo.Context) error {
 	}
 	userID := claims.UserID
 
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
 	// Check if purchase order exists and is in DRAFT status
 	var currentStatus string
-	err := h.DB.QueryRow("SELECT status FROM purchase_orders WHERE id = $1", id).Scan(&currentStatus)
+	var createdBy sql.NullString
+	err = tx.QueryRow("SELECT status, created_by FROM purchase_orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE", id, claims.TenantID).Scan(&currentStatus, &createdBy)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "Purchase order not found")
@@ -694,23 +741,41 @@ func (h *Handler) ApprovePurchaseOrder(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Can only approve purchase orders in DRAFT status")
 	}
 
-	// Update status to APPROVED
-	_, err = h.DB.Exec(`
-		UPDATE purchase_orders 
-		SET status = 'APPROVED', approved_by = $1, approved_at = NOW(), updated_at = NOW()
-		WHERE id = $2
-	`, userID, id)
+	before, err := auditSnapshot(c, tx, "purchase_order", id)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to approve purchase order")
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"message": "Purchase order approved successfully",
-	})
+	state, err := recordApproval(c, tx, ApprovalPurchaseOrder, id, createdBy)
+	if err != nil {
+		return err
+	}
+
+	// Update status to APPROVED once enough approvals are in
+	if state.complete() {
+		_, err = tx.Exec(`
+			UPDATE purchase_orders 
+			SET status = 'APPROVED', approved_by = $1, approved_at = NOW(), updated_at = NOW()
+			WHERE id = $2
+		`, userID, id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to approve purchase order")
+		}
+	}
+
+	if err := recordAudit(c, tx, AuditApprove, "purchase_order", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return approvalResponse(c, state, "Purchase order approved successfully")
 }
 
 type ReceiveItemsRequest struct {
-	Lines []ReceiveLineRequest `json:"lines" validate:"required"`
+	LocationID string               `json:"location_id"`
+	Lines      []ReceiveLineRequest `json:"lines" validate:"required"`
 }
 
 type ReceiveLineRequest struct {
@@ -720,15 +785,22 @@ type ReceiveLineRequest struct {
 
 func (h *Handler) ReceivePurchaseOrder(c echo.Context) error {
 	id := c.Param("id")
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
 
 	var req ReceiveItemsRequest
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
 	}
+	if err := checkLocationAccess(c, req.LocationID); err != nil {
+		return err
+	}
 
 	// Check if purchase order exists and is in APPROVED status
 	var currentStatus string
-	err := h.DB.QueryRow("SELECT status FROM purchase_orders WHERE id = $1", id).Scan(&currentStatus)
+	err := h.db(c).QueryRow("SELECT status FROM purchase_orders WHERE id = $1 AND tenant_id = $2", id, claims.TenantID).Scan(&currentStatus)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "Purchase order not found")
@@ -741,22 +813,39 @@ func (h *Handler) ReceivePurchaseOrder(c echo.Context) error {
 	}
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
 	defer tx.Rollback()
 
+	before, err := auditSnapshot(c, tx, "purchase_order", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	locationID, err := receiveLocation(c, tx, claims.TenantID, req.LocationID)
+	if err != nil {
+		return err
+	}
+
+	costingMethod, err := tenantCostingMethod(tx, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Update received quantities
 	for _, lineReq := range req.Lines {
 		// Get current line info
 		var qtyOrdered, currentQtyReceived int
 		var itemID string
+		var unitCost decimal.Decimal
 		err := tx.QueryRow(`
-			SELECT qty_ordered, qty_received, item_id 
+			SELECT qty_ordered, qty_received, item_id, unit_cost
 			FROM purchase_order_lines 
 			WHERE id = $1 AND purchase_order_id = $2
-		`, lineReq.LineID, id).Scan(&qtyOrdered, &currentQtyReceived, &itemID)
+			FOR UPDATE
+		`, lineReq.LineID, id).Scan(&qtyOrdered, &currentQtyReceived, &itemID, &unitCost)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusNotFound, "Purchase order line not found")
 		}
@@ -776,33 +865,78 @@ func (h *Handler) ReceivePurchaseOrder(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update line")
 		}
 
-		// Update inventory levels (this would normally go through inventory service)
-		// For now, we'll just create a stock movement record
-		if lineReq.QtyReceived > 0 {
-			_, err = tx.Exec(`
-				INSERT INTO stock_movements (id, item_id, movement_type, quantity, reference_type, reference_id, created_at)
-				VALUES ($1, $2, 'IN', $3, 'PURCHASE_ORDER', $4, NOW())
-			`, uuid.New().String(), itemID, lineReq.QtyReceived, id)
-			if err != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create stock movement")
-			}
+		if lineReq.QtyReceived <= 0 {
+			continue
+		}
+
+		// Create stock movement record and update the item's costing, as
+		// posting a goods receipt does
+		_, err = receiveStock(tx, costingMethod, stockMove{
+			TenantID:     claims.TenantID,
+			ItemID:       itemID,
+			LocationID:   locationID,
+			UserID:       claims.UserID,
+			Qty:          lineReq.QtyReceived,
+			Reason:       "PO_RECEIPT",
+			Reference:    "Purchase order",
+			RefID:        id,
+			SourceLineID: lineReq.LineID,
+		}, unitCost)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create stock movement")
+		}
+
+		// Update inventory levels
+		_, err = tx.Exec(`
+			INSERT INTO inventory_levels (id, tenant_id, item_id, location_id, on_hand, allocated, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, 0, NOW(), NOW())
+			ON CONFLICT (item_id, location_id)
+			DO UPDATE SET
+				on_hand = inventory_levels.on_hand + $5,
+				updated_at = NOW()
+		`, uuid.New().String(), claims.TenantID, itemID, locationID, lineReq.QtyReceived)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update inventory levels")
 		}
 	}
 
-	// Check if all lines are fully received
+	// Update purchase order status from the received quantities
+	newStatus, err := refreshPurchaseOrderStatus(tx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update purchase order status")
+	}
+
+	if err := recordAudit(c, tx, AuditReceive, "purchase_order", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	// Commit transaction
+	if err = tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	h.invalidateDashboard(claims.TenantID)
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Items received successfully",
+		"status":  newStatus,
+	})
+}
+
+// refreshPurchaseOrderStatus sets the status of an approved purchase order to
+// APPROVED, PARTIAL or RECEIVED depending on how many lines are fully received.
+func refreshPurchaseOrderStatus(tx *sql.Tx, poID string) (string, error) {
 	var totalLines, fullyReceivedLines int
-	err = tx.QueryRow(`
+	err := tx.QueryRow(`
 		SELECT 
 			COUNT(*) as total_lines,
 			COUNT(CASE WHEN qty_ordered = qty_received THEN 1 END) as fully_received_lines
 		FROM purchase_order_lines 
 		WHERE purchase_order_id = $1
-	`, id).Scan(&totalLines, &fullyReceivedLines)
+	`, poID).Scan(&totalLines, &fullyReceivedLines)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		return "", err
 	}
 
-	// Update purchase order status
 	var newStatus string
 	if fullyReceivedLines == totalLines {
 		newStatus = "RECEIVED"
@@ -816,28 +950,355 @@ func (h *Handler) ReceivePurchaseOrder(c echo.Context) error {
 		UPDATE purchase_orders 
 		SET status = $1, updated_at = NOW()
 		WHERE id = $2
-	`, newStatus, id)
+	`, newStatus, poID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update purchase order status")
+		return "", err
+	}
+	return newStatus, nil
+}
+
+// applyReceiptToPurchaseOrder adds the quantities of a posted receipt to the
+// received quantities of its purchase order lines, filling lines for the same
+// item in order and never exceeding the ordered quantity.
+func applyReceiptToPurchaseOrder(tx *sql.Tx, receiptID, poID string) error {
+	var poStatus string
+	if err := tx.QueryRow(`SELECT status FROM purchase_orders WHERE id = $1`, poID).Scan(&poStatus); err != nil {
+		return err
+	}
+	if poStatus != "APPROVED" && poStatus != "PARTIAL" {
+		return nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT item_id, SUM(qty)
+		FROM goods_receipt_lines
+		WHERE receipt_id = $1
+		GROUP BY item_id
+	`, receiptID)
+	if err != nil {
+		return err
+	}
+	received := map[string]int{}
+	for rows.Next() {
+		var itemID string
+		var qty int
+		if err := rows.Scan(&itemID, &qty); err != nil {
+			rows.Close()
+			return err
+		}
+		received[itemID] = qty
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	type openLine struct {
+		id        string
+		itemID    string
+		remaining int
+	}
+	rows, err = tx.Query(`
+		SELECT id, item_id, qty_ordered - qty_received
+		FROM purchase_order_lines
+		WHERE purchase_order_id = $1 AND qty_received < qty_ordered
+		ORDER BY created_at, id
+	`, poID)
+	if err != nil {
+		return err
+	}
+	var lines []openLine
+	for rows.Next() {
+		var l openLine
+		if err := rows.Scan(&l.id, &l.itemID, &l.remaining); err != nil {
+			rows.Close()
+			return err
+		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range lines {
+		qty := received[l.itemID]
+		if qty <= 0 {
+			continue
+		}
+		if qty > l.remaining {
+			qty = l.remaining
+		}
+		if _, err := tx.Exec(`
+			UPDATE purchase_order_lines
+			SET qty_received = qty_received + $1, updated_at = NOW()
+			WHERE id = $2
+		`, qty, l.id); err != nil {
+			return err
+		}
+		received[l.itemID] -= qty
+	}
+
+	_, err = refreshPurchaseOrderStatus(tx, poID)
+	return err
+}
+
+type ReturnItemsRequest struct {
+	// Location the goods are sent back from; may be omitted when the order was
+	// received into a single location
+	LocationID string              `json:"location_id"`
+	Lines      []ReturnLineRequest `json:"lines" validate:"required"`
+}
+
+type ReturnLineRequest struct {
+	LineID      string `json:"line_id" validate:"required"`
+	QtyReturned int    `json:"qty_returned" validate:"required,min=1"`
+}
+
+// ReturnPurchaseOrder records quantities sent back to the supplier against
+// received purchase order lines and issues them from the location the order was
+// received into. Returns feed the supplier scorecard.
+func (h *Handler) ReturnPurchaseOrder(c echo.Context) error {
+	id := c.Param("id")
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req ReturnItemsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if len(req.Lines) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "At least one line is required")
+	}
+
+	var currentStatus string
+	err := h.db(c).QueryRow("SELECT status FROM purchase_orders WHERE id = $1 AND tenant_id = $2", id, claims.TenantID).Scan(&currentStatus)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "Purchase order not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	if currentStatus == "DRAFT" || currentStatus == "CANCELED" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Can only return items for received purchase orders")
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "purchase_order", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	locationID, err := returnLocation(tx, id, claims.TenantID, req.LocationID)
+	if err != nil {
+		return err
+	}
+	if err := checkLocationAccess(c, locationID); err != nil {
+		return err
+	}
+
+	costingMethod, err := tenantCostingMethod(tx, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	for _, lineReq := range req.Lines {
+		if lineReq.QtyReturned <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "qty_returned must be positive")
+		}
+
+		var qtyReceived, qtyReturned int
+		var itemID string
+		err := tx.QueryRow(`
+			SELECT qty_received, qty_returned, item_id
+			FROM purchase_order_lines
+			WHERE id = $1 AND purchase_order_id = $2
+			FOR UPDATE
+		`, lineReq.LineID, id).Scan(&qtyReceived, &qtyReturned, &itemID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Purchase order line not found")
+		}
+
+		if qtyReturned+lineReq.QtyReturned > qtyReceived {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Cannot return more items than received for line %s", lineReq.LineID))
+		}
+
+		_, err = tx.Exec(`
+			UPDATE purchase_order_lines
+			SET qty_returned = qty_returned + $1, updated_at = NOW()
+			WHERE id = $2
+		`, lineReq.QtyReturned, lineReq.LineID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update line")
+		}
+
+		var onHand int
+		err = tx.QueryRow(`
+			SELECT on_hand FROM inventory_levels WHERE item_id = $1 AND location_id = $2 AND tenant_id = $3 FOR UPDATE
+		`, itemID, locationID, claims.TenantID).Scan(&onHand)
+		if err != nil && err != sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if onHand < lineReq.QtyReturned {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Not enough stock at the location to return line %s", lineReq.LineID))
+		}
+
+		// Issue the returned goods from stock
+		_, err = issueStock(tx, costingMethod, stockMove{
+			TenantID:   claims.TenantID,
+			ItemID:     itemID,
+			LocationID: locationID,
+			UserID:     claims.UserID,
+			Qty:        lineReq.QtyReturned,
+			Reason:     "PO_RETURN",
+			Reference:  "Purchase order return",
+			RefID:      id,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create stock movement")
+		}
+
+		_, err = tx.Exec(`
+			UPDATE inventory_levels SET on_hand = on_hand - $1, updated_at = NOW()
+			WHERE item_id = $2 AND location_id = $3 AND tenant_id = $4
+		`, lineReq.QtyReturned, itemID, locationID, claims.TenantID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update inventory levels")
+		}
+	}
+
+	if err := recordAudit(c, tx, AuditReturn, "purchase_order", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
 
-	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
+	h.invalidateDashboard(claims.TenantID)
 
 	return c.JSON(http.StatusOK, map[string]string{
-		"message": "Items received successfully",
-		"status":  newStatus,
+		"message": "Returns recorded successfully",
 	})
 }
 
+// receiveLocation picks the location a purchase order is received into: the
+// requested one, which must be an active location of the tenant, or the
+// tenant's only active location the user may access when none is requested.
+func receiveLocation(c echo.Context, tx *sql.Tx, tenantID, requested string) (string, error) {
+	if requested != "" {
+		var exists bool
+		err := tx.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM locations WHERE id = $1 AND tenant_id = $2 AND is_active = true)
+		`, requested, tenantID).Scan(&exists)
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to validate location")
+		}
+		if !exists {
+			return "", echo.NewHTTPError(http.StatusBadRequest, "Invalid location")
+		}
+		return requested, nil
+	}
+
+	rows, err := tx.Query(`SELECT id FROM locations WHERE tenant_id = $1 AND is_active = true ORDER BY id`, tenantID)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to validate location")
+	}
+	defer rows.Close()
+
+	locations := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to validate location")
+		}
+		locations = append(locations, id)
+	}
+	if err := rows.Err(); err != nil {
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to validate location")
+	}
+	return defaultReceiveLocation(c.Request().Context(), locations)
+}
+
+// defaultReceiveLocation returns the only one of the tenant's active locations
+// the user may access.
+func defaultReceiveLocation(ctx context.Context, locations []string) (string, error) {
+	allowed := []string{}
+	for _, id := range locations {
+		if appmw.LocationAllowed(ctx, id) {
+			allowed = append(allowed, id)
+		}
+	}
+	switch len(allowed) {
+	case 0:
+		return "", echo.NewHTTPError(http.StatusBadRequest, "No active location to receive into")
+	case 1:
+		return allowed[0], nil
+	}
+	return "", echo.NewHTTPError(http.StatusBadRequest, "location_id is required: there are several locations to receive into")
+}
+
+// returnLocation picks the location a purchase order return is issued from:
+// the requested one, which must be a location the order was received into, or
+// the only such location when none is requested.
+func returnLocation(tx *sql.Tx, poID, tenantID, requested string) (string, error) {
+	rows, err := tx.Query(`
+		SELECT DISTINCT m.location_id
+		FROM stock_movements m
+		WHERE m.tenant_id = $2 AND m.reason = 'PO_RECEIPT'
+		  AND (m.ref_id = $1 OR m.ref_id IN (SELECT id FROM goods_receipts WHERE purchase_order_id = $1 AND tenant_id = $2))
+		ORDER BY m.location_id
+	`, poID, tenantID)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer rows.Close()
+
+	locations := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return "", echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		locations = append(locations, id)
+	}
+	if err := rows.Err(); err != nil {
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	switch {
+	case len(locations) == 0:
+		return "", echo.NewHTTPError(http.StatusBadRequest, "Purchase order has no received stock to return")
+	case requested != "":
+		for _, id := range locations {
+			if strings.EqualFold(id, requested) {
+				return id, nil
+			}
+		}
+		return "", echo.NewHTTPError(http.StatusBadRequest, "Purchase order was not received into location "+requested)
+	case len(locations) > 1:
+		return "", echo.NewHTTPError(http.StatusBadRequest, "location_id is required: the purchase order was received into several locations")
+	}
+	return locations[0], nil
+}
+
 func (h *Handler) ClosePurchaseOrder(c echo.Context) error {
 	id := c.Param("id")
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
 
 	// Check if purchase order exists and can be closed
 	var currentStatus string
-	err := h.DB.QueryRow("SELECT status FROM purchase_orders WHERE id = $1", id).Scan(&currentStatus)
+	err := h.db(c).QueryRow("SELECT status FROM purchase_orders WHERE id = $1 AND tenant_id = $2", id, claims.TenantID).Scan(&currentStatus)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "Purchase order not found")
@@ -850,15 +1311,33 @@ func (h *Handler) ClosePurchaseOrder(c echo.Context) error {
 	}
 
 	// Update status to CLOSED
-	_, err = h.DB.Exec(`
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "purchase_order", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	_, err = tx.Exec(`
 		UPDATE purchase_orders 
 		SET status = 'CLOSED', updated_at = NOW()
-		WHERE id = $1
-	`, id)
+		WHERE id = $1 AND tenant_id = $2
+	`, id, claims.TenantID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to close purchase order")
 	}
 
+	if err := recordAudit(c, tx, AuditClose, "purchase_order", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "Purchase order closed successfully",
 	})
@@ -866,10 +1345,14 @@ func (h *Handler) ClosePurchaseOrder(c echo.Context) error {
 
 func (h *Handler) DeletePurchaseOrder(c echo.Context) error {
 	id := c.Param("id")
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
 
 	// Check if purchase order exists and is in DRAFT status
 	var currentStatus string
-	err := h.DB.QueryRow("SELECT status FROM purchase_orders WHERE id = $1", id).Scan(&currentStatus)
+	err := h.db(c).QueryRow("SELECT status FROM purchase_orders WHERE id = $1 AND tenant_id = $2", id, claims.TenantID).Scan(&currentStatus)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "Purchase order not found")
@@ -882,12 +1365,17 @@ func (h *Handler) DeletePurchaseOrder(c echo.Context) error {
 	}
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
 	defer tx.Rollback()
 
+	before, err := auditSnapshot(c, tx, "purchase_order", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Delete purchase order lines first
 	_, err = tx.Exec("DELETE FROM purchase_order_lines WHERE purchase_order_id = $1", id)
 	if err != nil {
@@ -895,11 +1383,15 @@ func (h *Handler) DeletePurchaseOrder(c echo.Context) error {
 	}
 
 	// Delete purchase order
-	_, err = tx.Exec("DELETE FROM purchase_orders WHERE id = $1", id)
+	_, err = tx.Exec("DELETE FROM purchase_orders WHERE id = $1 AND tenant_id = $2", id, claims.TenantID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete purchase order")
 	}
 
+	if err := recordAudit(c, tx, AuditDelete, "purchase_order", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
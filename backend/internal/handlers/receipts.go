@@ -12,28 +12,30 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
 )
 
 type GoodsReceipt struct {
 	ID         string             `json:"id"`
 	Number     string             `json:"number"`
-	SupplierID *string            `json:"supplier_id,omitempty"`
-	Supplier   *Supplier          `json:"supplier,omitempty"`
-	LocationID *string            `json:"location_id,omitempty"`
-	Location   *Location          `json:"location,omitempty"`
-	Status     string             `json:"status"`
-	Reference  *string            `json:"reference,omitempty"`
-	Notes      *string            `json:"notes,omitempty"`
-	CreatedBy  *string            `json:"created_by,omitempty"`
-	ApprovedBy *string            `json:"approved_by,omitempty"`
-	PostedBy   *string            `json:"posted_by,omitempty"`
-	ApprovedAt *time.Time         `json:"approved_at,omitempty"`
-	PostedAt   *time.Time         `json:"posted_at,omitempty"`
-	Lines      []GoodsReceiptLine `json:"lines,omitempty"`
-	Total      decimal.Decimal    `json:"total"`
-	CreatedAt  time.Time          `json:"created_at"`
-	UpdatedAt  time.Time          `json:"updated_at"`
+	SupplierID      *string            `json:"supplier_id,omitempty"`
+	Supplier        *Supplier          `json:"supplier,omitempty"`
+	LocationID      *string            `json:"location_id,omitempty"`
+	Location        *Location          `json:"location,omitempty"`
+	PurchaseOrderID *string            `json:"purchase_order_id,omitempty"`
+	Status          string             `json:"status"`
+	Reference       *string            `json:"reference,omitempty"`
+	Notes           *string            `json:"notes,omitempty"`
+	CreatedBy       *string            `json:"created_by,omitempty"`
+	ApprovedBy      *string            `json:"approved_by,omitempty"`
+	PostedBy        *string            `json:"posted_by,omitempty"`
+	ApprovedAt      *time.Time         `json:"approved_at,omitempty"`
+	PostedAt        *time.Time         `json:"posted_at,omitempty"`
+	Lines           []GoodsReceiptLine `json:"lines,omitempty"`
+	Total           decimal.Decimal    `json:"total"`
+	CreatedAt       time.Time          `json:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at"`
 }
 
 type Location struct {
@@ -50,10 +52,18 @@ type GoodsReceiptLine struct {
 	ItemID    string          `json:"item_id"`
 	Item      *Item           `json:"item,omitempty"`
 	Qty       int             `json:"qty"`
-	UnitCost  decimal.Decimal `json:"unit_cost"`
-	LineTotal decimal.Decimal `json:"line_total"`
-	CreatedAt time.Time       `json:"created_at"`
-	UpdatedAt time.Time       `json:"updated_at"`
+	UnitCost       decimal.Decimal  `json:"unit_cost"`
+	LandedCost     decimal.Decimal  `json:"landed_cost"`
+	PostedUnitCost *decimal.Decimal `json:"posted_unit_cost,omitempty"`
+	LineTotal      decimal.Decimal  `json:"line_total"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+}
+
+// checkReceiptLocation rejects access to a receipt at a location the caller is
+// not assigned to.
+func checkReceiptLocation(c echo.Context, q queryRower, id, tenantID string) error {
+	return checkDocumentLocation(c, q, `SELECT location_id FROM goods_receipts WHERE id = $1 AND tenant_id = $2`, id, tenantID)
 }
 
 func (h *Handler) ListReceipts(c echo.Context) error {
@@ -78,6 +88,9 @@ func (h *Handler) ListReceipts(c echo.Context) error {
 	status := c.QueryParam("status")
 	supplierID := c.QueryParam("supplier_id")
 	locationID := c.QueryParam("location_id")
+	if err := checkLocationAccess(c, locationID); err != nil {
+		return err
+	}
 	sort := c.QueryParam("sort")
 	if sort == "" {
 		sort = "created_at DESC"
@@ -127,6 +140,13 @@ func (h *Handler) ListReceipts(c echo.Context) error {
 		args = append(args, locationID)
 	}
 
+	allowed, restricted := appmw.GetAllowedLocations(c.Request().Context())
+	if restricted {
+		argCount++
+		query += fmt.Sprintf(" AND (gr.location_id IS NULL OR gr.location_id = ANY($%d))", argCount)
+		args = append(args, pq.Array(allowed))
+	}
+
 	query += " GROUP BY gr.id, s.name, l.name, l.code"
 
 	// Add sorting
@@ -154,7 +174,7 @@ func (h *Handler) ListReceipts(c echo.Context) error {
 	query += fmt.Sprintf(" OFFSET $%d", argCount)
 	args = append(args, offset)
 
-	rows, err := h.DB.Query(query, args...)
+	rows, err := h.db(c).Query(query, args...)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -255,8 +275,14 @@ func (h *Handler) ListReceipts(c echo.Context) error {
 		countArgs = append(countArgs, locationID)
 	}
 
+	if restricted {
+		countArgCount++
+		countQuery += fmt.Sprintf(" AND (gr.location_id IS NULL OR gr.location_id = ANY($%d))", countArgCount)
+		countArgs = append(countArgs, pq.Array(allowed))
+	}
+
 	var total int
-	err = h.DB.QueryRow(countQuery, countArgs...).Scan(&total)
+	err = h.db(c).QueryRow(countQuery, countArgs...).Scan(&total)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -298,22 +324,40 @@ func (h *Handler) CreateReceipt(c echo.Context) error {
 	}
 	userID := claims.UserID
 	tenantID := claims.TenantID
+	if req.LocationID != nil {
+		if err := checkLocationAccess(c, *req.LocationID); err != nil {
+			return err
+		}
+	}
 
 	// Generate receipt number
 	var maxNumber int
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT COALESCE(MAX(CAST(SUBSTRING(number FROM 'GR-([0-9]+)') AS INTEGER)), 0)
 		FROM goods_receipts 
-		WHERE number ~ '^GR-[0-9]+$'
-	`).Scan(&maxNumber)
+		WHERE number ~ '^GR-[0-9]+$' AND tenant_id = $1
+	`, tenantID).Scan(&maxNumber)
 	if err != nil && err != sql.ErrNoRows {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
 
 	grNumber := fmt.Sprintf("GR-%06d", maxNumber+1)
 
+	// A linked purchase order must belong to the tenant
+	if req.PurchaseOrderID != nil && strings.TrimSpace(*req.PurchaseOrderID) != "" {
+		var poExists bool
+		if err := h.db(c).QueryRow(`SELECT EXISTS(SELECT 1 FROM purchase_orders WHERE id = $1 AND tenant_id = $2)`, *req.PurchaseOrderID, tenantID).Scan(&poExists); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if !poExists {
+			return echo.NewHTTPError(http.StatusBadRequest, "purchase order not found")
+		}
+	} else {
+		req.PurchaseOrderID = nil
+	}
+
 	// Start transaction for receipt and lines creation
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -322,9 +366,9 @@ func (h *Handler) CreateReceipt(c echo.Context) error {
 	// Create receipt
 	grID := uuid.New().String()
 	_, err = tx.Exec(`
-		INSERT INTO goods_receipts (id, number, status, supplier_id, location_id, reference, notes, tenant_id, created_by, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW())
-	`, grID, grNumber, "DRAFT", req.SupplierID, req.LocationID, req.Reference, req.Notes, claims.TenantID, userID)
+		INSERT INTO goods_receipts (id, number, status, supplier_id, location_id, purchase_order_id, reference, notes, tenant_id, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), NOW())
+	`, grID, grNumber, "DRAFT", req.SupplierID, req.LocationID, req.PurchaseOrderID, req.Reference, req.Notes, claims.TenantID, userID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create receipt")
 	}
@@ -378,6 +422,10 @@ func (h *Handler) CreateReceipt(c echo.Context) error {
 		}
 	}
 
+	if err := recordAudit(c, tx, AuditCreate, "receipt", grID, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
@@ -388,14 +436,15 @@ func (h *Handler) CreateReceipt(c echo.Context) error {
 		ID:         grID,
 		Number:     grNumber,
 		Status:     "DRAFT",
-		SupplierID: req.SupplierID,
-		LocationID: req.LocationID,
-		Reference:  req.Reference,
-		Notes:      req.Notes,
-		CreatedBy:  &userID,
-		Total:      total,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		SupplierID:      req.SupplierID,
+		LocationID:      req.LocationID,
+		PurchaseOrderID: req.PurchaseOrderID,
+		Reference:       req.Reference,
+		Notes:           req.Notes,
+		CreatedBy:       &userID,
+		Total:           total,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
 	}
 
 	return c.JSON(http.StatusCreated, gr)
@@ -410,6 +459,9 @@ func (h *Handler) UpdateReceipt(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
 	tenantID := claims.TenantID
+	if err := checkReceiptLocation(c, h.db(c), id, claims.TenantID); err != nil {
+		return err
+	}
 
 	var req struct {
 		SupplierID *string `json:"supplier_id"`
@@ -436,6 +488,9 @@ func (h *Handler) UpdateReceipt(c echo.Context) error {
 		i++
 	}
 	if req.LocationID != nil {
+		if err := checkLocationAccess(c, *req.LocationID); err != nil {
+			return err
+		}
 		sets = append(sets, fmt.Sprintf("location_id = $%d", i))
 		args = append(args, *req.LocationID)
 		i++
@@ -461,6 +516,17 @@ func (h *Handler) UpdateReceipt(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "no fields to update")
 	}
 
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "receipt", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Declare variables outside conditional blocks
 	var out GoodsReceipt
 	var supplierID, locationID, reference, notes sql.NullString
@@ -471,7 +537,7 @@ func (h *Handler) UpdateReceipt(c echo.Context) error {
 		args = append(args, id)
 
 		query := fmt.Sprintf(`UPDATE goods_receipts SET %s WHERE id = $%d AND tenant_id = $%d RETURNING id, number, supplier_id, location_id, status, reference, notes, created_at, updated_at`, strings.Join(sets, ", "), i, i+1)
-		if err := h.DB.QueryRow(query, append(args, tenantID)...).Scan(&out.ID, &out.Number, &supplierID, &locationID, &out.Status, &reference, &notes, &out.CreatedAt, &out.UpdatedAt); err != nil {
+		if err := tx.QueryRow(query, append(args, tenantID)...).Scan(&out.ID, &out.Number, &supplierID, &locationID, &out.Status, &reference, &notes, &out.CreatedAt, &out.UpdatedAt); err != nil {
 			if err == sql.ErrNoRows {
 				return echo.NewHTTPError(http.StatusNotFound, "receipt not found")
 			}
@@ -480,13 +546,13 @@ func (h *Handler) UpdateReceipt(c echo.Context) error {
 	} else {
 		// If only lines are being updated, we need to get the current receipt data
 		// and update the updated_at timestamp
-		_, err := h.DB.Exec(`UPDATE goods_receipts SET updated_at = NOW() WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+		_, err := tx.Exec(`UPDATE goods_receipts SET updated_at = NOW() WHERE id = $1 AND tenant_id = $2`, id, tenantID)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update receipt timestamp")
 		}
 
 		// Get current receipt data for response
-		if err := h.DB.QueryRow(`SELECT id, number, supplier_id, location_id, status, reference, notes, created_at, updated_at FROM goods_receipts WHERE id = $1 AND tenant_id = $2`, id, tenantID).Scan(&out.ID, &out.Number, &supplierID, &locationID, &out.Status, &reference, &notes, &out.CreatedAt, &out.UpdatedAt); err != nil {
+		if err := tx.QueryRow(`SELECT id, number, supplier_id, location_id, status, reference, notes, created_at, updated_at FROM goods_receipts WHERE id = $1 AND tenant_id = $2`, id, tenantID).Scan(&out.ID, &out.Number, &supplierID, &locationID, &out.Status, &reference, &notes, &out.CreatedAt, &out.UpdatedAt); err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get receipt data")
 		}
 	}
@@ -507,15 +573,8 @@ func (h *Handler) UpdateReceipt(c echo.Context) error {
 
 	// Handle lines update if provided
 	if req.Lines != nil {
-		// Start transaction for lines update
-		tx, err := h.DB.Begin()
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
-		}
-		defer tx.Rollback()
-
 		// Delete existing lines
-		_, err = tx.Exec(`DELETE FROM goods_receipt_lines WHERE receipt_id = $1`, id)
+		_, err := tx.Exec(`DELETE FROM goods_receipt_lines WHERE receipt_id = $1`, id)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete existing lines")
 		}
@@ -567,15 +626,17 @@ func (h *Handler) UpdateReceipt(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update receipt total")
 		}
 
-		// Commit transaction
-		if err = tx.Commit(); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
-		}
-
 		// Update the returned receipt with new total
 		out.Total = total
 	}
 
+	if err := recordAudit(c, tx, AuditUpdate, "receipt", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	return c.JSON(http.StatusOK, out)
 }
 
@@ -588,8 +649,22 @@ func (h *Handler) DeleteReceipt(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
 	tenantID := claims.TenantID
+	if err := checkReceiptLocation(c, h.db(c), id, claims.TenantID); err != nil {
+		return err
+	}
 
-	res, err := h.DB.Exec(`DELETE FROM goods_receipts WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "receipt", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	res, err := tx.Exec(`DELETE FROM goods_receipts WHERE id = $1 AND tenant_id = $2`, id, tenantID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusConflict, "cannot delete receipt")
 	}
@@ -597,6 +672,12 @@ func (h *Handler) DeleteReceipt(c echo.Context) error {
 	if n == 0 {
 		return echo.NewHTTPError(http.StatusNotFound, "receipt not found")
 	}
+	if err := recordAudit(c, tx, AuditDelete, "receipt", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -622,11 +703,14 @@ func (h *Handler) CreateReceiptFromPO(c echo.Context) error {
 	if (strings.TrimSpace(req.PurchaseOrderNumber) == "" && strings.TrimSpace(req.PurchaseOrderID) == "") || strings.TrimSpace(req.LocationID) == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "purchase_order_number or purchase_order_id and location_id are required")
 	}
+	if err := checkLocationAccess(c, strings.TrimSpace(req.LocationID)); err != nil {
+		return err
+	}
 
 	// Resolve PO id by number if provided
 	poID := strings.TrimSpace(req.PurchaseOrderID)
 	if poID == "" {
-		if err := h.DB.QueryRow(`SELECT id FROM purchase_orders WHERE number = $1 AND tenant_id = $2`, strings.TrimSpace(req.PurchaseOrderNumber), tenantID).Scan(&poID); err != nil {
+		if err := h.db(c).QueryRow(`SELECT id FROM purchase_orders WHERE number = $1 AND tenant_id = $2`, strings.TrimSpace(req.PurchaseOrderNumber), tenantID).Scan(&poID); err != nil {
 			if err == sql.ErrNoRows {
 				return echo.NewHTTPError(http.StatusNotFound, "purchase order not found")
 			}
@@ -636,7 +720,7 @@ func (h *Handler) CreateReceiptFromPO(c echo.Context) error {
 
 	// Load PO header
 	var supplierID sql.NullString
-	if err := h.DB.QueryRow(`SELECT supplier_id FROM purchase_orders WHERE id = $1 AND tenant_id = $2`, poID, tenantID).Scan(&supplierID); err != nil {
+	if err := h.db(c).QueryRow(`SELECT supplier_id FROM purchase_orders WHERE id = $1 AND tenant_id = $2`, poID, tenantID).Scan(&supplierID); err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "purchase order not found")
 		}
@@ -644,7 +728,7 @@ func (h *Handler) CreateReceiptFromPO(c echo.Context) error {
 	}
 
 	// Load remaining lines
-	rows, err := h.DB.Query(`
+	rows, err := h.db(c).Query(`
         SELECT item_id, GREATEST(qty_ordered - qty_received, 0) AS remaining, unit_cost
         FROM purchase_order_lines
         WHERE purchase_order_id = $1`, poID)
@@ -673,16 +757,22 @@ func (h *Handler) CreateReceiptFromPO(c echo.Context) error {
 
 	// Create receipt header
 	var maxNumber int
-	_ = h.DB.QueryRow(`SELECT COALESCE(MAX(CAST(SUBSTRING(number FROM 'GR-([0-9]+)') AS INTEGER)), 0) FROM goods_receipts WHERE number ~ '^GR-[0-9]+$' AND tenant_id = $1`, tenantID).Scan(&maxNumber)
+	_ = h.db(c).QueryRow(`SELECT COALESCE(MAX(CAST(SUBSTRING(number FROM 'GR-([0-9]+)') AS INTEGER)), 0) FROM goods_receipts WHERE number ~ '^GR-[0-9]+$' AND tenant_id = $1`, tenantID).Scan(&maxNumber)
 	number := fmt.Sprintf("GR-%06d", maxNumber+1)
 	id := uuid.New().String()
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
 	var out GoodsReceipt
 	var supplierOut, locationOut, reference, notes sql.NullString
-	if err := h.DB.QueryRow(`
-        INSERT INTO goods_receipts (id, number, supplier_id, location_id, status, reference, notes, tenant_id, created_at, updated_at)
-        VALUES ($1, $2, $3, $4, 'DRAFT', $5, $6, $7, NOW(), NOW())
+	if err := tx.QueryRow(`
+        INSERT INTO goods_receipts (id, number, supplier_id, location_id, purchase_order_id, status, reference, notes, tenant_id, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, 'DRAFT', $6, $7, $8, NOW(), NOW())
         RETURNING id, number, supplier_id, location_id, status, reference, notes, created_at, updated_at
-    `, id, number, supplierID, req.LocationID, req.Reference, req.Notes, tenantID).Scan(&out.ID, &out.Number, &supplierOut, &locationOut, &out.Status, &reference, &notes, &out.CreatedAt, &out.UpdatedAt); err != nil {
+    `, id, number, supplierID, req.LocationID, poID, req.Reference, req.Notes, tenantID).Scan(&out.ID, &out.Number, &supplierOut, &locationOut, &out.Status, &reference, &notes, &out.CreatedAt, &out.UpdatedAt); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
 	if supplierOut.Valid {
@@ -691,6 +781,7 @@ func (h *Handler) CreateReceiptFromPO(c echo.Context) error {
 	if locationOut.Valid {
 		out.LocationID = &locationOut.String
 	}
+	out.PurchaseOrderID = &poID
 	if reference.Valid {
 		out.Reference = &reference.String
 	}
@@ -700,7 +791,7 @@ func (h *Handler) CreateReceiptFromPO(c echo.Context) error {
 
 	// Insert lines
 	for _, r := range pols {
-		if _, err := h.DB.Exec(`
+		if _, err := tx.Exec(`
             INSERT INTO goods_receipt_lines (id, receipt_id, item_id, qty, unit_cost, created_at, updated_at)
             VALUES ($1, $2, $3, $4, $5::numeric, NOW(), NOW())
         `, uuid.New().String(), id, r.itemID, r.remaining, r.unitCost); err != nil {
@@ -708,6 +799,13 @@ func (h *Handler) CreateReceiptFromPO(c echo.Context) error {
 		}
 	}
 
+	if err := recordAudit(c, tx, AuditCreate, "receipt", id, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
 	return c.JSON(http.StatusCreated, out)
 }
 
@@ -720,10 +818,13 @@ func (h *Handler) ListReceiptLines(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
 	tenantID := claims.TenantID
+	if err := checkReceiptLocation(c, h.db(c), receiptID, claims.TenantID); err != nil {
+		return err
+	}
 
 	// Verify receipt belongs to tenant
 	var receiptExists bool
-	err := h.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM goods_receipts WHERE id = $1 AND tenant_id = $2)`, receiptID, tenantID).Scan(&receiptExists)
+	err := h.db(c).QueryRow(`SELECT EXISTS(SELECT 1 FROM goods_receipts WHERE id = $1 AND tenant_id = $2)`, receiptID, tenantID).Scan(&receiptExists)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -731,7 +832,7 @@ func (h *Handler) ListReceiptLines(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "Receipt not found")
 	}
 
-	rows, err := h.DB.Query(`
+	rows, err := h.db(c).Query(`
 		SELECT 
 			grl.id, grl.receipt_id, grl.item_id, grl.qty, grl.unit_cost, 
 			grl.created_at, grl.updated_at,
@@ -774,6 +875,9 @@ func (h *Handler) AddReceiptLine(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
 	tenantID := claims.TenantID
+	if err := checkReceiptLocation(c, h.db(c), receiptID, claims.TenantID); err != nil {
+		return err
+	}
 
 	var req struct {
 		ItemID   string `json:"item_id"`
@@ -788,12 +892,17 @@ func (h *Handler) AddReceiptLine(c echo.Context) error {
 	}
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
 	defer tx.Rollback()
 
+	before, err := auditSnapshot(c, tx, "receipt", receiptID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Verify receipt belongs to tenant
 	var receiptExists bool
 	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM goods_receipts WHERE id = $1 AND tenant_id = $2)`, receiptID, tenantID).Scan(&receiptExists)
@@ -834,6 +943,10 @@ func (h *Handler) AddReceiptLine(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
 
+	if err := recordAudit(c, tx, AuditUpdate, "receipt", receiptID, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
@@ -852,6 +965,9 @@ func (h *Handler) UpdateReceiptLine(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
 	tenantID := claims.TenantID
+	if err := checkReceiptLocation(c, h.db(c), receiptID, claims.TenantID); err != nil {
+		return err
+	}
 
 	var req struct {
 		Qty      *int    `json:"qty"`
@@ -863,7 +979,7 @@ func (h *Handler) UpdateReceiptLine(c echo.Context) error {
 
 	// Verify receipt belongs to tenant
 	var receiptExists bool
-	err := h.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM goods_receipts WHERE id = $1 AND tenant_id = $2)`, receiptID, tenantID).Scan(&receiptExists)
+	err := h.db(c).QueryRow(`SELECT EXISTS(SELECT 1 FROM goods_receipts WHERE id = $1 AND tenant_id = $2)`, receiptID, tenantID).Scan(&receiptExists)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -890,13 +1006,31 @@ func (h *Handler) UpdateReceiptLine(c echo.Context) error {
 	sets = append(sets, "updated_at = NOW()")
 	args = append(args, lineID, receiptID)
 	query := fmt.Sprintf(`UPDATE goods_receipt_lines SET %s WHERE id = $%d AND receipt_id = $%d RETURNING id, receipt_id, item_id, qty, unit_cost, created_at, updated_at`, strings.Join(sets, ", "), i, i+1)
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "receipt", receiptID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	var out GoodsReceiptLine
-	if err := h.DB.QueryRow(query, args...).Scan(&out.ID, &out.ReceiptID, &out.ItemID, &out.Qty, &out.UnitCost, &out.CreatedAt, &out.UpdatedAt); err != nil {
+	if err := tx.QueryRow(query, args...).Scan(&out.ID, &out.ReceiptID, &out.ItemID, &out.Qty, &out.UnitCost, &out.CreatedAt, &out.UpdatedAt); err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "line not found")
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
+
+	if err := recordAudit(c, tx, AuditUpdate, "receipt", receiptID, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
 	return c.JSON(http.StatusOK, out)
 }
 
@@ -910,10 +1044,13 @@ func (h *Handler) DeleteReceiptLine(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
 	tenantID := claims.TenantID
+	if err := checkReceiptLocation(c, h.db(c), receiptID, claims.TenantID); err != nil {
+		return err
+	}
 
 	// Verify receipt belongs to tenant
 	var receiptExists bool
-	err := h.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM goods_receipts WHERE id = $1 AND tenant_id = $2)`, receiptID, tenantID).Scan(&receiptExists)
+	err := h.db(c).QueryRow(`SELECT EXISTS(SELECT 1 FROM goods_receipts WHERE id = $1 AND tenant_id = $2)`, receiptID, tenantID).Scan(&receiptExists)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -921,7 +1058,18 @@ func (h *Handler) DeleteReceiptLine(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "Receipt not found")
 	}
 
-	res, err := h.DB.Exec(`DELETE FROM goods_receipt_lines WHERE id = $1 AND receipt_id = $2`, lineID, receiptID)
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "receipt", receiptID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	res, err := tx.Exec(`DELETE FROM goods_receipt_lines WHERE id = $1 AND receipt_id = $2`, lineID, receiptID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusConflict, "cannot delete line")
 	}
@@ -929,6 +1077,12 @@ func (h *Handler) DeleteReceiptLine(c echo.Context) error {
 	if n == 0 {
 		return echo.NewHTTPError(http.StatusNotFound, "line not found")
 	}
+	if err := recordAudit(c, tx, AuditUpdate, "receipt", receiptID, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -942,6 +1096,9 @@ func (h *Handler) GetReceipt(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
 	tenantID := claims.TenantID
+	if err := checkReceiptLocation(c, h.db(c), id, claims.TenantID); err != nil {
+		return err
+	}
 
 	// Get receipt header
 	var gr GoodsReceipt
@@ -950,9 +1107,9 @@ func (h *Handler) GetReceipt(c echo.Context) error {
 	var approvedAt, postedAt sql.NullTime
 	var reference, notes sql.NullString
 
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT 
-			gr.id, gr.number, gr.status, gr.supplier_id, gr.location_id, gr.created_by,
+			gr.id, gr.number, gr.status, gr.supplier_id, gr.location_id, gr.purchase_order_id, gr.created_by,
 			gr.approved_by, gr.posted_by, gr.approved_at, gr.posted_at, gr.reference, gr.notes,
 			gr.created_at, gr.updated_at,
 			s.name as supplier_name,
@@ -962,7 +1119,7 @@ func (h *Handler) GetReceipt(c echo.Context) error {
 		LEFT JOIN locations l ON gr.location_id = l.id
 		WHERE gr.id = $1 AND gr.tenant_id = $2
 	`, id, tenantID).Scan(
-		&gr.ID, &gr.Number, &gr.Status, &gr.SupplierID, &gr.LocationID, &gr.CreatedBy,
+		&gr.ID, &gr.Number, &gr.Status, &gr.SupplierID, &gr.LocationID, &gr.PurchaseOrderID, &gr.CreatedBy,
 		&approvedBy, &postedBy, &approvedAt, &postedAt, &reference, &notes,
 		&gr.CreatedAt, &gr.UpdatedAt, &supplierName, &locationName, &locationCode,
 	)
@@ -1010,9 +1167,9 @@ func (h *Handler) GetReceipt(c echo.Context) error {
 	}
 
 	// Get receipt lines
-	rows, err := h.DB.Query(`
+	rows, err := h.db(c).Query(`
 		SELECT 
-			grl.id, grl.item_id, grl.qty, grl.unit_cost, 
+			grl.id, grl.item_id, grl.qty, grl.unit_cost, grl.landed_cost, grl.posted_unit_cost,
 			grl.created_at, grl.updated_at,
 			i.sku, i.name as item_name
 		FROM goods_receipt_lines grl
@@ -1034,7 +1191,7 @@ func (h *Handler) GetReceipt(c echo.Context) error {
 		var itemSKU, itemName sql.NullString
 
 		err := rows.Scan(
-			&line.ID, &line.ItemID, &line.Qty, &unitCostStr,
+			&line.ID, &line.ItemID, &line.Qty, &unitCostStr, &line.LandedCost, &line.PostedUnitCost,
 			&line.CreatedAt, &line.UpdatedAt,
 			&itemSKU, &itemName,
 		)
@@ -1074,10 +1231,13 @@ func (h *Handler) ApproveReceipt(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
 	userID := claims.UserID
+	if err := checkReceiptLocation(c, h.db(c), id, claims.TenantID); err != nil {
+		return err
+	}
 
 	// Check if receipt exists and is in DRAFT status
 	var currentStatus string
-	err := h.DB.QueryRow("SELECT status FROM goods_receipts WHERE id = $1 AND tenant_id = $2", id, claims.TenantID).Scan(&currentStatus)
+	err := h.db(c).QueryRow("SELECT status FROM goods_receipts WHERE id = $1 AND tenant_id = $2", id, claims.TenantID).Scan(&currentStatus)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "Receipt not found")
@@ -1090,7 +1250,18 @@ func (h *Handler) ApproveReceipt(c echo.Context) error {
 	}
 
 	// Update status to APPROVED
-	_, err = h.DB.Exec(`
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "receipt", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	_, err = tx.Exec(`
 		UPDATE goods_receipts 
 		SET status = 'APPROVED', approved_by = $1, approved_at = NOW(), updated_at = NOW()
 		WHERE id = $2 AND tenant_id = $3
@@ -1099,6 +1270,13 @@ func (h *Handler) ApproveReceipt(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to approve receipt")
 	}
 
+	if err := recordAudit(c, tx, AuditApprove, "receipt", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "Receipt approved successfully",
 	})
@@ -1112,11 +1290,14 @@ func (h *Handler) PostReceipt(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
 	userID := claims.UserID
+	if err := checkReceiptLocation(c, h.db(c), id, claims.TenantID); err != nil {
+		return err
+	}
 
 	// Check if receipt exists and is in APPROVED status
 	var currentStatus string
-	var locationID sql.NullString
-	err := h.DB.QueryRow("SELECT status, location_id FROM goods_receipts WHERE id = $1 AND tenant_id = $2", id, claims.TenantID).Scan(&currentStatus, &locationID)
+	var locationID, purchaseOrderID sql.NullString
+	err := h.db(c).QueryRow("SELECT status, location_id, purchase_order_id FROM goods_receipts WHERE id = $1 AND tenant_id = $2", id, claims.TenantID).Scan(&currentStatus, &locationID, &purchaseOrderID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "Receipt not found")
@@ -1133,57 +1314,92 @@ func (h *Handler) PostReceipt(c echo.Context) error {
 	}
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
 	defer tx.Rollback()
 
-	// Get receipt lines
-	rows, err := tx.Query(`
-		SELECT item_id, qty, unit_cost
-		FROM goods_receipt_lines
-		WHERE receipt_id = $1
-	`, id)
+	// Lock the receipt so charges added meanwhile are either allocated here or
+	// revalue the posted stock, and so it is posted once
+	if currentStatus, _, err = lockReceipt(tx, id, claims.TenantID); err != nil {
+		return err
+	}
+	if currentStatus != "APPROVED" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Can only post approved receipts")
+	}
+
+	before, err := auditSnapshot(c, tx, "receipt", id)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
-	defer rows.Close()
 
-	// Create stock movements and update inventory levels
-	for rows.Next() {
-		var itemID string
-		var qty int
-		var unitCostStr string
+	// Get receipt lines and allocate pending landed-cost charges across them
+	lines, err := loadCostLines(tx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := postPendingCharges(tx, claims.TenantID, id, lines); err != nil {
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to allocate landed costs")
+	}
+
+	costingMethod, err := tenantCostingMethod(tx, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	// Create stock movements, update inventory levels and item costs
+	for _, line := range lines {
+		if line.Qty <= 0 {
+			continue
+		}
+		unitCost := line.postedUnitCost()
 
-		err := rows.Scan(&itemID, &qty, &unitCostStr)
+		_, err = tx.Exec(`
+			UPDATE goods_receipt_lines SET landed_cost = $1, posted_unit_cost = $2, updated_at = NOW()
+			WHERE id = $3
+		`, line.LandedCost, unitCost, line.ID)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Database scan error")
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update receipt line")
 		}
 
-		if qty > 0 {
-			// Create stock movement record
-			_, err = tx.Exec(`
-				INSERT INTO stock_movements (id, item_id, location_id, movement_type, quantity, unit_cost, reference_type, reference_id, occurred_at, created_at)
-				VALUES ($1, $2, $3, 'IN', $4, $5::numeric, 'GOODS_RECEIPT', $6, NOW(), NOW())
-			`, uuid.New().String(), itemID, locationID.String, qty, unitCostStr, id)
-			if err != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create stock movement")
-			}
+		// Create stock movement record and update the item's costing
+		_, err = receiveStock(tx, costingMethod, stockMove{
+			TenantID:     claims.TenantID,
+			ItemID:       line.ItemID,
+			LocationID:   locationID.String,
+			UserID:       userID,
+			Qty:          line.Qty,
+			Reason:       "PO_RECEIPT",
+			Reference:    "Goods receipt",
+			RefID:        id,
+			SourceLineID: line.ID,
+		}, unitCost)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create stock movement")
+		}
 
-			// Update inventory levels
-			_, err = tx.Exec(`
-				INSERT INTO inventory_levels (id, item_id, location_id, on_hand, allocated, available, created_at, updated_at)
-				VALUES ($1, $2, $3, $4, 0, $4, NOW(), NOW())
-				ON CONFLICT (item_id, location_id) 
-				DO UPDATE SET 
-					on_hand = inventory_levels.on_hand + $4,
-					available = inventory_levels.available + $4,
-					updated_at = NOW()
-			`, uuid.New().String(), itemID, locationID.String, qty)
-			if err != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update inventory levels")
-			}
+		// Update inventory levels
+		_, err = tx.Exec(`
+			INSERT INTO inventory_levels (id, tenant_id, item_id, location_id, on_hand, allocated, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, 0, NOW(), NOW())
+			ON CONFLICT (item_id, location_id)
+			DO UPDATE SET
+				on_hand = inventory_levels.on_hand + $5,
+				updated_at = NOW()
+		`, uuid.New().String(), claims.TenantID, line.ItemID, locationID.String, line.Qty)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update inventory levels")
+		}
+	}
+
+	// Record the delivered quantities against the linked purchase order
+	if purchaseOrderID.Valid {
+		if err := applyReceiptToPurchaseOrder(tx, id, purchaseOrderID.String); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update purchase order")
 		}
 	}
 
@@ -1197,10 +1413,15 @@ func (h *Handler) PostReceipt(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to post receipt")
 	}
 
+	if err := recordAudit(c, tx, AuditPost, "receipt", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
+	h.invalidateDashboard(claims.TenantID)
 
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "Receipt posted successfully",
@@ -1216,10 +1437,13 @@ func (h *Handler) CloseReceipt(c echo.Context) error {
 	if errClaims != nil {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
+	if err := checkReceiptLocation(c, h.db(c), id, claims.TenantID); err != nil {
+		return err
+	}
 
 	// Check if receipt exists and can be closed
 	var currentStatus string
-	err := h.DB.QueryRow("SELECT status FROM goods_receipts WHERE id = $1 AND tenant_id = $2", id, claims.TenantID).Scan(&currentStatus)
+	err := h.db(c).QueryRow("SELECT status FROM goods_receipts WHERE id = $1 AND tenant_id = $2", id, claims.TenantID).Scan(&currentStatus)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "Receipt not found")
@@ -1232,7 +1456,18 @@ func (h *Handler) CloseReceipt(c echo.Context) error {
 	}
 
 	// Update status to CLOSED
-	_, err = h.DB.Exec(`
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "receipt", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	_, err = tx.Exec(`
 		UPDATE goods_receipts 
 		SET status = 'CLOSED', updated_at = NOW()
 		WHERE id = $1 AND tenant_id = $2
@@ -1241,6 +1476,13 @@ func (h *Handler) CloseReceipt(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to close receipt")
 	}
 
+	if err := recordAudit(c, tx, AuditClose, "receipt", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "Receipt closed successfully",
 	})
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"strings"
 
+	"inventory/internal/services"
+
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog/log"
@@ -22,10 +24,11 @@ type RegisterRequest struct {
 }
 
 type RegisterResponse struct {
-	User        UserResponse   `json:"user"`
-	Tenant      TenantResponse `json:"tenant"`
-	AccessToken string         `json:"access_token"`
-	ExpiresIn   int            `json:"expires_in"`
+	User         UserResponse   `json:"user"`
+	Tenant       TenantResponse `json:"tenant"`
+	AccessToken  string         `json:"access_token"`
+	RefreshToken string         `json:"refresh_token"`
+	ExpiresIn    int            `json:"expires_in"`
 }
 
 type UserResponse struct {
@@ -94,7 +97,7 @@ func (h *Handler) registerNewTenant(c echo.Context, req RegisterRequest) error {
 
 	// Check if email already exists globally
 	var existingUserCount int
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT COUNT(*) FROM users WHERE email = $1
 	`, req.Email).Scan(&existingUserCount)
 	if err != nil {
@@ -105,7 +108,7 @@ func (h *Handler) registerNewTenant(c echo.Context, req RegisterRequest) error {
 	}
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.db(c).Begin()
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
 	}
@@ -151,22 +154,37 @@ func (h *Handler) registerNewTenant(c echo.Context, req RegisterRequest) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
 	}
+	if err := addMembership(tx, userID.String(), tenantID.String(), "ADMIN"); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
+	}
+
+	// Audit the new tenant and its first admin as created by that admin
+	for _, e := range []services.AuditEntry{
+		auditEntry(c, AuditCreate, "tenant", tenantID.String()),
+		auditEntry(c, AuditCreate, "user", userID.String()),
+	} {
+		e.TenantID, e.UserID = tenantID.String(), userID.String()
+		if err := writeAudit(c, tx, e, nil); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+		}
+	}
+
+	// The account stays read-only until the email address is verified
+	verifyToken, err := issueUserToken(tx, userID.String(), TokenEmailVerify, h.Config.EmailVerifyExpiry)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to complete registration")
+	}
 
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to complete registration")
 	}
+	h.sendVerificationEmail(c.Request().Context(), req.Email, verifyToken)
 
-	// Generate JWT token
-	accessToken, err := h.generateToken(
-		userID.String(),
-		tenantID.String(),
-		req.Email,
-		"ADMIN",
-		h.Config.JWTExpiry,
-	)
+	// Start a login session
+	accessToken, refreshToken, err := h.startSession(c, userID.String(), tenantID.String(), req.Email, "ADMIN", false)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate access token")
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start session")
 	}
 
 	// Log successful registration
@@ -190,8 +208,9 @@ func (h *Handler) registerNewTenant(c echo.Context, req RegisterRequest) error {
 			Name: req.TenantName,
 			Slug: req.TenantSlug,
 		},
-		AccessToken: accessToken,
-		ExpiresIn:   int(h.Config.JWTExpiry.Seconds()),
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.Config.JWTExpiry.Seconds()),
 	})
 }
 
@@ -200,7 +219,7 @@ func (h *Handler) registerWithTenantSlug(c echo.Context, req RegisterRequest) er
 	// Find tenant by slug
 	var tenantID, tenantName, tenantSlug string
 	var isActive bool
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT id, name, slug, is_active
 		FROM tenants
 		WHERE slug = $1 AND is_active = true
@@ -212,17 +231,22 @@ func (h *Handler) registerWithTenantSlug(c echo.Context, req RegisterRequest) er
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to lookup company")
 	}
 
-	// Check if email already exists in this tenant
-	var existingUserCount int
-	err = h.DB.QueryRow(`
-		SELECT COUNT(*) FROM users WHERE email = $1 AND tenant_id = $2
-	`, req.Email, tenantID).Scan(&existingUserCount)
+	// Check if email is already a member here, or has an account elsewhere
+	var existingUserCount, memberCount int
+	err = h.db(c).QueryRow(`
+		SELECT COUNT(*), COUNT(m.id) FROM users u
+		LEFT JOIN tenant_memberships m ON m.user_id = u.id AND m.tenant_id = $2
+		WHERE u.email = $1
+	`, req.Email, tenantID).Scan(&existingUserCount, &memberCount)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check existing users")
 	}
-	if existingUserCount > 0 {
+	if memberCount > 0 {
 		return echo.NewHTTPError(http.StatusConflict, "Email already registered in this company")
 	}
+	if existingUserCount > 0 {
+		return echo.NewHTTPError(http.StatusConflict, "Email already registered. Sign in and ask the company for an invitation instead.")
+	}
 
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
@@ -231,8 +255,14 @@ func (h *Handler) registerWithTenantSlug(c echo.Context, req RegisterRequest) er
 	}
 
 	// Create user as clerk (default role for self-registration)
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
 	userID := uuid.New()
-	_, err = h.DB.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO users (id, tenant_id, email, password_hash, name, role, is_active, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6, true, NOW(), NOW())
 	`, userID, tenantID, req.Email, string(hashedPassword), req.Name, "CLERK")
@@ -240,17 +270,28 @@ func (h *Handler) registerWithTenantSlug(c echo.Context, req RegisterRequest) er
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
 	}
+	if err := addMembership(tx, userID.String(), tenantID, "CLERK"); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
+	}
+
+	e := auditEntry(c, AuditCreate, "user", userID.String())
+	e.TenantID, e.UserID = tenantID, userID.String()
+	if err := writeAudit(c, tx, e, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+	}
+	verifyToken, err := issueUserToken(tx, userID.String(), TokenEmailVerify, h.Config.EmailVerifyExpiry)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to complete registration")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to complete registration")
+	}
+	h.sendVerificationEmail(c.Request().Context(), req.Email, verifyToken)
 
-	// Generate JWT token
-	accessToken, err := h.generateToken(
-		userID.String(),
-		tenantID,
-		req.Email,
-		"CLERK",
-		h.Config.JWTExpiry,
-	)
+	// Start a login session
+	accessToken, refreshToken, err := h.startSession(c, userID.String(), tenantID, req.Email, "CLERK", false)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate access token")
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start session")
 	}
 
 	// Log successful registration
@@ -274,18 +315,12 @@ func (h *Handler) registerWithTenantSlug(c echo.Context, req RegisterRequest) er
 			Name: tenantName,
 			Slug: tenantSlug,
 		},
-		AccessToken: accessToken,
-		ExpiresIn:   int(h.Config.JWTExpiry.Seconds()),
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.Config.JWTExpiry.Seconds()),
 	})
 }
 
-// registerWithInvite handles invitation-based registration
-func (h *Handler) registerWithInvite(c echo.Context, req RegisterRequest) error {
-	// TODO: Implement invitation system
-	// This would validate the invite token and extract tenant/role information
-	return echo.NewHTTPError(http.StatusNotImplemented, "Invitation-based registration not yet implemented")
-}
-
 // TenantLookup allows users to find their tenant by email
 func (h *Handler) TenantLookup(c echo.Context) error {
 	log.Info().Msg("Tenant lookup endpoint called")
@@ -297,13 +332,13 @@ func (h *Handler) TenantLookup(c echo.Context) error {
 
 	email = strings.ToLower(strings.TrimSpace(email))
 
-	// Find all tenants for this email
-	rows, err := h.DB.Query(`
-		SELECT t.id, t.name, t.slug, u.role
-		FROM users u
-		INNER JOIN tenants t ON u.tenant_id = t.id
-		WHERE u.email = $1 AND u.is_active = true AND t.is_active = true
-		ORDER BY u.created_at ASC
+	// Find all tenants this email is a member of, home tenant first
+	rows, err := h.db(c).Query(`
+		SELECT t.id, t.name, t.slug, m.role
+		FROM `+memberTables+`
+		INNER JOIN tenants t ON m.tenant_id = t.id
+		WHERE u.email = $1 AND m.is_active = true AND t.is_active = true
+		ORDER BY m.tenant_id IS NOT DISTINCT FROM u.tenant_id DESC, m.created_at ASC
 	`, email)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to lookup tenants")
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+// Default look-back windows in days.
+const (
+	defaultSlowMoverDays = 90
+	defaultDeadStockDays = 180
+	defaultTurnoverDays  = 365
+	maxReportDays        = 3650
+)
+
+// StockReportRow holds the movement metrics of one item at one location over
+// the report window. Ratios are nil when there were no issues to measure.
+type StockReportRow struct {
+	Item              Item            `json:"item"`
+	Location          Location        `json:"location"`
+	OnHand            int             `json:"on_hand"`
+	UnitCost          decimal.Decimal `json:"unit_cost"`
+	CarryingValue     decimal.Decimal `json:"carrying_value"`
+	LastMovementAt    *time.Time      `json:"last_movement_at"`
+	DaysSinceMovement *int            `json:"days_since_movement"`
+	IssuedQty         int             `json:"issued_qty"`
+	AvgDailyUsage     *float64        `json:"avg_daily_usage"`
+	DaysOfSupply      *float64        `json:"days_of_supply"`
+	AvgOnHand         float64         `json:"avg_on_hand"`
+	TurnoverRatio     *float64        `json:"turnover_ratio"`
+}
+
+// StockReport is a stock report over the last Days days.
+type StockReport struct {
+	Report             string           `json:"report"`
+	Title              string           `json:"title"`
+	Days               int              `json:"days"`
+	From               time.Time        `json:"from"`
+	GeneratedAt        time.Time        `json:"generated_at"`
+	TotalCarryingValue decimal.Decimal  `json:"total_carrying_value"`
+	Rows               []StockReportRow `json:"rows"`
+}
+
+// stockReportQuery loads every stocked item-location with its last movement
+// (ever) and its issues and net movement since $2. Revaluations carry no
+// quantity and do not count as movement.
+const stockReportQuery = `
+	SELECT i.id, i.sku, i.name, l.id, l.code, l.name, il.on_hand, COALESCE(i.cost, 0),
+		mv.last_movement_at, COALESCE(mv.issued, 0), COALESCE(mv.net, 0)
+	FROM inventory_levels il
+	JOIN items i ON i.id = il.item_id
+	JOIN locations l ON l.id = il.location_id
+	LEFT JOIN LATERAL (
+		SELECT MAX(sm.occurred_at) FILTER (WHERE sm.qty <> 0) AS last_movement_at,
+			SUM(-sm.qty) FILTER (WHERE sm.qty < 0 AND sm.occurred_at >= $2) AS issued,
+			SUM(sm.qty) FILTER (WHERE sm.occurred_at >= $2) AS net
+		FROM stock_movements sm
+		WHERE sm.tenant_id = il.tenant_id AND sm.item_id = il.item_id AND sm.location_id = il.location_id
+	) mv ON TRUE
+	WHERE il.tenant_id = $1 %s
+	ORDER BY i.sku, l.code`
+
+// stockReportDef describes one report: which rows it keeps and how it orders them.
+type stockReportDef struct {
+	name        string
+	title       string
+	defaultDays int
+	keep        func(r StockReportRow, days int) bool
+	less        func(a, b StockReportRow) bool
+}
+
+var (
+	// Slow movers hold more stock than they issued over the window, i.e. more
+	// than `days` days of supply at the recent rate of usage.
+	slowMoversReport = stockReportDef{
+		name:        "slow-movers",
+		title:       "Slow Movers",
+		defaultDays: defaultSlowMoverDays,
+		keep: func(r StockReportRow, days int) bool {
+			return r.OnHand > 0 && (r.DaysOfSupply == nil || *r.DaysOfSupply > float64(days))
+		},
+		less: func(a, b StockReportRow) bool {
+			if (a.DaysOfSupply == nil) != (b.DaysOfSupply == nil) {
+				return a.DaysOfSupply == nil
+			}
+			if a.DaysOfSupply != nil && *a.DaysOfSupply != *b.DaysOfSupply {
+				return *a.DaysOfSupply > *b.DaysOfSupply
+			}
+			return a.CarryingValue.GreaterThan(b.CarryingValue)
+		},
+	}
+
+	// Dead stock has not moved at all within the window.
+	deadStockReport = stockReportDef{
+		name:        "dead-stock",
+		title:       "Dead Stock",
+		defaultDays: defaultDeadStockDays,
+		keep: func(r StockReportRow, days int) bool {
+			return r.OnHand > 0 && (r.DaysSinceMovement == nil || *r.DaysSinceMovement >= days)
+		},
+		less: func(a, b StockReportRow) bool {
+			return a.CarryingValue.GreaterThan(b.CarryingValue)
+		},
+	}
+
+	// Turnover lists every item-location that held or moved stock.
+	turnoverReport = stockReportDef{
+		name:        "turnover",
+		title:       "Inventory Turnover",
+		defaultDays: defaultTurnoverDays,
+		keep: func(r StockReportRow, days int) bool {
+			return r.OnHand != 0 || r.IssuedQty != 0
+		},
+		less: func(a, b StockReportRow) bool {
+			if (a.TurnoverRatio == nil) != (b.TurnoverRatio == nil) {
+				return b.TurnoverRatio == nil
+			}
+			if a.TurnoverRatio != nil && *a.TurnoverRatio != *b.TurnoverRatio {
+				return *a.TurnoverRatio > *b.TurnoverRatio
+			}
+			return false
+		},
+	}
+)
+
+// GetSlowMoversReport lists stock with more than N days of supply
+// (?days=&location_id=&format=json|csv|html).
+func (h *Handler) GetSlowMoversReport(c echo.Context) error {
+	return h.renderStockReport(c, slowMoversReport)
+}
+
+// GetDeadStockReport lists stock without any movement in the last N days
+// (?days=&location_id=&format=json|csv|html).
+func (h *Handler) GetDeadStockReport(c echo.Context) error {
+	return h.renderStockReport(c, deadStockReport)
+}
+
+// GetTurnoverReport reports issues, days of supply and turnover ratio per
+// item-location over the last N days (?days=&location_id=&format=json|csv|html).
+func (h *Handler) GetTurnoverReport(c echo.Context) error {
+	return h.renderStockReport(c, turnoverReport)
+}
+
+func (h *Handler) renderStockReport(c echo.Context, def stockReportDef) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	days := def.defaultDays
+	if v := c.QueryParam("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxReportDays {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d", maxReportDays))
+		}
+		days = n
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" && format != "html" {
+		return echo.NewHTTPError(http.StatusBadRequest, "format must be json, csv or html")
+	}
+
+	locationID := c.QueryParam("location_id")
+	if err := checkLocationAccess(c, locationID); err != nil {
+		return err
+	}
+	allowed, _ := appmw.GetAllowedLocations(c.Request().Context())
+
+	now := time.Now().UTC()
+	report, err := h.buildStockReport(c, claims.TenantID, locationID, allowed, def, days, now)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to build report")
+	}
+
+	switch format {
+	case "csv":
+		return writeStockReportCSV(c, report)
+	case "html":
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+		c.Response().WriteHeader(http.StatusOK)
+		return stockReportTemplate.Execute(c.Response(), report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// buildStockReport computes a report over one location, or all of them when
+// locationID is empty. A non-nil allowed keeps it to those locations.
+func (h *Handler) buildStockReport(c echo.Context, tenantID, locationID string, allowed []string, def stockReportDef, days int, now time.Time) (*StockReport, error) {
+	from := now.AddDate(0, 0, -days)
+	args := []interface{}{tenantID, from}
+	filter := ""
+	if locationID != "" {
+		args = append(args, locationID)
+		filter = fmt.Sprintf("AND il.location_id = $%d", len(args))
+	}
+	if allowed != nil {
+		args = append(args, pq.Array(allowed))
+		filter += fmt.Sprintf(" AND il.location_id = ANY($%d)", len(args))
+	}
+
+	rows, err := h.db(c).Query(fmt.Sprintf(stockReportQuery, filter), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &StockReport{
+		Report:             def.name,
+		Title:              def.title,
+		Days:               days,
+		From:               from,
+		GeneratedAt:        now,
+		TotalCarryingValue: decimal.Zero,
+		Rows:               []StockReportRow{},
+	}
+	for rows.Next() {
+		var r StockReportRow
+		var lastMovement sql.NullTime
+		var net int
+		if err := rows.Scan(
+			&r.Item.ID, &r.Item.SKU, &r.Item.Name, &r.Location.ID, &r.Location.Code, &r.Location.Name,
+			&r.OnHand, &r.UnitCost, &lastMovement, &r.IssuedQty, &net,
+		); err != nil {
+			return nil, err
+		}
+		if lastMovement.Valid {
+			r.LastMovementAt = &lastMovement.Time
+		}
+		computeStockMetrics(&r, net, days, now)
+		if def.keep(r, days) {
+			report.Rows = append(report.Rows, r)
+			report.TotalCarryingValue = report.TotalCarryingValue.Add(r.CarryingValue)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(report.Rows, func(i, j int) bool { return def.less(report.Rows[i], report.Rows[j]) })
+	return report, nil
+}
+
+// computeStockMetrics derives the window metrics of a row from its on-hand
+// quantity, issues and the net movement over the last days days. Average stock
+// is the mean of the opening and closing quantities.
+func computeStockMetrics(r *StockReportRow, net, days int, now time.Time) {
+	r.CarryingValue = r.UnitCost.Mul(decimal.NewFromInt(int64(r.OnHand))).Round(2)
+
+	if r.LastMovementAt != nil {
+		d := int(now.Sub(*r.LastMovementAt).Hours() / 24)
+		r.DaysSinceMovement = &d
+	}
+
+	opening := r.OnHand - net
+	r.AvgOnHand = float64(opening+r.OnHand) / 2
+
+	if r.IssuedQty > 0 {
+		usage := float64(r.IssuedQty) / float64(days)
+		r.AvgDailyUsage = round2(usage)
+		r.DaysOfSupply = round2(float64(r.OnHand) / usage)
+		if r.AvgOnHand > 0 {
+			r.TurnoverRatio = round2(float64(r.IssuedQty) / r.AvgOnHand)
+		}
+	}
+}
+
+func writeStockReportCSV(c echo.Context, report *StockReport) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-%s.csv"`, report.Report, report.GeneratedAt.Format(time.DateOnly)))
+	res.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(res)
+	if err := w.Write([]string{
+		"sku", "item_name", "location_code", "location_name", "on_hand", "unit_cost", "carrying_value",
+		"last_movement_at", "days_since_movement", "issued_qty", "avg_daily_usage", "days_of_supply", "turnover_ratio",
+	}); err != nil {
+		return err
+	}
+	for _, r := range report.Rows {
+		lastMovement := ""
+		if r.LastMovementAt != nil {
+			lastMovement = r.LastMovementAt.UTC().Format(time.RFC3339)
+		}
+		daysSince := ""
+		if r.DaysSinceMovement != nil {
+			daysSince = strconv.Itoa(*r.DaysSinceMovement)
+		}
+		if err := w.Write([]string{
+			r.Item.SKU, r.Item.Name, r.Location.Code, r.Location.Name, strconv.Itoa(r.OnHand),
+			r.UnitCost.StringFixed(4), r.CarryingValue.StringFixed(2), lastMovement, daysSince,
+			strconv.Itoa(r.IssuedQty), formatOptional(r.AvgDailyUsage), formatOptional(r.DaysOfSupply), formatOptional(r.TurnoverRatio),
+		}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func formatOptional(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', 2, 64)
+}
+
+var stockReportTemplate = template.Must(template.New("stock-report").Funcs(template.FuncMap{
+	"opt": func(v *float64) string {
+		if s := formatOptional(v); s != "" {
+			return s
+		}
+		return "—"
+	},
+	"date": func(t *time.Time) string {
+		if t == nil {
+			return "never"
+		}
+		return t.UTC().Format(time.DateOnly)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 12px; margin: 24px; }
+h1 { font-size: 18px; margin-bottom: 4px; }
+p.meta { color: #555; margin-top: 0; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 6px; text-align: left; }
+td.num, th.num { text-align: right; }
+tfoot td { font-weight: bold; border-top: 2px solid #333; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Last {{.Days}} days · generated {{.GeneratedAt.Format "2006-01-02 15:04"}} UTC</p>
+<table>
+<thead>
+<tr><th>SKU</th><th>Item</th><th>Location</th><th class="num">On hand</th><th class="num">Carrying value</th><th>Last movement</th><th class="num">Issued</th><th class="num">Days of supply</th><th class="num">Turnover</th></tr>
+</thead>
+<tbody>
+{{range .Rows}}<tr><td>{{.Item.SKU}}</td><td>{{.Item.Name}}</td><td>{{.Location.Code}}</td><td class="num">{{.OnHand}}</td><td class="num">{{.CarryingValue.StringFixed 2}}</td><td>{{date .LastMovementAt}}</td><td class="num">{{.IssuedQty}}</td><td class="num">{{opt .DaysOfSupply}}</td><td class="num">{{opt .TurnoverRatio}}</td></tr>
+{{else}}<tr><td colspan="9">No items</td></tr>
+{{end}}</tbody>
+<tfoot>
+<tr><td colspan="4">Total</td><td class="num">{{.TotalCarryingValue.StringFixed 2}}</td><td colspan="4"></td></tr>
+</tfoot>
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeStockMetrics(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	last := now.AddDate(0, 0, -10)
+
+	// 30 issued and 20 received over 30 days: opened at 60, closed at 50
+	r := StockReportRow{OnHand: 50, UnitCost: decimal.RequireFromString("2.5"), IssuedQty: 30, LastMovementAt: &last}
+	computeStockMetrics(&r, -10, 30, now)
+
+	assert.True(t, r.CarryingValue.Equal(decimal.RequireFromString("125")))
+	require.NotNil(t, r.DaysSinceMovement)
+	assert.Equal(t, 10, *r.DaysSinceMovement)
+	assert.Equal(t, 55.0, r.AvgOnHand)
+	require.NotNil(t, r.AvgDailyUsage)
+	assert.Equal(t, 1.0, *r.AvgDailyUsage)
+	require.NotNil(t, r.DaysOfSupply)
+	assert.Equal(t, 50.0, *r.DaysOfSupply)
+	require.NotNil(t, r.TurnoverRatio)
+	assert.Equal(t, 0.55, *r.TurnoverRatio)
+}
+
+func TestStockReportFilters(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	old := now.AddDate(0, 0, -200)
+
+	idle := StockReportRow{OnHand: 10, UnitCost: decimal.NewFromInt(1), LastMovementAt: &old}
+	computeStockMetrics(&idle, 0, 90, now)
+	assert.Nil(t, idle.DaysOfSupply)
+	assert.Nil(t, idle.TurnoverRatio)
+	assert.True(t, slowMoversReport.keep(idle, 90))
+	assert.True(t, deadStockReport.keep(idle, 180))
+
+	busy := StockReportRow{OnHand: 10, UnitCost: decimal.NewFromInt(1), IssuedQty: 90, LastMovementAt: &now}
+	computeStockMetrics(&busy, -80, 90, now)
+	assert.False(t, slowMoversReport.keep(busy, 90))
+	assert.False(t, deadStockReport.keep(busy, 180))
+	assert.True(t, turnoverReport.keep(busy, 90))
+	assert.True(t, turnoverReport.less(busy, idle))
+}
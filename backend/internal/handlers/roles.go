@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// Custom role names look like the built-in ones
+var roleNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]{1,49}$`)
+
+type Role struct {
+	ID          *string    `json:"id,omitempty"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Permissions []string   `json:"permissions"`
+	Builtin     bool       `json:"builtin"`
+	UserCount   int        `json:"user_count"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+var builtinRoleDescriptions = map[string]string{
+	appmw.RoleAdmin:   "Full access to the tenant",
+	appmw.RoleManager: "Manages items, suppliers, purchasing, transfers and adjustments; views users",
+	appmw.RoleClerk:   "Day-to-day stock work; drafts need approval",
+}
+
+// rolePermissions returns the permissions of a built-in role or of one of the
+// tenant's custom roles. Unknown roles have none.
+func rolePermissions(q queryRower, tenantID, role string) ([]string, error) {
+	if perms, ok := appmw.BuiltinRoles[role]; ok {
+		return append([]string{}, perms...), nil
+	}
+	if tenantID == "" {
+		return []string{}, nil
+	}
+	var perms []string
+	err := q.QueryRow(`SELECT permissions FROM roles WHERE tenant_id = $1 AND name = $2`, tenantID, role).Scan(pq.Array(&perms))
+	if err == sql.ErrNoRows {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	granted := []string{}
+	for _, p := range perms {
+		if appmw.IsTenantPermission(p) {
+			granted = append(granted, p)
+		}
+	}
+	return granted, nil
+}
+
+// tenantRoleExists reports whether role can be assigned to the tenant's users:
+// a built-in tenant role or one of its custom roles.
+func tenantRoleExists(q queryRower, tenantID, role string) (bool, error) {
+	if isUserRole(role) {
+		return true, nil
+	}
+	var exists bool
+	err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM roles WHERE tenant_id = $1 AND name = $2)`, tenantID, role).Scan(&exists)
+	return exists, err
+}
+
+// validatePermissions checks a custom role's permissions against the
+// catalogue and returns them sorted.
+func validatePermissions(perms []string) ([]string, error) {
+	for _, p := range perms {
+		if !appmw.IsTenantPermission(p) {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "unknown permission "+p)
+		}
+	}
+	return appmw.SortedPermissions(perms), nil
+}
+
+func scanRole(row rowScanner) (Role, error) {
+	var r Role
+	var id string
+	var createdAt, updatedAt time.Time
+	var description sql.NullString
+	if err := row.Scan(&id, &r.Name, &description, pq.Array(&r.Permissions), &r.UserCount, &createdAt, &updatedAt); err != nil {
+		return r, err
+	}
+	r.ID, r.Description, r.CreatedAt, r.UpdatedAt = &id, description.String, &createdAt, &updatedAt
+	if r.Permissions == nil {
+		r.Permissions = []string{}
+	}
+	return r, nil
+}
+
+const roleColumns = `r.id, r.name, r.description, r.permissions,
+	(SELECT COUNT(*) FROM tenant_memberships m WHERE m.tenant_id = r.tenant_id AND m.role = r.name), r.created_at, r.updated_at`
+
+// ListPermissions returns the catalogue of permissions custom roles can grant.
+func (h *Handler) ListPermissions(c echo.Context) error {
+	perms := []appmw.Permission{}
+	for _, p := range appmw.PermissionCatalogue {
+		if !p.System {
+			perms = append(perms, p)
+		}
+	}
+	return c.JSON(http.StatusOK, perms)
+}
+
+// ListRoles returns the built-in roles followed by the tenant's custom roles.
+func (h *Handler) ListRoles(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	roles := []Role{}
+	for _, name := range userRoles {
+		r := Role{
+			Name:        name,
+			Description: builtinRoleDescriptions[name],
+			Permissions: appmw.SortedPermissions(appmw.BuiltinRoles[name]),
+			Builtin:     true,
+		}
+		if err := h.db(c).QueryRow(`SELECT COUNT(*) FROM tenant_memberships WHERE tenant_id = $1 AND role = $2`, claims.TenantID, name).Scan(&r.UserCount); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		roles = append(roles, r)
+	}
+
+	rows, err := h.db(c).Query(`SELECT `+roleColumns+` FROM roles r WHERE r.tenant_id = $1 ORDER BY r.name`, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r, err := scanRole(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		roles = append(roles, r)
+	}
+	if err := rows.Err(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+// CreateRole adds a custom role to the tenant.
+func (h *Handler) CreateRole(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	name := strings.ToUpper(strings.TrimSpace(req.Name))
+	if !roleNamePattern.MatchString(name) {
+		return echo.NewHTTPError(http.StatusBadRequest, "name must be 2-50 letters, digits or underscores, starting with a letter")
+	}
+	if appmw.IsBuiltinRole(name) {
+		return echo.NewHTTPError(http.StatusConflict, "name is reserved for a built-in role")
+	}
+	perms, err := validatePermissions(req.Permissions)
+	if err != nil {
+		return err
+	}
+	if err := checkGrantablePermissions(claims, perms); err != nil {
+		return err
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow(`
+		INSERT INTO roles (tenant_id, name, description, permissions, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		RETURNING id
+	`, claims.TenantID, name, nullIfEmpty(strings.TrimSpace(req.Description)), pq.Array(perms)).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return echo.NewHTTPError(http.StatusConflict, "a role with this name already exists")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := recordAudit(c, tx, AuditCreate, "role", id, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	role, err := scanRole(tx.QueryRow(`SELECT `+roleColumns+` FROM roles r WHERE r.id = $1`, id))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole changes a custom role's description or permissions. Users with
+// the role get the new permissions when their access token is next refreshed.
+func (h *Handler) UpdateRole(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	id := c.Param("id")
+
+	var req struct {
+		Description *string   `json:"description"`
+		Permissions *[]string `json:"permissions"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	var perms []string
+	if req.Permissions != nil {
+		var err error
+		if perms, err = validatePermissions(*req.Permissions); err != nil {
+			return err
+		}
+		if err := checkGrantablePermissions(claims, perms); err != nil {
+			return err
+		}
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM roles WHERE id = $1 AND tenant_id = $2)
+	`, id, claims.TenantID).Scan(&exists); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "role not found")
+	}
+
+	before, err := auditSnapshot(c, tx, "role", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if req.Description != nil {
+		if _, err := tx.Exec(`UPDATE roles SET description = $2, updated_at = NOW() WHERE id = $1`,
+			id, nullIfEmpty(strings.TrimSpace(*req.Description))); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	if req.Permissions != nil {
+		if _, err := tx.Exec(`UPDATE roles SET permissions = $2, updated_at = NOW() WHERE id = $1`, id, pq.Array(perms)); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	if err := recordAudit(c, tx, AuditUpdate, "role", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	role, err := scanRole(tx.QueryRow(`SELECT `+roleColumns+` FROM roles r WHERE r.id = $1`, id))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole removes a custom role that no user or pending invitation has.
+func (h *Handler) DeleteRole(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	id := c.Param("id")
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	var name string
+	err = tx.QueryRow(`SELECT name FROM roles WHERE id = $1 AND tenant_id = $2 FOR UPDATE`, id, claims.TenantID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "role not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	var inUse bool
+	if err := tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM tenant_memberships WHERE tenant_id = $1 AND role = $2)
+			OR EXISTS (SELECT 1 FROM invitations WHERE tenant_id = $1 AND role = $2
+				AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW())
+	`, claims.TenantID, name).Scan(&inUse); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if inUse {
+		return echo.NewHTTPError(http.StatusConflict, "role is assigned to users or pending invitations")
+	}
+
+	before, err := auditSnapshot(c, tx, "role", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if _, err := tx.Exec(`DELETE FROM roles WHERE id = $1`, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := recordAudit(c, tx, AuditDelete, "role", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// checkAssignableRole returns a 400 unless role can be given to the tenant's
+// users.
+func checkAssignableRole(q queryRower, tenantID, role string) error {
+	ok, err := tenantRoleExists(q, tenantID, role)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "role must be ADMIN, MANAGER, CLERK or one of the tenant's custom roles")
+	}
+	return nil
+}
+
+// checkGrantableRole is checkAssignableRole for giving role to a user; it also
+// returns a 403 unless the caller holds every permission the role grants.
+func checkGrantableRole(q queryRower, claims *appmw.Claims, role string) error {
+	if err := checkAssignableRole(q, claims.TenantID, role); err != nil {
+		return err
+	}
+	perms, err := rolePermissions(q, claims.TenantID, role)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return checkGrantablePermissions(claims, perms)
+}
+
+// checkGrantablePermissions returns a 403 unless the caller holds every one of
+// perms, so nobody can hand out more access than they have.
+func checkGrantablePermissions(claims *appmw.Claims, perms []string) error {
+	for _, p := range perms {
+		if !claims.HasPermission(p) {
+			return echo.NewHTTPError(http.StatusForbidden, "cannot grant permission "+p+" you do not have")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type structValidator struct{ v *validator.Validate }
+
+func (sv structValidator) Validate(i interface{}) error { return sv.v.Struct(i) }
+
+// A custom role that may manage users and roles but nothing else
+var userManagerClaims = &appmw.Claims{
+	UserID:      "u1",
+	TenantID:    "t1",
+	Role:        "USER_MANAGER",
+	Permissions: []string{appmw.PermUserRead, appmw.PermUserWrite, appmw.PermRoleWrite},
+}
+
+func TestCheckGrantablePermissions(t *testing.T) {
+	assert.NoError(t, checkGrantablePermissions(userManagerClaims, []string{appmw.PermUserRead, appmw.PermRoleWrite}))
+	assert.NoError(t, checkGrantablePermissions(userManagerClaims, nil))
+
+	err := checkGrantablePermissions(userManagerClaims, []string{appmw.PermUserRead, appmw.PermItemWrite})
+	var httpErr *echo.HTTPError
+	require.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, http.StatusForbidden, httpErr.Code)
+
+	// Tokens without permissions fall back to their built-in role's
+	admin := &appmw.Claims{Role: appmw.RoleAdmin}
+	assert.NoError(t, checkGrantablePermissions(admin, appmw.BuiltinRoles[appmw.RoleManager]))
+}
+
+func TestCheckGrantableRole(t *testing.T) {
+	// Built-in roles are resolved without a query
+	manager := &appmw.Claims{TenantID: "t1", Role: appmw.RoleManager}
+	assert.NoError(t, checkGrantableRole(nil, manager, appmw.RoleClerk))
+	assert.NoError(t, checkGrantableRole(nil, manager, appmw.RoleManager))
+
+	var httpErr *echo.HTTPError
+	require.ErrorAs(t, checkGrantableRole(nil, manager, appmw.RoleAdmin), &httpErr)
+	assert.Equal(t, http.StatusForbidden, httpErr.Code)
+}
+
+func TestRoleAssignmentCannotEscalate(t *testing.T) {
+	h := &Handler{}
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		handler func(echo.Context) error
+	}{
+		{"create user", http.MethodPost, `{"email":"a@example.com","name":"A","password":"password1","role":"ADMIN"}`, h.CreateUser},
+		{"update user", http.MethodPut, `{"role":"ADMIN"}`, h.UpdateUser},
+		{"invite user", http.MethodPost, `{"email":"a@example.com","role":"MANAGER"}`, h.CreateInvitation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Validator = structValidator{validator.New()}
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			c := e.NewContext(req, httptest.NewRecorder())
+			c.SetParamNames("id")
+			c.SetParamValues("u2")
+			c.Set("user", userManagerClaims)
+
+			var httpErr *echo.HTTPError
+			require.ErrorAs(t, tt.handler(c), &httpErr)
+			assert.Equal(t, http.StatusForbidden, httpErr.Code)
+		})
+	}
+}
+
+func TestRolePermissionsCannotEscalate(t *testing.T) {
+	h := &Handler{}
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		handler func(echo.Context) error
+	}{
+		{"create role", http.MethodPost, `{"name":"SUPER","permissions":["user.read","item.write"]}`, h.CreateRole},
+		{"update own role", http.MethodPut, `{"permissions":["user.read","user.write","role.write","settings.write"]}`, h.UpdateRole},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+			c.SetParamNames("id")
+			c.SetParamValues("r1")
+			c.Set("user", userManagerClaims)
+
+			var httpErr *echo.HTTPError
+			require.ErrorAs(t, tt.handler(c), &httpErr)
+			assert.Equal(t, http.StatusForbidden, httpErr.Code)
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Refresh tokens are opaque random strings with their own prefix, so they can
+// never be confused with (or used as) a JWT access token. Only their SHA-256
+// is stored. Each refresh rotates the token; presenting a token that has
+// already been used means it was copied, and the whole session is revoked.
+const refreshTokenPrefix = "rt_"
+
+// Session revoke reasons
+const (
+	SessionLogout     = "LOGOUT"
+	SessionRevoked    = "REVOKED"
+	SessionReuse      = "TOKEN_REUSE"
+	SessionUserChange = "USER_CHANGED"
+)
+
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  *string   `json:"user_agent"`
+	IPAddress  *string   `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+func newRefreshToken() (string, error) {
+	return randomToken(refreshTokenPrefix)
+}
+
+// randomToken returns prefix followed by 32 random bytes, base64url encoded.
+func randomToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// startSession opens a login session for the user and returns an access token
+// bound to it and the session's first refresh token. mfaVerified records that
+// the user passed a second factor to open it.
+func (h *Handler) startSession(c echo.Context, userID, tenantID, email, role string, mfaVerified bool) (accessToken, refreshToken string, err error) {
+	refreshToken, err = newRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	var verified bool
+	if err := tx.QueryRow(`SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&verified); err != nil {
+		return "", "", err
+	}
+	perms, err := rolePermissions(tx, tenantID, role)
+	if err != nil {
+		return "", "", err
+	}
+
+	var sessionID string
+	err = tx.QueryRow(`
+		INSERT INTO user_sessions (user_id, tenant_id, user_agent, ip_address, created_at, last_used_at, expires_at, mfa_verified_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW(), $5, CASE WHEN $6 THEN NOW() END)
+		RETURNING id
+	`, userID, nullIfEmpty(tenantID), c.Request().UserAgent(), c.RealIP(), time.Now().Add(h.Config.RefreshExpiry), mfaVerified).Scan(&sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO refresh_tokens (session_id, token_hash, created_at) VALUES ($1, $2, NOW())
+	`, sessionID, hashToken(refreshToken)); err != nil {
+		return "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+
+	accessToken, err = h.generateSessionToken(userID, tenantID, email, role, sessionID, verified, perms)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+func (h *Handler) Refresh(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if !strings.HasPrefix(req.RefreshToken, refreshTokenPrefix) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid refresh token")
+	}
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	var tokenID, sessionID, userID, email, role string
+	var tenantID sql.NullString
+	var usedAt, revokedAt sql.NullTime
+	var expiresAt time.Time
+	var userActive, verified bool
+	err = tx.QueryRow(`
+		SELECT rt.id, s.id, s.user_id, s.tenant_id, u.email, COALESCE(m.role, u.role), COALESCE(m.is_active, s.tenant_id IS NULL AND u.is_active),
+			u.email_verified_at IS NOT NULL, rt.used_at, s.revoked_at, s.expires_at
+		FROM refresh_tokens rt
+		JOIN user_sessions s ON s.id = rt.session_id
+		JOIN users u ON u.id = s.user_id
+		LEFT JOIN tenant_memberships m ON m.user_id = s.user_id AND m.tenant_id = s.tenant_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt, s
+	`, hashToken(req.RefreshToken)).Scan(&tokenID, &sessionID, &userID, &tenantID, &email, &role, &userActive, &verified, &usedAt, &revokedAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid refresh token")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	if usedAt.Valid {
+		// A rotated token came back: someone else holds a copy of the family
+		if !revokedAt.Valid {
+			if err := revokeSession(tx, sessionID, SessionReuse); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+			}
+			if err := tx.Commit(); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+			}
+			log.Warn().Str("session_id", sessionID).Str("user_id", userID).Str("ip", c.RealIP()).
+				Msg("Refresh token reuse detected, session revoked")
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized, "refresh token has already been used")
+	}
+	if revokedAt.Valid || !time.Now().Before(expiresAt) || !userActive {
+		return echo.NewHTTPError(http.StatusUnauthorized, "session has expired or been revoked")
+	}
+	if host, ok := appmw.GetHostTenant(c.Request().Context()); ok && host.ID.String() != tenantID.String {
+		return echo.NewHTTPError(http.StatusUnauthorized, "credentials are not valid for this tenant")
+	}
+
+	perms, err := rolePermissions(tx, tenantID.String, role)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate refresh token")
+	}
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1`, tokenID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO refresh_tokens (session_id, token_hash, created_at) VALUES ($1, $2, NOW())
+	`, sessionID, hashToken(refreshToken)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if _, err := tx.Exec(`
+		UPDATE user_sessions SET last_used_at = NOW(), expires_at = $2, ip_address = $3, user_agent = $4 WHERE id = $1
+	`, sessionID, time.Now().Add(h.Config.RefreshExpiry), c.RealIP(), c.Request().UserAgent()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	// Role, permissions, tenant and verification are read fresh so changes
+	// apply from the next refresh
+	accessToken, err := h.generateSessionToken(userID, tenantID.String, email, role, sessionID, verified, perms)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate access token")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(h.Config.JWTExpiry.Seconds()),
+	})
+}
+
+// Logout ends the session of the refresh token in the body or, failing that,
+// of the bearer access token.
+func (h *Handler) Logout(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	var sessionID string
+	if req.RefreshToken != "" {
+		err := h.db(c).QueryRow(`SELECT session_id FROM refresh_tokens WHERE token_hash = $1`, hashToken(req.RefreshToken)).Scan(&sessionID)
+		if err != nil && err != sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+		}
+	} else if auth := c.Request().Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		if claims, err := h.validateToken(strings.TrimPrefix(auth, "Bearer ")); err == nil {
+			sessionID = claims.SessionID
+		}
+	}
+
+	if sessionID != "" {
+		tx, err := h.db(c).Begin()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+		}
+		defer tx.Rollback()
+		if err := revokeSession(tx, sessionID, SessionLogout); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+		}
+		if err := tx.Commit(); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "logged out successfully",
+	})
+}
+
+// ListMySessions lists the caller's active sessions.
+func (h *Handler) ListMySessions(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	rows, err := h.db(c).Query(`
+		SELECT id, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM user_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		var userAgent, ip sql.NullString
+		if err := rows.Scan(&s.ID, &userAgent, &ip, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+		}
+		if userAgent.Valid {
+			s.UserAgent = &userAgent.String
+		}
+		if ip.Valid {
+			s.IPAddress = &ip.String
+		}
+		s.Current = s.ID == claims.SessionID
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"data": sessions})
+}
+
+// RevokeMySession ends one of the caller's sessions.
+func (h *Handler) RevokeMySession(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	res, err := h.db(c).Exec(`
+		UPDATE user_sessions SET revoked_at = NOW(), revoke_reason = $3
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, c.Param("id"), claims.UserID, SessionRevoked)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "session not found")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RevokeMyOtherSessions ends all of the caller's sessions except the current one.
+func (h *Handler) RevokeMyOtherSessions(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	res, err := h.db(c).Exec(`
+		UPDATE user_sessions SET revoked_at = NOW(), revoke_reason = $3
+		WHERE user_id = $1 AND id::text <> $2 AND revoked_at IS NULL
+	`, claims.UserID, claims.SessionID, SessionRevoked)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	n, _ := res.RowsAffected()
+	return c.JSON(http.StatusOK, map[string]interface{}{"revoked": n})
+}
+
+// sessionMFAVerified reports whether the user's session was opened with a
+// second factor. Unknown sessions were not.
+func sessionMFAVerified(q queryRower, sessionID, userID string) (bool, error) {
+	if sessionID == "" {
+		return false, nil
+	}
+	var verified bool
+	err := q.QueryRow(`
+		SELECT mfa_verified_at IS NOT NULL FROM user_sessions WHERE id::text = $1 AND user_id = $2
+	`, sessionID, userID).Scan(&verified)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return verified, err
+}
+
+func revokeSession(tx *sql.Tx, sessionID, reason string) error {
+	_, err := tx.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW(), revoke_reason = $2 WHERE id = $1 AND revoked_at IS NULL
+	`, sessionID, reason)
+	return err
+}
+
+// revokeUserSessions ends every session of the user, e.g. when they are
+// disabled or their password or role changes.
+func revokeUserSessions(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW(), revoke_reason = $2 WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID, SessionUserChange)
+	return err
+}
+
+// revokeMembershipSessions ends the user's sessions in one tenant, after their
+// membership of it changed.
+func revokeMembershipSessions(tx *sql.Tx, userID, tenantID string) error {
+	_, err := tx.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW(), revoke_reason = $3
+		WHERE user_id = $1 AND tenant_id = $2 AND revoked_at IS NULL
+	`, userID, tenantID, SessionUserChange)
+	return err
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
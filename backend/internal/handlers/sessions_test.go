@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRefreshToken(t *testing.T) {
+	a, err := newRefreshToken()
+	require.NoError(t, err)
+	b, err := newRefreshToken()
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(a, refreshTokenPrefix))
+	assert.NotEqual(t, a, b)
+	assert.NotEqual(t, hashToken(a), hashToken(b))
+}
+
+func TestRefreshRejectsAccessToken(t *testing.T) {
+	e := echo.New()
+	body := `{"refresh_token":"eyJhbGciOiJIUzI1NiJ9.e30.sig"}`
+	req := httptest.NewRequest(http.MethodPost, "/auth/refresh", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := e.NewContext(req, httptest.NewRecorder())
+
+	err := (&Handler{}).Refresh(c)
+	var httpErr *echo.HTTPError
+	require.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, http.StatusUnauthorized, httpErr.Code)
+}
@@ -0,0 +1,902 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+)
+
+const priceDateLayout = "2006-01-02"
+
+// priceListHeader is the column order used for CSV export; import accepts the same
+// columns in any order.
+var priceListHeader = []string{"item_sku", "supplier_sku", "pack_size", "moq", "lead_time_days", "min_qty", "unit_cost", "valid_from", "valid_to"}
+
+// SupplierItem is an entry in a supplier's catalog: an item the supplier delivers
+// together with the supplier's ordering terms and tiered price list.
+type SupplierItem struct {
+	ID           string          `json:"id"`
+	SupplierID   string          `json:"supplier_id"`
+	ItemID       string          `json:"item_id"`
+	Item         *Item           `json:"item,omitempty"`
+	SupplierSKU  *string         `json:"supplier_sku,omitempty"`
+	PackSize     int             `json:"pack_size"`
+	MOQ          int             `json:"moq"`
+	LeadTimeDays int             `json:"lead_time_days"`
+	IsActive     bool            `json:"is_active"`
+	Prices       []SupplierPrice `json:"prices"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+// SupplierPrice is a single price tier. It applies to order quantities of at least
+// MinQty between ValidFrom and ValidTo (inclusive, open-ended when ValidTo is empty).
+type SupplierPrice struct {
+	ID        string          `json:"id"`
+	MinQty    int             `json:"min_qty"`
+	UnitCost  decimal.Decimal `json:"unit_cost"`
+	ValidFrom string          `json:"valid_from"`
+	ValidTo   *string         `json:"valid_to,omitempty"`
+}
+
+type SupplierItemRequest struct {
+	ItemID       string                 `json:"item_id"` // Item UUID or SKU
+	SupplierSKU  *string                `json:"supplier_sku"`
+	PackSize     *int                   `json:"pack_size"`
+	MOQ          *int                   `json:"moq"`
+	LeadTimeDays *int                   `json:"lead_time_days"`
+	IsActive     *bool                  `json:"is_active"`
+	Prices       []SupplierPriceRequest `json:"prices"`
+}
+
+type SupplierPriceRequest struct {
+	MinQty    int     `json:"min_qty"`
+	UnitCost  string  `json:"unit_cost"`
+	ValidFrom *string `json:"valid_from"`
+	ValidTo   *string `json:"valid_to"`
+}
+
+// priceListRow is one parsed line of a supplier price list CSV.
+type priceListRow struct {
+	Line         int
+	ItemSKU      string
+	SupplierSKU  string
+	PackSize     int
+	MOQ          int
+	LeadTimeDays int
+	MinQty       int
+	UnitCost     *decimal.Decimal
+	ValidFrom    *time.Time
+	ValidTo      *time.Time
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ListSupplierItems returns the supplier's catalog with all price tiers
+func (h *Handler) ListSupplierItems(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	supplierID := c.Param("id")
+
+	if err := h.ensureSupplierInTenant(h.db(c), supplierID, claims.TenantID); err != nil {
+		return err
+	}
+
+	items, err := h.loadSupplierItems(c, supplierID, claims.TenantID, "")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"data": items})
+}
+
+// CreateSupplierItem adds an item to the supplier's catalog
+func (h *Handler) CreateSupplierItem(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	supplierID := c.Param("id")
+
+	var req SupplierItemRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if strings.TrimSpace(req.ItemID) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "item_id is required")
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	if err := h.ensureSupplierInTenant(tx, supplierID, claims.TenantID); err != nil {
+		return err
+	}
+
+	itemID, err := findItemID(tx, strings.TrimSpace(req.ItemID), claims.TenantID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("item not found: %s", req.ItemID))
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	packSize, moq, leadTime := 1, 1, 0
+	if req.PackSize != nil {
+		packSize = *req.PackSize
+	}
+	if req.MOQ != nil {
+		moq = *req.MOQ
+	}
+	if req.LeadTimeDays != nil {
+		leadTime = *req.LeadTimeDays
+	}
+	if packSize <= 0 || moq <= 0 || leadTime < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "pack_size and moq must be positive and lead_time_days cannot be negative")
+	}
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	id := uuid.New().String()
+	_, err = tx.Exec(`
+		INSERT INTO supplier_items (id, tenant_id, supplier_id, item_id, supplier_sku, pack_size, moq, lead_time_days, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW())
+	`, id, claims.TenantID, supplierID, itemID, req.SupplierSKU, packSize, moq, leadTime, isActive)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return echo.NewHTTPError(http.StatusConflict, "item is already in this supplier's catalog")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create supplier item")
+	}
+
+	if err := insertSupplierPrices(tx, claims.TenantID, id, req.Prices); err != nil {
+		return err
+	}
+
+	if err := recordAudit(c, tx, AuditCreate, "supplier_item", id, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	items, err := h.loadSupplierItems(c, supplierID, claims.TenantID, id)
+	if err != nil || len(items) == 0 {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	return c.JSON(http.StatusCreated, items[0])
+}
+
+// UpdateSupplierItem updates catalog terms; when prices are supplied they replace all existing tiers
+func (h *Handler) UpdateSupplierItem(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	supplierID := c.Param("id")
+	supplierItemID := c.Param("supplier_item_id")
+
+	var req SupplierItemRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+	idx := 1
+	if req.SupplierSKU != nil {
+		sets = append(sets, fmt.Sprintf("supplier_sku = $%d", idx))
+		args = append(args, strings.TrimSpace(*req.SupplierSKU))
+		idx++
+	}
+	if req.PackSize != nil {
+		if *req.PackSize <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "pack_size must be positive")
+		}
+		sets = append(sets, fmt.Sprintf("pack_size = $%d", idx))
+		args = append(args, *req.PackSize)
+		idx++
+	}
+	if req.MOQ != nil {
+		if *req.MOQ <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "moq must be positive")
+		}
+		sets = append(sets, fmt.Sprintf("moq = $%d", idx))
+		args = append(args, *req.MOQ)
+		idx++
+	}
+	if req.LeadTimeDays != nil {
+		if *req.LeadTimeDays < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "lead_time_days cannot be negative")
+		}
+		sets = append(sets, fmt.Sprintf("lead_time_days = $%d", idx))
+		args = append(args, *req.LeadTimeDays)
+		idx++
+	}
+	if req.IsActive != nil {
+		sets = append(sets, fmt.Sprintf("is_active = $%d", idx))
+		args = append(args, *req.IsActive)
+		idx++
+	}
+	if len(sets) == 0 && req.Prices == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "no fields to update")
+	}
+	sets = append(sets, "updated_at = NOW()")
+	args = append(args, supplierItemID, supplierID, claims.TenantID)
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "supplier_item", supplierItemID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	query := fmt.Sprintf(`UPDATE supplier_items SET %s WHERE id = $%d AND supplier_id = $%d AND tenant_id = $%d`, strings.Join(sets, ", "), idx, idx+1, idx+2)
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update supplier item")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "supplier item not found")
+	}
+
+	if req.Prices != nil {
+		if _, err := tx.Exec(`DELETE FROM supplier_prices WHERE supplier_item_id = $1 AND tenant_id = $2`, supplierItemID, claims.TenantID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to replace prices")
+		}
+		if err := insertSupplierPrices(tx, claims.TenantID, supplierItemID, req.Prices); err != nil {
+			return err
+		}
+	}
+
+	if err := recordAudit(c, tx, AuditUpdate, "supplier_item", supplierItemID, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	items, err := h.loadSupplierItems(c, supplierID, claims.TenantID, supplierItemID)
+	if err != nil || len(items) == 0 {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	return c.JSON(http.StatusOK, items[0])
+}
+
+// DeleteSupplierItem removes an item (and its price tiers) from the supplier's catalog
+func (h *Handler) DeleteSupplierItem(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	supplierItemID := c.Param("supplier_item_id")
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "supplier_item", supplierItemID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	res, err := tx.Exec(`DELETE FROM supplier_items WHERE id = $1 AND supplier_id = $2 AND tenant_id = $3`,
+		supplierItemID, c.Param("id"), claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete supplier item")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "supplier item not found")
+	}
+	if err := recordAudit(c, tx, AuditDelete, "supplier_item", supplierItemID, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetSupplierPrice returns the active unit cost for an item and quantity (defaults to 1)
+func (h *Handler) GetSupplierPrice(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	supplierID := c.Param("id")
+
+	itemParam := strings.TrimSpace(c.QueryParam("item_id"))
+	if itemParam == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "item_id is required")
+	}
+	qty := 1
+	if q := c.QueryParam("qty"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil || n <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "qty must be a positive integer")
+		}
+		qty = n
+	}
+
+	itemID, err := findItemID(h.db(c), itemParam, claims.TenantID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "item not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	price, err := activeSupplierPrice(h.db(c), claims.TenantID, supplierID, itemID, qty, time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if price == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "no active price for this item and quantity")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"supplier_id": supplierID,
+		"item_id":     itemID,
+		"qty":         qty,
+		"unit_cost":   price,
+	})
+}
+
+// ExportSupplierPriceList writes the supplier's catalog as CSV, one row per price tier
+func (h *Handler) ExportSupplierPriceList(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	supplierID := c.Param("id")
+
+	var supplierCode string
+	err := h.db(c).QueryRow(`SELECT code FROM suppliers WHERE id = $1 AND tenant_id = $2`, supplierID, claims.TenantID).Scan(&supplierCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "supplier not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	items, err := h.loadSupplierItems(c, supplierID, claims.TenantID, "")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-price-list.csv"`, supplierCode))
+	res.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(res)
+	if err := w.Write(priceListHeader); err != nil {
+		return err
+	}
+	for _, si := range items {
+		sku := ""
+		if si.Item != nil {
+			sku = si.Item.SKU
+		}
+		supplierSKU := ""
+		if si.SupplierSKU != nil {
+			supplierSKU = *si.SupplierSKU
+		}
+		terms := []string{sku, supplierSKU, strconv.Itoa(si.PackSize), strconv.Itoa(si.MOQ), strconv.Itoa(si.LeadTimeDays)}
+		if len(si.Prices) == 0 {
+			if err := w.Write(append(terms, "", "", "", "")); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, p := range si.Prices {
+			validTo := ""
+			if p.ValidTo != nil {
+				validTo = *p.ValidTo
+			}
+			row := append(append([]string{}, terms...), strconv.Itoa(p.MinQty), p.UnitCost.StringFixed(2), p.ValidFrom, validTo)
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// ImportSupplierPriceList upserts catalog entries from a CSV price list. Every item with at
+// least one priced row has its price tiers replaced by the rows given for it; terms-only rows
+// leave prices alone, and items not in the file are untouched. Existing catalog terms are only
+// overwritten for the columns the file has.
+func (h *Handler) ImportSupplierPriceList(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	supplierID := c.Param("id")
+
+	// Accept either a multipart upload ("file") or a raw text/csv body
+	var src io.Reader = c.Request().Body
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "cannot read uploaded file")
+		}
+		defer f.Close()
+		src = f
+	}
+
+	rows, columns, err := parsePriceListCSV(src)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(rows) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "price list is empty")
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	if err := h.ensureSupplierInTenant(tx, supplierID, claims.TenantID); err != nil {
+		return err
+	}
+
+	termUpdates := priceListTermUpdates(columns)
+
+	// Group rows by SKU, keeping file order; catalog terms come from the first row of each SKU
+	var order []string
+	grouped := map[string][]priceListRow{}
+	for _, r := range rows {
+		if _, seen := grouped[r.ItemSKU]; !seen {
+			order = append(order, r.ItemSKU)
+		}
+		grouped[r.ItemSKU] = append(grouped[r.ItemSKU], r)
+	}
+
+	pricesImported := 0
+	for _, sku := range order {
+		group := grouped[sku]
+		first := group[0]
+
+		itemID, err := findItemID(tx, sku, claims.TenantID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("line %d: unknown item sku %q", first.Line, sku))
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+		}
+
+		var supplierSKU interface{}
+		if first.SupplierSKU != "" {
+			supplierSKU = first.SupplierSKU
+		}
+
+		// Capture the existing catalog entry, if any, for the audit trail
+		var supplierItemID string
+		var before json.RawMessage
+		err = tx.QueryRow(`SELECT id FROM supplier_items WHERE supplier_id = $1 AND item_id = $2`, supplierID, itemID).Scan(&supplierItemID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+		}
+		action := AuditCreate
+		if err == nil {
+			action = AuditUpdate
+			if before, err = auditSnapshot(c, tx, "supplier_item", supplierItemID); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+			}
+		}
+
+		err = tx.QueryRow(`
+			INSERT INTO supplier_items (id, tenant_id, supplier_id, item_id, supplier_sku, pack_size, moq, lead_time_days, is_active, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, TRUE, NOW(), NOW())
+			ON CONFLICT (supplier_id, item_id)
+			DO UPDATE SET `+termUpdates+`
+			RETURNING id
+		`, uuid.New().String(), claims.TenantID, supplierID, itemID, supplierSKU, first.PackSize, first.MOQ, first.LeadTimeDays).Scan(&supplierItemID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("line %d: failed to save supplier item", first.Line))
+		}
+
+		// Terms-only rows leave the item's price tiers as they are
+		if hasPricedRow(group) {
+			if _, err := tx.Exec(`DELETE FROM supplier_prices WHERE supplier_item_id = $1 AND tenant_id = $2`, supplierItemID, claims.TenantID); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to replace prices")
+			}
+
+			for _, r := range group {
+				if r.UnitCost == nil {
+					continue
+				}
+				validFrom := time.Now()
+				if r.ValidFrom != nil {
+					validFrom = *r.ValidFrom
+				}
+				_, err := tx.Exec(`
+					INSERT INTO supplier_prices (id, tenant_id, supplier_item_id, min_qty, unit_cost, valid_from, valid_to, created_at, updated_at)
+					VALUES ($1, $2, $3, $4, $5::numeric, $6, $7, NOW(), NOW())
+				`, uuid.New().String(), claims.TenantID, supplierItemID, r.MinQty, r.UnitCost.StringFixed(2), validFrom, r.ValidTo)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("line %d: invalid price tier", r.Line))
+				}
+				pricesImported++
+			}
+		}
+
+		if err := recordAudit(c, tx, action, "supplier_item", supplierItemID, before); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":         "Price list imported successfully",
+		"items_imported":  len(order),
+		"prices_imported": pricesImported,
+	})
+}
+
+// priceListTerms are the catalog term columns of a price list, updated on existing
+// catalog entries only when the file has them.
+var priceListTerms = []string{"supplier_sku", "pack_size", "moq", "lead_time_days"}
+
+// priceListTermUpdates returns the SET list for a price list upsert: the term columns
+// present in the file plus reactivating the entry.
+func priceListTermUpdates(columns map[string]bool) string {
+	var sets []string
+	for _, col := range priceListTerms {
+		if columns[col] {
+			sets = append(sets, col+" = EXCLUDED."+col)
+		}
+	}
+	return strings.Join(append(sets, "is_active = TRUE", "updated_at = NOW()"), ", ")
+}
+
+// hasPricedRow reports whether any of the rows carries a unit_cost.
+func hasPricedRow(rows []priceListRow) bool {
+	for _, r := range rows {
+		if r.UnitCost != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePriceListCSV reads a price list with a header row and returns its rows and the
+// columns the header has. Only item_sku is mandatory; rows without a unit_cost update
+// catalog terms only.
+func parsePriceListCSV(r io.Reader) ([]priceListRow, map[string]bool, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	cols := map[string]int{}
+	columns := map[string]bool{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		cols[name] = i
+		columns[name] = true
+	}
+	if _, ok := cols["item_sku"]; !ok {
+		return nil, nil, fmt.Errorf("missing required column: item_sku")
+	}
+
+	var rows []priceListRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		get := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		intOr := func(name string, def, min int) (int, error) {
+			v := get(name)
+			if v == "" {
+				return def, nil
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < min {
+				return 0, fmt.Errorf("line %d: invalid %s %q", line, name, v)
+			}
+			return n, nil
+		}
+		dateOrNil := func(name string) (*time.Time, error) {
+			v := get(name)
+			if v == "" {
+				return nil, nil
+			}
+			t, err := time.Parse(priceDateLayout, v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s %q (expected YYYY-MM-DD)", line, name, v)
+			}
+			return &t, nil
+		}
+
+		row := priceListRow{Line: line, ItemSKU: get("item_sku"), SupplierSKU: get("supplier_sku")}
+		if row.ItemSKU == "" {
+			// Skip rows that are only separators
+			if strings.TrimSpace(strings.Join(record, "")) == "" {
+				continue
+			}
+			return nil, nil, fmt.Errorf("line %d: item_sku is required", line)
+		}
+		if row.PackSize, err = intOr("pack_size", 1, 1); err != nil {
+			return nil, nil, err
+		}
+		if row.MOQ, err = intOr("moq", 1, 1); err != nil {
+			return nil, nil, err
+		}
+		if row.LeadTimeDays, err = intOr("lead_time_days", 0, 0); err != nil {
+			return nil, nil, err
+		}
+		if row.MinQty, err = intOr("min_qty", 1, 1); err != nil {
+			return nil, nil, err
+		}
+		if v := get("unit_cost"); v != "" {
+			cost, err := decimal.NewFromString(v)
+			if err != nil || cost.IsNegative() {
+				return nil, nil, fmt.Errorf("line %d: invalid unit_cost %q", line, v)
+			}
+			row.UnitCost = &cost
+		}
+		if row.ValidFrom, err = dateOrNil("valid_from"); err != nil {
+			return nil, nil, err
+		}
+		if row.ValidTo, err = dateOrNil("valid_to"); err != nil {
+			return nil, nil, err
+		}
+		if row.ValidFrom != nil && row.ValidTo != nil && row.ValidTo.Before(*row.ValidFrom) {
+			return nil, nil, fmt.Errorf("line %d: valid_to is before valid_from", line)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, columns, nil
+}
+
+// activeSupplierPrice returns the unit cost the supplier charges for qty units of the item
+// on the given day: the valid tier with the highest min_qty not above qty. It returns nil
+// when the supplier has no applicable price.
+func activeSupplierPrice(q queryRower, tenantID, supplierID, itemID string, qty int, on time.Time) (*decimal.Decimal, error) {
+	var unitCost decimal.Decimal
+	err := q.QueryRow(`
+		SELECT sp.unit_cost
+		FROM supplier_prices sp
+		INNER JOIN supplier_items si ON si.id = sp.supplier_item_id
+		WHERE si.tenant_id = $1 AND si.supplier_id = $2 AND si.item_id = $3 AND si.is_active = TRUE
+			AND sp.min_qty <= $4
+			AND sp.valid_from <= $5::date
+			AND (sp.valid_to IS NULL OR sp.valid_to >= $5::date)
+		ORDER BY sp.min_qty DESC, sp.valid_from DESC
+		LIMIT 1
+	`, tenantID, supplierID, itemID, qty, on.Format(priceDateLayout)).Scan(&unitCost)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &unitCost, nil
+}
+
+// purchaseLineUnitCost returns the explicitly provided unit cost, or falls back to the
+// supplier's active price for the ordered quantity.
+func purchaseLineUnitCost(tx *sql.Tx, tenantID, supplierID, itemID string, qty int, provided string) (decimal.Decimal, error) {
+	if strings.TrimSpace(provided) != "" {
+		cost, err := decimal.NewFromString(strings.TrimSpace(provided))
+		if err != nil {
+			return decimal.Zero, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid unit cost: %s", provided))
+		}
+		return cost, nil
+	}
+
+	price, err := activeSupplierPrice(tx, tenantID, supplierID, itemID, qty, time.Now())
+	if err != nil {
+		return decimal.Zero, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if price == nil {
+		return decimal.Zero, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unit_cost is required for item %s: supplier has no active price", itemID))
+	}
+	return *price, nil
+}
+
+// findItemID resolves an item UUID or SKU within the tenant without creating anything
+func findItemID(q queryRower, identifier, tenantID string) (string, error) {
+	var id string
+	if _, err := uuid.Parse(identifier); err == nil {
+		err := q.QueryRow(`SELECT id FROM items WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`, identifier, tenantID).Scan(&id)
+		return id, err
+	}
+	err := q.QueryRow(`SELECT id FROM items WHERE sku = $1 AND tenant_id = $2 AND deleted_at IS NULL`, identifier, tenantID).Scan(&id)
+	return id, err
+}
+
+func (h *Handler) ensureSupplierInTenant(q queryRower, supplierID, tenantID string) error {
+	var exists bool
+	if err := q.QueryRow(`SELECT EXISTS(SELECT 1 FROM suppliers WHERE id = $1 AND tenant_id = $2)`, supplierID, tenantID).Scan(&exists); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "supplier not found")
+	}
+	return nil
+}
+
+func insertSupplierPrices(tx *sql.Tx, tenantID, supplierItemID string, prices []SupplierPriceRequest) error {
+	for _, p := range prices {
+		minQty := p.MinQty
+		if minQty <= 0 {
+			minQty = 1
+		}
+		cost, err := decimal.NewFromString(strings.TrimSpace(p.UnitCost))
+		if err != nil || cost.IsNegative() {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid unit_cost: %s", p.UnitCost))
+		}
+		validFrom := time.Now()
+		if p.ValidFrom != nil && *p.ValidFrom != "" {
+			t, err := time.Parse(priceDateLayout, *p.ValidFrom)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "valid_from must be YYYY-MM-DD")
+			}
+			validFrom = t
+		}
+		var validTo *time.Time
+		if p.ValidTo != nil && *p.ValidTo != "" {
+			t, err := time.Parse(priceDateLayout, *p.ValidTo)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "valid_to must be YYYY-MM-DD")
+			}
+			if t.Before(validFrom) {
+				return echo.NewHTTPError(http.StatusBadRequest, "valid_to cannot be before valid_from")
+			}
+			validTo = &t
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO supplier_prices (id, tenant_id, supplier_item_id, min_qty, unit_cost, valid_from, valid_to, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5::numeric, $6, $7, NOW(), NOW())
+		`, uuid.New().String(), tenantID, supplierItemID, minQty, cost.StringFixed(2), validFrom, validTo)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to save price tier")
+		}
+	}
+	return nil
+}
+
+// loadSupplierItems returns catalog entries with their price tiers; pass a non-empty
+// supplierItemID to load a single entry.
+func (h *Handler) loadSupplierItems(c echo.Context, supplierID, tenantID, supplierItemID string) ([]SupplierItem, error) {
+	query := `
+		SELECT si.id, si.supplier_id, si.item_id, si.supplier_sku, si.pack_size, si.moq, si.lead_time_days,
+			si.is_active, si.created_at, si.updated_at, i.sku, i.name
+		FROM supplier_items si
+		LEFT JOIN items i ON si.item_id = i.id
+		WHERE si.supplier_id = $1 AND si.tenant_id = $2`
+	args := []interface{}{supplierID, tenantID}
+	if supplierItemID != "" {
+		query += " AND si.id = $3"
+		args = append(args, supplierItemID)
+	}
+	query += " ORDER BY i.sku ASC"
+
+	rows, err := h.db(c).Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []SupplierItem{}
+	index := map[string]int{}
+	for rows.Next() {
+		var si SupplierItem
+		var supplierSKU, sku, name sql.NullString
+		if err := rows.Scan(&si.ID, &si.SupplierID, &si.ItemID, &supplierSKU, &si.PackSize, &si.MOQ, &si.LeadTimeDays,
+			&si.IsActive, &si.CreatedAt, &si.UpdatedAt, &sku, &name); err != nil {
+			return nil, err
+		}
+		if supplierSKU.Valid {
+			si.SupplierSKU = &supplierSKU.String
+		}
+		if sku.Valid {
+			si.Item = &Item{ID: si.ItemID, SKU: sku.String, Name: name.String}
+		}
+		si.Prices = []SupplierPrice{}
+		index[si.ID] = len(items)
+		items = append(items, si)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return items, nil
+	}
+
+	priceQuery := `
+		SELECT sp.id, sp.supplier_item_id, sp.min_qty, sp.unit_cost, sp.valid_from, sp.valid_to
+		FROM supplier_prices sp
+		INNER JOIN supplier_items si ON si.id = sp.supplier_item_id
+		WHERE si.supplier_id = $1 AND si.tenant_id = $2`
+	if supplierItemID != "" {
+		priceQuery += " AND si.id = $3"
+	}
+	priceQuery += " ORDER BY sp.valid_from ASC, sp.min_qty ASC"
+
+	priceRows, err := h.db(c).Query(priceQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer priceRows.Close()
+
+	for priceRows.Next() {
+		var p SupplierPrice
+		var parentID string
+		var validFrom time.Time
+		var validTo sql.NullTime
+		if err := priceRows.Scan(&p.ID, &parentID, &p.MinQty, &p.UnitCost, &validFrom, &validTo); err != nil {
+			return nil, err
+		}
+		p.ValidFrom = validFrom.Format(priceDateLayout)
+		if validTo.Valid {
+			s := validTo.Time.Format(priceDateLayout)
+			p.ValidTo = &s
+		}
+		if i, ok := index[parentID]; ok {
+			items[i].Prices = append(items[i].Prices, p)
+		}
+	}
+
+	return items, priceRows.Err()
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePriceListCSV(t *testing.T) {
+	t.Run("Tiers and defaults", func(t *testing.T) {
+		input := "item_sku,unit_cost,min_qty,valid_from,valid_to,moq\n" +
+			"MOUSE-001,15.00,1,2026-01-01,,10\n" +
+			"MOUSE-001,12.50,100,2026-01-01,2026-12-31,10\n" +
+			"\n" +
+			"PAPER-001,,,,,\n"
+
+		rows, columns, err := parsePriceListCSV(strings.NewReader(input))
+		require.NoError(t, err)
+		require.Len(t, rows, 3)
+		assert.True(t, columns["moq"])
+		assert.False(t, columns["pack_size"])
+
+		assert.Equal(t, "MOUSE-001", rows[0].ItemSKU)
+		assert.Equal(t, 1, rows[0].MinQty)
+		assert.Equal(t, 10, rows[0].MOQ)
+		assert.Equal(t, 1, rows[0].PackSize)
+		assert.Equal(t, "15", rows[0].UnitCost.String())
+		assert.Nil(t, rows[0].ValidTo)
+
+		assert.Equal(t, 100, rows[1].MinQty)
+		require.NotNil(t, rows[1].ValidTo)
+		assert.Equal(t, "2026-12-31", rows[1].ValidTo.Format(priceDateLayout))
+
+		// Terms-only row without a price
+		assert.Equal(t, "PAPER-001", rows[2].ItemSKU)
+		assert.Nil(t, rows[2].UnitCost)
+		assert.Equal(t, 5, rows[2].Line)
+
+		// Only MOUSE-001 has its tiers replaced
+		assert.True(t, hasPricedRow(rows[:2]))
+		assert.False(t, hasPricedRow(rows[2:]))
+	})
+
+	t.Run("Missing item_sku column", func(t *testing.T) {
+		_, _, err := parsePriceListCSV(strings.NewReader("sku,unit_cost\nA,1\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid values report the line", func(t *testing.T) {
+		_, _, err := parsePriceListCSV(strings.NewReader("item_sku,unit_cost\nA,1\nB,abc\n"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "line 3")
+
+		_, _, err = parsePriceListCSV(strings.NewReader("item_sku,valid_from,valid_to\nA,2026-02-01,2026-01-01\n"))
+		assert.Error(t, err)
+
+		_, _, err = parsePriceListCSV(strings.NewReader("item_sku,min_qty\nA,0\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestPriceListTermUpdates(t *testing.T) {
+	// Columns missing from the file keep the existing catalog terms
+	assert.Equal(t, "moq = EXCLUDED.moq, is_active = TRUE, updated_at = NOW()",
+		priceListTermUpdates(map[string]bool{"item_sku": true, "moq": true, "unit_cost": true}))
+	assert.Equal(t, "is_active = TRUE, updated_at = NOW()", priceListTermUpdates(map[string]bool{"item_sku": true}))
+	assert.Equal(t,
+		"supplier_sku = EXCLUDED.supplier_sku, pack_size = EXCLUDED.pack_size, moq = EXCLUDED.moq, lead_time_days = EXCLUDED.lead_time_days, is_active = TRUE, updated_at = NOW()",
+		priceListTermUpdates(map[string]bool{"supplier_sku": true, "pack_size": true, "moq": true, "lead_time_days": true}))
+}
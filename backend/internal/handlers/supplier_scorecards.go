@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+)
+
+// defaultScorecardDays is the period used when no from date is given.
+const defaultScorecardDays = 90
+
+// Scorecard component weights. Components without data are left out and the
+// remaining weights are scaled up.
+const (
+	onTimeWeight   = 0.4
+	fillRateWeight = 0.4
+	priceWeight    = 0.2
+)
+
+// SupplierScorecard summarises how a supplier performed on the purchase orders
+// created in a period. Percentages are nil when there is nothing to measure.
+type SupplierScorecard struct {
+	Rank             int             `json:"rank,omitempty"`
+	SupplierID       string          `json:"supplier_id"`
+	SupplierCode     string          `json:"supplier_code"`
+	SupplierName     string          `json:"supplier_name"`
+	From             string          `json:"from"`
+	To               string          `json:"to"`
+	Orders           int             `json:"orders"`
+	DueOrders        int             `json:"due_orders"`
+	OnTimeOrders     int             `json:"on_time_orders"`
+	OnTimePct        *float64        `json:"on_time_pct"`
+	QtyOrdered       int             `json:"qty_ordered"`
+	QtyReceived      int             `json:"qty_received"`
+	QtyReturned      int             `json:"qty_returned"`
+	FillRatePct      *float64        `json:"fill_rate_pct"`
+	ReturnRatePct    *float64        `json:"return_rate_pct"`
+	AvgLeadTimeDays  *float64        `json:"avg_lead_time_days"`
+	PriceVariance    decimal.Decimal `json:"price_variance"`
+	PriceVariancePct *float64        `json:"price_variance_pct"`
+	Score            *float64        `json:"score"`
+}
+
+// scorecardQuery aggregates per supplier over purchase orders created in
+// [$2, $3). A PO counts as delivered on the posting date of its first linked
+// receipt, and is due once delivered or past its expected date. Price variance
+// compares received unit costs with the ordered unit cost of the same item.
+const scorecardQuery = `
+	WITH po AS (
+		SELECT id, supplier_id, expected_at, COALESCE(approved_at, created_at) AS ordered_at
+		FROM purchase_orders
+		WHERE tenant_id = $1 AND status NOT IN ('DRAFT', 'CANCELED')
+			AND created_at >= $2 AND created_at < $3 %s
+	),
+	delivery AS (
+		SELECT gr.purchase_order_id, MIN(gr.posted_at) AS first_posted_at
+		FROM goods_receipts gr
+		JOIN po ON po.id = gr.purchase_order_id
+		WHERE gr.status IN ('POSTED', 'CLOSED') AND gr.posted_at IS NOT NULL
+		GROUP BY gr.purchase_order_id
+	),
+	qty AS (
+		SELECT pol.purchase_order_id,
+			SUM(pol.qty_ordered) AS ordered,
+			SUM(pol.qty_received) AS received,
+			SUM(pol.qty_returned) AS returned
+		FROM purchase_order_lines pol
+		JOIN po ON po.id = pol.purchase_order_id
+		GROUP BY pol.purchase_order_id
+	),
+	price AS (
+		SELECT po.supplier_id,
+			SUM(grl.qty * ordered.unit_cost) AS ordered_cost,
+			SUM(grl.qty * grl.unit_cost) AS received_cost
+		FROM goods_receipt_lines grl
+		JOIN goods_receipts gr ON gr.id = grl.receipt_id AND gr.status IN ('POSTED', 'CLOSED')
+		JOIN po ON po.id = gr.purchase_order_id
+		JOIN LATERAL (
+			SELECT unit_cost FROM purchase_order_lines
+			WHERE purchase_order_id = po.id AND item_id = grl.item_id
+			ORDER BY created_at
+			LIMIT 1
+		) ordered ON TRUE
+		WHERE grl.unit_cost IS NOT NULL
+		GROUP BY po.supplier_id
+	)
+	SELECT s.id, s.code, s.name,
+		COUNT(po.id),
+		COUNT(po.id) FILTER (WHERE po.expected_at IS NOT NULL AND (d.first_posted_at IS NOT NULL OR po.expected_at < NOW())),
+		COUNT(po.id) FILTER (WHERE po.expected_at IS NOT NULL AND d.first_posted_at::date <= po.expected_at::date),
+		COALESCE(SUM(q.ordered), 0), COALESCE(SUM(q.received), 0), COALESCE(SUM(q.returned), 0),
+		AVG(EXTRACT(EPOCH FROM (d.first_posted_at - po.ordered_at)) / 86400),
+		COALESCE(pr.ordered_cost, 0), COALESCE(pr.received_cost, 0)
+	FROM suppliers s
+	JOIN po ON po.supplier_id = s.id
+	LEFT JOIN delivery d ON d.purchase_order_id = po.id
+	LEFT JOIN qty q ON q.purchase_order_id = po.id
+	LEFT JOIN price pr ON pr.supplier_id = s.id
+	GROUP BY s.id, s.code, s.name, pr.ordered_cost, pr.received_cost`
+
+// GetSupplierScorecard returns the scorecard of one supplier (?from=&to=).
+func (h *Handler) GetSupplierScorecard(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	supplierID := c.Param("id")
+
+	from, to, err := parseScorecardPeriod(c.QueryParam("from"), c.QueryParam("to"), time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.ensureSupplierInTenant(h.db(c), supplierID, claims.TenantID); err != nil {
+		return err
+	}
+
+	cards, err := h.loadSupplierScorecards(c, claims.TenantID, supplierID, from, to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to compute scorecard")
+	}
+	if len(cards) > 0 {
+		return c.JSON(http.StatusOK, cards[0])
+	}
+
+	// No purchase orders in the period: return an empty scorecard
+	card := SupplierScorecard{SupplierID: supplierID, From: from.Format(time.DateOnly), To: to.AddDate(0, 0, -1).Format(time.DateOnly)}
+	if err := h.db(c).QueryRow(`SELECT code, name FROM suppliers WHERE id = $1`, supplierID).Scan(&card.SupplierCode, &card.SupplierName); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, card)
+}
+
+// ListSupplierScorecards ranks the suppliers that had purchase orders in the
+// period (?from=&to=&sort=score|on_time|fill_rate|lead_time|price_variance&limit=).
+func (h *Handler) ListSupplierScorecards(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	from, to, err := parseScorecardPeriod(c.QueryParam("from"), c.QueryParam("to"), time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	sortBy := c.QueryParam("sort")
+	if sortBy == "" {
+		sortBy = "score"
+	}
+	if _, ok := scorecardSortKeys[sortBy]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "sort must be one of score, on_time, fill_rate, lead_time, price_variance")
+	}
+
+	cards, err := h.loadSupplierScorecards(c, claims.TenantID, "", from, to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to compute scorecards")
+	}
+	rankScorecards(cards, sortBy)
+
+	if limit, _ := strconv.Atoi(c.QueryParam("limit")); limit > 0 && limit < len(cards) {
+		cards = cards[:limit]
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"from": from.Format(time.DateOnly),
+		"to":   to.AddDate(0, 0, -1).Format(time.DateOnly),
+		"sort": sortBy,
+		"data": cards,
+	})
+}
+
+// loadSupplierScorecards computes scorecards for all suppliers of the tenant, or
+// only supplierID when given. to is exclusive.
+func (h *Handler) loadSupplierScorecards(c echo.Context, tenantID, supplierID string, from, to time.Time) ([]SupplierScorecard, error) {
+	args := []interface{}{tenantID, from, to}
+	supplierFilter := ""
+	if supplierID != "" {
+		args = append(args, supplierID)
+		supplierFilter = "AND supplier_id = $4"
+	}
+
+	rows, err := h.db(c).Query(fmt.Sprintf(scorecardQuery, supplierFilter), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cards := []SupplierScorecard{}
+	for rows.Next() {
+		card := SupplierScorecard{From: from.Format(time.DateOnly), To: to.AddDate(0, 0, -1).Format(time.DateOnly)}
+		var avgLead sql.NullFloat64
+		var orderedCost, receivedCost decimal.Decimal
+		if err := rows.Scan(
+			&card.SupplierID, &card.SupplierCode, &card.SupplierName,
+			&card.Orders, &card.DueOrders, &card.OnTimeOrders,
+			&card.QtyOrdered, &card.QtyReceived, &card.QtyReturned,
+			&avgLead, &orderedCost, &receivedCost,
+		); err != nil {
+			return nil, err
+		}
+		if avgLead.Valid {
+			card.AvgLeadTimeDays = round2(avgLead.Float64)
+		}
+		card.PriceVariance = receivedCost.Sub(orderedCost)
+		if orderedCost.IsPositive() {
+			pct, _ := card.PriceVariance.Div(orderedCost).Mul(decimal.NewFromInt(100)).Float64()
+			card.PriceVariancePct = round2(pct)
+		}
+		computeScorecard(&card)
+		cards = append(cards, card)
+	}
+	return cards, rows.Err()
+}
+
+// computeScorecard fills the derived percentages and the overall score. The
+// score is a weighted 0-100 blend of on-time %, fill rate and a price component
+// that loses one point per percent paid above the ordered price.
+func computeScorecard(card *SupplierScorecard) {
+	if card.DueOrders > 0 {
+		card.OnTimePct = round2(float64(card.OnTimeOrders) * 100 / float64(card.DueOrders))
+	}
+	if card.QtyOrdered > 0 {
+		card.FillRatePct = round2(float64(card.QtyReceived-card.QtyReturned) * 100 / float64(card.QtyOrdered))
+	}
+	if card.QtyReceived > 0 {
+		card.ReturnRatePct = round2(float64(card.QtyReturned) * 100 / float64(card.QtyReceived))
+	}
+
+	var total, weights float64
+	if card.OnTimePct != nil {
+		total += *card.OnTimePct * onTimeWeight
+		weights += onTimeWeight
+	}
+	if card.FillRatePct != nil {
+		total += math.Min(*card.FillRatePct, 100) * fillRateWeight
+		weights += fillRateWeight
+	}
+	if card.PriceVariancePct != nil {
+		total += math.Max(0, 100-math.Max(*card.PriceVariancePct, 0)) * priceWeight
+		weights += priceWeight
+	}
+	if weights > 0 {
+		card.Score = round2(total / weights)
+	}
+}
+
+// scorecardSortKeys returns the metric to rank by and whether higher is better.
+var scorecardSortKeys = map[string]func(card SupplierScorecard) (*float64, bool){
+	"score":          func(card SupplierScorecard) (*float64, bool) { return card.Score, true },
+	"on_time":        func(card SupplierScorecard) (*float64, bool) { return card.OnTimePct, true },
+	"fill_rate":      func(card SupplierScorecard) (*float64, bool) { return card.FillRatePct, true },
+	"lead_time":      func(card SupplierScorecard) (*float64, bool) { return card.AvgLeadTimeDays, false },
+	"price_variance": func(card SupplierScorecard) (*float64, bool) { return card.PriceVariancePct, false },
+}
+
+// rankScorecards sorts cards best-first by the given metric and assigns ranks.
+// Suppliers without a value for the metric are ranked last.
+func rankScorecards(cards []SupplierScorecard, sortBy string) {
+	metric := scorecardSortKeys[sortBy]
+	sort.SliceStable(cards, func(i, j int) bool {
+		a, higherIsBetter := metric(cards[i])
+		b, _ := metric(cards[j])
+		switch {
+		case a == nil || b == nil:
+			if a != nil || b != nil {
+				return a != nil
+			}
+		case *a != *b:
+			if higherIsBetter {
+				return *a > *b
+			}
+			return *a < *b
+		}
+		return cards[i].SupplierName < cards[j].SupplierName
+	})
+	for i := range cards {
+		cards[i].Rank = i + 1
+	}
+}
+
+// parseScorecardPeriod parses the inclusive from/to dates. It defaults to the
+// last defaultScorecardDays days and returns to as an exclusive bound.
+func parseScorecardPeriod(fromParam, toParam string, now time.Time) (time.Time, time.Time, error) {
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if toParam != "" {
+		t, err := time.Parse(time.DateOnly, toParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date, expected YYYY-MM-DD")
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -defaultScorecardDays)
+	if fromParam != "" {
+		f, err := time.Parse(time.DateOnly, fromParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from date, expected YYYY-MM-DD")
+		}
+		from = f
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+func round2(v float64) *float64 {
+	r := math.Round(v*100) / 100
+	return &r
+}
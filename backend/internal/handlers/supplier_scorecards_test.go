@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeScorecard(t *testing.T) {
+	variance := 10.0
+	card := SupplierScorecard{
+		DueOrders:        4,
+		OnTimeOrders:     3,
+		QtyOrdered:       100,
+		QtyReceived:      90,
+		QtyReturned:      9,
+		PriceVariancePct: &variance,
+	}
+	computeScorecard(&card)
+
+	require.NotNil(t, card.OnTimePct)
+	assert.Equal(t, 75.0, *card.OnTimePct)
+	require.NotNil(t, card.FillRatePct)
+	assert.Equal(t, 81.0, *card.FillRatePct)
+	require.NotNil(t, card.ReturnRatePct)
+	assert.Equal(t, 10.0, *card.ReturnRatePct)
+	require.NotNil(t, card.Score)
+	// 75*0.4 + 81*0.4 + 90*0.2
+	assert.Equal(t, 80.4, *card.Score)
+
+	t.Run("Missing components are left out", func(t *testing.T) {
+		card := SupplierScorecard{QtyOrdered: 10, QtyReceived: 5}
+		computeScorecard(&card)
+		assert.Nil(t, card.OnTimePct)
+		require.NotNil(t, card.Score)
+		assert.Equal(t, 50.0, *card.Score)
+	})
+}
+
+func TestRankScorecards(t *testing.T) {
+	lead := func(v float64) *float64 { return &v }
+	cards := []SupplierScorecard{
+		{SupplierName: "Slow", AvgLeadTimeDays: lead(12)},
+		{SupplierName: "Unknown"},
+		{SupplierName: "Fast", AvgLeadTimeDays: lead(3)},
+	}
+	rankScorecards(cards, "lead_time")
+
+	assert.Equal(t, "Fast", cards[0].SupplierName)
+	assert.Equal(t, "Slow", cards[1].SupplierName)
+	assert.Equal(t, "Unknown", cards[2].SupplierName)
+	assert.Equal(t, 3, cards[2].Rank)
+}
+
+func TestParseScorecardPeriod(t *testing.T) {
+	now := time.Date(2026, 3, 31, 15, 0, 0, 0, time.UTC)
+
+	from, to, err := parseScorecardPeriod("", "", now)
+	require.NoError(t, err)
+	assert.Equal(t, "2025-12-31", from.Format(time.DateOnly))
+	assert.Equal(t, "2026-04-01", to.Format(time.DateOnly))
+
+	_, _, err = parseScorecardPeriod("2026-04-01", "2026-03-01", now)
+	assert.Error(t, err)
+
+	_, _, err = parseScorecardPeriod("yesterday", "", now)
+	assert.Error(t, err)
+}
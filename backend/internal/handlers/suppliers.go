@@ -9,6 +9,8 @@ import (
 	"strconv"
 	"strings"
 
+	appmw "inventory/internal/middleware"
+
 	"github.com/labstack/echo/v4"
 )
 
@@ -21,6 +23,11 @@ type SupplierModel struct {
 }
 
 func (h *Handler) ListSuppliers(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
 	// Parse query parameters
 	page, _ := strconv.Atoi(c.QueryParam("page"))
 	if page <= 0 {
@@ -40,10 +47,10 @@ func (h *Handler) ListSuppliers(c echo.Context) error {
 	query := `
 		SELECT id, code, name, contact, is_active
 		FROM suppliers
-		WHERE 1=1`
+		WHERE tenant_id = $1`
 
-	args := []interface{}{}
-	argCount := 0
+	args := []interface{}{claims.TenantID}
+	argCount := 1
 
 	if search != "" {
 		argCount++
@@ -69,7 +76,7 @@ func (h *Handler) ListSuppliers(c echo.Context) error {
 	query += fmt.Sprintf(" OFFSET $%d", argCount)
 	args = append(args, offset)
 
-	rows, err := h.DB.Query(query, args...)
+	rows, err := h.db(c).Query(query, args...)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -95,9 +102,9 @@ func (h *Handler) ListSuppliers(c echo.Context) error {
 	}
 
 	// Get total count
-	countQuery := `SELECT COUNT(*) FROM suppliers WHERE 1=1`
-	countArgs := []interface{}{}
-	countArgCount := 0
+	countQuery := `SELECT COUNT(*) FROM suppliers WHERE tenant_id = $1`
+	countArgs := []interface{}{claims.TenantID}
+	countArgCount := 1
 
 	if search != "" {
 		countArgCount++
@@ -113,7 +120,7 @@ func (h *Handler) ListSuppliers(c echo.Context) error {
 	}
 
 	var total int
-	err = h.DB.QueryRow(countQuery, countArgs...).Scan(&total)
+	err = h.db(c).QueryRow(countQuery, countArgs...).Scan(&total)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -130,6 +137,10 @@ func (h *Handler) ListSuppliers(c echo.Context) error {
 }
 
 func (h *Handler) CreateSupplier(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
 	var req struct {
 		Code     string                 `json:"code" validate:"required"`
 		Name     string                 `json:"name" validate:"required"`
@@ -160,8 +171,8 @@ func (h *Handler) CreateSupplier(c echo.Context) error {
 	}
 
 	query := `
-        INSERT INTO suppliers (code, name, contact, is_active, created_at, updated_at)
-        VALUES ($1, $2, $3, $4, NOW(), NOW())
+        INSERT INTO suppliers (code, name, contact, is_active, tenant_id, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
         RETURNING id, code, name, contact, is_active
     `
 
@@ -173,13 +184,25 @@ func (h *Handler) CreateSupplier(c echo.Context) error {
 		contact    sql.NullString
 	)
 
-	err := h.DB.QueryRow(query, req.Code, req.Name, nullableJSON(contactJSON), isActive).Scan(&id, &code, &name, &contact, &isActiveDB)
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(query, req.Code, req.Name, nullableJSON(contactJSON), isActive, claims.TenantID).Scan(&id, &code, &name, &contact, &isActiveDB)
 	if err != nil {
 		if isUniqueViolation(err) {
 			return echo.NewHTTPError(http.StatusConflict, "supplier code already exists")
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
+	if err := recordAudit(c, tx, AuditCreate, "supplier", id, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 
 	resp := SupplierModel{ID: id, Code: code, Name: name, IsActive: isActiveDB}
 	if contact.Valid {
@@ -190,13 +213,17 @@ func (h *Handler) CreateSupplier(c echo.Context) error {
 
 func (h *Handler) GetSupplier(c echo.Context) error {
 	id := c.Param("id")
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
 
 	var s SupplierModel
 	var contact sql.NullString
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
         SELECT id, code, name, contact, is_active
-        FROM suppliers WHERE id = $1
-    `, id).Scan(&s.ID, &s.Code, &s.Name, &contact, &s.IsActive)
+        FROM suppliers WHERE id = $1 AND tenant_id = $2
+    `, id, claims.TenantID).Scan(&s.ID, &s.Code, &s.Name, &contact, &s.IsActive)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return echo.NewHTTPError(http.StatusNotFound, "supplier not found")
@@ -211,6 +238,10 @@ func (h *Handler) GetSupplier(c echo.Context) error {
 
 func (h *Handler) UpdateSupplier(c echo.Context) error {
 	id := c.Param("id")
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
 	var req struct {
 		Code     *string                `json:"code"`
 		Name     *string                `json:"name"`
@@ -255,13 +286,24 @@ func (h *Handler) UpdateSupplier(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "no fields to update")
 	}
 	sets = append(sets, fmt.Sprintf("updated_at = NOW()"))
-	args = append(args, id)
+	args = append(args, id, claims.TenantID)
+
+	query := fmt.Sprintf(`UPDATE suppliers SET %s WHERE id = $%d AND tenant_id = $%d RETURNING id, code, name, contact, is_active`, strings.Join(sets, ", "), idx, idx+1)
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
 
-	query := fmt.Sprintf(`UPDATE suppliers SET %s WHERE id = $%d RETURNING id, code, name, contact, is_active`, strings.Join(sets, ", "), idx)
+	before, err := auditSnapshot(c, tx, "supplier", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 
 	var out SupplierModel
 	var contact sql.NullString
-	if err := h.DB.QueryRow(query, args...).Scan(&out.ID, &out.Code, &out.Name, &contact, &out.IsActive); err != nil {
+	if err := tx.QueryRow(query, args...).Scan(&out.ID, &out.Code, &out.Name, &contact, &out.IsActive); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return echo.NewHTTPError(http.StatusNotFound, "supplier not found")
 		}
@@ -270,6 +312,12 @@ func (h *Handler) UpdateSupplier(c echo.Context) error {
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
+	if err := recordAudit(c, tx, AuditUpdate, "supplier", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 	if contact.Valid {
 		out.Contact = contact.String
 	}
@@ -278,7 +326,22 @@ func (h *Handler) UpdateSupplier(c echo.Context) error {
 
 func (h *Handler) DeleteSupplier(c echo.Context) error {
 	id := c.Param("id")
-	res, err := h.DB.Exec(`DELETE FROM suppliers WHERE id = $1`, id)
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "supplier", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+
+	res, err := tx.Exec(`DELETE FROM suppliers WHERE id = $1 AND tenant_id = $2`, id, claims.TenantID)
 	if err != nil {
 		// FK conflict or others
 		return echo.NewHTTPError(http.StatusConflict, "cannot delete supplier (in use)")
@@ -287,6 +350,12 @@ func (h *Handler) DeleteSupplier(c echo.Context) error {
 	if n == 0 {
 		return echo.NewHTTPError(http.StatusNotFound, "supplier not found")
 	}
+	if err := recordAudit(c, tx, AuditDelete, "supplier", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"inventory/internal/middleware"
+	"inventory/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+type SchedulePurgeRequest struct {
+	// The tenant's slug, to confirm the right tenant is being deleted
+	Confirm string `json:"confirm" validate:"required"`
+}
+
+// systemAuditEntry describes a system administration action on a tenant by
+// the requesting user
+func systemAuditEntry(c echo.Context, action string, tenant *services.PurgeSchedule, details map[string]interface{}) services.SystemAuditEntry {
+	id := tenant.TenantID.String()
+	e := services.SystemAuditEntry{
+		Action:     action,
+		TenantID:   &id,
+		TenantSlug: &tenant.Slug,
+		TenantName: &tenant.Name,
+		Details:    details,
+	}
+	if claims, err := middleware.GetUserClaims(c); err == nil {
+		e.ActorID, e.ActorEmail = &claims.UserID, &claims.Email
+	}
+	requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+	if requestID == "" {
+		requestID = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+	if requestID != "" {
+		e.RequestID = &requestID
+	}
+	ip := c.RealIP()
+	e.IPAddress = &ip
+	return e
+}
+
+// runTenantExports produces queued exports in the background
+func (h *Handler) runTenantExports() {
+	go func() {
+		if _, err := h.TenantData.RunExports(services.SystemScope(context.Background())); err != nil {
+			log.Error().Err(err).Msg("Failed to run tenant exports")
+		}
+	}()
+}
+
+// ExportTenant queues an export of all of a tenant's data, produced in the
+// background
+func (h *Handler) ExportTenant(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant ID")
+	}
+	claims, errClaims := middleware.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	ctx := c.Request().Context()
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	tenant, err := services.NewTenantService(tx).GetPurgeSchedule(ctx, id)
+	if errors.Is(err, services.ErrTenantNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	export, err := h.TenantData.RequestExport(ctx, tx, id, claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	entry := systemAuditEntry(c, services.SystemAuditExportRequested, tenant, map[string]interface{}{"export_id": export.ID})
+	if err := services.NewAuditService(tx).RecordSystem(ctx, entry); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
+	}
+	h.runTenantExports()
+
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"data": export,
+	})
+}
+
+// ListTenantExports returns a tenant's exports, newest first
+func (h *Handler) ListTenantExports(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant ID")
+	}
+	exports, err := h.TenantData.ListExports(c.Request().Context(), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": exports,
+	})
+}
+
+// getTenantExport loads the export named in the path
+func (h *Handler) getTenantExport(c echo.Context) (*services.TenantExport, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant ID")
+	}
+	exportID, err := uuid.Parse(c.Param("exportId"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid export ID")
+	}
+	export, err := h.TenantData.GetExport(c.Request().Context(), id, exportID)
+	if errors.Is(err, services.ErrExportNotFound) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return export, nil
+}
+
+// GetTenantExport returns an export and its status
+func (h *Handler) GetTenantExport(c echo.Context) error {
+	export, err := h.getTenantExport(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": export,
+	})
+}
+
+// DownloadTenantExport sends a finished export's ZIP
+func (h *Handler) DownloadTenantExport(c echo.Context) error {
+	export, err := h.getTenantExport(c)
+	if err != nil {
+		return err
+	}
+	if export.Status != services.ExportCompleted || export.FilePath == nil {
+		return echo.NewHTTPError(http.StatusConflict, "export is "+export.Status)
+	}
+	name := "tenant-" + export.TenantID.String() + "-" + export.CreatedAt.UTC().Format("20060102-150405") + ".zip"
+	return c.Attachment(*export.FilePath, name)
+}
+
+// GetTenantPurge returns a tenant's purge schedule
+func (h *Handler) GetTenantPurge(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant ID")
+	}
+	schedule, err := services.NewTenantService(h.DB).GetPurgeSchedule(c.Request().Context(), id)
+	if errors.Is(err, services.ErrTenantNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": schedule,
+	})
+}
+
+// ScheduleTenantPurge deactivates a tenant and schedules all its data to be
+// deleted once the grace period ends
+func (h *Handler) ScheduleTenantPurge(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant ID")
+	}
+	claims, errClaims := middleware.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	var req SchedulePurgeRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request format")
+	}
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	ctx := c.Request().Context()
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	tenants := services.NewTenantService(tx)
+	current, err := tenants.GetPurgeSchedule(ctx, id)
+	if errors.Is(err, services.ErrTenantNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if req.Confirm != current.Slug {
+		return echo.NewHTTPError(http.StatusBadRequest, "confirm must be the tenant's slug")
+	}
+
+	schedule, err := tenants.SchedulePurge(ctx, id, claims.UserID, time.Now().Add(h.Config.TenantPurgeGrace))
+	switch {
+	case errors.Is(err, services.ErrTenantNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrPurgeScheduled):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	entry := systemAuditEntry(c, services.SystemAuditPurgeScheduled, schedule, map[string]interface{}{
+		"purge_after": schedule.PurgeAfter,
+		"was_active":  current.IsActive,
+	})
+	if err := services.NewAuditService(tx).RecordSystem(ctx, entry); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
+	}
+	h.Tenants.Invalidate()
+
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"data": schedule,
+	})
+}
+
+// CancelTenantPurge cancels a scheduled purge during its grace period and
+// reactivates the tenant
+func (h *Handler) CancelTenantPurge(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant ID")
+	}
+	ctx := c.Request().Context()
+
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	tenants := services.NewTenantService(tx)
+	current, err := tenants.GetPurgeSchedule(ctx, id)
+	if errors.Is(err, services.ErrTenantNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	schedule, err := tenants.CancelPurge(ctx, id)
+	switch {
+	case errors.Is(err, services.ErrTenantNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrPurgeNotScheduled):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	entry := systemAuditEntry(c, services.SystemAuditPurgeCanceled, schedule, map[string]interface{}{
+		"purge_after": current.PurgeAfter,
+	})
+	if err := services.NewAuditService(tx).RecordSystem(ctx, entry); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
+	}
+	h.Tenants.Invalidate()
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": schedule,
+	})
+}
+
+// GetSystemAuditLogs lists the system audit trail, newest first
+// (?tenant_id=&page=&page_size=)
+func (h *Handler) GetSystemAuditLogs(c echo.Context) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page <= 0 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.QueryParam("page_size"))
+	if pageSize <= 0 || pageSize > h.Config.MaxPageSize {
+		pageSize = h.Config.DefaultPageSize
+	}
+	tenantID := c.QueryParam("tenant_id")
+	if tenantID != "" {
+		id, err := uuid.Parse(tenantID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant ID")
+		}
+		tenantID = id.String()
+	}
+
+	entries, total, err := services.NewAuditService(h.DB).ListSystemAudit(c.Request().Context(), tenantID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, PaginatedResponse{
+		Data:       entries,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+		Total:      total,
+	})
+}
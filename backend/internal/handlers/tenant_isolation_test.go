@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"inventory/internal/config"
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// isolationTestDB connects to the migrated database in TEST_DATABASE_URL.
+// Superusers and BYPASSRLS roles are not subject to row level security, so
+// for them the connection switches to a role that is.
+func isolationTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := services.OpenDB(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	// One connection, so SET ROLE holds for every statement
+	db.SetMaxOpenConns(1)
+
+	var bypass bool
+	require.NoError(t, db.QueryRow(`SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user`).Scan(&bypass))
+	if bypass {
+		for _, query := range []string{
+			`DO $$ BEGIN
+				IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'inventory_rls_test') THEN
+					CREATE ROLE inventory_rls_test NOLOGIN;
+				END IF;
+			END $$`,
+			`GRANT USAGE ON SCHEMA public TO inventory_rls_test`,
+			`GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO inventory_rls_test`,
+			`SET ROLE inventory_rls_test`,
+		} {
+			_, err := db.Exec(query)
+			require.NoError(t, err, query)
+		}
+	}
+	return db
+}
+
+type isolationTenant struct {
+	id, locationID, supplierID, poID, batchID string
+}
+
+func seedIsolationTenant(t *testing.T, db *sql.DB) isolationTenant {
+	ctx := services.SystemScope(context.Background())
+	suffix := uuid.NewString()[:8]
+	tn := isolationTenant{
+		id:         uuid.NewString(),
+		locationID: uuid.NewString(),
+		supplierID: uuid.NewString(),
+		poID:       uuid.NewString(),
+		batchID:    uuid.NewString(),
+	}
+	for _, seed := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO tenants (id, name, slug, is_active) VALUES ($1, $2, $2, true)`, []interface{}{tn.id, "iso-" + suffix}},
+		{`INSERT INTO locations (id, tenant_id, code, name) VALUES ($1, $2, $3, $3)`, []interface{}{tn.locationID, tn.id, "ISO-" + suffix}},
+		{`INSERT INTO suppliers (id, tenant_id, code, name) VALUES ($1, $2, $3, $3)`, []interface{}{tn.supplierID, tn.id, "ISO-" + suffix}},
+		{`INSERT INTO purchase_orders (id, tenant_id, number, supplier_id, status) VALUES ($1, $2, $3, $4, 'DRAFT')`, []interface{}{tn.poID, tn.id, "PO-ISO-" + suffix, tn.supplierID}},
+		{`INSERT INTO count_batches (id, tenant_id, number, location_id) VALUES ($1, $2, $3, $4)`, []interface{}{tn.batchID, tn.id, "CB-ISO-" + suffix, tn.locationID}},
+	} {
+		_, err := db.ExecContext(ctx, seed.query, seed.args...)
+		require.NoError(t, err, seed.query)
+	}
+	t.Cleanup(func() {
+		db.ExecContext(ctx, `DELETE FROM count_batches WHERE id = $1`, tn.batchID)
+		db.ExecContext(ctx, `DELETE FROM purchase_orders WHERE id = $1`, tn.poID)
+		db.ExecContext(ctx, `DELETE FROM suppliers WHERE id = $1`, tn.supplierID)
+		db.ExecContext(ctx, `DELETE FROM locations WHERE id = $1`, tn.locationID)
+		db.ExecContext(ctx, `DELETE FROM tenants WHERE id = $1`, tn.id)
+	})
+	return tn
+}
+
+// tenantRequest returns a request by an admin of tenantID, with its tenant
+// and claims set as the JWT middleware sets them
+func tenantRequest(tenantID, method, body, param, id string) echo.Context {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req = req.WithContext(appmw.SetTenantID(req.Context(), uuid.MustParse(tenantID)))
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	c.Set("user", &appmw.Claims{UserID: uuid.NewString(), TenantID: tenantID, Role: appmw.RoleAdmin})
+	c.SetParamNames(param)
+	c.SetParamValues(id)
+	return c
+}
+
+func TestTenantIsolation(t *testing.T) {
+	db := isolationTestDB(t)
+	h := &Handler{DB: db, Config: &config.Config{}}
+	a := seedIsolationTenant(t, db)
+	b := seedIsolationTenant(t, db)
+
+	for name, tc := range map[string]struct {
+		handler   echo.HandlerFunc
+		method    string
+		param, id string
+		body      string
+	}{
+		"get supplier":       {h.GetSupplier, http.MethodGet, "id", b.supplierID, ""},
+		"update supplier":    {h.UpdateSupplier, http.MethodPut, "id", b.supplierID, `{"name": "hijacked"}`},
+		"delete supplier":    {h.DeleteSupplier, http.MethodDelete, "id", b.supplierID, ""},
+		"get purchase order": {h.GetPurchaseOrder, http.MethodGet, "id", b.poID, ""},
+		"update purchase order": {h.UpdatePurchaseOrder, http.MethodPut, "id", b.poID,
+			`{"supplier_id": "` + a.supplierID + `", "notes": "hijacked", "lines": []}`},
+		"delete purchase order": {h.DeletePurchaseOrder, http.MethodDelete, "id", b.poID, ""},
+		"get count batch":       {h.ListCountLines, http.MethodGet, "batch_id", b.batchID, ""},
+		"update count batch":    {h.UpdateCountBatch, http.MethodPut, "id", b.batchID, `{"notes": "hijacked"}`},
+		"delete count batch":    {h.DeleteCountBatch, http.MethodDelete, "id", b.batchID, ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.handler(tenantRequest(a.id, tc.method, tc.body, tc.param, tc.id))
+			var httpErr *echo.HTTPError
+			require.ErrorAs(t, err, &httpErr)
+			assert.Equal(t, http.StatusNotFound, httpErr.Code)
+		})
+	}
+
+	t.Run("other tenant's rows are untouched", func(t *testing.T) {
+		ctx := services.SystemScope(context.Background())
+		var supplier, poNotes, batchNotes sql.NullString
+		require.NoError(t, db.QueryRowContext(ctx, `SELECT name FROM suppliers WHERE id = $1`, b.supplierID).Scan(&supplier))
+		require.NoError(t, db.QueryRowContext(ctx, `SELECT notes FROM purchase_orders WHERE id = $1`, b.poID).Scan(&poNotes))
+		require.NoError(t, db.QueryRowContext(ctx, `SELECT notes FROM count_batches WHERE id = $1`, b.batchID).Scan(&batchNotes))
+		assert.NotEqual(t, "hijacked", supplier.String)
+		assert.False(t, poNotes.Valid)
+		assert.False(t, batchNotes.Valid)
+	})
+
+	t.Run("a transaction's scope ends with it", func(t *testing.T) {
+		system := services.SystemScope(context.Background())
+		count := func(q interface {
+			QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+		}, ctx context.Context) int {
+			var n int
+			require.NoError(t, q.QueryRowContext(ctx, `SELECT COUNT(*) FROM suppliers WHERE id IN ($1, $2)`, a.supplierID, b.supplierID).Scan(&n))
+			return n
+		}
+		assert.Equal(t, 2, count(db, system))
+
+		tenantA := services.TenantScope(context.Background(), a.id)
+		tx, err := db.BeginTx(tenantA, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count(tx, tenantA))
+		require.NoError(t, tx.Rollback())
+
+		// The connection's session settings were not changed by the transaction
+		assert.Equal(t, 2, count(db, system))
+	})
+
+	t.Run("statements without a scope see no tenant", func(t *testing.T) {
+		var n int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM suppliers WHERE id IN ($1, $2)`, a.supplierID, b.supplierID).Scan(&n))
+		assert.Zero(t, n)
+	})
+}
+
+func TestIdentityTableIsolation(t *testing.T) {
+	db := isolationTestDB(t)
+	a := seedIsolationTenant(t, db)
+	b := seedIsolationTenant(t, db)
+	system := services.SystemScope(context.Background())
+
+	// shared is homed in a and also a member of b; local only belongs to b
+	shared, local := uuid.NewString(), uuid.NewString()
+	sharedSession, localSession := uuid.NewString(), uuid.NewString()
+	suffix := uuid.NewString()[:8]
+	for _, seed := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO users (id, tenant_id, email, name, role) VALUES ($1, $2, $3, 'Shared', 'CLERK')`, []interface{}{shared, a.id, "shared-" + suffix + "@example.com"}},
+		{`INSERT INTO users (id, tenant_id, email, name, role) VALUES ($1, $2, $3, 'Local', 'CLERK')`, []interface{}{local, b.id, "local-" + suffix + "@example.com"}},
+		{`INSERT INTO tenant_memberships (user_id, tenant_id, role) VALUES ($1, $2, 'CLERK'), ($1, $3, 'CLERK'), ($4, $3, 'CLERK')`, []interface{}{shared, a.id, b.id, local}},
+		{`INSERT INTO user_sessions (id, user_id, tenant_id, expires_at) VALUES ($1, $2, $3, NOW() + INTERVAL '1 day'), ($4, $5, $3, NOW() + INTERVAL '1 day')`, []interface{}{sharedSession, shared, b.id, localSession, local}},
+	} {
+		_, err := db.ExecContext(system, seed.query, seed.args...)
+		require.NoError(t, err, seed.query)
+	}
+	t.Cleanup(func() {
+		db.ExecContext(system, `DELETE FROM user_sessions WHERE user_id IN ($1, $2)`, shared, local)
+		db.ExecContext(system, `DELETE FROM users WHERE id IN ($1, $2)`, shared, local)
+	})
+
+	count := func(ctx context.Context, query string, args ...interface{}) int {
+		var n int
+		require.NoError(t, db.QueryRowContext(ctx, query, args...).Scan(&n), query)
+		return n
+	}
+	tenantA := services.TenantScope(context.Background(), a.id)
+	tenantB := services.TenantScope(context.Background(), b.id)
+	users := `SELECT COUNT(*) FROM users WHERE id IN ($1, $2)`
+	memberships := `SELECT COUNT(*) FROM tenant_memberships WHERE user_id IN ($1, $2)`
+	sessions := `SELECT COUNT(*) FROM user_sessions WHERE id IN ($1, $2)`
+
+	// a sees its own user, their membership of a and, as their home tenant,
+	// their session in b
+	assert.Equal(t, 1, count(tenantA, users, shared, local))
+	assert.Equal(t, 1, count(tenantA, memberships, shared, local))
+	assert.Equal(t, 1, count(tenantA, sessions, sharedSession, localSession))
+
+	// b sees both of its members and their sessions there
+	assert.Equal(t, 2, count(tenantB, users, shared, local))
+	assert.Equal(t, 2, count(tenantB, memberships, shared, local))
+	assert.Equal(t, 2, count(tenantB, sessions, sharedSession, localSession))
+
+	assert.Equal(t, 2, count(system, users, shared, local))
+	assert.Equal(t, 3, count(system, memberships, shared, local))
+
+	_, err := db.ExecContext(tenantA, `UPDATE users SET name = 'hijacked' WHERE id = $1`, local)
+	require.NoError(t, err)
+	var name string
+	require.NoError(t, db.QueryRowContext(system, `SELECT name FROM users WHERE id = $1`, local).Scan(&name))
+	assert.Equal(t, "Local", name)
+}
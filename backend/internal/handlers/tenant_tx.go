@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The database's row level security policies hide other tenants' rows from
+// every statement and reject writes into them, whatever the queries
+// themselves filter on. Statements are scoped by their context (see
+// services.OpenDB): the JWT middleware scopes a request to its tenant, and
+// sign-in, account (/me) and system administration routes to every tenant. Run statements
+// through h.db(c) or h.beginTx(c) so they carry the request's scope; a
+// statement without one sees no tenant's rows.
+
+// beginTx starts a transaction scoped like the request
+func (h *Handler) beginTx(c echo.Context) (*sql.Tx, error) {
+	return h.DB.BeginTx(c.Request().Context(), nil)
+}
+
+// db returns h.DB with statements scoped like the request
+func (h *Handler) db(c echo.Context) scopedDB {
+	return scopedDB{DB: h.DB, ctx: c.Request().Context()}
+}
+
+// scopedDB runs the statements that take no context in ctx
+type scopedDB struct {
+	*sql.DB
+	ctx context.Context
+}
+
+func (db scopedDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(db.ctx, query, args...)
+}
+
+func (db scopedDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(db.ctx, query, args...)
+}
+
+func (db scopedDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(db.ctx, query, args...)
+}
+
+func (db scopedDB) Begin() (*sql.Tx, error) {
+	return db.DB.BeginTx(db.ctx, nil)
+}
@@ -44,11 +44,25 @@ func (h *Handler) CreateTenant(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	tenantService := services.NewTenantService(h.DB)
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	tenantService := services.NewTenantService(tx)
 	tenant, err := tenantService.CreateTenant(c.Request().Context(), req.Name, req.Slug)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	if err := recordAudit(c, tx, AuditCreate, "tenant", tenant.ID.String(), nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
+	}
+	// New slugs may have been cached as unknown hosts
+	h.Tenants.Invalidate()
 
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"data": tenant,
@@ -89,11 +103,29 @@ func (h *Handler) UpdateTenant(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	tenantService := services.NewTenantService(h.DB)
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "tenant", id.String())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load tenant")
+	}
+
+	tenantService := services.NewTenantService(tx)
 	tenant, err := tenantService.UpdateTenant(c.Request().Context(), id, req.Name, req.Slug, req.Domain)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	if err := recordAudit(c, tx, AuditUpdate, "tenant", id.String(), before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
+	}
+	h.Tenants.Invalidate()
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"data": tenant,
@@ -107,10 +139,28 @@ func (h *Handler) DeactivateTenant(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant ID")
 	}
 
-	tenantService := services.NewTenantService(h.DB)
+	tx, err := h.db(c).Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "tenant", id.String())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load tenant")
+	}
+
+	tenantService := services.NewTenantService(tx)
 	if err := tenantService.DeactivateTenant(c.Request().Context(), id); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	if err := recordAudit(c, tx, AuditUpdate, "tenant", id.String(), before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record audit log")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
+	}
+	h.Tenants.Invalidate()
 
 	return c.NoContent(http.StatusNoContent)
 }
@@ -69,6 +69,12 @@ type UpdateTransferRequest struct {
 	} `json:"lines"`
 }
 
+// checkTransferSource rejects changes to a transfer whose source location the
+// caller is not assigned to. Only the destination's users can receive it.
+func checkTransferSource(c echo.Context, q queryRower, id, tenantID string) error {
+	return checkDocumentLocation(c, q, `SELECT from_location_id FROM transfers WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+}
+
 func (h *Handler) ListTransfers(c echo.Context) error {
 	// Get user claims for tenant ID
 	claims, errClaims := appmw.GetUserClaims(c)
@@ -138,6 +144,13 @@ func (h *Handler) ListTransfers(c echo.Context) error {
 		args = append(args, toLocationID)
 	}
 
+	// Transfers are visible from both ends
+	if allowed, restricted := appmw.GetAllowedLocations(c.Request().Context()); restricted {
+		argCount++
+		query += fmt.Sprintf(" AND (t.from_location_id = ANY($%d) OR t.to_location_id = ANY($%d))", argCount, argCount)
+		args = append(args, pq.Array(allowed))
+	}
+
 	// Add sorting
 	switch sort {
 	case "number", "number ASC":
@@ -157,7 +170,7 @@ func (h *Handler) ListTransfers(c echo.Context) error {
 	// Get total count
 	countQuery := "SELECT COUNT(*) FROM (" + query + ") as subquery"
 	var total int
-	err := h.DB.QueryRow(countQuery, args...).Scan(&total)
+	err := h.db(c).QueryRow(countQuery, args...).Scan(&total)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to count transfers")
 	}
@@ -171,7 +184,7 @@ func (h *Handler) ListTransfers(c echo.Context) error {
 	query += fmt.Sprintf(" OFFSET $%d", argCount)
 	args = append(args, offset)
 
-	rows, err := h.DB.Query(query, args...)
+	rows, err := h.db(c).Query(query, args...)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch transfers")
 	}
@@ -247,6 +260,9 @@ func (h *Handler) CreateTransfer(c echo.Context) error {
 	if req.FromLocationID == req.ToLocationID {
 		return echo.NewHTTPError(http.StatusBadRequest, "From and to locations cannot be the same")
 	}
+	if err := checkLocationAccess(c, req.FromLocationID); err != nil {
+		return err
+	}
 
 	if len(req.Lines) == 0 {
 		return echo.NewHTTPError(http.StatusBadRequest, "Transfer must have at least one item")
@@ -254,14 +270,14 @@ func (h *Handler) CreateTransfer(c echo.Context) error {
 
 	// Validate that locations exist
 	var fromLocationExists, toLocationExists bool
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT EXISTS(SELECT 1 FROM locations WHERE id = $1 AND tenant_id = $2 AND is_active = true)
 	`, req.FromLocationID, tenantID).Scan(&fromLocationExists)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to validate from location")
 	}
 
-	err = h.DB.QueryRow(`
+	err = h.db(c).QueryRow(`
 		SELECT EXISTS(SELECT 1 FROM locations WHERE id = $1 AND tenant_id = $2 AND is_active = true)
 	`, req.ToLocationID, tenantID).Scan(&toLocationExists)
 	if err != nil {
@@ -280,7 +296,7 @@ func (h *Handler) CreateTransfer(c echo.Context) error {
 	for _, line := range req.Lines {
 		// Try to resolve item (could be SKU or item ID)
 		var itemID string
-		err := h.DB.QueryRow(`
+		err := h.db(c).QueryRow(`
 			SELECT id FROM items WHERE (sku = $1 OR id = $1) AND tenant_id = $2 AND is_active = true
 		`, line.ItemID, tenantID).Scan(&itemID)
 
@@ -288,7 +304,7 @@ func (h *Handler) CreateTransfer(c echo.Context) error {
 		if err == nil {
 			// Check if source location has enough stock
 			var currentStock int
-			err = h.DB.QueryRow(`
+			err = h.db(c).QueryRow(`
 				SELECT COALESCE(qty, 0) FROM inventory
 				WHERE item_id = $1 AND location_id = $2 AND tenant_id = $3
 			`, itemID, req.FromLocationID, tenantID).Scan(&currentStock)
@@ -306,7 +322,7 @@ func (h *Handler) CreateTransfer(c echo.Context) error {
 	}
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
@@ -399,6 +415,10 @@ func (h *Handler) CreateTransfer(c echo.Context) error {
 		}
 	}
 
+	if err := recordAudit(c, tx, AuditCreate, "transfer", transferID, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
@@ -438,7 +458,7 @@ func (h *Handler) GetTransfer(c echo.Context) error {
 	t.ToLocation = &Location{}   // Initialize before scanning
 	var notes sql.NullString
 
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT
 			t.id, t.number, t.status, t.from_location_id, t.to_location_id,
 			t.notes, t.created_by, t.approved_by, t.shipped_at, t.received_at,
@@ -463,12 +483,16 @@ func (h *Handler) GetTransfer(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch transfer")
 	}
 
+	if !appmw.LocationAllowed(c.Request().Context(), t.FromLocationID) && !appmw.LocationAllowed(c.Request().Context(), t.ToLocationID) {
+		return echo.NewHTTPError(http.StatusForbidden, "no access to the transfer's locations")
+	}
+
 	if notes.Valid {
 		t.Notes = notes.String
 	}
 
 	// Get transfer lines
-	linesRows, err := h.DB.Query(`
+	linesRows, err := h.db(c).Query(`
 		SELECT tl.id, tl.item_id, tl.item_identifier, COALESCE(tl.description, '') as description, tl.qty, COALESCE(i.sku, '') as sku, COALESCE(i.name, '') as name
 		FROM transfer_lines tl
 		LEFT JOIN items i ON tl.item_id = i.id
@@ -531,7 +555,7 @@ func (h *Handler) UpdateTransfer(c echo.Context) error {
 
 	// Check if transfer exists and is in DRAFT status
 	var status, fromLocationID string
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT status, from_location_id FROM transfers WHERE id = $1 AND tenant_id = $2
 	`, id, tenantID).Scan(&status, &fromLocationID)
 	if err != nil {
@@ -544,14 +568,22 @@ func (h *Handler) UpdateTransfer(c echo.Context) error {
 	if status != "DRAFT" {
 		return echo.NewHTTPError(http.StatusBadRequest, "Can only update draft transfers")
 	}
+	if err := checkLocationAccess(c, fromLocationID); err != nil {
+		return err
+	}
 
 	// Start transaction
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
 	defer tx.Rollback()
 
+	before, err := auditSnapshot(c, tx, "transfer", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Update transfer
 	_, err = tx.Exec(`
 		UPDATE transfers SET notes = $1, updated_at = NOW()
@@ -614,6 +646,10 @@ func (h *Handler) UpdateTransfer(c echo.Context) error {
 		}
 	}
 
+	if err := recordAudit(c, tx, AuditUpdate, "transfer", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
@@ -632,9 +668,13 @@ func (h *Handler) DeleteTransfer(c echo.Context) error {
 
 	id := c.Param("id")
 
+	if err := checkTransferSource(c, h.db(c), id, tenantID); err != nil {
+		return err
+	}
+
 	// Check if transfer exists and is in DRAFT status
 	var status string
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT status FROM transfers WHERE id = $1 AND tenant_id = $2
 	`, id, tenantID).Scan(&status)
 	if err != nil {
@@ -649,11 +689,29 @@ func (h *Handler) DeleteTransfer(c echo.Context) error {
 	}
 
 	// Delete transfer (lines will be deleted automatically due to CASCADE)
-	_, err = h.DB.Exec(`DELETE FROM transfers WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "transfer", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	_, err = tx.Exec(`DELETE FROM transfers WHERE id = $1 AND tenant_id = $2`, id, tenantID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete transfer")
 	}
 
+	if err := recordAudit(c, tx, AuditDelete, "transfer", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Transfer deleted successfully"})
 }
 
@@ -668,9 +726,13 @@ func (h *Handler) ApproveTransfer(c echo.Context) error {
 
 	id := c.Param("id")
 
+	if err := checkTransferSource(c, h.db(c), id, tenantID); err != nil {
+		return err
+	}
+
 	// Check if transfer exists and is in DRAFT status
 	var status string
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT status FROM transfers WHERE id = $1 AND tenant_id = $2
 	`, id, tenantID).Scan(&status)
 	if err != nil {
@@ -685,7 +747,18 @@ func (h *Handler) ApproveTransfer(c echo.Context) error {
 	}
 
 	// Update transfer status to IN_TRANSIT
-	_, err = h.DB.Exec(`
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "transfer", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	_, err = tx.Exec(`
 		UPDATE transfers SET status = 'IN_TRANSIT', approved_by = $1, updated_at = NOW()
 		WHERE id = $2 AND tenant_id = $3
 	`, userID, id, tenantID)
@@ -693,6 +766,13 @@ func (h *Handler) ApproveTransfer(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to approve transfer")
 	}
 
+	if err := recordAudit(c, tx, AuditApprove, "transfer", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Transfer approved successfully"})
 }
 
@@ -706,9 +786,13 @@ func (h *Handler) ShipTransfer(c echo.Context) error {
 
 	id := c.Param("id")
 
+	if err := checkTransferSource(c, h.db(c), id, tenantID); err != nil {
+		return err
+	}
+
 	// Check if transfer exists and is in IN_TRANSIT status
 	var status string
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT status FROM transfers WHERE id = $1 AND tenant_id = $2
 	`, id, tenantID).Scan(&status)
 	if err != nil {
@@ -723,7 +807,18 @@ func (h *Handler) ShipTransfer(c echo.Context) error {
 	}
 
 	// Update transfer status to RECEIVED and set shipped timestamp
-	_, err = h.DB.Exec(`
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(c, tx, "transfer", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	_, err = tx.Exec(`
 		UPDATE transfers SET status = 'RECEIVED', shipped_at = NOW(), updated_at = NOW()
 		WHERE id = $1 AND tenant_id = $2
 	`, id, tenantID)
@@ -731,6 +826,13 @@ func (h *Handler) ShipTransfer(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to ship transfer")
 	}
 
+	if err := recordAudit(c, tx, AuditShip, "transfer", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Transfer shipped successfully"})
 }
 
@@ -747,7 +849,7 @@ func (h *Handler) ReceiveTransfer(c echo.Context) error {
 	// Check if transfer exists and is in RECEIVED status
 	var status string
 	var transfer Transfer
-	err := h.DB.QueryRow(`
+	err := h.db(c).QueryRow(`
 		SELECT id, from_location_id, to_location_id FROM transfers WHERE id = $1 AND tenant_id = $2
 	`, id, tenantID).Scan(&transfer.ID, &transfer.FromLocationID, &transfer.ToLocationID)
 	if err != nil {
@@ -757,8 +859,12 @@ func (h *Handler) ReceiveTransfer(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch transfer")
 	}
 
+	if err := checkLocationAccess(c, transfer.ToLocationID); err != nil {
+		return err
+	}
+
 	// Get current status
-	err = h.DB.QueryRow(`SELECT status FROM transfers WHERE id = $1 AND tenant_id = $2`, id, tenantID).Scan(&status)
+	err = h.db(c).QueryRow(`SELECT status FROM transfers WHERE id = $1 AND tenant_id = $2`, id, tenantID).Scan(&status)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch transfer status")
 	}
@@ -768,12 +874,17 @@ func (h *Handler) ReceiveTransfer(c echo.Context) error {
 	}
 
 	// Start transaction to update stock levels
-	tx, err := h.DB.Begin()
+	tx, err := h.beginTx(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
 	}
 	defer tx.Rollback()
 
+	before, err := auditSnapshot(c, tx, "transfer", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Get transfer lines
 	lines, err := tx.Query(`
 		SELECT item_id, qty FROM transfer_lines WHERE transfer_id = $1 AND tenant_id = $2
@@ -824,6 +935,10 @@ func (h *Handler) ReceiveTransfer(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to complete transfer")
 	}
 
+	if err := recordAudit(c, tx, AuditReceive, "transfer", id, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// checkLocationAccess returns a 403 unless the request may access every given
+// location. Empty IDs are skipped.
+func checkLocationAccess(c echo.Context, locationIDs ...string) error {
+	for _, id := range locationIDs {
+		if id != "" && !appmw.LocationAllowed(c.Request().Context(), id) {
+			return echo.NewHTTPError(http.StatusForbidden, "no access to location "+id)
+		}
+	}
+	return nil
+}
+
+// checkDocumentLocation returns a 403 when the location selected by query (a
+// document's location column, looked up by its ID) is not allowed. Missing
+// documents and documents without a location pass, so the caller can report
+// them as usual.
+func checkDocumentLocation(c echo.Context, q queryRower, query string, args ...interface{}) error {
+	if _, restricted := appmw.GetAllowedLocations(c.Request().Context()); !restricted {
+		return nil
+	}
+	var locationID sql.NullString
+	err := q.QueryRow(query, args...).Scan(&locationID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return checkLocationAccess(c, locationID.String)
+}
+
+func userLocationIDs(c echo.Context, q services.DBTX, userID string) ([]string, error) {
+	rows, err := q.QueryContext(c.Request().Context(), `
+		SELECT ul.location_id FROM user_locations ul
+		JOIN locations l ON l.id = ul.location_id
+		WHERE ul.user_id = $1
+		ORDER BY l.code
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetUserLocations lists the locations a user is restricted to. Unless
+// restricted is set the user can access every location; a restricted user with
+// an empty list can access none.
+func (h *Handler) GetUserLocations(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	id := c.Param("id")
+
+	var restricted bool
+	err := h.db(c).QueryRow(`SELECT location_restricted FROM tenant_memberships WHERE user_id = $1 AND tenant_id = $2`, id, claims.TenantID).Scan(&restricted)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	ids, err := userLocationIDs(c, h.db(c), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"location_ids": ids, "restricted": restricted})
+}
+
+// SetUserLocations replaces a user's location assignments and restricts the
+// user to them; an empty list lifts the restriction. The change applies to the
+// user's next request.
+func (h *Handler) SetUserLocations(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	id := c.Param("id")
+
+	var req struct {
+		LocationIDs []string `json:"location_ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	locationIDs := []string{}
+	for _, id := range req.LocationIDs {
+		locationIDs = append(locationIDs, strings.ToLower(strings.TrimSpace(id)))
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	var wasRestricted bool
+	err = tx.QueryRow(`SELECT location_restricted FROM tenant_memberships WHERE user_id = $1 AND tenant_id = $2`, id, claims.TenantID).Scan(&wasRestricted)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	var unknown int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FROM unnest($2::text[]) AS r(id)
+		LEFT JOIN locations l ON l.id::text = r.id AND l.tenant_id = $1
+		WHERE l.id IS NULL
+	`, claims.TenantID, pq.Array(locationIDs)).Scan(&unknown); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if unknown > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "location_ids must be locations of the tenant")
+	}
+
+	before, err := userLocationIDs(c, tx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if _, err := tx.Exec(`DELETE FROM user_locations WHERE user_id = $1`, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO user_locations (user_id, location_id, tenant_id, created_at)
+		SELECT $1, id, tenant_id, NOW() FROM locations WHERE tenant_id = $2 AND id::text = ANY($3::text[])
+	`, id, claims.TenantID, pq.Array(locationIDs)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	restricted := len(locationIDs) > 0
+	if _, err := tx.Exec(`
+		UPDATE tenant_memberships SET location_restricted = $3, updated_at = NOW() WHERE user_id = $1 AND tenant_id = $2
+	`, id, claims.TenantID, restricted); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	after, err := userLocationIDs(c, tx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	e := auditEntry(c, AuditUpdate, "user", id)
+	e.Before, _ = json.Marshal(map[string]interface{}{"location_ids": before, "restricted": wasRestricted})
+	e.After, _ = json.Marshal(map[string]interface{}{"location_ids": after, "restricted": restricted})
+	if err := services.NewAuditService(tx).Record(c.Request().Context(), e); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"location_ids": after, "restricted": restricted})
+}
@@ -1,46 +1,549 @@
 package handlers
 
 import (
+	"database/sql"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+	"inventory/internal/services"
 
 	"github.com/labstack/echo/v4"
 )
 
+// Built-in roles a tenant admin can assign; tenants can add custom roles
+var userRoles = []string{"ADMIN", "MANAGER", "CLERK"}
+
+func isUserRole(role string) bool {
+	for _, r := range userRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type UserModel struct {
+	ID              string     `json:"id"`
+	Email           string     `json:"email"`
+	Name            string     `json:"name"`
+	Role            string     `json:"role"`
+	IsActive        bool       `json:"is_active"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	MFAEnabled      bool       `json:"mfa_enabled"`
+	OAuthProvider   *string    `json:"oauth_provider,omitempty"`
+	AvatarURL       *string    `json:"avatar_url,omitempty"`
+	LastLogin       *time.Time `json:"last_login,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+const userColumns = `id, email, name, role, is_active, email_verified_at, mfa_enabled_at IS NOT NULL, oauth_provider, avatar_url, last_login, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (UserModel, error) {
+	var u UserModel
+	var oauthProvider, avatarURL sql.NullString
+	var verifiedAt, lastLogin sql.NullTime
+	if err := row.Scan(&u.ID, &u.Email, &u.Name, &u.Role, &u.IsActive, &verifiedAt, &u.MFAEnabled, &oauthProvider, &avatarURL, &lastLogin, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return u, err
+	}
+	if verifiedAt.Valid {
+		u.EmailVerifiedAt = &verifiedAt.Time
+	}
+	if oauthProvider.Valid {
+		u.OAuthProvider = &oauthProvider.String
+	}
+	if avatarURL.Valid {
+		u.AvatarURL = &avatarURL.String
+	}
+	if lastLogin.Valid {
+		u.LastLogin = &lastLogin.Time
+	}
+	return u, nil
+}
+
+// ListUsers lists the tenant's members (?q=&role=&is_active=&page=&page_size=).
 func (h *Handler) ListUsers(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page <= 0 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.QueryParam("page_size"))
+	if pageSize <= 0 || pageSize > h.Config.MaxPageSize {
+		pageSize = h.Config.DefaultPageSize
+	}
+
+	where := "m.tenant_id = $1"
+	args := []interface{}{claims.TenantID}
+	if q := strings.TrimSpace(c.QueryParam("q")); q != "" {
+		args = append(args, "%"+q+"%")
+		where += fmt.Sprintf(" AND (u.email ILIKE $%d OR u.name ILIKE $%d)", len(args), len(args))
+	}
+	if role := strings.ToUpper(c.QueryParam("role")); role != "" {
+		if err := checkAssignableRole(h.db(c), claims.TenantID, role); err != nil {
+			return err
+		}
+		args = append(args, role)
+		where += fmt.Sprintf(" AND m.role = $%d", len(args))
+	}
+	if v := c.QueryParam("is_active"); v != "" {
+		args = append(args, v == "true")
+		where += fmt.Sprintf(" AND m.is_active = $%d", len(args))
+	}
+
+	var total int64
+	if err := h.db(c).QueryRow(`SELECT COUNT(*) FROM `+memberTables+` WHERE `+where, args...).Scan(&total); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	rows, err := h.db(c).Query(fmt.Sprintf(`
+		SELECT %s FROM %s WHERE %s ORDER BY u.name, u.email LIMIT %d OFFSET %d
+	`, memberColumns, memberTables, where, pageSize, (page-1)*pageSize), args...)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer rows.Close()
+
+	users := []UserModel{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database scan error")
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
 	return c.JSON(http.StatusOK, PaginatedResponse{
-		Data:       []interface{}{},
-		Page:       1,
-		PageSize:   20,
-		TotalPages: 0,
-		Total:      0,
+		Data:       users,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: totalPages,
+		Total:      total,
 	})
 }
 
+// CreateUser adds a password user with this tenant as their home tenant.
 func (h *Handler) CreateUser(c echo.Context) error {
-	return c.JSON(http.StatusCreated, map[string]string{
-		"message": "user created",
-	})
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req struct {
+		Email    string `json:"email" validate:"required,email"`
+		Name     string `json:"name" validate:"required"`
+		Password string `json:"password" validate:"required,min=8"`
+		Role     string `json:"role" validate:"required"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	req.Name = strings.TrimSpace(req.Name)
+	req.Role = strings.ToUpper(strings.TrimSpace(req.Role))
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := checkGrantableRole(h.db(c), claims, req.Role); err != nil {
+		return err
+	}
+
+	passwordHash, err := hashPassword(req.Password)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to secure password")
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+	if err := checkQuota(c, tx, claims.TenantID, services.QuotaUsers); err != nil {
+		return err
+	}
+
+	u, err := scanUser(tx.QueryRow(`
+		INSERT INTO users (tenant_id, email, password_hash, name, role, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, true, NOW(), NOW())
+		RETURNING `+userColumns,
+		claims.TenantID, req.Email, passwordHash, req.Name, req.Role))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return echo.NewHTTPError(http.StatusConflict, "a user with this email already exists; invite them to add them to this tenant")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := addMembership(tx, u.ID, claims.TenantID, req.Role); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := recordAudit(c, tx, AuditCreate, "user", u.ID, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	verifyToken, err := issueUserToken(tx, u.ID, TokenEmailVerify, h.Config.EmailVerifyExpiry)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	h.sendVerificationEmail(c.Request().Context(), u.Email, verifyToken)
+
+	return c.JSON(http.StatusCreated, u)
 }
 
 func (h *Handler) GetUser(c echo.Context) error {
-	id := c.Param("id")
-	return c.JSON(http.StatusOK, map[string]string{
-		"id": id,
-	})
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	u, err := scanUser(h.db(c).QueryRow(`SELECT `+memberColumns+` FROM `+memberTables+` WHERE u.id = $1 AND m.tenant_id = $2`, c.Param("id"), claims.TenantID))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	return c.JSON(http.StatusOK, u)
 }
 
+// GetMe returns the current user with their role and its permissions in the
+// session's tenant, read from the database rather than the token, and the
+// tenants they can switch to.
+func (h *Handler) GetMe(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var u UserModel
+	var err error
+	if claims.TenantID != "" {
+		u, err = scanUser(h.db(c).QueryRow(`SELECT `+memberColumns+` FROM `+memberTables+` WHERE u.id = $1 AND m.tenant_id = $2`, claims.UserID, claims.TenantID))
+	} else {
+		u, err = scanUser(h.db(c).QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, claims.UserID))
+	}
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	perms, err := rolePermissions(h.db(c), claims.TenantID, u.Role)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	memberships, err := listMemberships(h.db(c), claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	var tenant *string
+	if claims.TenantID != "" {
+		tenant = &claims.TenantID
+	}
+	return c.JSON(http.StatusOK, struct {
+		UserModel
+		TenantID    *string      `json:"tenant_id"`
+		Permissions []string     `json:"permissions"`
+		Memberships []Membership `json:"memberships"`
+	}{u, tenant, appmw.SortedPermissions(perms), memberships})
+}
+
+// UpdateUser changes a user's profile, password, role or active flag. Role and
+// active flag belong to the membership of this tenant; the profile and
+// password belong to the account and only its home tenant can change them.
+// The last active ADMIN of a tenant can be neither demoted nor deactivated.
 func (h *Handler) UpdateUser(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
 	id := c.Param("id")
-	return c.JSON(http.StatusOK, map[string]string{
-		"id":      id,
-		"message": "user updated",
-	})
+
+	var req struct {
+		Email    *string `json:"email"`
+		Name     *string `json:"name"`
+		Password *string `json:"password"`
+		Role     *string `json:"role"`
+		IsActive *bool   `json:"is_active"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	sets := []string{}
+	args := []interface{}{id}
+	set := func(col string, v interface{}) {
+		args = append(args, v)
+		sets = append(sets, fmt.Sprintf("%s = $%d", col, len(args)))
+	}
+	memberSets := []string{}
+	memberArgs := []interface{}{id, claims.TenantID}
+	setMember := func(col string, v interface{}) {
+		memberArgs = append(memberArgs, v)
+		memberSets = append(memberSets, fmt.Sprintf("%s = $%d", col, len(memberArgs)))
+	}
+	if req.Email != nil {
+		email := strings.ToLower(strings.TrimSpace(*req.Email))
+		if err := c.Validate(struct {
+			Email string `validate:"required,email"`
+		}{email}); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid email")
+		}
+		set("email", email)
+		// A new address has to be verified again
+		sets = append(sets, fmt.Sprintf("email_verified_at = CASE WHEN email = $%d THEN email_verified_at END", len(args)))
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "name cannot be empty")
+		}
+		set("name", name)
+	}
+	if req.Password != nil {
+		if len(*req.Password) < 8 {
+			return echo.NewHTTPError(http.StatusBadRequest, "password must be at least 8 characters")
+		}
+		passwordHash, err := hashPassword(*req.Password)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to secure password")
+		}
+		set("password_hash", passwordHash)
+	}
+	var role string
+	if req.Role != nil {
+		role = strings.ToUpper(strings.TrimSpace(*req.Role))
+		if err := checkGrantableRole(h.db(c), claims, role); err != nil {
+			return err
+		}
+		setMember("role", role)
+	}
+	if req.IsActive != nil {
+		setMember("is_active", *req.IsActive)
+	}
+	if len(sets) == 0 && len(memberSets) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "no fields to update")
+	}
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	current, err := lockTenantUser(tx, id, claims.TenantID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if len(sets) > 0 {
+		var home bool
+		if err := tx.QueryRow(`SELECT tenant_id IS NOT DISTINCT FROM $2 FROM users WHERE id = $1`, id, claims.TenantID).Scan(&home); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if !home {
+			return echo.NewHTTPError(http.StatusForbidden, "only the user's home tenant can change their profile or password")
+		}
+	}
+
+	demoted := req.Role != nil && role != "ADMIN"
+	disabled := req.IsActive != nil && !*req.IsActive
+	if demoted || disabled {
+		if err := ensureOtherActiveAdmin(tx, current, claims.TenantID); err != nil {
+			return err
+		}
+	}
+
+	memberID, err := membershipID(tx, id, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	before, err := auditSnapshot(c, tx, "user", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	memberBefore, err := auditSnapshot(c, tx, "membership", memberID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	if len(sets) > 0 {
+		if _, err := tx.Exec(fmt.Sprintf(`
+			UPDATE users SET %s, updated_at = NOW() WHERE id = $1
+		`, strings.Join(sets, ", ")), args...); err != nil {
+			if isUniqueViolation(err) {
+				return echo.NewHTTPError(http.StatusConflict, "a user with this email already exists")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	if len(memberSets) > 0 {
+		if _, err := tx.Exec(fmt.Sprintf(`
+			UPDATE tenant_memberships SET %s, updated_at = NOW() WHERE user_id = $1 AND tenant_id = $2
+		`, strings.Join(memberSets, ", ")), memberArgs...); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		if err := syncHomeMembership(tx, id, claims.TenantID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	u, err := scanUser(tx.QueryRow(`SELECT `+memberColumns+` FROM `+memberTables+` WHERE u.id = $1 AND m.tenant_id = $2`, id, claims.TenantID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	if req.Password != nil {
+		if err := revokeUserSessions(tx, id); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	} else if (current.IsActive && !u.IsActive) || current.Role != u.Role {
+		if err := revokeMembershipSessions(tx, id, claims.TenantID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	if len(sets) > 0 {
+		if err := recordAudit(c, tx, AuditUpdate, "user", id, before); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	if len(memberSets) > 0 {
+		if err := recordAudit(c, tx, AuditUpdate, "membership", memberID, memberBefore); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	var verifyToken string
+	if u.Email != current.Email {
+		if verifyToken, err = issueUserToken(tx, id, TokenEmailVerify, h.Config.EmailVerifyExpiry); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if verifyToken != "" {
+		h.sendVerificationEmail(c.Request().Context(), u.Email, verifyToken)
+	}
+
+	return c.JSON(http.StatusOK, u)
 }
 
+// DisableUser deactivates a user's membership of the tenant and revokes their
+// sessions in it. Their other tenants are not affected.
 func (h *Handler) DisableUser(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
 	id := c.Param("id")
-	return c.JSON(http.StatusOK, map[string]string{
-		"id":      id,
-		"message": "user disabled",
-	})
-}
\ No newline at end of file
+
+	tx, err := h.beginTx(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
+
+	current, err := lockTenantUser(tx, id, claims.TenantID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if !current.IsActive {
+		return c.JSON(http.StatusOK, current)
+	}
+	if err := ensureOtherActiveAdmin(tx, current, claims.TenantID); err != nil {
+		return err
+	}
+
+	memberID, err := membershipID(tx, id, claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	before, err := auditSnapshot(c, tx, "membership", memberID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if _, err := tx.Exec(`
+		UPDATE tenant_memberships SET is_active = false, updated_at = NOW() WHERE id = $1
+	`, memberID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := syncHomeMembership(tx, id, claims.TenantID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	u, err := scanUser(tx.QueryRow(`SELECT `+memberColumns+` FROM `+memberTables+` WHERE m.id = $1`, memberID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := revokeMembershipSessions(tx, id, claims.TenantID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := recordAudit(c, tx, AuditDisable, "membership", memberID, before); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return c.JSON(http.StatusOK, u)
+}
+
+// lockTenantUser loads a member of the tenant for update. The tenant's active
+// admins are locked as well so that concurrent demotions cannot both pass the
+// last-admin check.
+func lockTenantUser(tx *sql.Tx, id, tenantID string) (UserModel, error) {
+	if _, err := tx.Exec(`
+		SELECT id FROM tenant_memberships WHERE tenant_id = $1 AND role = 'ADMIN' AND is_active = true FOR UPDATE
+	`, tenantID); err != nil {
+		return UserModel{}, err
+	}
+	return scanUser(tx.QueryRow(`
+		SELECT `+memberColumns+` FROM `+memberTables+` WHERE u.id = $1 AND m.tenant_id = $2 FOR UPDATE OF m
+	`, id, tenantID))
+}
+
+// membershipID returns the ID of the user's membership of the tenant.
+func membershipID(q queryRower, userID, tenantID string) (string, error) {
+	var id string
+	err := q.QueryRow(`SELECT id FROM tenant_memberships WHERE user_id = $1 AND tenant_id = $2`, userID, tenantID).Scan(&id)
+	return id, err
+}
+
+// ensureOtherActiveAdmin refuses to demote or disable u when it is the tenant's
+// last active ADMIN.
+func ensureOtherActiveAdmin(tx *sql.Tx, u UserModel, tenantID string) error {
+	if u.Role != "ADMIN" || !u.IsActive {
+		return nil
+	}
+	var others int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FROM tenant_memberships WHERE tenant_id = $1 AND role = 'ADMIN' AND is_active = true AND user_id <> $2
+	`, tenantID, u.ID).Scan(&others); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if others == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "cannot demote or disable the last active ADMIN")
+	}
+	return nil
+}
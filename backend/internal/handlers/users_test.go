@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsUserRole(t *testing.T) {
+	for _, role := range []string{"ADMIN", "MANAGER", "CLERK"} {
+		assert.True(t, isUserRole(role), role)
+	}
+	assert.False(t, isUserRole("SYSTEM_ADMIN"))
+	assert.False(t, isUserRole("admin"))
+}
+
+func TestEnsureOtherActiveAdminSkipsNonAdmins(t *testing.T) {
+	// Only an active ADMIN needs another admin to remain; no query is made otherwise
+	assert.NoError(t, ensureOtherActiveAdmin(nil, UserModel{Role: "MANAGER", IsActive: true}, "t1"))
+	assert.NoError(t, ensureOtherActiveAdmin(nil, UserModel{Role: "ADMIN", IsActive: false}, "t1"))
+}
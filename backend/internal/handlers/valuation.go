@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	appmw "inventory/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+// LocationValuation is the stock quantity and value held at one location.
+type LocationValuation struct {
+	LocationID   string          `json:"location_id"`
+	LocationCode string          `json:"location_code"`
+	LocationName string          `json:"location_name"`
+	Qty          int             `json:"qty"`
+	Value        decimal.Decimal `json:"value"`
+	Items        []ItemValuation `json:"items,omitempty"`
+}
+
+type ItemValuation struct {
+	ItemID   string          `json:"item_id"`
+	SKU      string          `json:"sku"`
+	Name     string          `json:"name"`
+	Qty      int             `json:"qty"`
+	Value    decimal.Decimal `json:"value"`
+	UnitCost decimal.Decimal `json:"unit_cost"`
+}
+
+// valuationRow is the value of one item at one location.
+type valuationRow struct {
+	LocationID   string
+	LocationCode string
+	LocationName string
+	ItemID       string
+	SKU          string
+	Name         string
+	Qty          int
+	Value        decimal.Decimal
+}
+
+// fifoValuationQuery values the layers open at $2: quantity received less what
+// was consumed up to then, at the layer's unit cost.
+const fifoValuationQuery = `
+	SELECT cl.location_id, l.code, l.name, cl.item_id, i.sku, i.name,
+		SUM(cl.qty_received - COALESCE(cons.qty, 0)),
+		SUM((cl.qty_received - COALESCE(cons.qty, 0)) * cl.unit_cost)
+	FROM cost_layers cl
+	LEFT JOIN LATERAL (
+		SELECT SUM(qty) AS qty FROM cost_layer_consumptions
+		WHERE layer_id = cl.id AND consumed_at <= $2
+	) cons ON TRUE
+	JOIN locations l ON l.id = cl.location_id
+	JOIN items i ON i.id = cl.item_id
+	WHERE cl.tenant_id = $1 AND cl.received_at <= $2
+		AND (cl.closed_at IS NULL OR cl.closed_at > $2) %s
+	GROUP BY cl.location_id, l.code, l.name, cl.item_id, i.sku, i.name
+	HAVING SUM(cl.qty_received - COALESCE(cons.qty, 0)) <> 0
+	ORDER BY l.code, i.sku`
+
+// ledgerValuationQuery sums the signed quantities and extended costs of stock
+// movements up to $2. Movements posted before costs were recorded are valued at
+// the item's current cost.
+const ledgerValuationQuery = `
+	SELECT sm.location_id, l.code, l.name, sm.item_id, i.sku, i.name,
+		SUM(sm.qty),
+		SUM(COALESCE(sm.total_cost, sm.qty * COALESCE(i.cost, 0)))
+	FROM stock_movements sm
+	JOIN locations l ON l.id = sm.location_id
+	JOIN items i ON i.id = sm.item_id
+	WHERE sm.tenant_id = $1 AND sm.occurred_at <= $2 %s
+	GROUP BY sm.location_id, l.code, l.name, sm.item_id, i.sku, i.name
+	HAVING SUM(sm.qty) <> 0 OR SUM(COALESCE(sm.total_cost, sm.qty * COALESCE(i.cost, 0))) <> 0
+	ORDER BY l.code, i.sku`
+
+// GetInventoryValuation reports stock value per location as of a date
+// (?as_of=&location_id=&detail=items). FIFO tenants are valued from their cost
+// layers, other methods from the costed stock movement ledger.
+func (h *Handler) GetInventoryValuation(c echo.Context) error {
+	claims, errClaims := appmw.GetUserClaims(c)
+	if errClaims != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	asOf, err := parseAsOf(c.QueryParam("as_of"), time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	method, err := tenantCostingMethod(h.db(c), claims.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	query := ledgerValuationQuery
+	locationColumn := "sm.location_id"
+	if method == CostingFIFO {
+		query = fifoValuationQuery
+		locationColumn = "cl.location_id"
+	}
+	args := []interface{}{claims.TenantID, asOf}
+	filter := ""
+	if locationID := c.QueryParam("location_id"); locationID != "" {
+		if err := checkLocationAccess(c, locationID); err != nil {
+			return err
+		}
+		args = append(args, locationID)
+		filter = fmt.Sprintf("AND %s = $%d", locationColumn, len(args))
+	}
+	if ids, restricted := appmw.GetAllowedLocations(c.Request().Context()); restricted {
+		args = append(args, pq.Array(ids))
+		filter += fmt.Sprintf(" AND %s = ANY($%d)", locationColumn, len(args))
+	}
+
+	rows, err := h.db(c).Query(fmt.Sprintf(query, filter), args...)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to compute valuation")
+	}
+	defer rows.Close()
+
+	var valuationRows []valuationRow
+	for rows.Next() {
+		var r valuationRow
+		if err := rows.Scan(&r.LocationID, &r.LocationCode, &r.LocationName, &r.ItemID, &r.SKU, &r.Name, &r.Qty, &r.Value); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database scan error")
+		}
+		valuationRows = append(valuationRows, r)
+	}
+	if err := rows.Err(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	locations, total := summariseValuation(valuationRows, c.QueryParam("detail") == "items")
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"as_of":          asOf,
+		"costing_method": method,
+		"total_value":    total,
+		"locations":      locations,
+	})
+}
+
+// summariseValuation groups item rows (ordered by location) into per-location
+// totals, keeping the item breakdown when withItems is set.
+func summariseValuation(rows []valuationRow, withItems bool) ([]LocationValuation, decimal.Decimal) {
+	locations := []LocationValuation{}
+	total := decimal.Zero
+	for _, r := range rows {
+		if len(locations) == 0 || locations[len(locations)-1].LocationID != r.LocationID {
+			locations = append(locations, LocationValuation{
+				LocationID:   r.LocationID,
+				LocationCode: r.LocationCode,
+				LocationName: r.LocationName,
+				Value:        decimal.Zero,
+			})
+		}
+		loc := &locations[len(locations)-1]
+		value := r.Value.Round(2)
+		loc.Qty += r.Qty
+		loc.Value = loc.Value.Add(value)
+		total = total.Add(value)
+		if withItems {
+			item := ItemValuation{ItemID: r.ItemID, SKU: r.SKU, Name: r.Name, Qty: r.Qty, Value: value}
+			if r.Qty != 0 {
+				item.UnitCost = r.Value.DivRound(decimal.NewFromInt(int64(r.Qty)), 4)
+			}
+			loc.Items = append(loc.Items, item)
+		}
+	}
+	return locations, total
+}
+
+// parseAsOf parses an as-of instant given as RFC3339 or as a date, which means
+// the end of that day (UTC). An empty value means now.
+func parseAsOf(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.Parse(time.DateOnly, value); err == nil {
+		return d.AddDate(0, 0, 1).Add(-time.Microsecond), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid as_of, expected RFC3339 timestamp or YYYY-MM-DD")
+}
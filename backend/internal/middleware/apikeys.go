@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"inventory/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix starts every API key so they are easy to recognise in logs and
+// secret scanners.
+const APIKeyPrefix = "ik_"
+
+// How often last_used_at is refreshed for a busy key
+const apiKeyUsageResolution = time.Minute
+
+// HashAPIKey returns the digest API keys are stored and looked up by.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IPAllowed reports whether ip matches an entry of allowList, each an IP
+// address or CIDR range. An empty list allows every address.
+func IPAllowed(ip string, allowList []string) bool {
+	if len(allowList) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range allowList {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIKey resolves an API key to claims acting as the user who
+// created it. The key's scopes are limited to what that user's role in the
+// key's tenant currently grants, so a key never outlives its creator's access.
+func authenticateAPIKey(c echo.Context, db *sql.DB, key string) (*Claims, error) {
+	if db == nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "API keys are not accepted here")
+	}
+	// The key's tenant is not known until it is found
+	ctx := services.SystemScope(c.Request().Context())
+
+	var (
+		keyID, tenantID, userID, email, role string
+		scopes, allowedIPs, rolePerms        []string
+		expiresAt                            sql.NullTime
+	)
+	err := db.QueryRowContext(ctx, `
+		SELECT k.id, k.tenant_id, u.id, u.email, m.role, k.scopes, k.allowed_ips, k.expires_at, r.permissions
+		FROM api_keys k
+		JOIN users u ON u.id = k.created_by
+		JOIN tenant_memberships m ON m.user_id = k.created_by AND m.tenant_id = k.tenant_id AND m.is_active = true
+		LEFT JOIN roles r ON r.tenant_id = k.tenant_id AND r.name = m.role
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL
+	`, HashAPIKey(key)).Scan(&keyID, &tenantID, &userID, &email, &role,
+		pq.Array(&scopes), pq.Array(&allowedIPs), &expiresAt, pq.Array(&rolePerms))
+	if err == sql.ErrNoRows {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "invalid API key")
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+	if expiresAt.Valid && !expiresAt.Time.After(time.Now()) {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "API key expired")
+	}
+	if !IPAllowed(c.RealIP(), allowedIPs) {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "API key not allowed from this address")
+	}
+
+	if perms, ok := BuiltinRoles[role]; ok {
+		rolePerms = perms
+	}
+	granted := map[string]bool{}
+	for _, p := range rolePerms {
+		granted[p] = true
+	}
+	perms := []string{}
+	for _, p := range scopes {
+		if granted[p] {
+			perms = append(perms, p)
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		UPDATE api_keys SET last_used_at = NOW(), last_used_ip = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - $3 * INTERVAL '1 second')
+	`, keyID, c.RealIP(), apiKeyUsageResolution.Seconds()); err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+	}
+
+	return &Claims{
+		UserID:      userID,
+		TenantID:    tenantID,
+		Email:       email,
+		Role:        role,
+		APIKeyID:    keyID,
+		Permissions: perms,
+	}, nil
+}
+
+// setClaims stores authenticated claims and their tenant on the request. On a
+// tenant's subdomain or custom domain only that tenant's credentials are
+// accepted.
+func setClaims(c echo.Context, claims *Claims) error {
+	if host, ok := GetHostTenant(c.Request().Context()); ok && !strings.EqualFold(host.ID.String(), claims.TenantID) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "credentials are not valid for this tenant")
+	}
+	c.Set("user", claims)
+
+	// Set tenant ID in context from the claims
+	if claims.TenantID != "" {
+		if tenantID, err := uuid.Parse(claims.TenantID); err == nil {
+			c.SetRequest(c.Request().WithContext(SetTenantID(c.Request().Context(), tenantID)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPAllowed(t *testing.T) {
+	assert.True(t, IPAllowed("203.0.113.7", nil), "empty list allows all")
+	list := []string{"203.0.113.0/24", "2001:db8::1"}
+	assert.True(t, IPAllowed("203.0.113.7", list))
+	assert.True(t, IPAllowed("2001:db8::1", list))
+	assert.False(t, IPAllowed("198.51.100.1", list))
+	assert.False(t, IPAllowed("not-an-ip", list))
+}
+
+func TestJWTRejectsAPIKeyWithoutDatabase(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "ApiKey ik_abc")
+	c := e.NewContext(req, httptest.NewRecorder())
+
+	err := JWT("secret", nil)(func(c echo.Context) error { return nil })(c)
+	httpErr, ok := err.(*echo.HTTPError)
+	if assert.True(t, ok) {
+		assert.Equal(t, http.StatusUnauthorized, httpErr.Code)
+	}
+}
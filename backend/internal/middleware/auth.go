@@ -1,10 +1,14 @@
 package middleware
 
 import (
-	"context"
+	"database/sql"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
+
+	"inventory/internal/services"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -16,10 +20,21 @@ type Claims struct {
 	TenantID string `json:"tenant_id"`
 	Email    string `json:"email"`
 	Role     string `json:"role"`
+	// Login session the token belongs to, if any
+	SessionID string `json:"sid,omitempty"`
+	// Set until the user has verified their email address
+	Unverified bool `json:"unverified,omitempty"`
+	// Permissions of the user's role when the token was issued
+	Permissions []string `json:"perms,omitempty"`
+	// Set when the request is authenticated with an API key instead of a token
+	APIKeyID string `json:"-"`
 	jwt.RegisteredClaims
 }
 
-func JWT(secret string) echo.MiddlewareFunc {
+// JWT authenticates requests with a bearer token, or with an API key
+// ("Authorization: ApiKey <key>") when db is set. With db, a token whose login
+// session has been revoked or has expired is rejected too.
+func JWT(secret string, db *sql.DB) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			auth := c.Request().Header.Get("Authorization")
@@ -28,10 +43,21 @@ func JWT(secret string) echo.MiddlewareFunc {
 			}
 
 			parts := strings.Split(auth, " ")
-			if len(parts) != 2 || parts[0] != "Bearer" {
+			if len(parts) != 2 || (parts[0] != "Bearer" && parts[0] != "ApiKey") {
 				return echo.NewHTTPError(http.StatusUnauthorized, "invalid authorization header format")
 			}
 
+			if parts[0] == "ApiKey" {
+				claims, err := authenticateAPIKey(c, db, parts[1])
+				if err != nil {
+					return err
+				}
+				if err := setClaims(c, claims); err != nil {
+					return err
+				}
+				return next(c)
+			}
+
 			tokenString := parts[1]
 
 			token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
@@ -46,16 +72,12 @@ func JWT(secret string) echo.MiddlewareFunc {
 			}
 
 			if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-				c.Set("user", claims)
-
-				// Set tenant ID in context from JWT claims
-				if claims.TenantID != "" {
-					if tenantID, err := uuid.Parse(claims.TenantID); err == nil {
-						ctx := context.WithValue(c.Request().Context(), TenantIDKey, tenantID)
-						c.SetRequest(c.Request().WithContext(ctx))
-					}
+				if err := checkSession(c, db, claims); err != nil {
+					return err
+				}
+				if err := setClaims(c, claims); err != nil {
+					return err
 				}
-
 				return next(c)
 			}
 
@@ -64,6 +86,80 @@ func JWT(secret string) echo.MiddlewareFunc {
 	}
 }
 
+// How long the state of a session is trusted before it is looked up again
+const sessionCacheTTL = 30 * time.Second
+
+// sessionCache remembers recently checked sessions so a busy client does not
+// cost a query per request. Revocation takes effect within sessionCacheTTL.
+type sessionCache struct {
+	mu      sync.Mutex
+	entries map[string]sessionState
+	pruned  time.Time
+}
+
+type sessionState struct {
+	active  bool
+	checked time.Time
+}
+
+var sessionStates = &sessionCache{entries: map[string]sessionState{}}
+
+func (s *sessionCache) get(id string, now time.Time) (active, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.entries[id]
+	if !ok || now.Sub(state.checked) >= sessionCacheTTL {
+		return false, false
+	}
+	return state.active, true
+}
+
+func (s *sessionCache) put(id string, active bool, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.pruned) >= sessionCacheTTL {
+		for key, state := range s.entries {
+			if now.Sub(state.checked) >= sessionCacheTTL {
+				delete(s.entries, key)
+			}
+		}
+		s.pruned = now
+	}
+	s.entries[id] = sessionState{active: active, checked: now}
+}
+
+// checkSession rejects a token whose session has been revoked (logout, "sign
+// out other sessions", a disabled member or changed role) or has expired.
+// Tokens without a session, such as the one used to pick a tenant after an
+// OAuth sign-in, are left alone.
+func checkSession(c echo.Context, db *sql.DB, claims *Claims) error {
+	if db == nil || claims.SessionID == "" {
+		return nil
+	}
+	if _, err := uuid.Parse(claims.SessionID); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token claims")
+	}
+
+	now := time.Now()
+	active, ok := sessionStates.get(claims.SessionID, now)
+	if !ok {
+		// Sessions are looked up before the request's tenant is known
+		ctx := services.SystemScope(c.Request().Context())
+		err := db.QueryRowContext(ctx, `
+			SELECT revoked_at IS NULL AND expires_at > NOW() FROM user_sessions
+			WHERE id = $1 AND user_id::text = $2
+		`, claims.SessionID, claims.UserID).Scan(&active)
+		if err != nil && err != sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Database error")
+		}
+		sessionStates.put(claims.SessionID, active, now)
+	}
+	if !active {
+		return echo.NewHTTPError(http.StatusUnauthorized, "session has ended")
+	}
+	return nil
+}
+
 func RequireRole(roles ...string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -83,6 +179,28 @@ func RequireRole(roles ...string) echo.MiddlewareFunc {
 	}
 }
 
+// RequireVerifiedEmail makes the API read-only for users who have not verified
+// their email address yet.
+func RequireVerifiedEmail() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := c.Get("user").(*Claims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "user not found in context")
+			}
+
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			if user.Unverified {
+				return echo.NewHTTPError(http.StatusForbidden, "email address not verified; the account is read-only until it is")
+			}
+			return next(c)
+		}
+	}
+}
+
 func GetUserClaims(c echo.Context) (*Claims, error) {
 	user, ok := c.Get("user").(*Claims)
 	if !ok {
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	e := echo.New()
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+
+	tests := []struct {
+		name       string
+		method     string
+		unverified bool
+		wantErr    bool
+	}{
+		{"verified write", http.MethodPost, false, false},
+		{"unverified read", http.MethodGet, true, false},
+		{"unverified write", http.MethodPost, true, true},
+		{"unverified delete", http.MethodDelete, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := e.NewContext(httptest.NewRequest(tt.method, "/", nil), httptest.NewRecorder())
+			c.Set("user", &Claims{UserID: "u1", Unverified: tt.unverified})
+
+			err := RequireVerifiedEmail()(ok)(c)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			httpErr, isHTTP := err.(*echo.HTTPError)
+			if assert.True(t, isHTTP) {
+				assert.Equal(t, http.StatusForbidden, httpErr.Code)
+			}
+		})
+	}
+}
+
+func TestJWTRejectsEndedSessions(t *testing.T) {
+	// Never connected: every session below is answered from the cache
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable")
+	require.NoError(t, err)
+	defer db.Close()
+
+	active, revoked := uuid.NewString(), uuid.NewString()
+	now := time.Now()
+	sessionStates.put(active, true, now)
+	sessionStates.put(revoked, false, now)
+
+	secret := "test-secret"
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	token := func(sid string) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+			UserID:    "u1",
+			SessionID: sid,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		}).SignedString([]byte(secret))
+		require.NoError(t, err)
+		return signed
+	}
+
+	tests := []struct {
+		name    string
+		db      *sql.DB
+		sid     string
+		wantErr bool
+	}{
+		{"active session", db, active, false},
+		{"revoked session", db, revoked, true},
+		{"malformed session", db, "not-a-uuid", true},
+		{"no session", db, "", false},
+		{"revoked session without db", nil, revoked, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token(tt.sid))
+			rec := httptest.NewRecorder()
+			err := JWT(secret, tt.db)(ok)(echo.New().NewContext(req, rec))
+			if !tt.wantErr {
+				require.NoError(t, err)
+				assert.Equal(t, http.StatusNoContent, rec.Code)
+				return
+			}
+			var httpErr *echo.HTTPError
+			require.ErrorAs(t, err, &httpErr)
+			assert.Equal(t, http.StatusUnauthorized, httpErr.Code)
+		})
+	}
+}
+
+func TestSessionCacheExpires(t *testing.T) {
+	cache := &sessionCache{entries: map[string]sessionState{}}
+	now := time.Now()
+	cache.put("s1", true, now)
+
+	active, ok := cache.get("s1", now.Add(sessionCacheTTL-time.Second))
+	assert.True(t, ok)
+	assert.True(t, active)
+
+	_, ok = cache.get("s1", now.Add(sessionCacheTTL))
+	assert.False(t, ok)
+
+	cache.put("s2", false, now.Add(sessionCacheTTL))
+	assert.NotContains(t, cache.entries, "s1")
+}
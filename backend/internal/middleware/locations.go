@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const AllowedLocationsKey TenantContextKey = "allowed_locations"
+
+// ResolveLocations puts the locations the user is assigned to in the request
+// context, next to the tenant ID. ADMIN and SYSTEM_ADMIN users, and members
+// who were never restricted, are not restricted. A restricted member whose
+// assignments are all gone (say, the locations were deleted) can access no
+// location, nor can a user without a membership of the tenant.
+func ResolveLocations(db *sql.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := c.Get("user").(*Claims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "user not found in context")
+			}
+			if user.Role == RoleAdmin || user.Role == RoleSystemAdmin {
+				return next(c)
+			}
+
+			ctx := c.Request().Context()
+			restricted := true
+			err := db.QueryRowContext(ctx, `
+				SELECT location_restricted FROM tenant_memberships WHERE user_id = $1 AND tenant_id = $2
+			`, user.UserID, user.TenantID).Scan(&restricted)
+			if err != nil && err != sql.ErrNoRows {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to load location assignments")
+			}
+			if !restricted {
+				return next(c)
+			}
+
+			rows, err := db.QueryContext(ctx, `
+				SELECT location_id FROM user_locations WHERE user_id = $1 AND tenant_id = $2
+			`, user.UserID, user.TenantID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to load location assignments")
+			}
+			defer rows.Close()
+			locationIDs := []string{}
+			for rows.Next() {
+				var id string
+				if err := rows.Scan(&id); err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "failed to load location assignments")
+				}
+				locationIDs = append(locationIDs, id)
+			}
+			if err := rows.Err(); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to load location assignments")
+			}
+			rows.Close()
+
+			c.SetRequest(c.Request().WithContext(SetAllowedLocations(ctx, locationIDs)))
+			return next(c)
+		}
+	}
+}
+
+// GetAllowedLocations returns the locations the request is restricted to, or
+// false when it may access every location of the tenant.
+func GetAllowedLocations(ctx context.Context) ([]string, bool) {
+	ids, ok := ctx.Value(AllowedLocationsKey).([]string)
+	return ids, ok
+}
+
+// SetAllowedLocations restricts the context to the given locations (useful for
+// testing)
+func SetAllowedLocations(ctx context.Context, locationIDs []string) context.Context {
+	return context.WithValue(ctx, AllowedLocationsKey, locationIDs)
+}
+
+// LocationAllowed reports whether the context may access the location.
+func LocationAllowed(ctx context.Context, locationID string) bool {
+	ids, restricted := GetAllowedLocations(ctx)
+	if !restricted {
+		return true
+	}
+	for _, id := range ids {
+		if strings.EqualFold(id, locationID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocationAllowed(t *testing.T) {
+	ctx := context.Background()
+	assert.True(t, LocationAllowed(ctx, "any"), "unrestricted without assignments")
+
+	ctx = SetAllowedLocations(ctx, []string{"3f1c2a9e-0000-4000-8000-000000000001"})
+	ids, restricted := GetAllowedLocations(ctx)
+	assert.True(t, restricted)
+	assert.Len(t, ids, 1)
+	assert.True(t, LocationAllowed(ctx, "3F1C2A9E-0000-4000-8000-000000000001"))
+	assert.False(t, LocationAllowed(ctx, "3f1c2a9e-0000-4000-8000-000000000002"))
+
+	// Restricted to no location at all
+	ctx = SetAllowedLocations(context.Background(), []string{})
+	_, restricted = GetAllowedLocations(ctx)
+	assert.True(t, restricted)
+	assert.False(t, LocationAllowed(ctx, "3f1c2a9e-0000-4000-8000-000000000001"))
+}
+
+func TestResolveLocationsAdminBypass(t *testing.T) {
+	e := echo.New()
+	var restricted bool
+	next := func(c echo.Context) error {
+		_, restricted = GetAllowedLocations(c.Request().Context())
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	// Admins never hit the database, so no connection is needed
+	for _, role := range []string{RoleAdmin, RoleSystemAdmin} {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+		c.Set("user", &Claims{UserID: "u1", TenantID: "t1", Role: role})
+		assert.NoError(t, ResolveLocations(nil)(next)(c))
+		assert.False(t, restricted, role)
+	}
+}
@@ -0,0 +1,244 @@
+package middleware
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Permissions guarding the API. Every protected route requires one of them;
+// roles are sets of permissions.
+const (
+	PermItemRead          = "item.read"
+	PermItemWrite         = "item.write"
+	PermLocationRead      = "location.read"
+	PermLocationWrite     = "location.write"
+	PermSupplierRead      = "supplier.read"
+	PermSupplierWrite     = "supplier.write"
+	PermCategoryRead      = "category.read"
+	PermCategoryWrite     = "category.write"
+	PermInventoryRead     = "inventory.read"
+	PermDashboardRead     = "dashboard.read"
+	PermReportRead        = "report.read"
+	PermPORead            = "po.read"
+	PermPOWrite           = "po.write"
+	PermPOApprove         = "po.approve"
+	PermPOReceive         = "po.receive"
+	PermPOClose           = "po.close"
+	PermTransferRead      = "transfer.read"
+	PermTransferWrite     = "transfer.write"
+	PermTransferApprove   = "transfer.approve"
+	PermTransferShip      = "transfer.ship"
+	PermTransferReceive   = "transfer.receive"
+	PermAdjustmentRead    = "adjustment.read"
+	PermAdjustmentWrite   = "adjustment.write"
+	PermAdjustmentApprove = "adjustment.approve"
+	PermReceiptRead       = "receipt.read"
+	PermReceiptWrite      = "receipt.write"
+	PermReceiptApprove    = "receipt.approve"
+	PermReceiptPost       = "receipt.post"
+	PermReceiptClose      = "receipt.close"
+	PermCountRead         = "count.read"
+	PermCountWrite        = "count.write"
+	PermUserRead          = "user.read"
+	PermUserWrite         = "user.write"
+	PermRoleWrite         = "role.write"
+	PermAPIKeyWrite       = "apikey.write"
+	PermSettingsRead      = "settings.read"
+	PermSettingsWrite     = "settings.write"
+	PermAuditRead         = "audit.read"
+	PermAuditVerify       = "audit.verify"
+	PermSystemTenants     = "system.tenants"
+)
+
+// Permission describes an entry of the catalogue.
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// System permissions cannot be granted by tenant roles
+	System bool `json:"system,omitempty"`
+}
+
+// PermissionCatalogue lists every permission.
+var PermissionCatalogue = []Permission{
+	{Name: PermItemRead, Description: "View items"},
+	{Name: PermItemWrite, Description: "Create, edit and delete items"},
+	{Name: PermLocationRead, Description: "View locations"},
+	{Name: PermLocationWrite, Description: "Create, edit and delete locations"},
+	{Name: PermSupplierRead, Description: "View suppliers, their items, prices and scorecards"},
+	{Name: PermSupplierWrite, Description: "Manage suppliers, supplier items and price lists"},
+	{Name: PermCategoryRead, Description: "View categories"},
+	{Name: PermCategoryWrite, Description: "Create, edit and delete categories"},
+	{Name: PermInventoryRead, Description: "View stock levels, movements and valuation"},
+	{Name: PermDashboardRead, Description: "View the dashboard"},
+	{Name: PermReportRead, Description: "View reports"},
+	{Name: PermPORead, Description: "View purchase orders"},
+	{Name: PermPOWrite, Description: "Create, edit and delete draft purchase orders"},
+	{Name: PermPOApprove, Description: "Approve purchase orders"},
+	{Name: PermPOReceive, Description: "Receive and return goods against purchase orders"},
+	{Name: PermPOClose, Description: "Close purchase orders"},
+	{Name: PermTransferRead, Description: "View transfers"},
+	{Name: PermTransferWrite, Description: "Create, edit and delete draft transfers"},
+	{Name: PermTransferApprove, Description: "Approve transfers"},
+	{Name: PermTransferShip, Description: "Ship transfers"},
+	{Name: PermTransferReceive, Description: "Receive transfers"},
+	{Name: PermAdjustmentRead, Description: "View adjustments"},
+	{Name: PermAdjustmentWrite, Description: "Create, edit and delete draft adjustments"},
+	{Name: PermAdjustmentApprove, Description: "Approve adjustments (write-offs and corrections)"},
+	{Name: PermReceiptRead, Description: "View goods receipts"},
+	{Name: PermReceiptWrite, Description: "Create and edit goods receipts, their lines and charges"},
+	{Name: PermReceiptApprove, Description: "Approve goods receipts"},
+	{Name: PermReceiptPost, Description: "Post goods receipts to stock"},
+	{Name: PermReceiptClose, Description: "Close goods receipts"},
+	{Name: PermCountRead, Description: "View stock counts"},
+	{Name: PermCountWrite, Description: "Create and edit stock counts"},
+	{Name: PermUserRead, Description: "View users and roles"},
+	{Name: PermUserWrite, Description: "Create, edit, disable, unlock and invite users"},
+	{Name: PermRoleWrite, Description: "Create, edit and delete custom roles"},
+	{Name: PermAPIKeyWrite, Description: "View, create, rotate and revoke API keys"},
+	{Name: PermSettingsRead, Description: "View tenant settings"},
+	{Name: PermSettingsWrite, Description: "Change tenant settings"},
+	{Name: PermAuditRead, Description: "View the audit trail"},
+	{Name: PermAuditVerify, Description: "Verify audit chains and manage checkpoints"},
+	{Name: PermSystemTenants, Description: "Manage all tenants", System: true},
+}
+
+// Built-in roles
+const (
+	RoleAdmin       = "ADMIN"
+	RoleManager     = "MANAGER"
+	RoleClerk       = "CLERK"
+	RoleSystemAdmin = "SYSTEM_ADMIN"
+)
+
+var clerkPermissions = []string{
+	PermItemRead, PermLocationRead, PermSupplierRead, PermCategoryRead,
+	PermInventoryRead, PermDashboardRead,
+	PermPORead, PermPOWrite, PermPOReceive,
+	PermTransferRead, PermTransferWrite,
+	PermAdjustmentRead, PermAdjustmentWrite,
+	PermReceiptRead, PermReceiptWrite,
+	PermCountRead, PermCountWrite,
+	PermSettingsRead,
+}
+
+var managerPermissions = append(append([]string{}, clerkPermissions...),
+	PermItemWrite, PermLocationWrite, PermSupplierWrite, PermCategoryWrite,
+	PermReportRead,
+	PermPOApprove, PermPOClose,
+	PermTransferApprove, PermTransferShip, PermTransferReceive,
+	PermAdjustmentApprove,
+	PermReceiptApprove, PermReceiptPost, PermReceiptClose,
+	PermUserRead, PermAuditRead,
+)
+
+// BuiltinRoles maps the built-in roles to their permissions. ADMIN has every
+// tenant permission and SYSTEM_ADMIN every permission.
+var BuiltinRoles = map[string][]string{
+	RoleAdmin:       tenantPermissions(),
+	RoleManager:     managerPermissions,
+	RoleClerk:       clerkPermissions,
+	RoleSystemAdmin: allPermissions(),
+}
+
+func tenantPermissions() []string {
+	var perms []string
+	for _, p := range PermissionCatalogue {
+		if !p.System {
+			perms = append(perms, p.Name)
+		}
+	}
+	return perms
+}
+
+func allPermissions() []string {
+	perms := make([]string, len(PermissionCatalogue))
+	for i, p := range PermissionCatalogue {
+		perms[i] = p.Name
+	}
+	return perms
+}
+
+// IsBuiltinRole reports whether role is one of the built-in roles.
+func IsBuiltinRole(role string) bool {
+	_, ok := BuiltinRoles[role]
+	return ok
+}
+
+// IsTenantPermission reports whether perm exists and may be granted by a
+// tenant's custom role.
+func IsTenantPermission(perm string) bool {
+	for _, p := range PermissionCatalogue {
+		if p.Name == perm {
+			return !p.System
+		}
+	}
+	return false
+}
+
+// SortedPermissions returns perms sorted and without duplicates.
+func SortedPermissions(perms []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, p := range perms {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// HasPermission reports whether the token grants perm. Tokens carry the
+// permissions of the user's role as of their issue; tokens without any fall
+// back to the built-in role's.
+func (c *Claims) HasPermission(perm string) bool {
+	perms := c.Permissions
+	if perms == nil {
+		perms = BuiltinRoles[c.Role]
+	}
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission allows the request only if the token grants perm.
+func RequirePermission(perm string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := c.Get("user").(*Claims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "user not found in context")
+			}
+			if !user.HasPermission(perm) {
+				return echo.NewHTTPError(http.StatusForbidden, "missing permission "+perm)
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireAnyPermission allows the request if the token grants at least one of
+// perms.
+func RequireAnyPermission(perms ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := c.Get("user").(*Claims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "user not found in context")
+			}
+			for _, perm := range perms {
+				if user.HasPermission(perm) {
+					return next(c)
+				}
+			}
+			return echo.NewHTTPError(http.StatusForbidden, "missing permission "+strings.Join(perms, " or "))
+		}
+	}
+}
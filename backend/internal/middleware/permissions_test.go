@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltinRolePermissions(t *testing.T) {
+	clerk := &Claims{Role: RoleClerk}
+	manager := &Claims{Role: RoleManager}
+	admin := &Claims{Role: RoleAdmin}
+	system := &Claims{Role: RoleSystemAdmin}
+
+	assert.True(t, clerk.HasPermission(PermPOWrite))
+	assert.False(t, clerk.HasPermission(PermPOApprove))
+	assert.False(t, clerk.HasPermission(PermAdjustmentApprove))
+	assert.False(t, clerk.HasPermission(PermUserRead))
+
+	assert.True(t, manager.HasPermission(PermPOApprove))
+	assert.True(t, manager.HasPermission(PermUserRead))
+	assert.False(t, manager.HasPermission(PermUserWrite))
+
+	assert.True(t, admin.HasPermission(PermRoleWrite))
+	assert.False(t, admin.HasPermission(PermSystemTenants))
+	assert.True(t, system.HasPermission(PermSystemTenants))
+
+	assert.False(t, (&Claims{Role: "UNKNOWN"}).HasPermission(PermItemRead))
+}
+
+func TestHasPermissionUsesTokenPermissions(t *testing.T) {
+	// A custom role's permissions come from the token, not the role name
+	custom := &Claims{Role: "RECEIVER", Permissions: []string{PermReceiptRead, PermReceiptPost}}
+	assert.True(t, custom.HasPermission(PermReceiptPost))
+	assert.False(t, custom.HasPermission(PermReceiptApprove))
+
+	// An empty list grants nothing, even for a built-in role name
+	none := &Claims{Role: RoleAdmin, Permissions: []string{}}
+	assert.False(t, none.HasPermission(PermItemRead))
+}
+
+func TestTenantPermissions(t *testing.T) {
+	assert.True(t, IsTenantPermission(PermItemRead))
+	assert.False(t, IsTenantPermission(PermSystemTenants))
+	assert.False(t, IsTenantPermission("item.delete"))
+	assert.Equal(t, []string{PermItemRead, PermItemWrite}, SortedPermissions([]string{PermItemWrite, PermItemRead, PermItemWrite}))
+}
+
+func TestRequirePermission(t *testing.T) {
+	e := echo.New()
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+
+	c := e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+	c.Set("user", &Claims{UserID: "u1", Role: RoleClerk})
+	assert.NoError(t, RequirePermission(PermAdjustmentWrite)(ok)(c))
+
+	err := RequirePermission(PermAdjustmentApprove)(ok)(c)
+	httpErr, isHTTP := err.(*echo.HTTPError)
+	if assert.True(t, isHTTP) {
+		assert.Equal(t, http.StatusForbidden, httpErr.Code)
+	}
+}
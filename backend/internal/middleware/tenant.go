@@ -2,9 +2,13 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
 	"strings"
 
+	"inventory/internal/services"
+
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
@@ -13,6 +17,9 @@ type TenantContextKey string
 
 const TenantIDKey TenantContextKey = "tenant_id"
 
+// HostTenantKey holds the *services.Tenant the request's host belongs to
+const HostTenantKey TenantContextKey = "host_tenant"
+
 // TenantMiddleware extracts tenant information from the request and adds it to context
 func TenantMiddleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
@@ -23,8 +30,7 @@ func TenantMiddleware() echo.MiddlewareFunc {
 			}
 
 			// Add tenant ID to request context
-			ctx := context.WithValue(c.Request().Context(), TenantIDKey, tenantID)
-			c.SetRequest(c.Request().WithContext(ctx))
+			c.SetRequest(c.Request().WithContext(SetTenantID(c.Request().Context(), tenantID)))
 
 			return next(c)
 		}
@@ -42,13 +48,9 @@ func extractTenantID(c echo.Context) (uuid.UUID, error) {
 	// Method 2: From Authorization token claims (will be implemented later)
 	// This requires the JWT middleware to run first and extract tenant from token
 
-	// Method 3: From subdomain (e.g., tenant1.api.example.com)
-	host := c.Request().Host
-	if strings.Contains(host, ".") {
-		subdomain := strings.Split(host, ".")[0]
-		// This would require a lookup table from subdomain to tenant ID
-		// For now, we'll skip this implementation
-		_ = subdomain
+	// Method 3: From the subdomain or custom domain, resolved by HostTenant
+	if tenant, ok := GetHostTenant(c.Request().Context()); ok {
+		return tenant.ID, nil
 	}
 
 	// Method 4: From path parameter (e.g., /api/v1/tenants/{tenant_id}/items)
@@ -66,6 +68,91 @@ func extractTenantID(c echo.Context) (uuid.UUID, error) {
 	return uuid.Nil, echo.NewHTTPError(http.StatusBadRequest, "Tenant identifier not found")
 }
 
+// Subdomains of the base domain that do not name a tenant
+var reservedSubdomains = map[string]bool{"www": true, "api": true, "app": true}
+
+// TenantLookup finds active tenants by slug and by custom domain; it is
+// usually a services.TenantCache.
+type TenantLookup interface {
+	GetTenantBySlug(ctx context.Context, slug string) (*services.Tenant, error)
+	GetTenantByDomain(ctx context.Context, domain string) (*services.Tenant, error)
+}
+
+// TenantResolver finds the tenant a host belongs to: "<slug>.<BaseDomain>" by
+// slug, any other host by custom domain. Without a BaseDomain only custom
+// domains are resolved.
+type TenantResolver struct {
+	BaseDomain string
+	Tenants    TenantLookup
+}
+
+// errUnknownSubdomain is returned for a subdomain of the base domain that
+// names no tenant
+var errUnknownSubdomain = errors.New("unknown tenant subdomain")
+
+// Resolve returns the tenant of host, or nil for hosts that belong to no
+// tenant (the base domain itself, IP addresses, localhost).
+func (r *TenantResolver) Resolve(ctx context.Context, host string) (*services.Tenant, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || host == "localhost" || net.ParseIP(host) != nil {
+		return nil, nil
+	}
+
+	base := strings.TrimSuffix(strings.ToLower(r.BaseDomain), ".")
+	if base != "" {
+		if host == base {
+			return nil, nil
+		}
+		if slug, ok := strings.CutSuffix(host, "."+base); ok {
+			if strings.Contains(slug, ".") || reservedSubdomains[slug] {
+				return nil, nil
+			}
+			tenant, err := r.Tenants.GetTenantBySlug(ctx, slug)
+			if errors.Is(err, services.ErrTenantNotFound) {
+				return nil, errUnknownSubdomain
+			}
+			return tenant, err
+		}
+	}
+
+	tenant, err := r.Tenants.GetTenantByDomain(ctx, host)
+	if errors.Is(err, services.ErrTenantNotFound) {
+		return nil, nil
+	}
+	return tenant, err
+}
+
+// HostTenant resolves the tenant of the request's Host header. Requests to an
+// unknown tenant subdomain are rejected; JWT then rejects tokens of any other
+// tenant on a tenant's host.
+func HostTenant(resolver *TenantResolver) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			tenant, err := resolver.Resolve(c.Request().Context(), c.Request().Host)
+			if err == errUnknownSubdomain {
+				return echo.NewHTTPError(http.StatusNotFound, "unknown tenant")
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve tenant")
+			}
+			if tenant != nil {
+				ctx := context.WithValue(c.Request().Context(), HostTenantKey, tenant)
+				c.SetRequest(c.Request().WithContext(ctx))
+			}
+			return next(c)
+		}
+	}
+}
+
+// GetHostTenant returns the tenant the request's host belongs to, if any
+func GetHostTenant(ctx context.Context) (*services.Tenant, bool) {
+	tenant, ok := ctx.Value(HostTenantKey).(*services.Tenant)
+	return tenant, ok && tenant != nil
+}
+
 // GetTenantID retrieves the tenant ID from the request context
 func GetTenantID(ctx context.Context) (uuid.UUID, bool) {
 	tenantID, ok := ctx.Value(TenantIDKey).(uuid.UUID)
@@ -85,7 +172,21 @@ func RequireTenant() echo.MiddlewareFunc {
 	}
 }
 
-// SetTenantID manually sets the tenant ID in context (useful for testing)
+// SetTenantID sets the tenant ID in context and scopes the database
+// statements run with it to that tenant
 func SetTenantID(ctx context.Context, tenantID uuid.UUID) context.Context {
+	ctx = services.TenantScope(ctx, tenantID.String())
 	return context.WithValue(ctx, TenantIDKey, tenantID)
 }
+
+// SystemScope lets the database statements of the request see every tenant.
+// It belongs on sign-in, the user's own account and system administration
+// routes only, after any middleware that sets the tenant.
+func SystemScope() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.SetRequest(c.Request().WithContext(services.SystemScope(c.Request().Context())))
+			return next(c)
+		}
+	}
+}
@@ -5,10 +5,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"inventory/internal/services"
+
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTenantMiddleware(t *testing.T) {
@@ -143,3 +148,106 @@ func TestSetTenantID(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, tenantID, extractedTenantID)
 }
+
+type fakeTenants map[string]*services.Tenant
+
+func (f fakeTenants) GetTenantBySlug(ctx context.Context, slug string) (*services.Tenant, error) {
+	if t, ok := f["slug:"+slug]; ok {
+		return t, nil
+	}
+	return nil, services.ErrTenantNotFound
+}
+
+func (f fakeTenants) GetTenantByDomain(ctx context.Context, domain string) (*services.Tenant, error) {
+	if t, ok := f["domain:"+domain]; ok {
+		return t, nil
+	}
+	return nil, services.ErrTenantNotFound
+}
+
+func TestTenantResolver(t *testing.T) {
+	acme := &services.Tenant{ID: uuid.New(), Slug: "acme"}
+	globex := &services.Tenant{ID: uuid.New(), Slug: "globex"}
+	r := &TenantResolver{
+		BaseDomain: "inventory.example.com",
+		Tenants:    fakeTenants{"slug:acme": acme, "domain:stock.globex.com": globex},
+	}
+
+	tests := []struct {
+		host    string
+		want    *services.Tenant
+		wantErr bool
+	}{
+		{"acme.inventory.example.com", acme, false},
+		{"ACME.inventory.example.com:443", acme, false},
+		{"stock.globex.com", globex, false},
+		{"stock.globex.com.", globex, false},
+		{"nope.inventory.example.com", nil, true},
+		{"inventory.example.com", nil, false},
+		{"www.inventory.example.com", nil, false},
+		{"a.b.inventory.example.com", nil, false},
+		{"api.other.com", nil, false},
+		{"localhost:8080", nil, false},
+		{"127.0.0.1:8080", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, err := r.Resolve(context.Background(), tt.host)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHostTenantRejectsOtherTenantsTokens(t *testing.T) {
+	acme := &services.Tenant{ID: uuid.New(), Slug: "acme"}
+	resolver := &TenantResolver{BaseDomain: "inventory.example.com", Tenants: fakeTenants{"slug:acme": acme}}
+	secret := "test-secret"
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	handler := HostTenant(resolver)(JWT(secret, nil)(ok))
+
+	token := func(tenantID string) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+			UserID:   "u1",
+			TenantID: tenantID,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		}).SignedString([]byte(secret))
+		require.NoError(t, err)
+		return signed
+	}
+
+	tests := []struct {
+		name     string
+		host     string
+		tenantID string
+		wantCode int
+	}{
+		{"own tenant", "acme.inventory.example.com", acme.ID.String(), http.StatusNoContent},
+		{"other tenant", "acme.inventory.example.com", uuid.NewString(), http.StatusUnauthorized},
+		{"unknown subdomain", "nope.inventory.example.com", acme.ID.String(), http.StatusNotFound},
+		{"main host", "inventory.example.com", uuid.NewString(), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tt.host
+			req.Header.Set("Authorization", "Bearer "+token(tt.tenantID))
+			rec := httptest.NewRecorder()
+			err := handler(echo.New().NewContext(req, rec))
+			if tt.wantCode == http.StatusNoContent {
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantCode, rec.Code)
+				return
+			}
+			var httpErr *echo.HTTPError
+			require.ErrorAs(t, err, &httpErr)
+			assert.Equal(t, tt.wantCode, httpErr.Code)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// RequestMeter counts API requests per tenant; it is usually a
+// services.UsageMeter.
+type RequestMeter interface {
+	// CountRequest returns false when the tenant has no requests left
+	CountRequest(tenantID string) bool
+}
+
+// MeterRequests counts each request against its tenant's monthly API request
+// allowance and answers QUOTA_EXCEEDED once that is used up. It must run after
+// JWT.
+func MeterRequests(meter RequestMeter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			tenantID, ok := GetTenantID(c.Request().Context())
+			if ok && tenantID != uuid.Nil && !meter.CountRequest(tenantID.String()) {
+				return echo.NewHTTPError(http.StatusTooManyRequests, map[string]interface{}{
+					"error": map[string]interface{}{
+						"code":    "QUOTA_EXCEEDED",
+						"message": "the monthly API request allowance of this plan is used up",
+						"details": map[string]interface{}{"resource": "api_requests"},
+					},
+				})
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMeter struct {
+	exceeded map[string]bool
+	counted  []string
+}
+
+func (m *fakeMeter) CountRequest(tenantID string) bool {
+	if m.exceeded[tenantID] {
+		return false
+	}
+	m.counted = append(m.counted, tenantID)
+	return true
+}
+
+func TestMeterRequests(t *testing.T) {
+	e := echo.New()
+	ok, over := uuid.New(), uuid.New()
+	meter := &fakeMeter{exceeded: map[string]bool{over.String(): true}}
+	handler := MeterRequests(meter)(func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	serve := func(tenantID uuid.UUID) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), TenantIDKey, tenantID))
+		rec := httptest.NewRecorder()
+		return rec, handler(e.NewContext(req, rec))
+	}
+
+	rec, err := serve(ok)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []string{ok.String()}, meter.counted)
+
+	_, err = serve(over)
+	httpErr, isHTTP := err.(*echo.HTTPError)
+	require.True(t, isHTTP)
+	assert.Equal(t, http.StatusTooManyRequests, httpErr.Code)
+	body := httpErr.Message.(map[string]interface{})["error"].(map[string]interface{})
+	assert.Equal(t, "QUOTA_EXCEEDED", body["code"])
+	assert.Equal(t, []string{ok.String()}, meter.counted)
+}
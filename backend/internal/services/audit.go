@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DBTX is implemented by both *sql.DB and *sql.Tx so services can run inside a
+// caller's transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// AuditService records and queries the audit trail in audit_logs.
+type AuditService struct {
+	db DBTX
+}
+
+func NewAuditService(db DBTX) *AuditService {
+	return &AuditService{db: db}
+}
+
+// AuditEntry is one audited change. Before and After are JSON snapshots of the
+// entity; either may be nil (creates have no before, hard deletes no after).
+type AuditEntry struct {
+	TenantID  string
+	UserID    string
+	Action    string
+	Entity    string
+	EntityID  string
+	RequestID string
+	IPAddress string
+	UserAgent string
+	Before    json.RawMessage
+	After     json.RawMessage
+}
+
+// AuditLog is a stored audit entry.
+type AuditLog struct {
+	ID        string          `json:"id"`
+	UserID    *string         `json:"user_id"`
+	UserEmail *string         `json:"user_email,omitempty"`
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityID  string          `json:"entity_id"`
+	RequestID *string         `json:"request_id"`
+	IPAddress *string         `json:"ip_address"`
+	UserAgent *string         `json:"user_agent,omitempty"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	At        time.Time       `json:"at"`
+}
+
+// AuditFilter narrows ListAuditLogs. Empty fields are ignored; From is
+// inclusive and To exclusive.
+type AuditFilter struct {
+	TenantID string
+	Entity   string
+	EntityID string
+	UserID   string
+	Action   string
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+	Offset   int
+}
+
+// auditedEntity describes how to snapshot an audited entity: its table, an
+// optional line table joined on lineFK, and columns that must never be logged.
+type auditedEntity struct {
+	table  string
+	lines  string
+	lineFK string
+	omit   []string
+}
+
+var auditedEntities = map[string]auditedEntity{
+	"item":           {table: "items"},
+	"location":       {table: "locations"},
+	"supplier":       {table: "suppliers"},
+	"supplier_item":  {table: "supplier_items", lines: "supplier_prices", lineFK: "supplier_item_id"},
+	"category":       {table: "categories"},
+	"purchase_order": {table: "purchase_orders", lines: "purchase_order_lines", lineFK: "purchase_order_id"},
+	"receipt":        {table: "goods_receipts", lines: "goods_receipt_lines", lineFK: "receipt_id"},
+	"receipt_charge": {table: "receipt_charges"},
+	"transfer":       {table: "transfers", lines: "transfer_lines", lineFK: "transfer_id"},
+	"adjustment":     {table: "adjustments", lines: "adjustment_lines", lineFK: "adjustment_id"},
+	"count_batch":    {table: "count_batches", lines: "count_lines", lineFK: "batch_id"},
+	"user":           {table: "users", omit: []string{"password_hash", "mfa_secret", "mfa_last_counter"}},
+	"tenant":         {table: "tenants"},
+	"invitation":     {table: "invitations", omit: []string{"token_hash"}},
+	"role":           {table: "roles"},
+	"approval_rule":  {table: "approval_rules"},
+	"api_key":        {table: "api_keys", omit: []string{"key_hash"}},
+	"oidc_provider":  {table: "oidc_providers", omit: []string{"client_secret"}},
+	"membership":     {table: "tenant_memberships"},
+}
+
+// IsAuditedEntity reports whether entity can be snapshotted.
+func IsAuditedEntity(entity string) bool {
+	_, ok := auditedEntities[entity]
+	return ok
+}
+
+// Snapshot returns the current row of an entity (with its lines, if any) as
+// JSON, or nil when it does not exist. Run it on the mutation's transaction so
+// the before and after images match what is committed.
+func (s *AuditService) Snapshot(ctx context.Context, entity, id string) (json.RawMessage, error) {
+	def, ok := auditedEntities[entity]
+	if !ok {
+		return nil, fmt.Errorf("unknown audit entity %q", entity)
+	}
+
+	row := "to_jsonb(t)"
+	for _, col := range def.omit {
+		row += fmt.Sprintf(" - '%s'", col)
+	}
+	if def.lines != "" {
+		row = fmt.Sprintf(`%s || jsonb_build_object('lines', COALESCE(
+			(SELECT jsonb_agg(to_jsonb(l) ORDER BY l.id) FROM %s l WHERE l.%s = t.id), '[]'::jsonb))`,
+			row, def.lines, def.lineFK)
+	}
+
+	var snapshot []byte
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT %s FROM %s t WHERE t.id = $1`, row, def.table), id).Scan(&snapshot)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot %s: %w", entity, err)
+	}
+	return snapshot, nil
+}
+
+// Record writes an audit entry and links it into the tenant's hash chain, so it
+// must run on a transaction.
+func (s *AuditService) Record(ctx context.Context, e AuditEntry) error {
+	var id string
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO audit_logs (tenant_id, user_id, action, entity, entity_id, request_id, ip_address, user_agent, before, after, at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW())
+		RETURNING id
+	`, e.TenantID, nullString(e.UserID), e.Action, e.Entity, e.EntityID,
+		nullString(e.RequestID), nullString(e.IPAddress), nullString(e.UserAgent), nullJSON(e.Before), nullJSON(e.After)).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return NewHashChainService(s.db).Append(ctx, ChainAuditLogs, e.TenantID, id)
+}
+
+// ListAuditLogs returns a page of a tenant's audit trail, newest first, and the
+// total number of matching entries.
+func (s *AuditService) ListAuditLogs(ctx context.Context, f AuditFilter) ([]AuditLog, int64, error) {
+	args := []interface{}{f.TenantID}
+	where := []string{"a.tenant_id = $1"}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Entity != "" {
+		add("a.entity = $%d", f.Entity)
+	}
+	if f.EntityID != "" {
+		add("a.entity_id = $%d", f.EntityID)
+	}
+	if f.UserID != "" {
+		add("a.user_id = $%d", f.UserID)
+	}
+	if f.Action != "" {
+		add("a.action = $%d", strings.ToUpper(f.Action))
+	}
+	if f.From != nil {
+		add("a.at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("a.at < $%d", *f.To)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT a.id, a.user_id, u.email, a.action, a.entity, a.entity_id, a.request_id, a.ip_address,
+			a.user_agent, a.before, a.after, a.at, COUNT(*) OVER()
+		FROM audit_logs a
+		LEFT JOIN users u ON u.id = a.user_id
+		WHERE %s
+		ORDER BY a.at DESC, a.id
+		LIMIT %d OFFSET %d`, strings.Join(where, " AND "), f.Limit, f.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	logs := []AuditLog{}
+	var total int64
+	for rows.Next() {
+		var l AuditLog
+		var before, after []byte
+		if err := rows.Scan(&l.ID, &l.UserID, &l.UserEmail, &l.Action, &l.Entity, &l.EntityID, &l.RequestID,
+			&l.IPAddress, &l.UserAgent, &before, &after, &l.At, &total); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan audit log: %w", err)
+		}
+		if before != nil {
+			l.Before = before
+		}
+		if after != nil {
+			l.After = after
+		}
+		logs = append(logs, l)
+	}
+	return logs, total, rows.Err()
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullJSON(b json.RawMessage) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return []byte(b)
+}
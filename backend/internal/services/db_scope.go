@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/lib/pq"
+)
+
+// The database's row level security policies only show a connection the rows
+// of the tenant in its app.tenant_id setting, or every row when app.rls_bypass
+// is on. A database opened with OpenDB sets both from the context of each
+// statement, so a statement run without a scope sees no tenant's rows.
+
+type dbScopeKey struct{}
+
+type dbScope struct {
+	tenantID string
+	system   bool
+}
+
+// TenantScope returns a copy of ctx whose statements only see tenantID's rows
+func TenantScope(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, dbScopeKey{}, dbScope{tenantID: tenantID})
+}
+
+// SystemScope returns a copy of ctx whose statements see every tenant's rows.
+// It is for sign-in, system administration, background jobs and tools only.
+func SystemScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dbScopeKey{}, dbScope{system: true})
+}
+
+// OpenDB opens the Postgres database at dsn with statements scoped by their
+// context, see TenantScope and SystemScope.
+func OpenDB(dsn string) (*sql.DB, error) {
+	return openScopedDB(dsn, dbScope{})
+}
+
+// OpenSystemDB opens the Postgres database at dsn for the command line tools:
+// statements without a scope in their context see every tenant's rows.
+func OpenSystemDB(dsn string) (*sql.DB, error) {
+	return openScopedDB(dsn, dbScope{system: true})
+}
+
+func openScopedDB(dsn string, unscoped dbScope) (*sql.DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(scopedConnector{Connector: connector, unscoped: unscoped}), nil
+}
+
+type scopedConnector struct {
+	driver.Connector
+	unscoped dbScope // the scope of statements without one
+}
+
+func (c scopedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &scopedConn{Conn: conn, unscoped: c.unscoped}, nil
+}
+
+// scopedConn sets the scope of each statement's context on the connection
+// before running it. A transaction keeps the scope it began with, as
+// statements run on a *sql.Tx do not always carry the context.
+//
+// Statements outside a transaction use session settings: the connection goes
+// back to the pool with the last scope still set, but every statement run on
+// it sets its own scope first (skipped only when the settings already hold
+// it), so a scope is never inherited from an earlier user of the connection.
+// This needs the API to hold a server connection for the life of a driver
+// connection, which rules out transaction pooling in front of the database.
+// Transactions set their scope with is_local instead, so it ends with them
+// and leaves the session settings as they were.
+type scopedConn struct {
+	driver.Conn
+	unscoped dbScope
+	applied  *dbScope // what the connection's session settings hold; nil when unknown
+	inTx     bool
+}
+
+const setScope = `SELECT set_config('app.tenant_id', $1, $3), set_config('app.rls_bypass', $2, $3)`
+
+func contextScope(ctx context.Context, unscoped dbScope) dbScope {
+	if scope, ok := ctx.Value(dbScopeKey{}).(dbScope); ok {
+		return scope
+	}
+	return unscoped
+}
+
+func (c *scopedConn) setScope(ctx context.Context, scope dbScope, local bool) error {
+	bypass := "off"
+	if scope.system {
+		bypass = "on"
+	}
+	_, err := c.Conn.(driver.ExecerContext).ExecContext(ctx, setScope, []driver.NamedValue{
+		{Ordinal: 1, Value: scope.tenantID}, {Ordinal: 2, Value: bypass}, {Ordinal: 3, Value: local},
+	})
+	return err
+}
+
+func (c *scopedConn) applyScope(ctx context.Context) error {
+	if c.inTx {
+		return nil
+	}
+	scope := contextScope(ctx, c.unscoped)
+	if c.applied != nil && *c.applied == scope {
+		return nil
+	}
+	if err := c.setScope(ctx, scope, false); err != nil {
+		c.applied = nil
+		return err
+	}
+	c.applied = &scope
+	return nil
+}
+
+func (c *scopedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.applyScope(ctx); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c *scopedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.applyScope(ctx); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *scopedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.applyScope(ctx); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+}
+
+func (c *scopedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.setScope(ctx, contextScope(ctx, c.unscoped), true); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	c.inTx = true
+	return &scopedTx{Tx: tx, conn: c}, nil
+}
+
+func (c *scopedConn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+func (c *scopedConn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c *scopedConn) IsValid() bool {
+	return c.Conn.(driver.Validator).IsValid()
+}
+
+type scopedTx struct {
+	driver.Tx
+	conn *scopedConn
+}
+
+func (tx *scopedTx) Commit() error {
+	tx.conn.inTx = false
+	return tx.Tx.Commit()
+}
+
+func (tx *scopedTx) Rollback() error {
+	tx.conn.inTx = false
+	return tx.Tx.Rollback()
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConn records the statements run on it along with their arguments
+type fakeConn struct {
+	log *[]string
+}
+
+func (c fakeConn) record(query string, args []driver.NamedValue) {
+	entry := query
+	for _, arg := range args {
+		entry += " " + fmt.Sprint(arg.Value)
+	}
+	*c.log = append(*c.log, entry)
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return c, nil }
+func (c fakeConn) Commit() error                             { *c.log = append(*c.log, "COMMIT"); return nil }
+func (c fakeConn) Rollback() error                           { *c.log = append(*c.log, "ROLLBACK"); return nil }
+func (c fakeConn) Ping(ctx context.Context) error            { return nil }
+func (c fakeConn) ResetSession(ctx context.Context) error    { return nil }
+func (c fakeConn) IsValid() bool                             { return true }
+
+func (c fakeConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	*c.log = append(*c.log, "BEGIN")
+	return c, nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.record(query, args)
+	return driver.RowsAffected(0), nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.record(query, args)
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return nil }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+type fakeConnector struct {
+	log *[]string
+}
+
+func (c fakeConnector) Connect(ctx context.Context) (driver.Conn, error) { return fakeConn{c.log}, nil }
+func (c fakeConnector) Driver() driver.Driver                            { return nil }
+
+func TestScopedConn(t *testing.T) {
+	const set = `SELECT set_config('app.tenant_id', $1, $3), set_config('app.rls_bypass', $2, $3)`
+	var log []string
+	db := sql.OpenDB(scopedConnector{Connector: fakeConnector{&log}})
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	acme := TenantScope(context.Background(), "acme")
+
+	_, err := db.ExecContext(acme, `UPDATE items`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(acme, `DELETE FROM items`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{set + " acme off false", `UPDATE items`, `DELETE FROM items`}, log, "scope is set once per change")
+
+	log = nil
+	_, err = db.Exec(`UPDATE items`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(SystemScope(context.Background()), `UPDATE items`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{set + "  off false", `UPDATE items`, set + "  on false", `UPDATE items`}, log, "unscoped statements see no tenant")
+
+	log = nil
+	tx, err := db.BeginTx(acme, nil)
+	require.NoError(t, err)
+	_, err = tx.Exec(`UPDATE items`)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	rows, err := db.Query(`SELECT id FROM items`)
+	require.NoError(t, err)
+	rows.Close()
+	assert.Equal(t, []string{"BEGIN", set + " acme off true", `UPDATE items`, "COMMIT", set + "  off false", `SELECT id FROM items`}, log,
+		"transactions keep the scope they began with")
+}
+
+func TestScopedConnTransactionScopeIsLocal(t *testing.T) {
+	const set = `SELECT set_config('app.tenant_id', $1, $3), set_config('app.rls_bypass', $2, $3)`
+	var log []string
+	db := sql.OpenDB(scopedConnector{Connector: fakeConnector{&log}})
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	system := SystemScope(context.Background())
+	_, err := db.ExecContext(system, `UPDATE tenants`)
+	require.NoError(t, err)
+
+	// A tenant's transaction sets its scope for the transaction only, so
+	// the session settings still hold the system scope after it
+	tx, err := db.BeginTx(TenantScope(context.Background(), "acme"), nil)
+	require.NoError(t, err)
+	_, err = tx.Exec(`UPDATE items`)
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	_, err = db.ExecContext(system, `UPDATE tenants`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		set + "  on false", `UPDATE tenants`,
+		"BEGIN", set + " acme off true", `UPDATE items`, "ROLLBACK",
+		`UPDATE tenants`,
+	}, log)
+}
+
+func TestScopedConnUnscoped(t *testing.T) {
+	var log []string
+	db := sql.OpenDB(scopedConnector{Connector: fakeConnector{&log}, unscoped: dbScope{system: true}})
+	defer db.Close()
+
+	_, err := db.Exec(`UPDATE items`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(TenantScope(context.Background(), "acme"), `UPDATE items`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`SELECT set_config('app.tenant_id', $1, $3), set_config('app.rls_bypass', $2, $3)  on false`, `UPDATE items`,
+		`SELECT set_config('app.tenant_id', $1, $3), set_config('app.rls_bypass', $2, $3) acme off false`, `UPDATE items`,
+	}, log)
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Hash chains make audit_logs and stock_movements tamper-evident. Every row of a
+// tenant's chain stores its position (chain_seq), the previous row's hash
+// (prev_hash) and row_hash = sha256(prev_hash + "\n" + content), where content
+// is the row's canonical JSON without the chain columns. Editing, inserting or
+// deleting a row directly in SQL breaks the chain from that row on; rewriting
+// the whole chain is caught by the signed checkpoints.
+const (
+	ChainAuditLogs      = "audit_logs"
+	ChainStockMovements = "stock_movements"
+)
+
+// Chains lists the hash-chained tables with the column that orders rows written
+// before chaining was enabled.
+var Chains = []struct {
+	Name    string
+	OrderBy string
+}{
+	{ChainAuditLogs, "at"},
+	{ChainStockMovements, "created_at"},
+}
+
+// chainContent is the canonical content of a chained row t. jsonb output has
+// sorted keys, and stripping nulls keeps old rows stable when nullable columns
+// are added. Timestamps render in the session time zone, so it is pinned to UTC
+// first (see pinUTC).
+const chainContent = `jsonb_strip_nulls(to_jsonb(t) - 'chain_seq' - 'prev_hash' - 'row_hash')::text`
+
+// HashChainService appends rows to and verifies the per-tenant hash chains. All
+// methods except CreateCheckpoints and ListCheckpoints must run on a
+// transaction.
+type HashChainService struct {
+	db DBTX
+}
+
+func NewHashChainService(db DBTX) *HashChainService {
+	return &HashChainService{db: db}
+}
+
+// ChainHash links content to the previous row's hash.
+func ChainHash(prevHash, content string) string {
+	sum := sha256.Sum256([]byte(prevHash + "\n" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+func isChain(chain string) bool {
+	for _, c := range Chains {
+		if c.Name == chain {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *HashChainService) pinUTC(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `SET LOCAL TIME ZONE 'UTC'`); err != nil {
+		return fmt.Errorf("failed to set time zone: %w", err)
+	}
+	return nil
+}
+
+// Append links the just-inserted row id to the end of the tenant's chain. The
+// chain head is locked until the transaction ends, so appends per tenant are
+// serialised.
+func (s *HashChainService) Append(ctx context.Context, chain, tenantID, id string) error {
+	if !isChain(chain) {
+		return fmt.Errorf("unknown hash chain %q", chain)
+	}
+	if err := s.pinUTC(ctx); err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO hash_chain_heads (tenant_id, chain) VALUES ($1, $2) ON CONFLICT DO NOTHING
+	`, tenantID, chain); err != nil {
+		return fmt.Errorf("failed to create chain head: %w", err)
+	}
+	var seq int64
+	var lastHash string
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT seq, last_hash FROM hash_chain_heads WHERE tenant_id = $1 AND chain = $2 FOR UPDATE
+	`, tenantID, chain).Scan(&seq, &lastHash); err != nil {
+		return fmt.Errorf("failed to lock chain head: %w", err)
+	}
+
+	var content string
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT %s FROM %s t WHERE t.id = $1`, chainContent, chain), id).Scan(&content); err != nil {
+		return fmt.Errorf("failed to read %s row: %w", chain, err)
+	}
+
+	seq++
+	hash := ChainHash(lastHash, content)
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET chain_seq = $2, prev_hash = $3, row_hash = $4 WHERE id = $1
+	`, chain), id, seq, lastHash, hash); err != nil {
+		return fmt.Errorf("failed to chain %s row: %w", chain, err)
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE hash_chain_heads SET seq = $3, last_hash = $4, updated_at = NOW() WHERE tenant_id = $1 AND chain = $2
+	`, tenantID, chain, seq, hash); err != nil {
+		return fmt.Errorf("failed to advance chain head: %w", err)
+	}
+	return nil
+}
+
+// StartChain chains a tenant's existing rows in their original order, once.
+// It does nothing when the tenant's chain has already been started, so rows
+// later inserted behind the application's back stay unchained and are reported
+// by Verify.
+func (s *HashChainService) StartChain(ctx context.Context, chain, orderBy, tenantID string) (int, error) {
+	var started bool
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM hash_chain_heads WHERE tenant_id = $1 AND chain = $2)
+	`, tenantID, chain).Scan(&started); err != nil {
+		return 0, fmt.Errorf("failed to check chain head: %w", err)
+	}
+	if started {
+		return 0, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id FROM %s WHERE tenant_id = $1 AND chain_seq IS NULL ORDER BY %s, id
+	`, chain, orderBy), tenantID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list %s rows: %w", chain, err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan %s row: %w", chain, err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list %s rows: %w", chain, err)
+	}
+
+	for _, id := range ids {
+		if err := s.Append(ctx, chain, tenantID, id); err != nil {
+			return 0, err
+		}
+	}
+	if len(ids) == 0 {
+		// Mark the chain as started even when the tenant has no rows yet
+		if _, err := s.db.ExecContext(ctx, `
+			INSERT INTO hash_chain_heads (tenant_id, chain) VALUES ($1, $2) ON CONFLICT DO NOTHING
+		`, tenantID, chain); err != nil {
+			return 0, fmt.Errorf("failed to create chain head: %w", err)
+		}
+	}
+	return len(ids), nil
+}
+
+// ChainBreak is the first link of a chain that does not verify.
+type ChainBreak struct {
+	RowID  string `json:"row_id,omitempty"`
+	Seq    int64  `json:"seq"`
+	Reason string `json:"reason"`
+}
+
+// ChainReport is the result of verifying one tenant's chain.
+type ChainReport struct {
+	TenantID    string      `json:"tenant_id"`
+	Chain       string      `json:"chain"`
+	Rows        int64       `json:"rows"`
+	HeadSeq     int64       `json:"head_seq"`
+	HeadHash    string      `json:"head_hash"`
+	Checkpoints int         `json:"checkpoints_verified"`
+	Valid       bool        `json:"valid"`
+	Break       *ChainBreak `json:"break,omitempty"`
+}
+
+// Verify walks a tenant's chain from the start and reports the first broken
+// link: a row whose content no longer matches its hash, a gap or reordering, a
+// truncated tail, a row that was never chained, or a checkpoint (signed with
+// key) that the chain no longer reproduces. A nil key skips checkpoints.
+func (s *HashChainService) Verify(ctx context.Context, chain, tenantID string, key []byte) (*ChainReport, error) {
+	if !isChain(chain) {
+		return nil, fmt.Errorf("unknown hash chain %q", chain)
+	}
+	if err := s.pinUTC(ctx); err != nil {
+		return nil, err
+	}
+	report := &ChainReport{TenantID: tenantID, Chain: chain}
+
+	var unchained sql.NullString
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT MIN(id::text) FROM %s WHERE tenant_id = $1 AND chain_seq IS NULL
+	`, chain), tenantID).Scan(&unchained); err != nil {
+		return nil, fmt.Errorf("failed to check unchained rows: %w", err)
+	}
+
+	checkpoints, err := s.checkpointsFor(ctx, chain, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	next := 0
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT t.id, t.chain_seq, t.prev_hash, t.row_hash, %s
+		FROM %s t
+		WHERE t.tenant_id = $1 AND t.chain_seq IS NOT NULL
+		ORDER BY t.chain_seq
+	`, chainContent, chain), tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s chain: %w", chain, err)
+	}
+	defer rows.Close()
+
+	var prev string
+	for rows.Next() {
+		var id, prevHash, rowHash, content string
+		var seq int64
+		if err := rows.Scan(&id, &seq, &prevHash, &rowHash, &content); err != nil {
+			return nil, fmt.Errorf("failed to scan %s chain: %w", chain, err)
+		}
+		want := report.HeadSeq + 1
+		switch {
+		case seq != want:
+			report.Break = &ChainBreak{RowID: id, Seq: want, Reason: fmt.Sprintf("row %d is missing (next row is %d)", want, seq)}
+		case prevHash != prev:
+			report.Break = &ChainBreak{RowID: id, Seq: seq, Reason: "previous hash does not match the preceding row"}
+		case ChainHash(prevHash, content) != rowHash:
+			report.Break = &ChainBreak{RowID: id, Seq: seq, Reason: "row content does not match its hash"}
+		}
+		if report.Break != nil {
+			return report, nil
+		}
+		report.Rows++
+		report.HeadSeq, report.HeadHash, prev = seq, rowHash, rowHash
+
+		for ; next < len(checkpoints) && checkpoints[next].Seq == seq; next++ {
+			if b := checkpointBreak(checkpoints[next], rowHash, key); b != nil {
+				report.Break = b
+				return report, nil
+			}
+			report.Checkpoints++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s chain: %w", chain, err)
+	}
+
+	if next < len(checkpoints) && key != nil {
+		cp := checkpoints[next]
+		report.Break = &ChainBreak{Seq: cp.Seq, Reason: fmt.Sprintf("checkpoint %s covers rows that no longer exist", cp.ID)}
+		return report, nil
+	}
+
+	var headSeq int64
+	var headHash string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT seq, last_hash FROM hash_chain_heads WHERE tenant_id = $1 AND chain = $2
+	`, tenantID, chain).Scan(&headSeq, &headHash)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to read chain head: %w", err)
+	}
+	if headSeq != report.HeadSeq || headHash != report.HeadHash {
+		report.Break = &ChainBreak{Seq: report.HeadSeq + 1, Reason: fmt.Sprintf("chain ends at row %d but its head is at row %d", report.HeadSeq, headSeq)}
+		return report, nil
+	}
+	if unchained.Valid {
+		report.Break = &ChainBreak{RowID: unchained.String, Reason: "row is not part of the chain"}
+		return report, nil
+	}
+
+	report.Valid = true
+	return report, nil
+}
+
+func checkpointBreak(cp Checkpoint, rowHash string, key []byte) *ChainBreak {
+	if key == nil {
+		return nil
+	}
+	if !hmac.Equal([]byte(cp.Signature), []byte(SignCheckpoint(key, cp))) {
+		return &ChainBreak{Seq: cp.Seq, Reason: fmt.Sprintf("checkpoint %s has an invalid signature", cp.ID)}
+	}
+	if cp.HeadHash != rowHash {
+		return &ChainBreak{Seq: cp.Seq, Reason: fmt.Sprintf("chain no longer matches checkpoint %s", cp.ID)}
+	}
+	return nil
+}
+
+// Checkpoint is a signed record of a chain head at a point in time. Exported
+// checkpoints let an auditor prove later that the chain up to Seq is unchanged.
+type Checkpoint struct {
+	ID        string    `json:"id"`
+	TenantID  string    `json:"tenant_id"`
+	Chain     string    `json:"chain"`
+	Seq       int64     `json:"seq"`
+	HeadHash  string    `json:"head_hash"`
+	CreatedAt time.Time `json:"created_at"`
+	Signature string    `json:"signature"`
+}
+
+// SignCheckpoint returns the hex HMAC-SHA256 of a checkpoint's fields.
+func SignCheckpoint(key []byte, cp Checkpoint) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s|%s|%d|%s|%s", cp.TenantID, cp.Chain, cp.Seq, cp.HeadHash, cp.CreatedAt.UTC().Format(time.RFC3339))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateCheckpoints signs the current head of every chain that has advanced
+// since its last checkpoint, optionally for one tenant only, and returns the
+// number of checkpoints written.
+func (s *HashChainService) CreateCheckpoints(ctx context.Context, key []byte, tenantID string) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT h.tenant_id, h.chain, h.seq, h.last_hash
+		FROM hash_chain_heads h
+		WHERE h.seq > 0
+		  AND ($1 = '' OR h.tenant_id::text = $1)
+		  AND h.seq > COALESCE((SELECT MAX(c.seq) FROM audit_checkpoints c
+		                        WHERE c.tenant_id = h.tenant_id AND c.chain = h.chain), 0)
+	`, tenantID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read chain heads: %w", err)
+	}
+	var heads []Checkpoint
+	for rows.Next() {
+		var cp Checkpoint
+		if err := rows.Scan(&cp.TenantID, &cp.Chain, &cp.Seq, &cp.HeadHash); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan chain head: %w", err)
+		}
+		heads = append(heads, cp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read chain heads: %w", err)
+	}
+
+	// Signed timestamps have second precision so they survive the round trip
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, cp := range heads {
+		cp.CreatedAt = now
+		cp.Signature = SignCheckpoint(key, cp)
+		if _, err := s.db.ExecContext(ctx, `
+			INSERT INTO audit_checkpoints (tenant_id, chain, seq, head_hash, created_at, signature)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, cp.TenantID, cp.Chain, cp.Seq, cp.HeadHash, cp.CreatedAt, cp.Signature); err != nil {
+			return 0, fmt.Errorf("failed to write checkpoint: %w", err)
+		}
+	}
+	return len(heads), nil
+}
+
+// ListCheckpoints returns a tenant's checkpoints created in [from, to), oldest
+// first. Zero times are unbounded.
+func (s *HashChainService) ListCheckpoints(ctx context.Context, tenantID string, from, to time.Time) ([]Checkpoint, error) {
+	query := `
+		SELECT id, tenant_id, chain, seq, head_hash, created_at, signature
+		FROM audit_checkpoints
+		WHERE tenant_id = $1`
+	args := []interface{}{tenantID}
+	if !from.IsZero() {
+		args = append(args, from)
+		query += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+	if !to.IsZero() {
+		args = append(args, to)
+		query += fmt.Sprintf(" AND created_at < $%d", len(args))
+	}
+	return s.queryCheckpoints(ctx, query+" ORDER BY created_at, chain", args...)
+}
+
+func (s *HashChainService) checkpointsFor(ctx context.Context, chain, tenantID string) ([]Checkpoint, error) {
+	return s.queryCheckpoints(ctx, `
+		SELECT id, tenant_id, chain, seq, head_hash, created_at, signature
+		FROM audit_checkpoints
+		WHERE tenant_id = $1 AND chain = $2
+		ORDER BY seq, created_at`, tenantID, chain)
+}
+
+func (s *HashChainService) queryCheckpoints(ctx context.Context, query string, args ...interface{}) ([]Checkpoint, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	checkpoints := []Checkpoint{}
+	for rows.Next() {
+		var cp Checkpoint
+		if err := rows.Scan(&cp.ID, &cp.TenantID, &cp.Chain, &cp.Seq, &cp.HeadHash, &cp.CreatedAt, &cp.Signature); err != nil {
+			return nil, fmt.Errorf("failed to scan checkpoint: %w", err)
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints, rows.Err()
+}
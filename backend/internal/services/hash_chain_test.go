@@ -0,0 +1,40 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainHash(t *testing.T) {
+	first := ChainHash("", `{"qty": 5}`)
+	assert.Len(t, first, 64)
+	assert.Equal(t, first, ChainHash("", `{"qty": 5}`))
+
+	// Content and position both feed the hash
+	assert.NotEqual(t, first, ChainHash("", `{"qty": 6}`))
+	assert.NotEqual(t, ChainHash(first, `{"qty": 5}`), ChainHash("", `{"qty": 5}`))
+}
+
+func TestSignCheckpoint(t *testing.T) {
+	cp := Checkpoint{
+		TenantID:  "t1",
+		Chain:     ChainAuditLogs,
+		Seq:       42,
+		HeadHash:  ChainHash("", "x"),
+		CreatedAt: time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC),
+	}
+	sig := SignCheckpoint([]byte("key"), cp)
+	assert.Equal(t, sig, SignCheckpoint([]byte("key"), cp))
+	assert.NotEqual(t, sig, SignCheckpoint([]byte("other"), cp))
+
+	tampered := cp
+	tampered.Seq = 41
+	assert.NotEqual(t, sig, SignCheckpoint([]byte("key"), tampered))
+
+	cp.Signature = sig
+	assert.Nil(t, checkpointBreak(cp, cp.HeadHash, []byte("key")))
+	assert.NotNil(t, checkpointBreak(cp, ChainHash("", "y"), []byte("key")))
+	assert.NotNil(t, checkpointBreak(cp, cp.HeadHash, []byte("other")))
+}
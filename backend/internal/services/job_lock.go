@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Names of the background jobs that must run on one API replica at a time
+const (
+	JobStockSnapshots   = "stock_snapshots"
+	JobAuditCheckpoints = "audit_checkpoints"
+)
+
+// RunExclusive runs fn unless another process is running the job of the same
+// name, as told by a session advisory lock held on a dedicated connection for
+// the duration of fn. Returns false when the job was skipped.
+func RunExclusive(ctx context.Context, db *sql.DB, job string, fn func(ctx context.Context) error) (bool, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection for %s: %w", job, err)
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, job).Scan(&locked); err != nil {
+		return false, fmt.Errorf("failed to lock %s: %w", job, err)
+	}
+	if !locked {
+		return false, nil
+	}
+	// Closing the connection returns it to the pool, so unlock explicitly
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, job)
+
+	return true, fn(ctx)
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Throttle scopes: failed logins are counted per account (email address, so
+// unknown addresses are throttled the same way) and per client IP.
+const (
+	ThrottleAccount = "ACCOUNT"
+	ThrottleIP      = "IP"
+)
+
+// ThrottlePolicy configures login throttling. The first FreeAttempts failures
+// of a key are not delayed; after that each failure blocks the key for
+// BaseDelay, doubling per failure up to Lockout. MaxAttempts failures lock the
+// key for Lockout. Failures are forgotten once a key has been quiet for
+// Lockout.
+type ThrottlePolicy struct {
+	MaxAttempts    int
+	IPMaxAttempts  int
+	FreeAttempts   int
+	IPFreeAttempts int
+	BaseDelay      time.Duration
+	Lockout        time.Duration
+}
+
+// LoginThrottle tracks failed logins in login_throttles.
+type LoginThrottle struct {
+	db     DBTX
+	policy ThrottlePolicy
+}
+
+func NewLoginThrottle(db DBTX, policy ThrottlePolicy) *LoginThrottle {
+	return &LoginThrottle{db: db, policy: policy}
+}
+
+// ThrottleResult tells whether a key is blocked and for how long.
+type ThrottleResult struct {
+	Scope      string
+	RetryAfter time.Duration
+	// Locked is set when the block is a lockout rather than a backoff delay
+	Locked bool
+}
+
+// Backoff is the delay after the given number of consecutive failures.
+func Backoff(failures, free int, base, max time.Duration) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	delay := base
+	for i := free + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Check returns the longest block on the account or IP, or nil when a login
+// may be attempted.
+func (t *LoginThrottle) Check(ctx context.Context, email, ip string) (*ThrottleResult, error) {
+	rows, err := t.db.QueryContext(ctx, `
+		SELECT scope, failures, blocked_until FROM login_throttles
+		WHERE ((scope = $1 AND key = $2) OR (scope = $3 AND key = $4)) AND blocked_until > NOW()
+	`, ThrottleAccount, accountKey(email), ThrottleIP, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var worst *ThrottleResult
+	for rows.Next() {
+		var scope string
+		var failures int
+		var blockedUntil time.Time
+		if err := rows.Scan(&scope, &failures, &blockedUntil); err != nil {
+			return nil, err
+		}
+		r := &ThrottleResult{Scope: scope, RetryAfter: time.Until(blockedUntil), Locked: failures >= t.maxAttempts(scope)}
+		if worst == nil || r.RetryAfter > worst.RetryAfter {
+			worst = r
+		}
+	}
+	return worst, rows.Err()
+}
+
+// Fail records a failed login for the account and IP and reports whether the
+// account has just been locked.
+func (t *LoginThrottle) Fail(ctx context.Context, email, ip string) (bool, error) {
+	locked, err := t.fail(ctx, ThrottleAccount, accountKey(email))
+	if err != nil {
+		return false, err
+	}
+	if ip != "" {
+		if _, err := t.fail(ctx, ThrottleIP, ip); err != nil {
+			return false, err
+		}
+	}
+	return locked, nil
+}
+
+func (t *LoginThrottle) fail(ctx context.Context, scope, key string) (bool, error) {
+	var failures int
+	err := t.db.QueryRowContext(ctx, `
+		INSERT INTO login_throttles (scope, key, failures, last_failure_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (scope, key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < NOW() - make_interval(secs => $3)
+				THEN 1 ELSE login_throttles.failures + 1 END,
+			last_failure_at = NOW()
+		RETURNING failures
+	`, scope, key, t.policy.Lockout.Seconds()).Scan(&failures)
+	if err != nil {
+		return false, err
+	}
+
+	free := t.policy.FreeAttempts
+	if scope == ThrottleIP {
+		free = t.policy.IPFreeAttempts
+	}
+	block := Backoff(failures, free, t.policy.BaseDelay, t.policy.Lockout)
+	locked := failures >= t.maxAttempts(scope)
+	if locked {
+		block = t.policy.Lockout
+	}
+	if block > 0 {
+		if _, err := t.db.ExecContext(ctx, `
+			UPDATE login_throttles SET blocked_until = NOW() + make_interval(secs => $3) WHERE scope = $1 AND key = $2
+		`, scope, key, block.Seconds()); err != nil {
+			return false, err
+		}
+	}
+	// Only the failure that reaches the threshold reports the lockout
+	return locked && failures == t.maxAttempts(scope), nil
+}
+
+// Succeed clears the account's failures. The IP's are kept, so one valid
+// account cannot be used to reset an IP that is guessing others.
+func (t *LoginThrottle) Succeed(ctx context.Context, email string) error {
+	return t.Unlock(ctx, email)
+}
+
+// Unlock clears an account's failures and any lockout.
+func (t *LoginThrottle) Unlock(ctx context.Context, email string) error {
+	_, err := t.db.ExecContext(ctx, `DELETE FROM login_throttles WHERE scope = $1 AND key = $2`, ThrottleAccount, accountKey(email))
+	return err
+}
+
+func (t *LoginThrottle) maxAttempts(scope string) int {
+	if scope == ThrottleIP {
+		return t.policy.IPMaxAttempts
+	}
+	return t.policy.MaxAttempts
+}
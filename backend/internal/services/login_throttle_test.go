@@ -0,0 +1,32 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	max := 15 * time.Minute
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{8, 16 * time.Second},
+		{13, 512 * time.Second},
+		{14, max},
+		{100, max},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Backoff(tt.failures, 3, time.Second, max), "failures=%d", tt.failures)
+	}
+}
+
+func TestAccountKey(t *testing.T) {
+	assert.Equal(t, "jane@example.com", accountKey("  Jane@Example.com "))
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCProvider is the configuration of an OpenID Connect identity provider.
+// Claim names may be dotted paths into nested claims, such as Keycloak's
+// "realm_access.roles".
+type OIDCProvider struct {
+	DiscoveryURL string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	EmailClaim   string
+	NameClaim    string
+	GroupsClaim  string
+}
+
+// OIDCDiscovery is the part of a provider's discovery document the client uses.
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCIdentity is a verified user identity, with the provider's claims mapped.
+type OIDCIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+	Groups        []string
+}
+
+// How long discovery documents and signing keys are cached
+const oidcCacheTTL = time.Hour
+
+// OIDCClient signs users in with any OpenID Connect provider using the
+// authorization code flow. Discovery documents and signing keys are cached.
+type OIDCClient struct {
+	http *http.Client
+
+	mu        sync.Mutex
+	discovery map[string]cachedDiscovery
+	keys      map[string]cachedKeys
+}
+
+type cachedDiscovery struct {
+	doc     *OIDCDiscovery
+	fetched time.Time
+}
+
+type cachedKeys struct {
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// NewOIDCClient returns a client using httpClient, or a default client with a
+// timeout when it is nil.
+func NewOIDCClient(httpClient *http.Client) *OIDCClient {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCClient{
+		http:      httpClient,
+		discovery: map[string]cachedDiscovery{},
+		keys:      map[string]cachedKeys{},
+	}
+}
+
+// Discover returns the provider's discovery document.
+func (c *OIDCClient) Discover(ctx context.Context, discoveryURL string) (*OIDCDiscovery, error) {
+	c.mu.Lock()
+	cached, ok := c.discovery[discoveryURL]
+	c.mu.Unlock()
+	if ok && time.Since(cached.fetched) < oidcCacheTTL {
+		return cached.doc, nil
+	}
+
+	var doc OIDCDiscovery
+	if err := c.getJSON(ctx, discoveryURL, "", &doc); err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document: %w", err)
+	}
+	if doc.Issuer == "" || doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document at %s is incomplete", discoveryURL)
+	}
+
+	c.mu.Lock()
+	c.discovery[discoveryURL] = cachedDiscovery{doc: &doc, fetched: time.Now()}
+	c.mu.Unlock()
+	return &doc, nil
+}
+
+// AuthorizationURL returns the URL to send the user to for signing in.
+func (c *OIDCClient) AuthorizationURL(ctx context.Context, p OIDCProvider, redirectURI, state, nonce string) (string, error) {
+	doc, err := c.Discover(ctx, p.DiscoveryURL)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(doc.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(oidcScopes(p.Scopes), " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// oidcScopes always includes the openid scope.
+func oidcScopes(scopes []string) []string {
+	for _, s := range scopes {
+		if s == "openid" {
+			return scopes
+		}
+	}
+	return append([]string{"openid"}, scopes...)
+}
+
+// Exchange redeems an authorization code, verifies the ID token (signature,
+// issuer, audience, expiry and nonce) and returns the user's identity. Claims
+// from the userinfo endpoint, when the provider has one, complement the ID
+// token's.
+func (c *OIDCClient) Exchange(ctx context.Context, p OIDCProvider, code, redirectURI, nonce string) (*OIDCIdentity, error) {
+	doc, err := c.Discover(ctx, p.DiscoveryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	claims, err := c.verifyIDToken(ctx, doc, p.ClientID, tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if nonce != "" && claims["nonce"] != nonce {
+		return nil, fmt.Errorf("id_token nonce mismatch")
+	}
+
+	if doc.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		var info map[string]interface{}
+		if err := c.getJSON(ctx, doc.UserinfoEndpoint, tokens.AccessToken, &info); err != nil {
+			return nil, fmt.Errorf("failed to fetch userinfo: %w", err)
+		}
+		if sub, _ := info["sub"].(string); sub != "" && sub != claims["sub"] {
+			return nil, fmt.Errorf("userinfo subject does not match the id_token")
+		}
+		for k, v := range info {
+			if _, ok := claims[k]; !ok {
+				claims[k] = v
+			}
+		}
+	}
+
+	return MapOIDCClaims(p, claims)
+}
+
+// MapOIDCClaims builds an identity from claims using the provider's claim
+// mapping. The email claim is required.
+func MapOIDCClaims(p OIDCProvider, claims map[string]interface{}) (*OIDCIdentity, error) {
+	id := &OIDCIdentity{}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = lookupClaim(claims, defaultClaim(p.EmailClaim, "email")).(string)
+	id.Email = strings.ToLower(strings.TrimSpace(id.Email))
+	if id.Subject == "" || id.Email == "" {
+		return nil, fmt.Errorf("identity has no subject or email")
+	}
+	id.EmailVerified, _ = claims["email_verified"].(bool)
+	id.Name, _ = lookupClaim(claims, defaultClaim(p.NameClaim, "name")).(string)
+	if id.Name == "" {
+		id.Name = id.Email
+	}
+	id.Picture, _ = claims["picture"].(string)
+
+	switch groups := lookupClaim(claims, defaultClaim(p.GroupsClaim, "groups")).(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	case string:
+		id.Groups = strings.Fields(strings.ReplaceAll(groups, ",", " "))
+	}
+	return id, nil
+}
+
+func defaultClaim(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+// lookupClaim resolves a dotted claim path.
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	var v interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+	return v
+}
+
+func (c *OIDCClient) verifyIDToken(ctx context.Context, doc *OIDCDiscovery, clientID, idToken string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return c.signingKey(ctx, doc.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithIssuer(doc.Issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	return claims, nil
+}
+
+// signingKey returns the provider key with ID kid, refetching the key set
+// once if the key is unknown (the provider may have rotated its keys).
+func (c *OIDCClient) signingKey(ctx context.Context, jwksURI, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	cached, ok := c.keys[jwksURI]
+	c.mu.Unlock()
+	if !ok || time.Since(cached.fetched) >= oidcCacheTTL || cached.keys[kid] == nil {
+		keys, err := c.fetchKeys(ctx, jwksURI)
+		if err != nil {
+			return nil, err
+		}
+		cached = cachedKeys{keys: keys, fetched: time.Now()}
+		c.mu.Lock()
+		c.keys[jwksURI] = cached
+		c.mu.Unlock()
+	}
+	if key := cached.keys[kid]; key != nil {
+		return key, nil
+	}
+	// Providers with a single key may omit kid
+	if kid == "" && len(cached.keys) == 1 {
+		for _, key := range cached.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (c *OIDCClient) fetchKeys(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := c.getJSON(ctx, jwksURI, "", &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+func (c *OIDCClient) getJSON(ctx context.Context, url, bearer string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
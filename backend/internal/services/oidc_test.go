@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockOIDCServer is a minimal OpenID Connect provider issuing ID tokens for a
+// single authorization code.
+type mockOIDCServer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	code     string
+	nonce    string
+	claims   jwt.MapClaims
+	userinfo map[string]interface{}
+	audience string
+}
+
+func newMockOIDCServer(t *testing.T) *mockOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m := &mockOIDCServer{key: key, code: "good-code", audience: "client-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"userinfo_endpoint":      m.URL + "/userinfo",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != m.code || r.FormValue("client_secret") != "secret" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss": m.URL, "aud": m.audience, "sub": "user-1", "nonce": m.nonce,
+			"exp": time.Now().Add(time.Minute).Unix(), "iat": time.Now().Unix(),
+		}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at-1", "id_token": signed, "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer at-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(m.userinfo)
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockOIDCServer) provider() OIDCProvider {
+	return OIDCProvider{
+		DiscoveryURL: m.URL + "/.well-known/openid-configuration",
+		ClientID:     "client-1",
+		ClientSecret: "secret",
+		Scopes:       []string{"email", "profile"},
+	}
+}
+
+func TestOIDCAuthorizationURL(t *testing.T) {
+	m := newMockOIDCServer(t)
+	client := NewOIDCClient(m.Client())
+
+	raw, err := client.AuthorizationURL(context.Background(), m.provider(), "https://app/cb", "st", "nc")
+	require.NoError(t, err)
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	assert.Equal(t, "/authorize", u.Path)
+	assert.Equal(t, "openid email profile", u.Query().Get("scope"))
+	assert.Equal(t, "client-1", u.Query().Get("client_id"))
+	assert.Equal(t, "st", u.Query().Get("state"))
+	assert.Equal(t, "nc", u.Query().Get("nonce"))
+}
+
+func TestOIDCExchange(t *testing.T) {
+	m := newMockOIDCServer(t)
+	m.nonce = "n-1"
+	m.claims = jwt.MapClaims{
+		"email": "Jane@Example.com", "email_verified": true,
+		"realm_access": map[string]interface{}{"roles": []string{"inventory-admins", "staff"}},
+	}
+	m.userinfo = map[string]interface{}{"sub": "user-1", "name": "Jane Doe", "email": "other@example.com"}
+	client := NewOIDCClient(m.Client())
+	p := m.provider()
+	p.GroupsClaim = "realm_access.roles"
+
+	id, err := client.Exchange(context.Background(), p, "good-code", "https://app/cb", "n-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", id.Subject)
+	assert.Equal(t, "jane@example.com", id.Email, "id_token claims win over userinfo")
+	assert.True(t, id.EmailVerified)
+	assert.Equal(t, "Jane Doe", id.Name, "userinfo fills in missing claims")
+	assert.Equal(t, []string{"inventory-admins", "staff"}, id.Groups)
+
+	_, err = client.Exchange(context.Background(), p, "good-code", "https://app/cb", "other-nonce")
+	assert.ErrorContains(t, err, "nonce")
+
+	_, err = client.Exchange(context.Background(), p, "bad-code", "https://app/cb", "n-1")
+	assert.Error(t, err)
+}
+
+func TestOIDCExchangeRejectsWrongAudience(t *testing.T) {
+	m := newMockOIDCServer(t)
+	m.audience = "someone-else"
+	m.claims = jwt.MapClaims{"email": "jane@example.com"}
+	client := NewOIDCClient(m.Client())
+
+	_, err := client.Exchange(context.Background(), m.provider(), "good-code", "https://app/cb", "")
+	assert.ErrorContains(t, err, "invalid id_token")
+}
+
+func TestMapOIDCClaims(t *testing.T) {
+	p := OIDCProvider{EmailClaim: "upn", GroupsClaim: "roles"}
+	id, err := MapOIDCClaims(p, map[string]interface{}{"sub": "s", "upn": "bob@corp.example", "roles": "a, b"})
+	require.NoError(t, err)
+	assert.Equal(t, "bob@corp.example", id.Email)
+	assert.Equal(t, "bob@corp.example", id.Name, "name falls back to email")
+	assert.Equal(t, []string{"a", "b"}, id.Groups)
+
+	_, err = MapOIDCClaims(p, map[string]interface{}{"sub": "s"})
+	assert.Error(t, err)
+}
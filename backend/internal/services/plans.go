@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Resources limited by a plan
+const (
+	QuotaUsers       = "users"
+	QuotaLocations   = "locations"
+	QuotaItems       = "items"
+	QuotaAPIRequests = "api_requests"
+)
+
+// Metric under which API requests are metered in tenant_usage
+const MetricAPIRequests = "api_requests"
+
+// ErrPlanNotFound is returned for an unknown plan code
+var ErrPlanNotFound = errors.New("plan not found")
+
+// Plan limits what a tenant may create and use. A nil limit is unlimited.
+type Plan struct {
+	ID                  uuid.UUID       `json:"id"`
+	Code                string          `json:"code"`
+	Name                string          `json:"name"`
+	MaxUsers            *int64          `json:"max_users"`
+	MaxLocations        *int64          `json:"max_locations"`
+	MaxItems            *int64          `json:"max_items"`
+	MaxAPIRequestsMonth *int64          `json:"max_api_requests_month"`
+	Features            map[string]bool `json:"features"`
+}
+
+// HasFeature reports whether the plan enables a feature flag
+func (p *Plan) HasFeature(name string) bool {
+	return p == nil || p.Features[name]
+}
+
+// limit returns the plan's limit for a resource
+func (p *Plan) limit(resource string) *int64 {
+	switch resource {
+	case QuotaUsers:
+		return p.MaxUsers
+	case QuotaLocations:
+		return p.MaxLocations
+	case QuotaItems:
+		return p.MaxItems
+	case QuotaAPIRequests:
+		return p.MaxAPIRequestsMonth
+	}
+	return nil
+}
+
+// QuotaError is returned when a tenant's plan allows no more of a resource
+type QuotaError struct {
+	Resource string
+	Plan     string
+	Limit    int64
+	Used     int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("the %s plan allows at most %d %s", e.Plan, e.Limit, e.Resource)
+}
+
+const planColumns = `id, code, name, max_users, max_locations, max_items, max_api_requests_month, features`
+
+func scanPlan(row interface{ Scan(...interface{}) error }) (*Plan, error) {
+	p := &Plan{}
+	var features []byte
+	if err := row.Scan(&p.ID, &p.Code, &p.Name, &p.MaxUsers, &p.MaxLocations, &p.MaxItems, &p.MaxAPIRequestsMonth, &features); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(features, &p.Features); err != nil {
+		return nil, fmt.Errorf("invalid plan features: %w", err)
+	}
+	return p, nil
+}
+
+// ListPlans returns every plan
+func (s *TenantService) ListPlans(ctx context.Context) ([]*Plan, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+planColumns+` FROM plans ORDER BY max_users NULLS LAST, code`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list plans: %w", err)
+	}
+	defer rows.Close()
+
+	plans := []*Plan{}
+	for rows.Next() {
+		p, err := scanPlan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan plan: %w", err)
+		}
+		plans = append(plans, p)
+	}
+	return plans, rows.Err()
+}
+
+// SavePlan creates the plan with p.Code or replaces its name, limits and
+// features
+func (s *TenantService) SavePlan(ctx context.Context, p *Plan) (*Plan, error) {
+	if !isValidSlug(p.Code) {
+		return nil, fmt.Errorf("invalid plan code: must be URL-safe")
+	}
+	features, err := json.Marshal(p.Features)
+	if err != nil {
+		return nil, err
+	}
+	if p.Features == nil {
+		features = []byte("{}")
+	}
+	saved, err := scanPlan(s.db.QueryRowContext(ctx, `
+		INSERT INTO plans (code, name, max_users, max_locations, max_items, max_api_requests_month, features)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (code) DO UPDATE SET name = EXCLUDED.name, max_users = EXCLUDED.max_users,
+			max_locations = EXCLUDED.max_locations, max_items = EXCLUDED.max_items,
+			max_api_requests_month = EXCLUDED.max_api_requests_month, features = EXCLUDED.features, updated_at = NOW()
+		RETURNING `+planColumns,
+		p.Code, p.Name, p.MaxUsers, p.MaxLocations, p.MaxItems, p.MaxAPIRequestsMonth, features))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save plan: %w", err)
+	}
+	return saved, nil
+}
+
+// AssignPlan puts a tenant on the plan with the given code, or takes it off
+// any plan when code is empty
+func (s *TenantService) AssignPlan(ctx context.Context, tenantID uuid.UUID, code string) (*Tenant, error) {
+	var planID *uuid.UUID
+	if code != "" {
+		var id uuid.UUID
+		err := s.db.QueryRowContext(ctx, `SELECT id FROM plans WHERE code = $1`, code).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, ErrPlanNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get plan: %w", err)
+		}
+		planID = &id
+	}
+
+	tenant := &Tenant{}
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE tenants SET plan_id = $2, updated_at = NOW()
+		WHERE id = $1 AND is_active = true
+		RETURNING id, name, slug, domain, settings, contact, is_active, plan_id
+	`, tenantID, planID).
+		Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, jsonMap{&tenant.Settings}, jsonMap{&tenant.Contact}, &tenant.IsActive, &tenant.PlanID)
+	if err == sql.ErrNoRows {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to assign plan: %w", err)
+	}
+	return tenant, nil
+}
+
+// TenantPlan returns the tenant's plan, or nil when it has none
+func (s *TenantService) TenantPlan(ctx context.Context, tenantID string) (*Plan, error) {
+	p, err := scanPlan(s.db.QueryRowContext(ctx, `
+		SELECT p.id, p.code, p.name, p.max_users, p.max_locations, p.max_items, p.max_api_requests_month, p.features
+		FROM tenants t JOIN plans p ON p.id = t.plan_id
+		WHERE t.id = $1
+	`, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant plan: %w", err)
+	}
+	return p, nil
+}
+
+// countResource counts what a tenant holds of a resource
+func (s *TenantService) countResource(ctx context.Context, tenantID, resource string) (int64, error) {
+	var query string
+	switch resource {
+	case QuotaUsers:
+		query = `SELECT COUNT(*) FROM tenant_memberships WHERE tenant_id = $1 AND is_active = true`
+	case QuotaLocations:
+		query = `SELECT COUNT(*) FROM locations WHERE tenant_id = $1`
+	case QuotaItems:
+		query = `SELECT COUNT(*) FROM items WHERE tenant_id = $1 AND deleted_at IS NULL`
+	case QuotaAPIRequests:
+		query = `SELECT COALESCE(SUM(count), 0) FROM tenant_usage WHERE tenant_id = $1 AND period = $2 AND metric = '` + MetricAPIRequests + `'`
+		var n int64
+		err := s.db.QueryRowContext(ctx, query, tenantID, UsagePeriod(time.Now())).Scan(&n)
+		return n, err
+	default:
+		return 0, fmt.Errorf("unknown quota resource %q", resource)
+	}
+	var n int64
+	err := s.db.QueryRowContext(ctx, query, tenantID).Scan(&n)
+	return n, err
+}
+
+// CheckQuota returns a *QuotaError when the tenant's plan allows no more of
+// resource. Run it in the transaction that creates the resource: it locks the
+// tenant, so concurrent creates cannot both take the last slot.
+func (s *TenantService) CheckQuota(ctx context.Context, tenantID, resource string) error {
+	// NO KEY UPDATE leaves inserts referencing the tenant unblocked
+	if _, err := s.db.ExecContext(ctx, `SELECT 1 FROM tenants WHERE id = $1 FOR NO KEY UPDATE`, tenantID); err != nil {
+		return fmt.Errorf("failed to lock tenant: %w", err)
+	}
+	plan, err := s.TenantPlan(ctx, tenantID)
+	if err != nil || plan == nil {
+		return err
+	}
+	limit := plan.limit(resource)
+	if limit == nil {
+		return nil
+	}
+	used, err := s.countResource(ctx, tenantID, resource)
+	if err != nil {
+		return fmt.Errorf("failed to count %s: %w", resource, err)
+	}
+	if used >= *limit {
+		return &QuotaError{Resource: resource, Plan: plan.Code, Limit: *limit, Used: used}
+	}
+	return nil
+}
+
+// UsageItem is how much of a resource a tenant uses, against its plan's limit
+type UsageItem struct {
+	Used  int64  `json:"used"`
+	Limit *int64 `json:"limit"`
+}
+
+// TenantUsage is a tenant's usage of everything its plan limits
+type TenantUsage struct {
+	TenantID    uuid.UUID `json:"tenant_id"`
+	Plan        *Plan     `json:"plan"`
+	Period      string    `json:"period"`
+	Users       UsageItem `json:"users"`
+	Locations   UsageItem `json:"locations"`
+	Items       UsageItem `json:"items"`
+	APIRequests UsageItem `json:"api_requests"`
+}
+
+// GetUsage reports a tenant's usage in the current month
+func (s *TenantService) GetUsage(ctx context.Context, tenantID uuid.UUID) (*TenantUsage, error) {
+	if _, err := s.GetTenantByID(ctx, tenantID); err != nil {
+		return nil, err
+	}
+	plan, err := s.TenantPlan(ctx, tenantID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &TenantUsage{TenantID: tenantID, Plan: plan, Period: UsagePeriod(time.Now()).Format("2006-01")}
+	for resource, item := range map[string]*UsageItem{
+		QuotaUsers:       &usage.Users,
+		QuotaLocations:   &usage.Locations,
+		QuotaItems:       &usage.Items,
+		QuotaAPIRequests: &usage.APIRequests,
+	} {
+		if item.Used, err = s.countResource(ctx, tenantID.String(), resource); err != nil {
+			return nil, fmt.Errorf("failed to count %s: %w", resource, err)
+		}
+		if plan != nil {
+			item.Limit = plan.limit(resource)
+		}
+	}
+	return usage, nil
+}
+
+// UsagePeriod is the first day (UTC) of the month t falls in
+func UsagePeriod(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
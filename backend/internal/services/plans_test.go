@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanLimits(t *testing.T) {
+	three := int64(3)
+	plan := &Plan{Code: "free", MaxUsers: &three, Features: map[string]bool{"lots": true, "multi_currency": false}}
+
+	assert.Equal(t, &three, plan.limit(QuotaUsers))
+	assert.Nil(t, plan.limit(QuotaItems), "unset limits are unlimited")
+	assert.True(t, plan.HasFeature("lots"))
+	assert.False(t, plan.HasFeature("multi_currency"))
+	assert.False(t, plan.HasFeature("unknown"))
+
+	var none *Plan
+	assert.True(t, none.HasFeature("multi_currency"), "tenants without a plan have every feature")
+
+	err := &QuotaError{Resource: QuotaUsers, Plan: "free", Limit: 3, Used: 3}
+	assert.EqualError(t, err, "the free plan allows at most 3 users")
+}
+
+func TestUsagePeriod(t *testing.T) {
+	at := time.Date(2024, 3, 31, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+	assert.Equal(t, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), UsagePeriod(at))
+}
+
+func TestUsageMeterCountsUntilExceeded(t *testing.T) {
+	meter := NewUsageMeter(nil)
+
+	assert.True(t, meter.CountRequest("a"))
+	assert.True(t, meter.CountRequest("a"))
+	assert.True(t, meter.CountRequest("b"))
+	assert.Equal(t, int64(2), meter.Pending("a"))
+	assert.Equal(t, int64(1), meter.Pending("b"))
+
+	meter.exceeded = map[string]bool{"a": true}
+	assert.False(t, meter.CountRequest("a"))
+	assert.Equal(t, int64(2), meter.Pending("a"), "rejected requests are not counted")
+	assert.True(t, meter.CountRequest("b"))
+}
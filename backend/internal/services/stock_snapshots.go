@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SnapshotService materialises the stock on hand per item and location at the
+// end of each day so that as-of queries only replay movements since the last
+// snapshot.
+type SnapshotService struct {
+	db *sql.DB
+}
+
+func NewSnapshotService(db *sql.DB) *SnapshotService {
+	return &SnapshotService{db: db}
+}
+
+// SnapshotDay returns the UTC calendar day of t.
+func SnapshotDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// TakeSnapshots writes the snapshot for day (end of day, UTC) for every active
+// tenant and returns the number of rows written. Each tenant's snapshot is built
+// from its previous snapshot plus the movements in between.
+func (s *SnapshotService) TakeSnapshots(ctx context.Context, day time.Time) (int64, error) {
+	day = SnapshotDay(day)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM tenants WHERE is_active = true`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	var tenantIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan tenant: %w", err)
+		}
+		tenantIDs = append(tenantIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	var total int64
+	for _, tenantID := range tenantIDs {
+		n, err := s.TakeTenantSnapshot(ctx, tenantID, day)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// TakeTenantSnapshot (re)builds one tenant's snapshot for day.
+func (s *SnapshotService) TakeTenantSnapshot(ctx context.Context, tenantID string, day time.Time) (int64, error) {
+	day = SnapshotDay(day)
+	end := day.AddDate(0, 0, 1)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var previous sql.NullTime
+	if err := tx.QueryRowContext(ctx, `
+		SELECT MAX(snapshot_date) FROM stock_snapshots WHERE tenant_id = $1 AND snapshot_date < $2
+	`, tenantID, day).Scan(&previous); err != nil {
+		return 0, fmt.Errorf("failed to find previous snapshot: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM stock_snapshots WHERE tenant_id = $1 AND snapshot_date = $2`, tenantID, day); err != nil {
+		return 0, fmt.Errorf("failed to clear snapshot: %w", err)
+	}
+
+	query, args := StockOnHandQuery(tenantID, previous, end)
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO stock_snapshots (tenant_id, snapshot_date, item_id, location_id, on_hand, created_at)
+		SELECT $%d, $%d, item_id, location_id, on_hand, NOW()
+		FROM (%s) soh
+	`, len(args)+1, len(args)+2, query), append(args, tenantID, day)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	n, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit snapshot: %w", err)
+	}
+	return n, nil
+}
+
+// LatestSnapshotBefore returns the most recent snapshot day whose end of day is
+// not after before, if any.
+func LatestSnapshotBefore(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}, tenantID string, before time.Time) (sql.NullTime, error) {
+	// A snapshot for day D covers movements up to the start of D+1
+	lastCovered := SnapshotDay(before.Add(time.Microsecond)).AddDate(0, 0, -1)
+	var day sql.NullTime
+	err := q.QueryRowContext(ctx, `
+		SELECT MAX(snapshot_date) FROM stock_snapshots WHERE tenant_id = $1 AND snapshot_date <= $2
+	`, tenantID, lastCovered).Scan(&day)
+	return day, err
+}
+
+// StockOnHandQuery builds a query yielding (item_id, location_id, on_hand) for a
+// tenant from the snapshot of day base (when valid) plus the movements from the
+// end of that day until before `until` (exclusive).
+func StockOnHandQuery(tenantID string, base sql.NullTime, until time.Time) (string, []interface{}) {
+	if !base.Valid {
+		return `
+			SELECT item_id, location_id, SUM(qty) AS on_hand
+			FROM stock_movements
+			WHERE tenant_id = $1 AND occurred_at < $2
+			GROUP BY item_id, location_id
+			HAVING SUM(qty) <> 0`, []interface{}{tenantID, until}
+	}
+
+	return `
+		SELECT item_id, location_id, SUM(qty) AS on_hand
+		FROM (
+			SELECT item_id, location_id, on_hand AS qty
+			FROM stock_snapshots
+			WHERE tenant_id = $1 AND snapshot_date = $2
+			UNION ALL
+			SELECT item_id, location_id, qty
+			FROM stock_movements
+			WHERE tenant_id = $1 AND occurred_at >= $3 AND occurred_at < $4
+		) replay
+		GROUP BY item_id, location_id
+		HAVING SUM(qty) <> 0`, []interface{}{tenantID, SnapshotDay(base.Time), SnapshotDay(base.Time).AddDate(0, 0, 1), until}
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotDay(t *testing.T) {
+	est := time.FixedZone("EST", -5*3600)
+	day := SnapshotDay(time.Date(2024, 3, 10, 22, 30, 0, 0, est))
+	assert.Equal(t, time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), day)
+}
+
+func TestStockOnHandQuery(t *testing.T) {
+	until := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	_, args := StockOnHandQuery("t1", sql.NullTime{}, until)
+	assert.Equal(t, []interface{}{"t1", until}, args)
+
+	base := sql.NullTime{Time: time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC), Valid: true}
+	query, args := StockOnHandQuery("t1", base, until)
+	assert.Contains(t, query, "stock_snapshots")
+	assert.Equal(t, []interface{}{
+		"t1",
+		time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC),
+		until,
+	}, args)
+}
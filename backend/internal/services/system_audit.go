@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// System audit actions
+const (
+	SystemAuditExportRequested = "tenant.export_requested"
+	SystemAuditPurgeScheduled  = "tenant.purge_scheduled"
+	SystemAuditPurgeCanceled   = "tenant.purge_canceled"
+	SystemAuditPurged          = "tenant.purged"
+)
+
+// SystemAuditEntry is one action of system administration. Unlike audit_logs
+// it is not tenant data: it names the tenant acted on and survives its purge.
+type SystemAuditEntry struct {
+	ID         string                 `json:"id"`
+	Action     string                 `json:"action"`
+	TenantID   *string                `json:"tenant_id"`
+	TenantSlug *string                `json:"tenant_slug"`
+	TenantName *string                `json:"tenant_name"`
+	ActorID    *string                `json:"actor_id"`
+	ActorEmail *string                `json:"actor_email"`
+	RequestID  *string                `json:"request_id"`
+	IPAddress  *string                `json:"ip_address"`
+	Details    map[string]interface{} `json:"details"`
+	At         time.Time              `json:"at"`
+}
+
+// RecordSystem appends an entry to the system audit log. Empty optional fields
+// are stored as NULL.
+func (s *AuditService) RecordSystem(ctx context.Context, e SystemAuditEntry) error {
+	var details []byte
+	if e.Details != nil {
+		b, err := json.Marshal(e.Details)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit details: %w", err)
+		}
+		details = b
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO system_audit_log (action, tenant_id, tenant_slug, tenant_name, actor_id, actor_email, request_id, ip_address, details, at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+	`, e.Action, e.TenantID, e.TenantSlug, e.TenantName, e.ActorID, e.ActorEmail, e.RequestID, e.IPAddress, details)
+	if err != nil {
+		return fmt.Errorf("failed to record system audit entry: %w", err)
+	}
+	return nil
+}
+
+// ListSystemAudit returns system audit entries newest first, of one tenant
+// when tenantID is not empty
+func (s *AuditService) ListSystemAudit(ctx context.Context, tenantID string, limit, offset int) ([]SystemAuditEntry, int64, error) {
+	var total int64
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM system_audit_log WHERE ($1 = '' OR tenant_id::text = $1)
+	`, tenantID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count system audit entries: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, action, tenant_id, tenant_slug, tenant_name, actor_id, actor_email, request_id, ip_address, details, at
+		FROM system_audit_log
+		WHERE ($1 = '' OR tenant_id::text = $1)
+		ORDER BY at DESC, id
+		LIMIT $2 OFFSET $3
+	`, tenantID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list system audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []SystemAuditEntry{}
+	for rows.Next() {
+		var e SystemAuditEntry
+		if err := rows.Scan(&e.ID, &e.Action, &e.TenantID, &e.TenantSlug, &e.TenantName, &e.ActorID, &e.ActorEmail,
+			&e.RequestID, &e.IPAddress, jsonMap{&e.Details}, &e.At); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan system audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
@@ -3,14 +3,19 @@ package services
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/google/uuid"
 )
 
+// ErrTenantNotFound is returned for a tenant that does not exist or is inactive
+var ErrTenantNotFound = errors.New("tenant not found")
+
 type TenantService struct {
-	db *sql.DB
+	db DBTX
 }
 
 type Tenant struct {
@@ -21,9 +26,27 @@ type Tenant struct {
 	Settings map[string]interface{} `json:"settings"`
 	Contact  map[string]interface{} `json:"contact"`
 	IsActive bool                   `json:"is_active"`
+	// Plan limiting the tenant; nil is unlimited
+	PlanID *uuid.UUID `json:"plan_id"`
 }
 
-func NewTenantService(db *sql.DB) *TenantService {
+// jsonMap scans a JSONB column into a map; NULL leaves the map nil
+type jsonMap struct{ m *map[string]interface{} }
+
+func (j jsonMap) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*j.m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, j.m)
+	case string:
+		return json.Unmarshal([]byte(v), j.m)
+	}
+	return fmt.Errorf("cannot scan %T into a JSON object", src)
+}
+
+func NewTenantService(db DBTX) *TenantService {
 	return &TenantService{db: db}
 }
 
@@ -44,11 +67,11 @@ func (s *TenantService) CreateTenant(ctx context.Context, name, slug string) (*T
 	query := `
 		INSERT INTO tenants (id, name, slug, is_active, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, NOW(), NOW())
-		RETURNING id, name, slug, domain, settings, contact, is_active
+		RETURNING id, name, slug, domain, settings, contact, is_active, plan_id
 	`
 
 	err := s.db.QueryRowContext(ctx, query, tenant.ID, tenant.Name, tenant.Slug, tenant.IsActive).
-		Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, &tenant.Settings, &tenant.Contact, &tenant.IsActive)
+		Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, jsonMap{&tenant.Settings}, jsonMap{&tenant.Contact}, &tenant.IsActive, &tenant.PlanID)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create tenant: %w", err)
@@ -62,17 +85,17 @@ func (s *TenantService) GetTenantByID(ctx context.Context, id uuid.UUID) (*Tenan
 	tenant := &Tenant{}
 
 	query := `
-		SELECT id, name, slug, domain, settings, contact, is_active
+		SELECT id, name, slug, domain, settings, contact, is_active, plan_id
 		FROM tenants
 		WHERE id = $1 AND is_active = true
 	`
 
 	err := s.db.QueryRowContext(ctx, query, id).
-		Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, &tenant.Settings, &tenant.Contact, &tenant.IsActive)
+		Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, jsonMap{&tenant.Settings}, jsonMap{&tenant.Contact}, &tenant.IsActive, &tenant.PlanID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("tenant not found")
+			return nil, ErrTenantNotFound
 		}
 		return nil, fmt.Errorf("failed to get tenant: %w", err)
 	}
@@ -85,17 +108,40 @@ func (s *TenantService) GetTenantBySlug(ctx context.Context, slug string) (*Tena
 	tenant := &Tenant{}
 
 	query := `
-		SELECT id, name, slug, domain, settings, contact, is_active
+		SELECT id, name, slug, domain, settings, contact, is_active, plan_id
 		FROM tenants
 		WHERE slug = $1 AND is_active = true
 	`
 
 	err := s.db.QueryRowContext(ctx, query, slug).
-		Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, &tenant.Settings, &tenant.Contact, &tenant.IsActive)
+		Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, jsonMap{&tenant.Settings}, jsonMap{&tenant.Contact}, &tenant.IsActive, &tenant.PlanID)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTenantNotFound
+		}
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+
+	return tenant, nil
+}
+
+// GetTenantByDomain retrieves a tenant by its custom domain
+func (s *TenantService) GetTenantByDomain(ctx context.Context, domain string) (*Tenant, error) {
+	tenant := &Tenant{}
+
+	query := `
+		SELECT id, name, slug, domain, settings, contact, is_active, plan_id
+		FROM tenants
+		WHERE LOWER(domain) = LOWER($1) AND is_active = true
+	`
+
+	err := s.db.QueryRowContext(ctx, query, domain).
+		Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, jsonMap{&tenant.Settings}, jsonMap{&tenant.Contact}, &tenant.IsActive, &tenant.PlanID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("tenant not found")
+			return nil, ErrTenantNotFound
 		}
 		return nil, fmt.Errorf("failed to get tenant: %w", err)
 	}
@@ -106,7 +152,7 @@ func (s *TenantService) GetTenantBySlug(ctx context.Context, slug string) (*Tena
 // ListTenants returns all active tenants
 func (s *TenantService) ListTenants(ctx context.Context) ([]*Tenant, error) {
 	query := `
-		SELECT id, name, slug, domain, settings, contact, is_active
+		SELECT id, name, slug, domain, settings, contact, is_active, plan_id
 		FROM tenants
 		WHERE is_active = true
 		ORDER BY name
@@ -121,7 +167,7 @@ func (s *TenantService) ListTenants(ctx context.Context) ([]*Tenant, error) {
 	var tenants []*Tenant
 	for rows.Next() {
 		tenant := &Tenant{}
-		err := rows.Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, &tenant.Settings, &tenant.Contact, &tenant.IsActive)
+		err := rows.Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, jsonMap{&tenant.Settings}, jsonMap{&tenant.Contact}, &tenant.IsActive, &tenant.PlanID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan tenant: %w", err)
 		}
@@ -144,16 +190,16 @@ func (s *TenantService) UpdateTenant(ctx context.Context, id uuid.UUID, name, sl
 		    domain = $4,
 		    updated_at = NOW()
 		WHERE id = $1 AND is_active = true
-		RETURNING id, name, slug, domain, settings, contact, is_active
+		RETURNING id, name, slug, domain, settings, contact, is_active, plan_id
 	`
 
 	tenant := &Tenant{}
 	err := s.db.QueryRowContext(ctx, query, id, name, slug, domain).
-		Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, &tenant.Settings, &tenant.Contact, &tenant.IsActive)
+		Scan(&tenant.ID, &tenant.Name, &tenant.Slug, &tenant.Domain, jsonMap{&tenant.Settings}, jsonMap{&tenant.Contact}, &tenant.IsActive, &tenant.PlanID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("tenant not found")
+			return nil, ErrTenantNotFound
 		}
 		return nil, fmt.Errorf("failed to update tenant: %w", err)
 	}
@@ -180,7 +226,7 @@ func (s *TenantService) DeactivateTenant(ctx context.Context, id uuid.UUID) erro
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("tenant not found")
+		return ErrTenantNotFound
 	}
 
 	return nil
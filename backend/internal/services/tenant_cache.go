@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Most entries a TenantCache holds before it drops expired ones, and failing
+// that all of them. Unknown hosts are cached too, so this bounds the memory
+// requests with made-up Host headers can take.
+const tenantCacheMaxEntries = 10000
+
+// tenantLookup is the part of TenantService a TenantCache sits in front of
+type tenantLookup interface {
+	GetTenantBySlug(ctx context.Context, slug string) (*Tenant, error)
+	GetTenantByDomain(ctx context.Context, domain string) (*Tenant, error)
+}
+
+// TenantCache remembers tenants looked up by slug or custom domain for a TTL,
+// so resolving the tenant of every request does not query the database.
+// Lookups that find no tenant are cached as well.
+type TenantCache struct {
+	tenants tenantLookup
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]tenantCacheEntry
+}
+
+type tenantCacheEntry struct {
+	tenant  *Tenant // nil when there is no such tenant
+	expires time.Time
+}
+
+func NewTenantCache(tenants tenantLookup, ttl time.Duration) *TenantCache {
+	return &TenantCache{
+		tenants: tenants,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]tenantCacheEntry{},
+	}
+}
+
+// GetTenantBySlug returns the active tenant with the slug, or ErrTenantNotFound
+func (c *TenantCache) GetTenantBySlug(ctx context.Context, slug string) (*Tenant, error) {
+	return c.get("slug:"+slug, func() (*Tenant, error) {
+		return c.tenants.GetTenantBySlug(ctx, slug)
+	})
+}
+
+// GetTenantByDomain returns the active tenant with the custom domain, or
+// ErrTenantNotFound
+func (c *TenantCache) GetTenantByDomain(ctx context.Context, domain string) (*Tenant, error) {
+	return c.get("domain:"+domain, func() (*Tenant, error) {
+		return c.tenants.GetTenantByDomain(ctx, domain)
+	})
+}
+
+// Invalidate forgets every cached lookup. Call it after changing a tenant's
+// slug, domain or active flag.
+func (c *TenantCache) Invalidate() {
+	c.mu.Lock()
+	c.entries = map[string]tenantCacheEntry{}
+	c.mu.Unlock()
+}
+
+func (c *TenantCache) get(key string, load func() (*Tenant, error)) (*Tenant, error) {
+	now := c.now()
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		if entry.tenant == nil {
+			return nil, ErrTenantNotFound
+		}
+		return entry.tenant, nil
+	}
+
+	tenant, err := load()
+	if err != nil && !errors.Is(err, ErrTenantNotFound) {
+		// Database errors are not cached
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= tenantCacheMaxEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= tenantCacheMaxEntries {
+			c.entries = map[string]tenantCacheEntry{}
+		}
+	}
+	c.entries[key] = tenantCacheEntry{tenant: tenant, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return tenant, err
+}
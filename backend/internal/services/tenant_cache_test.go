@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeTenantLookup struct {
+	tenants map[string]*Tenant
+	err     error
+	calls   int
+}
+
+func (f *fakeTenantLookup) GetTenantBySlug(ctx context.Context, slug string) (*Tenant, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if t, ok := f.tenants[slug]; ok {
+		return t, nil
+	}
+	return nil, ErrTenantNotFound
+}
+
+func (f *fakeTenantLookup) GetTenantByDomain(ctx context.Context, domain string) (*Tenant, error) {
+	return f.GetTenantBySlug(ctx, domain)
+}
+
+func TestTenantCache(t *testing.T) {
+	ctx := context.Background()
+	acme := &Tenant{ID: uuid.New(), Slug: "acme"}
+	lookup := &fakeTenantLookup{tenants: map[string]*Tenant{"acme": acme}}
+	cache := NewTenantCache(lookup, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	got, err := cache.GetTenantBySlug(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, acme, got)
+	_, err = cache.GetTenantBySlug(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, 1, lookup.calls, "second lookup is cached")
+
+	// Unknown tenants are cached too
+	_, err = cache.GetTenantBySlug(ctx, "nope")
+	assert.ErrorIs(t, err, ErrTenantNotFound)
+	_, err = cache.GetTenantBySlug(ctx, "nope")
+	assert.ErrorIs(t, err, ErrTenantNotFound)
+	assert.Equal(t, 2, lookup.calls)
+
+	// Slugs and domains are cached apart
+	_, err = cache.GetTenantByDomain(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, 3, lookup.calls)
+
+	now = now.Add(time.Minute)
+	_, err = cache.GetTenantBySlug(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, 4, lookup.calls, "expired entries are looked up again")
+
+	cache.Invalidate()
+	_, err = cache.GetTenantBySlug(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, 5, lookup.calls)
+}
+
+func TestTenantCacheDoesNotCacheErrors(t *testing.T) {
+	lookup := &fakeTenantLookup{err: errors.New("connection refused")}
+	cache := NewTenantCache(lookup, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.GetTenantBySlug(context.Background(), "acme")
+		assert.EqualError(t, err, "connection refused")
+	}
+	assert.Equal(t, 2, lookup.calls)
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TenantDataService exports and purges all of a tenant's data. Exports are
+// written as ZIP files below exportDir.
+type TenantDataService struct {
+	db        *sql.DB
+	exportDir string
+	now       func() time.Time
+}
+
+func NewTenantDataService(db *sql.DB, exportDir string) *TenantDataService {
+	return &TenantDataService{db: db, exportDir: exportDir, now: time.Now}
+}
+
+// Line tables without a tenant_id, and the header column they hang off
+var tenantLineTables = []struct{ table, parent, column string }{
+	{"purchase_order_lines", "purchase_orders", "purchase_order_id"},
+	{"goods_receipt_lines", "goods_receipts", "receipt_id"},
+	{"count_lines", "count_batches", "batch_id"},
+}
+
+// Tables with a tenant_id that do not hold the tenant's data
+var tenantDataExcluded = map[string]bool{
+	"system_audit_log": true,
+}
+
+// tenantTable is a table holding tenant data and the condition selecting one
+// tenant's rows, with the tenant ID as $1
+type tenantTable struct {
+	name   string
+	filter string
+}
+
+// listTenantTables finds every table holding tenant data: those with a
+// tenant_id column and the line tables of tenant-scoped headers.
+func listTenantTables(ctx context.Context, db DBTX) ([]tenantTable, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.table_name
+		FROM information_schema.columns c
+		JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = current_schema() AND c.column_name = 'tenant_id' AND t.table_type = 'BASE TABLE'
+		ORDER BY c.table_name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenant tables: %w", err)
+	}
+	defer rows.Close()
+
+	tables := []tenantTable{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to list tenant tables: %w", err)
+		}
+		if !tenantDataExcluded[name] {
+			tables = append(tables, tenantTable{name: name, filter: "tenant_id = $1"})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tenant tables: %w", err)
+	}
+
+	for _, line := range tenantLineTables {
+		tables = append(tables, tenantTable{
+			name:   line.table,
+			filter: fmt.Sprintf("%s IN (SELECT id FROM %s WHERE tenant_id = $1)", line.column, line.parent),
+		})
+	}
+	return tables, nil
+}
+
+// foreignKeys returns the (referencing, referenced) table pairs of every
+// foreign key in the schema
+func foreignKeys(ctx context.Context, db DBTX) ([][2]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT child.relname, parent.relname
+		FROM pg_constraint c
+		JOIN pg_class child ON child.oid = c.conrelid
+		JOIN pg_class parent ON parent.oid = c.confrelid
+		JOIN pg_namespace n ON n.oid = child.relnamespace
+		WHERE c.contype = 'f' AND n.nspname = current_schema()
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	edges := [][2]string{}
+	for rows.Next() {
+		var edge [2]string
+		if err := rows.Scan(&edge[0], &edge[1]); err != nil {
+			return nil, fmt.Errorf("failed to list foreign keys: %w", err)
+		}
+		edges = append(edges, edge)
+	}
+	return edges, rows.Err()
+}
+
+// purgeOrder orders tables so that every table comes before the tables its
+// foreign keys reference, which makes deleting in that order safe. Keys to
+// tables outside the list and to the table itself are ignored.
+func purgeOrder(tables []string, edges [][2]string) ([]string, error) {
+	inSet := map[string]bool{}
+	for _, t := range tables {
+		inSet[t] = true
+	}
+	referrers := map[string]int{}       // tables left that reference a table
+	references := map[string][]string{} // tables a table references
+	seen := map[[2]string]bool{}
+	for _, e := range edges {
+		child, parent := e[0], e[1]
+		if !inSet[child] || !inSet[parent] || child == parent || seen[e] {
+			continue
+		}
+		seen[e] = true
+		referrers[parent]++
+		references[child] = append(references[child], parent)
+	}
+
+	order := make([]string, 0, len(tables))
+	done := map[string]bool{}
+	for len(order) < len(inSet) {
+		ready := []string{}
+		for t := range inSet {
+			if !done[t] && referrers[t] == 0 {
+				ready = append(ready, t)
+			}
+		}
+		if len(ready) == 0 {
+			left := []string{}
+			for t := range inSet {
+				if !done[t] {
+					left = append(left, t)
+				}
+			}
+			sort.Strings(left)
+			return nil, fmt.Errorf("foreign keys between %v form a cycle", left)
+		}
+		sort.Strings(ready)
+		for _, t := range ready {
+			done[t] = true
+			order = append(order, t)
+			for _, parent := range references[t] {
+				referrers[parent]--
+			}
+		}
+	}
+	return order, nil
+}
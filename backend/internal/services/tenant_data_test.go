@@ -0,0 +1,41 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeOrder(t *testing.T) {
+	tables := []string{"users", "items", "categories", "purchase_orders", "purchase_order_lines", "audit_logs"}
+	edges := [][2]string{
+		{"items", "categories"},
+		{"items", "tenants"}, // outside the list
+		{"categories", "categories"},
+		{"purchase_orders", "users"},
+		{"purchase_order_lines", "purchase_orders"},
+		{"purchase_order_lines", "items"},
+		{"purchase_order_lines", "items"},
+		{"audit_logs", "users"},
+	}
+
+	order, err := purgeOrder(tables, edges)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, tables, order)
+
+	pos := map[string]int{}
+	for i, table := range order {
+		pos[table] = i
+	}
+	for _, e := range edges {
+		if _, ok := pos[e[1]]; ok && e[0] != e[1] {
+			assert.Less(t, pos[e[0]], pos[e[1]], "%s must be purged before %s", e[0], e[1])
+		}
+	}
+}
+
+func TestPurgeOrderRejectsCycles(t *testing.T) {
+	_, err := purgeOrder([]string{"a", "b", "c"}, [][2]string{{"a", "b"}, {"b", "a"}, {"c", "a"}})
+	assert.EqualError(t, err, "foreign keys between [a b] form a cycle")
+}
@@ -0,0 +1,376 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Export statuses
+const (
+	ExportPending   = "PENDING"
+	ExportRunning   = "RUNNING"
+	ExportCompleted = "COMPLETED"
+	ExportFailed    = "FAILED"
+)
+
+// A running export not finished after this long is assumed to have died with
+// its process and is started again
+const exportStaleAfter = time.Hour
+
+// ErrExportNotFound is returned for an export that does not exist
+var ErrExportNotFound = errors.New("export not found")
+
+// Columns holding secrets, left out of exports
+var exportOmittedColumns = map[string]bool{
+	"password_hash": true,
+	"mfa_secret":    true,
+	"token_hash":    true,
+	"key_hash":      true,
+	"code_hash":     true,
+	"client_secret": true,
+}
+
+// TenantExport is a background export of a tenant's data
+type TenantExport struct {
+	ID          uuid.UUID  `json:"id"`
+	TenantID    uuid.UUID  `json:"tenant_id"`
+	Status      string     `json:"status"`
+	RequestedBy *string    `json:"requested_by"`
+	SizeBytes   *int64     `json:"size_bytes"`
+	Error       *string    `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	// Path of the finished ZIP
+	FilePath *string `json:"-"`
+}
+
+const exportColumns = `id, tenant_id, status, requested_by, size_bytes, error, created_at, started_at, completed_at, file_path`
+
+func scanExport(row interface{ Scan(...interface{}) error }) (*TenantExport, error) {
+	e := &TenantExport{}
+	err := row.Scan(&e.ID, &e.TenantID, &e.Status, &e.RequestedBy, &e.SizeBytes, &e.Error, &e.CreatedAt, &e.StartedAt, &e.CompletedAt, &e.FilePath)
+	return e, err
+}
+
+// RequestExport queues an export of the tenant's data on db, which may be the
+// caller's transaction; RunExports produces it. Tenants deactivated or awaiting
+// purge can be exported too.
+func (s *TenantDataService) RequestExport(ctx context.Context, db DBTX, tenantID uuid.UUID, requestedBy string) (*TenantExport, error) {
+	var by *string
+	if requestedBy != "" {
+		by = &requestedBy
+	}
+	export, err := scanExport(db.QueryRowContext(ctx, `
+		INSERT INTO tenant_exports (tenant_id, status, requested_by, created_at)
+		SELECT id, $2::varchar, $3::uuid, NOW() FROM tenants WHERE id = $1
+		RETURNING `+exportColumns,
+		tenantID, ExportPending, by))
+	if err == sql.ErrNoRows {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue export: %w", err)
+	}
+	return export, nil
+}
+
+// ListExports returns the tenant's exports, newest first
+func (s *TenantDataService) ListExports(ctx context.Context, tenantID uuid.UUID) ([]*TenantExport, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+exportColumns+` FROM tenant_exports WHERE tenant_id = $1 ORDER BY created_at DESC
+	`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list exports: %w", err)
+	}
+	defer rows.Close()
+
+	exports := []*TenantExport{}
+	for rows.Next() {
+		e, err := scanExport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan export: %w", err)
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+// GetExport returns one of the tenant's exports
+func (s *TenantDataService) GetExport(ctx context.Context, tenantID, id uuid.UUID) (*TenantExport, error) {
+	e, err := scanExport(s.db.QueryRowContext(ctx, `
+		SELECT `+exportColumns+` FROM tenant_exports WHERE id = $1 AND tenant_id = $2
+	`, id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get export: %w", err)
+	}
+	return e, nil
+}
+
+// RunExports produces queued exports one at a time until none are left. Any
+// number of processes may run it at once.
+func (s *TenantDataService) RunExports(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		var id, tenantID uuid.UUID
+		err := s.db.QueryRowContext(ctx, `
+			UPDATE tenant_exports SET status = $1, started_at = NOW(), error = NULL
+			WHERE id = (
+				SELECT id FROM tenant_exports
+				WHERE status = $2 OR (status = $1 AND started_at < NOW() - $3 * INTERVAL '1 second')
+				ORDER BY created_at
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, tenant_id
+		`, ExportRunning, ExportPending, exportStaleAfter.Seconds()).Scan(&id, &tenantID)
+		if err == sql.ErrNoRows {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to claim export: %w", err)
+		}
+
+		path := filepath.Join(s.exportDir, tenantID.String(), id.String()+".zip")
+		size, runErr := s.writeExportFile(ctx, tenantID, path)
+		if runErr != nil {
+			_, err = s.db.ExecContext(ctx, `
+				UPDATE tenant_exports SET status = $2, error = $3, completed_at = NOW() WHERE id = $1
+			`, id, ExportFailed, runErr.Error())
+		} else {
+			_, err = s.db.ExecContext(ctx, `
+				UPDATE tenant_exports SET status = $2, file_path = $3, size_bytes = $4, completed_at = NOW() WHERE id = $1
+			`, id, ExportCompleted, path, size)
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to finish export: %w", err)
+		}
+		n++
+	}
+}
+
+// writeExportFile writes the tenant's export to path, returning its size
+func (s *TenantDataService) writeExportFile(ctx context.Context, tenantID uuid.UUID, path string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return 0, fmt.Errorf("failed to create export directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create export file: %w", err)
+	}
+	err = s.WriteExport(ctx, tenantID, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// WriteExport writes a ZIP of the tenant's data to w: for every table holding
+// tenant data a <table>.json array of rows and a <table>.csv, plus
+// manifest.json. All files are read from one snapshot, and columns holding
+// secrets are left out.
+func (s *TenantDataService) WriteExport(ctx context.Context, tenantID uuid.UUID, w io.Writer) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to start export: %w", err)
+	}
+	defer tx.Rollback()
+	// Row level security hides other tenants' rows even if a filter is wrong
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('app.tenant_id', $1, true), set_config('app.rls_bypass', 'off', true)`, tenantID.String()); err != nil {
+		return fmt.Errorf("failed to start export: %w", err)
+	}
+
+	all, err := listTenantTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	tables := []tenantTable{{name: "tenants", filter: "id = $1"}}
+	for _, table := range all {
+		switch table.name {
+		case "tenant_exports":
+			continue
+		case "users":
+			// Users belong to the tenants they are members of, not just their
+			// home one
+			table.filter = "tenant_id = $1 OR id IN (SELECT user_id FROM tenant_memberships WHERE tenant_id = $1)"
+		}
+		tables = append(tables, table)
+	}
+
+	zw := zip.NewWriter(w)
+	counts := map[string]int64{}
+	for _, table := range tables {
+		columns, err := exportedColumns(ctx, tx, table.name)
+		if err != nil {
+			return err
+		}
+		if counts[table.name], err = writeTableJSON(ctx, tx, zw, table, columns, tenantID); err != nil {
+			return fmt.Errorf("failed to export %s: %w", table.name, err)
+		}
+		if err := writeTableCSV(ctx, tx, zw, table, columns, tenantID); err != nil {
+			return fmt.Errorf("failed to export %s: %w", table.name, err)
+		}
+	}
+
+	manifest, err := json.MarshalIndent(map[string]interface{}{
+		"tenant_id":   tenantID,
+		"exported_at": s.now().UTC(),
+		"tables":      counts,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	mw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// exportedColumns returns the quoted columns of a table, in order, without the
+// ones holding secrets
+func exportedColumns(ctx context.Context, db DBTX, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+		ORDER BY ordinal_position
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to list columns of %s: %w", table, err)
+		}
+		if !exportOmittedColumns[name] {
+			columns = append(columns, pq.QuoteIdentifier(name))
+		}
+	}
+	return columns, rows.Err()
+}
+
+func tableQuery(table tenantTable, columns []string) string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE %s`, strings.Join(columns, ", "), pq.QuoteIdentifier(table.name), table.filter)
+}
+
+// writeTableJSON writes the tenant's rows of a table as a JSON array and
+// returns how many there were
+func writeTableJSON(ctx context.Context, tx *sql.Tx, zw *zip.Writer, table tenantTable, columns []string, tenantID uuid.UUID) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT row_to_json(r) FROM (`+tableQuery(table, columns)+`) r`, tenantID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	f, err := zw.Create(table.name + ".json")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.WriteString(f, "["); err != nil {
+		return 0, err
+	}
+	var n int64
+	for rows.Next() {
+		var row []byte
+		if err := rows.Scan(&row); err != nil {
+			return n, err
+		}
+		sep := ",\n"
+		if n == 0 {
+			sep = "\n"
+		}
+		if _, err := io.WriteString(f, sep); err != nil {
+			return n, err
+		}
+		if _, err := f.Write(row); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(f, "\n]\n")
+	return n, err
+}
+
+// writeTableCSV writes the tenant's rows of a table as CSV with a header row.
+// NULL is written as an empty field.
+func writeTableCSV(ctx context.Context, tx *sql.Tx, zw *zip.Writer, table tenantTable, columns []string, tenantID uuid.UUID) error {
+	rows, err := tx.QueryContext(ctx, tableQuery(table, columns), tenantID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	f, err := zw.Create(table.name + ".csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	if err := cw.Write(names); err != nil {
+		return err
+	}
+
+	values := make([]sql.NullString, len(names))
+	dest := make([]interface{}, len(names))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(names))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			record[i] = v.String
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrPurgeScheduled is returned when scheduling a purge already scheduled
+	ErrPurgeScheduled = errors.New("tenant purge already scheduled")
+	// ErrPurgeNotScheduled is returned for a tenant with no purge scheduled
+	ErrPurgeNotScheduled = errors.New("no tenant purge scheduled")
+	// ErrPurgeNotDue is returned when purging before the grace period ends
+	ErrPurgeNotDue = errors.New("tenant purge grace period has not ended")
+)
+
+// PurgeSchedule is a tenant and when it is to be purged; the purge fields are
+// nil when none is scheduled
+type PurgeSchedule struct {
+	TenantID    uuid.UUID  `json:"tenant_id"`
+	Slug        string     `json:"slug"`
+	Name        string     `json:"name"`
+	IsActive    bool       `json:"is_active"`
+	RequestedAt *time.Time `json:"purge_requested_at"`
+	RequestedBy *string    `json:"purge_requested_by"`
+	PurgeAfter  *time.Time `json:"purge_after"`
+}
+
+const purgeScheduleColumns = `id, slug, name, is_active, purge_requested_at, purge_requested_by, purge_after`
+
+func scanPurgeSchedule(row interface{ Scan(...interface{}) error }) (*PurgeSchedule, error) {
+	p := &PurgeSchedule{}
+	err := row.Scan(&p.TenantID, &p.Slug, &p.Name, &p.IsActive, &p.RequestedAt, &p.RequestedBy, &p.PurgeAfter)
+	return p, err
+}
+
+// GetPurgeSchedule returns the tenant, active or not, with its purge schedule
+func (s *TenantService) GetPurgeSchedule(ctx context.Context, tenantID uuid.UUID) (*PurgeSchedule, error) {
+	p, err := scanPurgeSchedule(s.db.QueryRowContext(ctx, `SELECT `+purgeScheduleColumns+` FROM tenants WHERE id = $1`, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	return p, nil
+}
+
+// SchedulePurge deactivates the tenant and schedules its data to be deleted
+// after purgeAfter
+func (s *TenantService) SchedulePurge(ctx context.Context, tenantID uuid.UUID, requestedBy string, purgeAfter time.Time) (*PurgeSchedule, error) {
+	var by *string
+	if requestedBy != "" {
+		by = &requestedBy
+	}
+	p, err := scanPurgeSchedule(s.db.QueryRowContext(ctx, `
+		UPDATE tenants
+		SET is_active = false, purge_requested_at = NOW(), purge_requested_by = $2, purge_after = $3, updated_at = NOW()
+		WHERE id = $1 AND purge_after IS NULL
+		RETURNING `+purgeScheduleColumns,
+		tenantID, by, purgeAfter))
+	if err == sql.ErrNoRows {
+		if _, err := s.GetPurgeSchedule(ctx, tenantID); err != nil {
+			return nil, err
+		}
+		return nil, ErrPurgeScheduled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to schedule purge: %w", err)
+	}
+	return p, nil
+}
+
+// CancelPurge cancels a scheduled purge that has not run yet and reactivates
+// the tenant
+func (s *TenantService) CancelPurge(ctx context.Context, tenantID uuid.UUID) (*PurgeSchedule, error) {
+	p, err := scanPurgeSchedule(s.db.QueryRowContext(ctx, `
+		UPDATE tenants
+		SET is_active = true, purge_requested_at = NULL, purge_requested_by = NULL, purge_after = NULL, updated_at = NOW()
+		WHERE id = $1 AND purge_after IS NOT NULL
+		RETURNING `+purgeScheduleColumns,
+		tenantID))
+	if err == sql.ErrNoRows {
+		if _, err := s.GetPurgeSchedule(ctx, tenantID); err != nil {
+			return nil, err
+		}
+		return nil, ErrPurgeNotScheduled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel purge: %w", err)
+	}
+	return p, nil
+}
+
+// PurgeDue purges every tenant whose grace period has ended. A tenant that
+// fails to purge is left for the next run; the first error is returned.
+func (s *TenantDataService) PurgeDue(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM tenants WHERE purge_after <= $1 ORDER BY purge_after`, s.now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to list due purges: %w", err)
+	}
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to list due purges: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list due purges: %w", err)
+	}
+
+	n := 0
+	var firstErr error
+	for _, id := range ids {
+		if _, err := s.PurgeTenant(ctx, id); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to purge tenant %s: %w", id, err)
+			}
+			continue
+		}
+		n++
+	}
+	return n, firstErr
+}
+
+// PurgeTenant hard-deletes a tenant whose grace period has ended, with every
+// row of its data, and records the purge in the system audit log. Tables are
+// emptied in foreign key order in one transaction. Users who are members of
+// other tenants are kept and moved to one of them. Returns the rows deleted by
+// table.
+func (s *TenantDataService) PurgeTenant(ctx context.Context, tenantID uuid.UUID) (map[string]int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start purge: %w", err)
+	}
+	defer tx.Rollback()
+
+	schedule, err := scanPurgeSchedule(tx.QueryRowContext(ctx, `
+		SELECT `+purgeScheduleColumns+` FROM tenants WHERE id = $1 FOR UPDATE
+	`, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if schedule.PurgeAfter == nil {
+		return nil, ErrPurgeNotScheduled
+	}
+	if schedule.PurgeAfter.After(s.now()) {
+		return nil, ErrPurgeNotDue
+	}
+
+	// Shared users move while their other tenants' memberships are visible
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users u
+		SET tenant_id = (
+			SELECT m.tenant_id FROM tenant_memberships m
+			WHERE m.user_id = u.id AND m.tenant_id <> $1
+			ORDER BY m.is_active DESC, m.created_at
+			LIMIT 1
+		), updated_at = NOW()
+		WHERE u.tenant_id = $1
+		  AND EXISTS (SELECT 1 FROM tenant_memberships m WHERE m.user_id = u.id AND m.tenant_id <> $1)
+	`, tenantID); err != nil {
+		return nil, fmt.Errorf("failed to move shared users: %w", err)
+	}
+
+	// Row level security keeps the deletes to this tenant's rows
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('app.tenant_id', $1, true), set_config('app.rls_bypass', 'off', true)`, tenantID.String()); err != nil {
+		return nil, fmt.Errorf("failed to scope purge: %w", err)
+	}
+
+	tables, err := listTenantTables(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	filters := map[string]string{}
+	names := make([]string, 0, len(tables))
+	for _, table := range tables {
+		filters[table.name] = table.filter
+		names = append(names, table.name)
+	}
+	// Sessions of deleted users may have been opened without a tenant
+	filters["user_sessions"] = "tenant_id = $1 OR user_id IN (SELECT id FROM users WHERE tenant_id = $1)"
+
+	edges, err := foreignKeys(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := purgeOrder(names, edges)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := map[string]int64{}
+	for _, table := range order {
+		res, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE %s`, pq.QuoteIdentifier(table), filters[table]), tenantID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge %s: %w", table, err)
+		}
+		if deleted[table], err = res.RowsAffected(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tenants WHERE id = $1`, tenantID); err != nil {
+		return nil, fmt.Errorf("failed to purge tenant: %w", err)
+	}
+
+	id := tenantID.String()
+	details := map[string]interface{}{
+		"rows":               deleted,
+		"purge_requested_at": schedule.RequestedAt,
+		"purge_requested_by": schedule.RequestedBy,
+		"purge_after":        schedule.PurgeAfter,
+	}
+	if err := NewAuditService(tx).RecordSystem(ctx, SystemAuditEntry{
+		Action:     SystemAuditPurged,
+		TenantID:   &id,
+		TenantSlug: &schedule.Slug,
+		TenantName: &schedule.Name,
+		Details:    details,
+	}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit purge: %w", err)
+	}
+
+	// The tenant's exports went with its data
+	if err := os.RemoveAll(filepath.Join(s.exportDir, id)); err != nil {
+		return deleted, fmt.Errorf("tenant purged but its export files were not removed: %w", err)
+	}
+	return deleted, nil
+}
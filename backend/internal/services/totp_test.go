@@ -0,0 +1,74 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RFC 6238 appendix B test secret ("12345678901234567890"), SHA1
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// Last six digits of the RFC's eight-digit reference values
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1234567890:  "005924",
+		20000000000: "353130",
+	} {
+		got, err := TOTPCode(rfcSecret, TOTPCounter(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "t=%d", unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	counter := TOTPCounter(now)
+	prev, _ := TOTPCode(rfcSecret, counter-1)
+
+	got, ok := ValidateTOTP(rfcSecret, "081 804", now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, counter, got)
+
+	_, ok = ValidateTOTP(rfcSecret, prev, now, 0)
+	assert.True(t, ok, "previous step is within the skew")
+
+	_, ok = ValidateTOTP(rfcSecret, "081804", now, counter)
+	assert.False(t, ok, "a used step cannot be replayed")
+
+	_, ok = ValidateTOTP(rfcSecret, "000000", now, 0)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Acme Stock", "jane@example.com", "ABC")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Acme%20Stock:jane@example.com?"))
+	assert.Contains(t, uri, "secret=ABC")
+	assert.Contains(t, uri, "issuer=Acme+Stock")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	assert.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}$`, codes[0])
+	assert.Equal(t, codes[0], NormalizeRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))+" "))
+}
+
+func TestSealSecret(t *testing.T) {
+	sealed, err := SealSecret("key", "secret")
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, "secret")
+
+	plain, err := OpenSecret("key", sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", plain)
+
+	_, err = OpenSecret("other", sealed)
+	assert.Error(t, err)
+}
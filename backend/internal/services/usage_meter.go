@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// UsageMeter counts API requests per tenant. Counts are kept in memory and
+// added to tenant_usage by Flush, which also refreshes which tenants have used
+// up their plan's monthly allowance; the allowance is therefore enforced up to
+// one flush late.
+type UsageMeter struct {
+	db  DBTX
+	now func() time.Time
+
+	mu       sync.Mutex
+	pending  map[string]int64 // requests since the last flush, by tenant ID
+	exceeded map[string]bool  // tenants over their monthly allowance
+}
+
+func NewUsageMeter(db DBTX) *UsageMeter {
+	return &UsageMeter{
+		db:       db,
+		now:      time.Now,
+		pending:  map[string]int64{},
+		exceeded: map[string]bool{},
+	}
+}
+
+// CountRequest counts an API request of the tenant. It returns false, without
+// counting, when the tenant has used up its monthly allowance.
+func (m *UsageMeter) CountRequest(tenantID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.exceeded[tenantID] {
+		return false
+	}
+	m.pending[tenantID]++
+	return true
+}
+
+// Pending returns the tenant's requests not yet flushed
+func (m *UsageMeter) Pending(tenantID string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pending[tenantID]
+}
+
+// Flush adds the counted requests to the current month in tenant_usage.
+// Counts that fail to be written are kept for the next flush.
+func (m *UsageMeter) Flush(ctx context.Context) error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = map[string]int64{}
+	m.mu.Unlock()
+
+	period := UsagePeriod(m.now())
+	var flushErr error
+	for tenantID, n := range pending {
+		_, err := m.db.ExecContext(ctx, `
+			INSERT INTO tenant_usage (tenant_id, period, metric, count, updated_at)
+			VALUES ($1, $2, $3, $4, NOW())
+			ON CONFLICT (tenant_id, period, metric) DO UPDATE SET count = tenant_usage.count + EXCLUDED.count, updated_at = NOW()
+		`, tenantID, period, MetricAPIRequests, n)
+		if err != nil {
+			m.mu.Lock()
+			m.pending[tenantID] += n
+			m.mu.Unlock()
+			flushErr = fmt.Errorf("failed to record usage: %w", err)
+		}
+	}
+
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT u.tenant_id
+		FROM tenant_usage u
+		JOIN tenants t ON t.id = u.tenant_id
+		JOIN plans p ON p.id = t.plan_id
+		WHERE u.period = $1 AND u.metric = $2
+		  AND p.max_api_requests_month IS NOT NULL AND u.count >= p.max_api_requests_month
+	`, period, MetricAPIRequests)
+	if err != nil {
+		return fmt.Errorf("failed to check usage: %w", err)
+	}
+	defer rows.Close()
+	exceeded := map[string]bool{}
+	for rows.Next() {
+		var tenantID string
+		if err := rows.Scan(&tenantID); err != nil {
+			return fmt.Errorf("failed to check usage: %w", err)
+		}
+		exceeded[tenantID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check usage: %w", err)
+	}
+
+	m.mu.Lock()
+	m.exceeded = exceeded
+	m.mu.Unlock()
+	return flushErr
+}
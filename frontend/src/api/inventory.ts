@@ -55,7 +55,7 @@ export async function getItemLocations(itemId: string): Promise<{ item_id: strin
   return res.data;
 }
 
-export type MovementReason = 'PO_RECEIPT' | 'ADJUSTMENT' | 'TRANSFER_OUT' | 'TRANSFER_IN' | 'COUNT';
+export type MovementReason = 'PO_RECEIPT' | 'PO_RETURN' | 'ADJUSTMENT' | 'TRANSFER_OUT' | 'TRANSFER_IN' | 'COUNT';
 
 export interface StockMovementRow {
   id: string;
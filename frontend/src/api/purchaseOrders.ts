@@ -93,6 +93,7 @@ export interface ReceiveLine {
 }
 
 export interface ReceiveItemsRequest {
+  location_id?: string;
   lines: ReceiveLine[];
 }
 
@@ -1,9 +1,12 @@
 import { useForm, useFieldArray } from 'react-hook-form';
+import { useQuery } from '@tanstack/react-query';
 import { zodResolver } from '@hookform/resolvers/zod';
 import { z } from 'zod';
 import type { PurchaseOrder, ReceiveItemsRequest } from '../api/purchaseOrders';
+import { listLocations } from '../api/locations';
 
 const receiveItemsSchema = z.object({
+  location_id: z.string().min(1, 'Location is required'),
   lines: z.array(z.object({
     line_id: z.string(),
     qty_received: z.number().min(0, 'Quantity must be 0 or more'),
@@ -36,6 +39,7 @@ export default function ReceiveItemsForm({
   } = useForm<ReceiveItemsFormData>({
     resolver: zodResolver(receiveItemsSchema),
     defaultValues: {
+      location_id: '',
       lines: purchaseOrder?.lines?.map(line => ({
         line_id: line.id,
         qty_received: 0,
@@ -43,6 +47,11 @@ export default function ReceiveItemsForm({
     },
   });
 
+  const { data: locationsData } = useQuery({
+    queryKey: ['locations'],
+    queryFn: () => listLocations({ page_size: 100 }),
+  });
+
   useFieldArray({
     control,
     name: 'lines',
@@ -60,6 +69,7 @@ export default function ReceiveItemsForm({
     }
 
     onSubmit({
+      location_id: data.location_id,
       lines: linesToReceive,
     });
   };
@@ -106,6 +116,26 @@ export default function ReceiveItemsForm({
           </div>
 
           <form onSubmit={handleSubmit(handleFormSubmit)}>
+            <div className="mb-6">
+              <label className="block text-sm font-medium text-gray-700 mb-2">
+                Receive into location
+              </label>
+              <select
+                {...register('location_id')}
+                className="w-full border border-gray-300 rounded-md px-3 py-2 focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-blue-500"
+              >
+                <option value="">Select Location</option>
+                {locationsData?.data?.map((location) => (
+                  <option key={location.id} value={location.id}>
+                    {location.name} ({location.code})
+                  </option>
+                ))}
+              </select>
+              {errors.location_id && (
+                <p className="mt-1 text-sm text-red-600">{errors.location_id.message}</p>
+              )}
+            </div>
+
             <div className="mb-6">
               <h3 className="text-lg font-medium text-gray-900 mb-4">Items to Receive</h3>
               <div className="overflow-x-auto">